@@ -0,0 +1,621 @@
+package dune
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Assemble reads a program in the format written by Fprint.
+func Assemble(r io.Reader) (*Program, error) {
+	return AssembleWith(r, nil)
+}
+
+// AssembleWith reads a program that uses the natives of the registry.
+func AssembleWith(r io.Reader, natives *NativeRegistry) (*Program, error) {
+	a := &assembler{
+		p:         &Program{Natives: natives},
+		functions: make(map[int]*Function),
+		positions: make(map[*Function][]string),
+	}
+
+	br := bufio.NewReader(r)
+
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			a.line++
+			if err := a.parseLine(strings.TrimRight(line, "\r\n")); err != nil {
+				return nil, fmt.Errorf("line %d: %w", a.line, err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := a.finish(); err != nil {
+		return nil, err
+	}
+
+	return a.p, nil
+}
+
+type assembler struct {
+	p         *Program
+	line      int
+	section   string
+	separator string // the last line if it was a separator
+
+	class     *Class
+	enum      *EnumList
+	function  *Function
+	funcAttrs int // 1 after the title of the attributes of a function, 2 reading them
+
+	functions map[int]*Function
+	positions map[*Function][]string // the position of each instruction
+}
+
+var opcodes = func() map[string]Opcode {
+	m := make(map[string]Opcode)
+	for op := op_loadConstant; op <= op_typeof; op++ {
+		m[strings.Title(op.String()[3:])] = op
+	}
+	return m
+}()
+
+func (a *assembler) parseLine(line string) error {
+	prevSeparator := a.separator
+	a.separator = ""
+
+	switch {
+	case strings.TrimSpace(line) == "":
+		return nil
+
+	case strings.HasPrefix(line, "====="):
+		a.separator = separator1
+		return nil
+
+	case strings.HasPrefix(line, "-----"):
+		a.separator = separator2
+		switch a.funcAttrs {
+		case 1:
+			a.funcAttrs = 2
+		case 2:
+			a.funcAttrs = 0
+		}
+		return nil
+	}
+
+	if prevSeparator == separator1 {
+		switch line {
+		case "Attributes", "Functions", "Classes", "Enums", "Constants", "Files", "Resources":
+			a.section = line
+			a.function = nil
+			a.class = nil
+			a.enum = nil
+			return nil
+		}
+	}
+
+	if a.function != nil {
+		if prevSeparator == separator2 && line == "Attributes" {
+			a.funcAttrs = 1
+			return nil
+		}
+		if a.funcAttrs == 2 {
+			a.function.Attributes = append(a.function.Attributes, strings.TrimPrefix(line, " "))
+			return nil
+		}
+	}
+
+	switch a.section {
+	case "Attributes":
+		a.p.Attributes = append(a.p.Attributes, strings.TrimPrefix(line, " "))
+		return nil
+
+	case "Functions", "Classes":
+		return a.parseFunctionLine(line)
+
+	case "Enums":
+		return a.parseEnumLine(line)
+
+	case "Constants":
+		return a.parseConstant(line)
+
+	case "Files":
+		_, value, err := indexed(line, "")
+		if err != nil {
+			return err
+		}
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return err
+		}
+		a.p.Files = append(a.p.Files, s)
+		return nil
+
+	case "Resources":
+		i := strings.LastIndexByte(line, ' ')
+		if i == -1 {
+			return fmt.Errorf("invalid resource: %s", line)
+		}
+		name, err := strconv.Unquote(line[:i])
+		if err != nil {
+			return err
+		}
+		b, err := base64.StdEncoding.DecodeString(line[i+1:])
+		if err != nil {
+			return err
+		}
+		if a.p.Resources == nil {
+			a.p.Resources = make(map[string][]byte)
+		}
+		a.p.Resources[name] = b
+		return nil
+	}
+
+	return fmt.Errorf("unexpected line: %s", line)
+}
+
+func (a *assembler) parseFunctionLine(line string) error {
+	if !strings.HasPrefix(line, " ") {
+		if a.section == "Classes" && strings.Contains(line, "C Class ") {
+			return a.parseClass(line)
+		}
+		return a.parseFunctionHeader(line)
+	}
+
+	if a.function == nil {
+		if a.class == nil {
+			return fmt.Errorf("unexpected line: %s", line)
+		}
+		return a.parseClassMember(line)
+	}
+
+	fields := strings.Fields(line)
+	if _, err := strconv.Atoi(fields[0]); err == nil {
+		return a.parseInstruction(line)
+	}
+
+	return a.parseRegister(fields)
+}
+
+func (a *assembler) parseFunctionHeader(line string) error {
+	index, rest, err := indexed(line, "F")
+	if err != nil {
+		return err
+	}
+
+	if _, ok := a.functions[index]; ok {
+		return fmt.Errorf("duplicated function %dF", index)
+	}
+
+	f := &Function{Index: index, WrapClass: -1}
+
+	i := strings.LastIndex(rest, " [")
+	if i == -1 || !strings.HasSuffix(rest, "]") {
+		return fmt.Errorf("invalid function header: %s", line)
+	}
+
+	name := rest[:i]
+
+	for _, prop := range strings.Fields(rest[i+2 : len(rest)-1]) {
+		k, v, hasValue := cut(prop, "=")
+		n := 0
+		if hasValue {
+			if n, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("invalid property %s", prop)
+			}
+		}
+
+		switch k {
+		case "regs":
+			f.MaxRegIndex = n
+		case "args":
+			f.Arguments = n
+		case "optional":
+			f.OptionalArguments = n
+		case "class":
+			f.IsClass = true
+			f.Class = n
+		case "wrap":
+			f.WrapClass = n
+		case "variadic":
+			f.Variadic = true
+		case "exported":
+			f.Exported = true
+		case "anonymous":
+			f.Anonimous = true
+		case "global":
+			f.IsGlobal = true
+		default:
+			return fmt.Errorf("invalid property %s", prop)
+		}
+	}
+
+	if f.IsClass {
+		c := a.class
+		if c == nil || a.p.Classes[f.Class] != c {
+			return fmt.Errorf("function %dF is not inside its class", index)
+		}
+
+		list := &c.Functions
+		switch {
+		case strings.HasPrefix(name, "get "):
+			list = &c.Getters
+			name = strings.TrimPrefix(name, "get ")
+		case strings.HasPrefix(name, "set "):
+			list = &c.Setters
+			name = strings.TrimPrefix(name, "set ")
+		}
+		*list = append(*list, index)
+
+		name = strings.TrimPrefix(name, c.Name+".")
+	}
+
+	f.Name = name
+	a.function = f
+	a.funcAttrs = 0
+	a.functions[index] = f
+	return nil
+}
+
+func (a *assembler) parseClass(line string) error {
+	index, rest, err := indexed(line, "C")
+	if err != nil {
+		return err
+	}
+
+	if index != len(a.p.Classes) {
+		return fmt.Errorf("expected class %dC", len(a.p.Classes))
+	}
+
+	name, exported := exportedFlag(strings.TrimPrefix(rest, "Class "))
+
+	a.class = &Class{Name: name, Exported: exported}
+	a.function = nil
+	a.p.Classes = append(a.p.Classes, a.class)
+	return nil
+}
+
+func (a *assembler) parseClassMember(line string) error {
+	line = strings.TrimSpace(line)
+
+	switch {
+	case strings.HasPrefix(line, "attribute "):
+		a.class.Attributes = append(a.class.Attributes, strings.TrimPrefix(line, "attribute "))
+	case strings.HasPrefix(line, "field "):
+		name, exported := exportedFlag(strings.TrimPrefix(line, "field "))
+		a.class.Fields = append(a.class.Fields, &Field{Name: name, Exported: exported})
+	default:
+		return fmt.Errorf("unexpected line: %s", line)
+	}
+
+	return nil
+}
+
+func (a *assembler) parseRegister(fields []string) error {
+	exported := fields[len(fields)-1] == "[exported]"
+	if exported {
+		fields = fields[:len(fields)-1]
+	}
+
+	// the name is empty in stripped programs
+	var name string
+	switch len(fields) {
+	case 2:
+	case 3:
+		name = fields[1]
+	default:
+		return fmt.Errorf("invalid register: %s", strings.Join(fields, " "))
+	}
+
+	addr := fields[0]
+	kind := addr[len(addr)-1]
+
+	index, err := strconv.Atoi(addr[:len(addr)-1])
+	if err != nil {
+		return fmt.Errorf("invalid register: %s", addr)
+	}
+
+	start, end, ok := cut(fields[len(fields)-1], "-")
+	if !ok {
+		return fmt.Errorf("invalid register range: %s", fields[len(fields)-1])
+	}
+
+	r := &Register{Name: name, Index: index, Exported: exported}
+	if r.StartPC, err = strconv.Atoi(start); err != nil {
+		return err
+	}
+	if r.EndPC, err = strconv.Atoi(end); err != nil {
+		return err
+	}
+
+	switch kind {
+	case 'G', 'L':
+		a.function.Registers = append(a.function.Registers, r)
+	case 'C':
+		a.function.Closures = append(a.function.Closures, r)
+	default:
+		return fmt.Errorf("invalid register: %s", addr)
+	}
+
+	return nil
+}
+
+func (a *assembler) parseInstruction(line string) error {
+	var pos string
+	if i := strings.Index(line, ";"); i != -1 {
+		pos = strings.TrimSpace(line[i+1:])
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 5 {
+		return fmt.Errorf("invalid instruction: %s", line)
+	}
+
+	f := a.function
+
+	if pc, _ := strconv.Atoi(fields[0]); pc != len(f.Instructions) {
+		return fmt.Errorf("expected instruction %d", len(f.Instructions))
+	}
+
+	op, ok := opcodes[fields[1]]
+	if !ok {
+		return fmt.Errorf("invalid opcode: %s", fields[1])
+	}
+
+	instr := &Instruction{Opcode: op}
+
+	for i, dst := range []**Address{&instr.A, &instr.B, &instr.C} {
+		addr, err := a.parseAddress(fields[i+2])
+		if err != nil {
+			return err
+		}
+		*dst = addr
+	}
+
+	f.Instructions = append(f.Instructions, instr)
+	a.positions[f] = append(a.positions[f], pos)
+	return nil
+}
+
+// parseAddress parses an address printed by operand.
+func (a *assembler) parseAddress(s string) (*Address, error) {
+	if s == "--" {
+		return Void, nil
+	}
+
+	if strings.HasPrefix(s, "N(") && strings.HasSuffix(s, ")") {
+		name := s[2 : len(s)-1]
+		f, ok := a.p.NativeFuncFromName(name)
+		if !ok {
+			return nil, fmt.Errorf("native function not found: %s", name)
+		}
+		return NewAddress(AddrNativeFunc, f.Index), nil
+	}
+
+	if len(s) < 2 {
+		return nil, fmt.Errorf("invalid address: %s", s)
+	}
+
+	v, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid address: %s", s)
+	}
+
+	var kind AddressKind
+	switch s[len(s)-1] {
+	case 'E':
+		kind = AddrEnum
+	case 'F':
+		kind = AddrFunc
+	case 'N':
+		kind = AddrNativeFunc
+	case 'K':
+		kind = AddrConstant
+	case 'G':
+		kind = AddrGlobal
+	case 'L':
+		kind = AddrLocal
+	case 'C':
+		kind = AddrClosure
+	case 'A':
+		kind = AddrClass
+	case 'D':
+		kind = AddrData
+	case 'U':
+		kind = AddrUnresolved
+	default:
+		return nil, fmt.Errorf("invalid address: %s", s)
+	}
+
+	return NewAddress(kind, v), nil
+}
+
+func (a *assembler) parseEnumLine(line string) error {
+	if !strings.HasPrefix(line, " ") {
+		index, rest, err := indexed(line, "E")
+		if err != nil {
+			return err
+		}
+		if index != len(a.p.Enums) {
+			return fmt.Errorf("expected enum %dE", len(a.p.Enums))
+		}
+		name, exported := exportedFlag(rest)
+		a.enum = &EnumList{Name: name, Exported: exported}
+		a.p.Enums = append(a.p.Enums, a.enum)
+		return nil
+	}
+
+	if a.enum == nil {
+		return fmt.Errorf("unexpected line: %s", line)
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return fmt.Errorf("invalid enum value: %s", line)
+	}
+
+	k, err := strconv.Atoi(strings.TrimSuffix(fields[1], "K"))
+	if err != nil {
+		return fmt.Errorf("invalid enum value: %s", line)
+	}
+
+	name, _, _ := cut(fields[2], "=")
+	a.enum.Values = append(a.enum.Values, &EnumValue{Name: name, KIndex: k})
+	return nil
+}
+
+func (a *assembler) parseConstant(line string) error {
+	index, rest, err := indexed(line, "K")
+	if err != nil {
+		return err
+	}
+
+	if index != len(a.p.Constants) {
+		return fmt.Errorf("expected constant %dK", len(a.p.Constants))
+	}
+
+	kind, value, _ := cut(rest, " ")
+
+	var v Value
+
+	switch kind {
+	case "string":
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return err
+		}
+		v = NewString(s)
+	case "rune":
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return err
+		}
+		r := []rune(s)
+		if len(r) != 1 {
+			return fmt.Errorf("invalid rune: %s", value)
+		}
+		v = NewRune(r[0])
+	case "int":
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v = NewInt64(i)
+	case "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v = NewFloat(f)
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v = NewBool(b)
+	case "null":
+		v = NullValue
+	case "undefined":
+		v = UndefinedValue
+	default:
+		return fmt.Errorf("invalid constant type: %s", kind)
+	}
+
+	a.p.Constants = append(a.p.Constants, v)
+	return nil
+}
+
+// finish sorts the functions by index and sets the positions now
+// that the files are known.
+func (a *assembler) finish() error {
+	p := a.p
+
+	p.Functions = make([]*Function, len(a.functions))
+	for i := range p.Functions {
+		f, ok := a.functions[i]
+		if !ok {
+			return fmt.Errorf("function %dF not found", i)
+		}
+		p.Functions[i] = f
+	}
+
+	for f, list := range a.positions {
+		var positions []Position
+		for i, s := range list {
+			if s == "" {
+				continue
+			}
+
+			if positions == nil {
+				positions = make([]Position, len(list))
+			}
+
+			pos, err := a.parsePosition(s)
+			if err != nil {
+				return fmt.Errorf("%dF instruction %d: %w", f.Index, i, err)
+			}
+			positions[i] = pos
+		}
+		f.Positions = positions
+	}
+
+	return nil
+}
+
+func (a *assembler) parsePosition(s string) (Position, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i == -1 {
+		if !strings.HasPrefix(s, "line ") {
+			return Position{}, fmt.Errorf("invalid position: %s", s)
+		}
+		line, err := strconv.Atoi(strings.TrimPrefix(s, "line "))
+		return Position{Line: line}, err
+	}
+
+	line, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return Position{}, fmt.Errorf("invalid position: %s", s)
+	}
+
+	file := a.p.FileIndex(s[:i])
+	if file == -1 {
+		return Position{}, fmt.Errorf("file not found: %s", s[:i])
+	}
+
+	return Position{File: file, Line: line}, nil
+}
+
+// indexed parses lines like "3F name" returning the index and the rest.
+func indexed(line, suffix string) (int, string, error) {
+	head, rest, _ := cut(line, " ")
+	i, err := strconv.Atoi(strings.TrimSuffix(head, suffix))
+	if err != nil || !strings.HasSuffix(head, suffix) {
+		return 0, "", fmt.Errorf("invalid line: %s", line)
+	}
+	return i, rest, nil
+}
+
+func exportedFlag(s string) (string, bool) {
+	if strings.HasSuffix(s, " [exported]") {
+		return strings.TrimSuffix(s, " [exported]"), true
+	}
+	return s, false
+}
+
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i != -1 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
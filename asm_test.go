@@ -0,0 +1,158 @@
+package dune
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssembleRoundTrip(t *testing.T) {
+	p, err := CompileStr(`
+		// [permissions trusted]
+
+		enum Color { Red, Green = "g" }
+
+		let total = 1.5
+
+		export class Foo {
+			private x = 1
+			y: string
+
+			constructor() { this.x = 2 }
+			get v() { return this.x }
+			set v(a) { this.x = a }
+		}
+
+		function main(...args) {
+			let f = (a, b?) => a + total
+			let foo = new Foo()
+			foo.v = 3
+			let s = "a \"quoted\"\nline"
+			let c = 'x'
+			try {
+				throw "error"
+			} catch {
+				s += Color.Green
+			}
+			return f(foo.v) + s.length + (c == 'x' ? 1 : 0)
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Resources = map[string][]byte{"a.txt": []byte("hello")}
+
+	src, _ := Sprint(p)
+
+	p2, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src2, _ := Sprint(p2)
+	if src != src2 {
+		t.Fatalf("the disassembly is different:\n%s\n\n%s", src, src2)
+	}
+
+	for _, prog := range []*Program{p, p2} {
+		v, err := NewVM(prog).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.ToFloat() != 21.5 {
+			t.Fatal(v)
+		}
+	}
+
+	if string(p2.Resources["a.txt"]) != "hello" || p2.Attributes[0] != "permissions trusted" {
+		t.Fatal(p2.Resources, p2.Attributes)
+	}
+}
+
+func TestAssembleNativeRegistry(t *testing.T) {
+	r := NewNativeRegistry()
+	r.Add(NativeFunction{
+		Name:      "tenant.name",
+		Arguments: 0,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NewString("acme"), nil
+		},
+	})
+
+	p, err := CompileStrWith(`function main() { return tenant.name() }`, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, _ := Sprint(p)
+
+	if _, err := Assemble(strings.NewReader(src)); err == nil || !strings.Contains(err.Error(), "tenant.name") {
+		t.Fatal(err)
+	}
+
+	p2, err := AssembleWith(strings.NewReader(src), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVM(p2).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "acme" {
+		t.Fatal(v)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	// it doesn't need registers, positions or files
+	p, err := Assemble(strings.NewReader(`
+===============================================================
+Functions
+===============================================================
+0F @global [regs=0 global]
+---------------------------------------------------------------
+  0     Return              --     --     --
+
+1F main [regs=1]
+---------------------------------------------------------------
+  0     Add                 0L     0K     1K
+  1     Return              0L     --     --
+
+===============================================================
+Constants
+===============================================================
+0K int 40
+1K int 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVM(p).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.ToInt() != 42 {
+		t.Fatal(v)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	data := []struct {
+		src string
+		err string
+	}{
+		{"=====\nFunctions\n=====\n0F main [regs=0]\n-----\n  0     Foo   --  --  --\n", "line 6: invalid opcode: Foo"},
+		{"=====\nFunctions\n=====\n1F main [regs=0]\n", "function 0F not found"},
+		{"=====\nConstants\n=====\n0K string \"x\n", "line 4: invalid syntax"},
+	}
+
+	for _, d := range data {
+		_, err := Assemble(strings.NewReader(d.src))
+		if err == nil || err.Error() != d.err {
+			t.Fatalf("expected %q, got %v", d.err, err)
+		}
+	}
+}
@@ -10,6 +10,7 @@ type Comment struct {
 	MultiLine bool
 	Str       string
 	Pos       Position
+	Trailing  bool // if it is in the same line after other code
 }
 
 func (i *Comment) Position() Position {
@@ -31,6 +32,7 @@ func (i *ImportStmt) stmtNode() {}
 
 type File struct {
 	Path       string
+	Source     string
 	Stms       []Stmt
 	Global     []Stmt
 	Comments   []*Comment
@@ -66,6 +68,7 @@ type SwitchStmt struct {
 	Expression Expr
 	Blocks     []*CaseBlock
 	Default    *CaseBlock
+	Rbrace     Position
 	label      string
 	continuePC int
 	breakPC    int
@@ -97,6 +100,19 @@ func (i *SwitchStmt) Position() Position {
 
 func (i *SwitchStmt) stmtNode() {}
 
+// TypeDeclStmt is an interface, a type alias or a declare block.
+// The compiler ignores them so they are only returned by ParseSource.
+type TypeDeclStmt struct {
+	Pos    Position
+	Tokens []*Token
+}
+
+func (i *TypeDeclStmt) Position() Position {
+	return i.Pos
+}
+
+func (i *TypeDeclStmt) stmtNode() {}
+
 type CaseBlock struct {
 	Pos        Position
 	Expression Expr
@@ -275,6 +291,7 @@ type ClassDeclStmt struct {
 	Getters    []*FuncDeclStmt
 	Setters    []*FuncDeclStmt
 	Attributes []string
+	Rbrace     Position
 }
 
 func (c *ClassDeclStmt) Position() Position {
@@ -288,6 +305,7 @@ type EnumDeclStmt struct {
 	Name     string
 	Values   []EnumValue
 	Exported bool
+	Rbrace   Position
 }
 
 type EnumValue struct {
@@ -316,6 +334,10 @@ type FuncDeclStmt struct {
 
 	// a Object value means that it is a method of that object
 	ReceiverType string
+
+	// type parameters and return type. Only set by ParseSource.
+	Generic    string
+	ReturnType string
 }
 
 func (i *FuncDeclStmt) Position() Position {
@@ -330,6 +352,8 @@ type VarDeclStmt struct {
 	Value    Expr
 	Exported bool
 	Const    bool
+	Var      bool   // declared with var. Only set by ParseSource
+	Type     string // only set by ParseSource
 }
 
 func (i *VarDeclStmt) Position() Position {
@@ -395,10 +419,12 @@ func (i *ThrowStmt) stmtNode() {}
 
 // FuncDeclExpr is a function as a value expression
 type FuncDeclExpr struct {
-	Pos      Position
-	Args     *Arguments
-	Variadic bool
-	Body     *BlockStmt
+	Pos        Position
+	Args       *Arguments
+	Variadic   bool
+	Body       *BlockStmt
+	Lambda     bool
+	ReturnType string // only set by ParseSource
 }
 
 func (i *FuncDeclExpr) Position() Position {
@@ -420,8 +446,9 @@ func (i *RegisterExpr) Position() Position {
 func (i *RegisterExpr) exprNode() {}
 
 type IdentExpr struct {
-	Pos  Position
-	Name string
+	Pos      Position
+	Name     string
+	TypeArgs string // generic arguments like foo<T>(). Only set by ParseSource
 }
 
 func (i *IdentExpr) Position() Position {
@@ -501,6 +528,18 @@ func (i *CallExpr) Position() Position {
 }
 func (i *CallExpr) exprNode() {}
 
+// TypeAssertExpr is a type assertion like "x as T". The compiler
+// ignores them so they are only returned by ParseSource.
+type TypeAssertExpr struct {
+	X    Expr
+	Type string
+}
+
+func (i *TypeAssertExpr) Position() Position {
+	return i.X.Position()
+}
+func (i *TypeAssertExpr) exprNode() {}
+
 type TypeofExpr struct {
 	Expr Expr
 }
@@ -543,8 +582,9 @@ type KeyValue struct {
 }
 
 type MapDeclExpr struct {
-	Pos  Position
-	List []KeyValue
+	Pos    Position
+	List   []KeyValue
+	Rbrace Position
 }
 
 func (i *MapDeclExpr) Position() Position {
@@ -553,8 +593,9 @@ func (i *MapDeclExpr) Position() Position {
 func (i *MapDeclExpr) exprNode() {}
 
 type ArrayDeclExpr struct {
-	Pos  Position
-	List []Expr
+	Pos    Position
+	List   []Expr
+	Rbrack Position
 }
 
 func (i *ArrayDeclExpr) Position() Position {
@@ -604,4 +645,5 @@ type Field struct {
 	Pos      Position
 	Name     string
 	Optional bool
+	Type     string // only set by ParseSource
 }
@@ -0,0 +1,210 @@
+package ast
+
+import (
+	"strings"
+)
+
+// DocDecl is an exported declaration of a file with its documentation.
+type DocDecl struct {
+	Kind      string // function, class, enum, const, let, var, interface or type
+	Name      string
+	Signature string
+	Doc       string
+	Pos       Position
+	Members   []*DocDecl
+}
+
+// Docs returns the exported declarations of a file parsed with
+// parser.ParseSource and the comments that precede them.
+func Docs(f *File) []*DocDecl {
+	d := &docReader{comments: f.Comments}
+
+	var list []*DocDecl
+	for _, s := range f.Stms {
+		if decl := d.decl(s); decl != nil {
+			list = append(list, decl)
+		}
+	}
+	return list
+}
+
+type docReader struct {
+	comments []*Comment
+}
+
+func (d *docReader) decl(s Stmt) *DocDecl {
+	switch t := s.(type) {
+	case *FuncDeclStmt:
+		if !t.Exported {
+			return nil
+		}
+		return &DocDecl{
+			Kind:      "function",
+			Name:      t.Name,
+			Signature: "function " + t.Name + t.Generic + signature(t),
+			Doc:       d.doc(t.Pos.Line - len(t.Attributes)),
+			Pos:       t.Pos,
+		}
+
+	case *VarDeclStmt:
+		if !t.Exported {
+			return nil
+		}
+		sig := keyword(t) + " " + t.Name
+		if t.Type != "" {
+			sig += ": " + t.Type
+		}
+		return &DocDecl{
+			Kind:      keyword(t),
+			Name:      t.Name,
+			Signature: sig,
+			Doc:       d.doc(t.Pos.Line),
+			Pos:       t.Pos,
+		}
+
+	case *EnumDeclStmt:
+		if !t.Exported {
+			return nil
+		}
+		decl := &DocDecl{
+			Kind:      "enum",
+			Name:      t.Name,
+			Signature: "enum " + t.Name,
+			Doc:       d.doc(t.Pos.Line),
+			Pos:       t.Pos,
+		}
+		for _, v := range t.Values {
+			decl.Members = append(decl.Members, &DocDecl{
+				Kind:      "const",
+				Name:      v.Name,
+				Signature: v.Name,
+				Doc:       d.doc(v.Pos.Line),
+				Pos:       v.Pos,
+			})
+		}
+		return decl
+
+	case *ClassDeclStmt:
+		if !t.Exported {
+			return nil
+		}
+		return d.class(t)
+
+	case *TypeDeclStmt:
+		return d.typeDecl(t)
+	}
+
+	return nil
+}
+
+func (d *docReader) class(c *ClassDeclStmt) *DocDecl {
+	decl := &DocDecl{
+		Kind:      "class",
+		Name:      c.Name,
+		Signature: "class " + c.Name,
+		Doc:       d.doc(c.Pos.Line - len(c.Attributes)),
+		Pos:       c.Pos,
+	}
+
+	for _, f := range c.Fields {
+		if !f.Exported {
+			continue
+		}
+		sig := f.Name
+		if f.Type != "" {
+			sig += ": " + f.Type
+		}
+		decl.Members = append(decl.Members, &DocDecl{
+			Kind:      "field",
+			Name:      f.Name,
+			Signature: sig,
+			Doc:       d.doc(f.Pos.Line),
+			Pos:       f.Pos,
+		})
+	}
+
+	methods := func(list []*FuncDeclStmt, prefix string) {
+		for _, f := range list {
+			if !f.Exported {
+				continue
+			}
+			decl.Members = append(decl.Members, &DocDecl{
+				Kind:      "method",
+				Name:      f.Name,
+				Signature: prefix + f.Name + f.Generic + signature(f),
+				Doc:       d.doc(f.Pos.Line),
+				Pos:       f.Pos,
+			})
+		}
+	}
+
+	methods(c.Functions, "")
+	methods(c.Getters, "get ")
+	methods(c.Setters, "set ")
+
+	return decl
+}
+
+// typeDecl returns the exported interfaces and types.
+func (d *docReader) typeDecl(t *TypeDeclStmt) *DocDecl {
+	tokens := t.Tokens
+	if len(tokens) < 3 || tokens[0].Type != EXPORT {
+		return nil
+	}
+
+	kind := tokens[1].Str
+	switch kind {
+	case "interface", "type":
+	default:
+		return nil
+	}
+
+	return &DocDecl{
+		Kind:      kind,
+		Name:      tokens[2].Str,
+		Signature: formatTokens(tokens[1:], false),
+		Doc:       d.doc(t.Pos.Line),
+		Pos:       t.Pos,
+	}
+}
+
+// doc returns the text of the comments that end in the line before line
+// without empty lines in between.
+func (d *docReader) doc(line int) string {
+	var parts []string
+
+	for i := len(d.comments) - 1; i >= 0; i-- {
+		c := d.comments[i]
+		if c.Pos.Line >= line {
+			continue
+		}
+		if c.Trailing || c.Pos.Line != line-1 {
+			break
+		}
+		parts = append([]string{commentText(c)}, parts...)
+		line = c.Pos.Line - strings.Count(c.Str, "\n")
+	}
+
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
+
+// commentText removes the comment markers and the leading asterisks.
+func commentText(c *Comment) string {
+	if !c.MultiLine {
+		return strings.TrimSpace(c.Str)
+	}
+
+	lines := strings.Split(c.Str, "\n")
+	for i, l := range lines {
+		l = strings.TrimSpace(l)
+		l = strings.TrimPrefix(l, "*")
+		lines[i] = strings.TrimPrefix(l, " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func signature(f *FuncDeclStmt) string {
+	p := &sourcePrinter{}
+	p.signature(f.Args, f.Variadic, f.ReturnType)
+	return string(p.out)
+}
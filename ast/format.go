@@ -0,0 +1,1216 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Format writes a file parsed with parser.ParseSource in the canonical
+// style: four spaces of indentation, no semicolons and at most one empty
+// line between statements. src is the parsed code and is used to keep
+// the empty lines and the quotes of raw strings.
+func Format(w io.Writer, f *File, src []byte) error {
+	p := &sourcePrinter{
+		lines:    strings.Split(string(src), "\n"),
+		comments: f.Comments,
+	}
+
+	p.file(f)
+
+	if p.err != nil {
+		return p.err
+	}
+
+	_, err := w.Write(p.out)
+	return err
+}
+
+// FormatType returns the tokens of a type in a single line.
+func FormatType(tokens []*Token) string {
+	return formatTokens(tokens, true)
+}
+
+// precedence levels of the expressions, from the loosest to the tightest.
+const (
+	levelLambda  = -1
+	levelTernary = 0
+	levelUnary   = 5
+	levelFactor  = 6
+	levelValue   = 7
+)
+
+type sourcePrinter struct {
+	out      []byte
+	indent   int
+	lines    []string
+	comments []*Comment
+	next     int  // the next comment to print
+	fresh    bool // at the start of a block
+	last     int  // the source line of the last statement or comment
+	err      error
+}
+
+func (p *sourcePrinter) file(f *File) {
+	if len(f.Attributes) > 0 {
+		p.flush(Position{Line: p.attributesLine()})
+		for _, a := range f.Attributes {
+			p.print("// [" + a + "]")
+			p.newline()
+		}
+		// separate them from the first declaration or they would be its attributes
+		p.out = append(p.out, '\n')
+	}
+
+	stmts := make([]Stmt, 0, len(f.Imports)+len(f.Stms))
+	for _, imp := range f.Imports {
+		stmts = append(stmts, imp)
+	}
+	stmts = append(stmts, f.Stms...)
+
+	sort.SliceStable(stmts, func(i, j int) bool {
+		return before(stmts[i].Position(), stmts[j].Position())
+	})
+
+	p.stmts(stmts)
+	p.flush(Position{Line: 1 << 30})
+
+	for bytes.HasSuffix(p.out, []byte("\n\n")) {
+		p.out = p.out[:len(p.out)-1]
+	}
+}
+
+// attributesLine returns the line of the first attribute in the source.
+func (p *sourcePrinter) attributesLine() int {
+	for i, l := range p.lines {
+		l = strings.TrimSpace(l)
+		if !strings.HasPrefix(l, "//") {
+			continue
+		}
+		l = strings.TrimSpace(l[2:])
+		if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (p *sourcePrinter) print(s string) {
+	if s == "" {
+		return
+	}
+	if p.atLineStart() {
+		for i := 0; i < p.indent; i++ {
+			p.out = append(p.out, "    "...)
+		}
+	}
+	p.out = append(p.out, s...)
+	p.fresh = false
+}
+
+func (p *sourcePrinter) atLineStart() bool {
+	return len(p.out) == 0 || p.out[len(p.out)-1] == '\n'
+}
+
+func (p *sourcePrinter) newline() {
+	p.out = bytes.TrimRight(p.out, " ")
+	p.out = append(p.out, '\n')
+}
+
+// space keeps one empty line before the source line if there was one.
+func (p *sourcePrinter) space(line int) {
+	last := p.last
+	p.last = line
+	if p.fresh || len(p.out) == 0 || line-1 <= last || line-2 >= len(p.lines) {
+		return
+	}
+	if strings.TrimSpace(p.lines[line-2]) != "" {
+		return
+	}
+	if !bytes.HasSuffix(p.out, []byte("\n\n")) {
+		p.out = append(p.out, '\n')
+	}
+}
+
+func before(a, b Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// flush prints the comments that are before pos.
+func (p *sourcePrinter) flush(pos Position) {
+	for p.next < len(p.comments) {
+		c := p.comments[p.next]
+		if !before(c.Pos, pos) {
+			return
+		}
+		p.next++
+		p.comment(c)
+	}
+}
+
+// hasComments returns true if there are comments to print before pos.
+func (p *sourcePrinter) hasComments(pos Position) bool {
+	return p.next < len(p.comments) && before(p.comments[p.next].Pos, pos)
+}
+
+func (p *sourcePrinter) comment(c *Comment) {
+	text := "//" + c.Str
+	if c.MultiLine {
+		text = "/*" + c.Str + "*/"
+	}
+
+	if c.Trailing && len(p.out) > 0 {
+		// append it to the last line
+		if p.atLineStart() {
+			p.out = p.out[:len(p.out)-1]
+		}
+		p.out = append(p.out, ' ')
+		p.out = append(p.out, text...)
+		p.newline()
+		return
+	}
+
+	p.space(c.Pos.Line - strings.Count(c.Str, "\n"))
+	p.print(text)
+	p.newline()
+}
+
+func (p *sourcePrinter) stmts(list []Stmt) {
+	for _, s := range list {
+		pos := s.Position()
+		p.flush(pos)
+
+		line := pos.Line
+		switch t := s.(type) {
+		case *FuncDeclStmt:
+			line -= len(t.Attributes)
+		case *ClassDeclStmt:
+			line -= len(t.Attributes)
+		case Target:
+			// the label can be in the previous line
+			if l := t.Label(); l != "" && line >= 2 && line-2 < len(p.lines) {
+				if strings.TrimSpace(p.lines[line-2]) == l+":" {
+					line--
+				}
+			}
+		}
+		p.space(line)
+
+		p.stmt(s)
+		p.newline()
+	}
+}
+
+func (p *sourcePrinter) block(b *BlockStmt) {
+	if len(b.List) == 0 && !p.hasComments(b.Rbrace) {
+		p.print("{}")
+		return
+	}
+
+	p.print("{")
+	p.newline()
+	p.indent++
+	p.fresh = true
+	p.stmts(b.List)
+	p.flush(b.Rbrace)
+	p.indent--
+	p.print("}")
+}
+
+func (p *sourcePrinter) stmt(s Stmt) {
+	switch t := s.(type) {
+	case *ImportStmt:
+		if t.Alias == "" {
+			p.print("import " + quote(t.Path))
+		} else {
+			p.print("import * as " + t.Alias + " from " + quote(t.Path))
+		}
+
+	case *TypeDeclStmt:
+		p.typeDecl(t)
+
+	case *VarDeclStmt:
+		if t.Exported {
+			p.print("export ")
+		}
+		p.print(keyword(t) + " ")
+		p.varDecl(t)
+
+	case *FuncDeclStmt:
+		for _, a := range t.Attributes {
+			p.print("// [" + a + "]")
+			p.newline()
+		}
+		if t.ReceiverType != "" {
+			p.print(t.ReceiverType + ".prototype." + t.Name + " = function")
+			if t.Generic == "" {
+				p.print(" ")
+			}
+			p.print(t.Generic)
+		} else {
+			if t.Exported {
+				p.print("export ")
+			}
+			p.print("function " + t.Name + t.Generic)
+		}
+		p.signature(t.Args, t.Variadic, t.ReturnType)
+		p.print(" ")
+		p.block(t.Body)
+
+	case *ClassDeclStmt:
+		p.classDecl(t)
+
+	case *EnumDeclStmt:
+		p.enumDecl(t)
+
+	case *AsignStmt:
+		p.expr(t.Left, levelValue)
+		if b, ok := t.Value.(*BinaryExpr); ok && b.Left == t.Left {
+			// it was parsed from a compound assignment like x += 1
+			p.print(" " + operator(b.Operator) + "= ")
+			p.expr(b.Right, levelTernary)
+		} else {
+			p.print(" = ")
+			p.expr(t.Value, levelLambda)
+		}
+
+	case *IndexAsignStmt:
+		p.print(t.Name + "[")
+		p.expr(t.IndexExpr, levelTernary)
+		p.print("] = ")
+		p.expr(t.Value, levelLambda)
+
+	case *IncStmt:
+		p.expr(t.Left, levelValue)
+		if t.Operator == INC {
+			p.print("++")
+		} else {
+			p.print("--")
+		}
+
+	case *CallStmt:
+		p.expr(t.CallExpr, levelLambda)
+
+	case *TailCallStmt:
+		p.expr(t.CallExpr, levelLambda)
+
+	case *BlockStmt:
+		p.block(t)
+
+	case *IfStmt:
+		for i, b := range t.IfBlocks {
+			if i == 0 {
+				p.print("if (")
+			} else {
+				p.print(" else if (")
+			}
+			p.expr(b.Condition, levelTernary)
+			p.print(") ")
+			p.block(b.Body)
+		}
+		if t.Else != nil {
+			p.print(" else ")
+			p.block(t.Else)
+		}
+
+	case *WhileStmt:
+		p.label(t.Label())
+		p.print("while (")
+		p.expr(t.Expression, levelTernary)
+		p.print(") ")
+		p.block(t.Body)
+
+	case *ForStmt:
+		p.forStmt(t)
+
+	case *SwitchStmt:
+		p.switchStmt(t)
+
+	case *TryStmt:
+		p.print("try ")
+		p.block(t.Body)
+		if t.Catch != nil {
+			p.print(" catch ")
+			if t.CatchIdent != nil {
+				p.print("(" + t.CatchIdent.Name + ") ")
+			}
+			p.block(t.Catch)
+		}
+		if t.Finally != nil {
+			p.print(" finally ")
+			p.block(t.Finally)
+		}
+
+	case *ReturnStmt:
+		p.print("return")
+		if t.Value != nil {
+			p.print(" ")
+			p.expr(t.Value, levelLambda)
+		}
+
+	case *ThrowStmt:
+		p.print("throw ")
+		p.expr(t.Value, levelLambda)
+
+	case *BreakStmt:
+		p.print("break")
+		if t.Label != "" {
+			p.print(" " + t.Label)
+		}
+
+	case *ContinueStmt:
+		p.print("continue")
+		if t.Label != "" {
+			p.print(" " + t.Label)
+		}
+
+	case *DeleteStmt:
+		p.print("delete " + t.Object + "." + t.Field)
+
+	default:
+		p.err = fmt.Errorf("can't format %T", s)
+	}
+}
+
+func (p *sourcePrinter) label(name string) {
+	if name != "" {
+		p.print(name + ": ")
+	}
+}
+
+func keyword(v *VarDeclStmt) string {
+	switch {
+	case v.Const:
+		return "const"
+	case v.Var:
+		return "var"
+	default:
+		return "let"
+	}
+}
+
+func (p *sourcePrinter) varDecl(v *VarDeclStmt) {
+	p.print(v.Name)
+	if v.Type != "" {
+		p.print(": " + v.Type)
+	}
+	if v.Value != nil {
+		p.print(" = ")
+		p.expr(v.Value, levelLambda)
+	}
+}
+
+func (p *sourcePrinter) signature(args *Arguments, variadic bool, returnType string) {
+	p.print("(")
+	for i, f := range args.List {
+		if i > 0 {
+			p.print(", ")
+		}
+		if variadic && i == len(args.List)-1 {
+			p.print("...")
+		}
+		p.print(f.Name)
+		if f.Optional {
+			p.print("?")
+		}
+		if f.Type != "" {
+			p.print(": " + f.Type)
+		}
+	}
+	p.print(")")
+	if returnType != "" {
+		p.print(": " + returnType)
+	}
+}
+
+func (p *sourcePrinter) classDecl(c *ClassDeclStmt) {
+	for _, a := range c.Attributes {
+		p.print("// [" + a + "]")
+		p.newline()
+	}
+	if c.Exported {
+		p.print("export ")
+	}
+	p.print("class " + c.Name + " {")
+
+	members := make([]Node, 0, len(c.Fields)+len(c.Functions)+len(c.Getters)+len(c.Setters))
+	for _, f := range c.Fields {
+		members = append(members, f)
+	}
+	for _, f := range c.Functions {
+		members = append(members, f)
+	}
+	for _, f := range c.Getters {
+		members = append(members, f)
+	}
+	for _, f := range c.Setters {
+		members = append(members, f)
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		return before(members[i].Position(), members[j].Position())
+	})
+
+	if len(members) == 0 && !p.hasComments(c.Rbrace) {
+		p.print("}")
+		return
+	}
+
+	p.newline()
+	p.indent++
+	p.fresh = true
+
+	for _, m := range members {
+		p.flush(m.Position())
+		p.space(m.Position().Line)
+
+		switch t := m.(type) {
+		case *VarDeclStmt:
+			if !t.Exported {
+				p.print("private ")
+			}
+			p.varDecl(t)
+
+		case *FuncDeclStmt:
+			if !t.Exported {
+				p.print("private ")
+			}
+			for _, g := range c.Getters {
+				if g == t {
+					p.print("get ")
+				}
+			}
+			for _, s := range c.Setters {
+				if s == t {
+					p.print("set ")
+				}
+			}
+			p.print(t.Name + t.Generic)
+			p.signature(t.Args, t.Variadic, t.ReturnType)
+			p.print(" ")
+			p.block(t.Body)
+		}
+		p.newline()
+	}
+
+	p.flush(c.Rbrace)
+	p.indent--
+	p.print("}")
+}
+
+func (p *sourcePrinter) enumDecl(e *EnumDeclStmt) {
+	if e.Exported {
+		p.print("export ")
+	}
+	p.print("enum " + e.Name + " {")
+
+	if len(e.Values) == 0 && !p.hasComments(e.Rbrace) {
+		p.print("}")
+		return
+	}
+
+	p.newline()
+	p.indent++
+	p.fresh = true
+
+	for _, v := range e.Values {
+		p.flush(v.Pos)
+		p.space(v.Pos.Line)
+		p.print(v.Name)
+		if v.Value != nil {
+			p.print(" = ")
+			p.expr(v.Value, levelLambda)
+		}
+		p.print(",")
+		p.newline()
+	}
+
+	p.flush(e.Rbrace)
+	p.indent--
+	p.print("}")
+}
+
+func (p *sourcePrinter) typeDecl(t *TypeDeclStmt) {
+	lines := strings.Split(formatTokens(t.Tokens, false), "\n")
+	for i, l := range lines {
+		if i > 0 {
+			p.newline()
+		}
+		p.print(l)
+	}
+
+	// the comments inside the declaration are already printed
+	last := t.Tokens[len(t.Tokens)-1].Pos
+	for p.next < len(p.comments) && !before(last, p.comments[p.next].Pos) {
+		p.next++
+	}
+}
+
+func (p *sourcePrinter) forStmt(f *ForStmt) {
+	p.label(f.Label())
+	p.print("for (")
+
+	switch {
+	case f.InExpression != nil || f.OfExpression != nil:
+		d := f.Declaration[0].(*VarDeclStmt)
+		p.print(keyword(d) + " ")
+		p.varDecl(d)
+		if f.OfExpression != nil {
+			p.print(" of ")
+			p.expr(f.OfExpression, levelTernary)
+		} else {
+			p.print(" in ")
+			p.expr(f.InExpression, levelTernary)
+		}
+
+	default:
+		for i, d := range f.Declaration {
+			switch t := d.(type) {
+			case *VarDeclStmt:
+				if i == 0 {
+					p.print(keyword(t) + " ")
+				} else {
+					p.print(", ")
+				}
+				p.varDecl(t)
+			default:
+				p.stmt(d)
+			}
+		}
+		p.print(";")
+		if f.Expression != nil {
+			p.print(" ")
+			p.expr(f.Expression, levelTernary)
+		}
+		p.print(";")
+		if f.Step != nil {
+			p.print(" ")
+			p.stmt(f.Step)
+		}
+	}
+
+	p.print(") ")
+	p.block(f.Body)
+}
+
+func (p *sourcePrinter) switchStmt(s *SwitchStmt) {
+	p.label(s.Label())
+	p.print("switch (")
+	p.expr(s.Expression, levelTernary)
+	p.print(") {")
+	p.newline()
+	p.indent++
+	p.fresh = true
+
+	blocks := s.Blocks
+	if s.Default != nil {
+		blocks = append(blocks[:len(blocks):len(blocks)], s.Default)
+		sort.SliceStable(blocks, func(i, j int) bool {
+			return before(blocks[i].Pos, blocks[j].Pos)
+		})
+	}
+
+	for _, b := range blocks {
+		p.flush(b.Pos)
+		p.space(b.Pos.Line)
+		if b == s.Default {
+			p.print("default:")
+		} else {
+			p.print("case ")
+			p.expr(b.Expression, levelTernary)
+			p.print(":")
+		}
+
+		stmts := b.Stmts
+		if len(stmts) > 0 {
+			// keep a block in the same line: "case 1: {"
+			if block, ok := stmts[0].(*BlockStmt); ok && block.Lbrace.Line == b.Pos.Line {
+				p.print(" ")
+				p.block(block)
+				stmts = stmts[1:]
+			}
+		}
+
+		p.newline()
+		p.indent++
+		p.fresh = true
+		p.stmts(stmts)
+		p.indent--
+	}
+
+	p.flush(s.Rbrace)
+	p.indent--
+	p.print("}")
+}
+
+// level returns the precedence of an expression. It needs parenthesis
+// in a place that only accepts expressions with a higher level.
+func level(e Expr) int {
+	switch t := e.(type) {
+	case *FuncDeclExpr:
+		return levelLambda
+	case *TernaryExpr, *TypeAssertExpr:
+		return levelTernary
+	case *BinaryExpr:
+		return binaryLevel(t.Operator)
+	case *UnaryExpr, *TypeofExpr:
+		return levelUnary
+	case *ConstantExpr:
+		if strings.HasPrefix(t.Value, "-") || strings.HasPrefix(t.Value, "+") {
+			if t.Kind != STRING && t.Kind != RUNE {
+				return levelUnary
+			}
+		}
+		return levelFactor
+	case *MapDeclExpr, *ArrayDeclExpr:
+		return levelFactor
+	default:
+		return levelValue
+	}
+}
+
+// binaryLevel mirrors the precedence of the parser.
+func binaryLevel(op Type) int {
+	switch op {
+	case LOR, NOR, LAND:
+		return 1
+	case AND, XOR, BOR, EQL, NEQ, SEQ, SNE, LSS, LEQ, GTR, GEQ:
+		return 2
+	case ADD, SUB, LSH, RSH:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func (p *sourcePrinter) expr(e Expr, min int) {
+	if level(e) < min {
+		p.print("(")
+		p.expr(e, levelLambda)
+		p.print(")")
+		return
+	}
+
+	switch t := e.(type) {
+	case *IdentExpr:
+		p.print(t.Name + t.TypeArgs)
+
+	case *ConstantExpr:
+		p.print(p.constant(t))
+
+	case *UnaryExpr:
+		p.print(operator(t.Operator))
+		p.expr(t.Operand, levelFactor)
+
+	case *TypeofExpr:
+		p.print("typeof ")
+		p.expr(t.Expr, levelFactor)
+
+	case *TypeAssertExpr:
+		p.expr(t.X, levelUnary)
+		p.print(" as " + t.Type)
+
+	case *BinaryExpr:
+		l := binaryLevel(t.Operator)
+		p.operand(t.Left, t.Operator, l)
+		p.print(" " + operator(t.Operator) + " ")
+		p.operand(t.Right, t.Operator, l+1)
+
+	case *TernaryExpr:
+		p.expr(t.Condition, 2)
+		p.print(" ? ")
+		p.expr(t.Left, levelTernary)
+		p.print(" : ")
+		p.expr(t.Right, levelTernary)
+
+	case *SelectorExpr:
+		p.expr(t.X, levelValue)
+		if t.Optional {
+			p.print("?.")
+		} else {
+			p.print(".")
+		}
+		p.print(t.Sel.Name + t.Sel.TypeArgs)
+
+	case *IndexExpr:
+		p.expr(t.Left, levelValue)
+		if t.Optional {
+			p.print("?.")
+		}
+		p.print("[")
+		p.expr(t.Index, levelTernary)
+		p.print("]")
+
+	case *CallExpr:
+		p.expr(t.Ident, levelValue)
+		if t.Optional {
+			p.print("?.")
+		}
+		p.list("(", ")", t.Args, t.Spread, t.Lparen, t.Rparen)
+
+	case *NewInstanceExpr:
+		p.print("new ")
+		p.expr(t.Name, levelValue)
+		p.list("(", ")", t.Args, t.Spread, t.Lparen, t.Rparen)
+
+	case *ArrayDeclExpr:
+		p.list("[", "]", t.List, false, t.Pos, t.Rbrack)
+
+	case *MapDeclExpr:
+		p.mapDecl(t)
+
+	case *FuncDeclExpr:
+		p.funcDeclExpr(t)
+
+	default:
+		p.err = fmt.Errorf("can't format %T", e)
+	}
+}
+
+// operand prints the operand of a binary expression. The parser gives the
+// same precedence to operators that have a different one in Typescript,
+// like && and ||, so they are grouped with parenthesis when mixed.
+func (p *sourcePrinter) operand(e Expr, op Type, min int) {
+	if b, ok := e.(*BinaryExpr); ok && operatorGroup(b.Operator) != operatorGroup(op) && binaryLevel(b.Operator) <= binaryLevel(op) {
+		min = levelValue
+	}
+	if op == EXP && level(e) == levelUnary {
+		min = levelValue
+	}
+	p.expr(e, min)
+}
+
+func operatorGroup(op Type) Type {
+	switch op {
+	case NEQ, SEQ, SNE:
+		return EQL
+	case LEQ, GTR, GEQ:
+		return LSS
+	case SUB:
+		return ADD
+	case RSH:
+		return LSH
+	case DIV, MOD:
+		return MUL
+	default:
+		return op
+	}
+}
+
+// list prints the arguments of a call or the elements of an array.
+// They are printed one per line if the first one was not in the
+// line of the opening bracket.
+func (p *sourcePrinter) list(open, close string, list []Expr, spread bool, lpos, rpos Position) {
+	p.print(open)
+
+	if len(list) == 0 || list[0].Position().Line == lpos.Line {
+		for i, e := range list {
+			if i > 0 {
+				p.print(", ")
+			}
+			if spread && i == len(list)-1 {
+				p.print("...")
+			}
+			p.expr(e, levelLambda)
+		}
+		p.print(close)
+		return
+	}
+
+	p.newline()
+	p.indent++
+	p.fresh = true
+	for i, e := range list {
+		p.flush(e.Position())
+		p.space(e.Position().Line)
+		if spread && i == len(list)-1 {
+			p.print("...")
+		}
+		p.expr(e, levelLambda)
+		if !spread || i < len(list)-1 {
+			p.print(",")
+		}
+		p.newline()
+	}
+	p.flush(rpos)
+	p.indent--
+	p.print(close)
+}
+
+func (p *sourcePrinter) mapDecl(m *MapDeclExpr) {
+	if len(m.List) == 0 && !p.hasComments(m.Rbrace) {
+		p.print("{}")
+		return
+	}
+
+	if len(m.List) > 0 && m.List[0].Value.Position().Line == m.Pos.Line {
+		p.print("{ ")
+		for i, kv := range m.List {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.keyValue(kv)
+		}
+		p.print(" }")
+		return
+	}
+
+	p.print("{")
+	p.newline()
+	p.indent++
+	p.fresh = true
+	for _, kv := range m.List {
+		pos := kv.Value.Position()
+		p.flush(pos)
+		p.space(pos.Line)
+		p.keyValue(kv)
+		p.print(",")
+		p.newline()
+	}
+	p.flush(m.Rbrace)
+	p.indent--
+	p.print("}")
+}
+
+func (p *sourcePrinter) keyValue(kv KeyValue) {
+	if kv.KeyType == STRING {
+		p.print(quote(kv.Key))
+	} else {
+		p.print(kv.Key)
+	}
+	p.print(": ")
+	p.expr(kv.Value, levelLambda)
+}
+
+func (p *sourcePrinter) funcDeclExpr(f *FuncDeclExpr) {
+	if !f.Lambda {
+		p.print("function ")
+		p.signature(f.Args, f.Variadic, f.ReturnType)
+		p.print(" ")
+		p.block(f.Body)
+		return
+	}
+
+	p.signature(f.Args, f.Variadic, "")
+	p.print(" => ")
+
+	body := f.Body
+	if body.Rbrace.Line == 0 && len(body.List) == 1 {
+		// the body is an expression: "(t) => t * 2"
+		if ret, ok := body.List[0].(*ReturnStmt); ok {
+			if _, ok := ret.Value.(*MapDeclExpr); ok {
+				p.print("(")
+				p.expr(ret.Value, levelLambda)
+				p.print(")")
+			} else {
+				p.expr(ret.Value, levelLambda)
+			}
+			return
+		}
+	}
+
+	p.block(body)
+}
+
+func (p *sourcePrinter) constant(c *ConstantExpr) string {
+	switch c.Kind {
+	case STRING:
+		if p.rawString(c) {
+			return "`" + c.Value + "`"
+		}
+		return quote(c.Value)
+	case RUNE:
+		return "'" + escape(c.Value, '\'') + "'"
+	case INT, FLOAT:
+		return p.number(c)
+	default:
+		return c.Value
+	}
+}
+
+// number returns a number as it was written, with the separators.
+func (p *sourcePrinter) number(c *ConstantExpr) string {
+	i := c.Pos.Line - 1
+	if i < 0 || i >= len(p.lines) || c.Pos.Column >= len(p.lines[i]) {
+		return c.Value
+	}
+	line := p.lines[i]
+	start := c.Pos.Column
+	for start > 0 && strings.IndexByte("0123456789_.", line[start-1]) != -1 {
+		start--
+	}
+	if start > 0 && (line[start-1] == '-' || line[start-1] == '+') {
+		start--
+	}
+	raw := line[start : c.Pos.Column+1]
+	if strings.Replace(raw, "_", "", -1) != c.Value {
+		return c.Value
+	}
+	return raw
+}
+
+// rawString returns true if the string was written between backticks.
+func (p *sourcePrinter) rawString(c *ConstantExpr) bool {
+	if strings.Contains(c.Value, "`") {
+		return false
+	}
+	if strings.Contains(c.Value, "\n") {
+		return true
+	}
+	i := c.Pos.Line - 1
+	if i < 0 || i >= len(p.lines) {
+		return false
+	}
+	line := p.lines[i]
+	return c.Pos.Column < len(line) && line[c.Pos.Column] == '`'
+}
+
+func quote(s string) string {
+	return `"` + escape(s, '"') + `"`
+}
+
+func escape(s string, quote byte) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case quote, '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+func operator(t Type) string {
+	switch t {
+	case ADD:
+		return "+"
+	case SUB:
+		return "-"
+	case MUL:
+		return "*"
+	case DIV:
+		return "/"
+	case MOD:
+		return "%"
+	case EXP:
+		return "**"
+	case AND:
+		return "&"
+	case BOR:
+		return "|"
+	case XOR:
+		return "^"
+	case LSH:
+		return "<<"
+	case RSH:
+		return ">>"
+	case BNT:
+		return "~"
+	case NOT:
+		return "!"
+	case LAND:
+		return "&&"
+	case LOR:
+		return "||"
+	case NOR:
+		return "??"
+	case EQL:
+		return "=="
+	case SEQ:
+		return "==="
+	case NEQ:
+		return "!="
+	case SNE:
+		return "!=="
+	case LSS:
+		return "<"
+	case LEQ:
+		return "<="
+	case GTR:
+		return ">"
+	case GEQ:
+		return ">="
+	default:
+		return t.String()
+	}
+}
+
+// formatTokens prints the tokens of a type or a type declaration.
+// Braces that were multiline in the source are printed one member per
+// line unless inline is set.
+func formatTokens(tokens []*Token, inline bool) string {
+	var b []byte
+	var prev *Token
+	var open []Type      // the brackets that are open
+	var multiline []bool // if the open brace is multiline
+	depth := 0
+
+	atLineStart := func() bool {
+		return len(b) == 0 || b[len(b)-1] == '\n'
+	}
+
+	newline := func() {
+		b = bytes.TrimRight(b, " ")
+		b = append(b, '\n')
+	}
+
+	write := func(s string) {
+		if atLineStart() {
+			for i := 0; i < depth; i++ {
+				b = append(b, "    "...)
+			}
+		}
+		b = append(b, s...)
+	}
+
+	for i, t := range tokens {
+		var next *Token
+		for _, n := range tokens[i+1:] {
+			if !isCommentToken(n) {
+				next = n
+				break
+			}
+		}
+
+		if inline && isCommentToken(t) {
+			continue
+		}
+
+		if !inline && prev != nil {
+			line := t.Pos.Line - strings.Count(t.Str, "\n")
+			if line > prev.Pos.Line {
+				// keep the lines breaks of the source and one empty line between members
+				if !atLineStart() {
+					newline()
+				}
+				if depth > 0 && line > prev.Pos.Line+1 && t.Type != RBRACE && prev.Type != LBRACE {
+					b = append(b, '\n')
+				}
+			} else if isCommentToken(t) && atLineStart() && len(b) > 0 {
+				// a comment at the end of the line
+				b = b[:len(b)-1]
+			}
+		}
+
+		switch t.Type {
+		case COMMENT, MULTILINE_COMMENT:
+			text := "//" + t.Str
+			if t.Type == MULTILINE_COMMENT {
+				text = "/*" + t.Str + "*/"
+			}
+			if !atLineStart() {
+				write(" ")
+			}
+			write(text)
+			newline()
+			prev = t
+			continue
+
+		case SEMICOLON:
+			// the semicolon that ends the declaration
+			if len(open) == 0 {
+				prev = t
+				continue
+			}
+
+		case RBRACE, RPAREN, RBRACK:
+			if len(open) > 0 {
+				if open[len(open)-1] == LBRACE && multiline[len(multiline)-1] && !inline {
+					if !atLineStart() {
+						newline()
+					}
+					depth--
+				}
+				open = open[:len(open)-1]
+				multiline = multiline[:len(multiline)-1]
+			}
+		}
+
+		if needSpace(prev, t, open) && !atLineStart() {
+			write(" ")
+		}
+
+		switch t.Type {
+		case STRING:
+			write(quote(t.Str))
+		case RUNE:
+			write("'" + escape(t.Str, '\'') + "'")
+		default:
+			write(t.Str)
+		}
+
+		switch t.Type {
+		case LBRACE:
+			ml := !inline && next != nil && next.Type != RBRACE && next.Pos.Line > t.Pos.Line
+			open = append(open, LBRACE)
+			multiline = append(multiline, ml)
+			if ml {
+				newline()
+				depth++
+			}
+		case LPAREN, LBRACK:
+			open = append(open, t.Type)
+			multiline = append(multiline, false)
+		case LSS:
+			open = append(open, LSS)
+			multiline = append(multiline, false)
+		case GTR:
+			if len(open) > 0 && open[len(open)-1] == LSS {
+				open = open[:len(open)-1]
+				multiline = multiline[:len(multiline)-1]
+			}
+		}
+
+		prev = t
+	}
+
+	return strings.TrimRight(string(b), " \n")
+}
+
+func needSpace(prev, t *Token, open []Type) bool {
+	if prev == nil {
+		return false
+	}
+
+	switch t.Type {
+	case COMMA, SEMICOLON, RPAREN, RBRACK, COLON, PERIOD, QUESTION:
+		return false
+	case GTR:
+		// closing a generic
+		return len(open) == 0 || open[len(open)-1] != LSS
+	case LBRACK, LPAREN, LSS:
+		switch prev.Type {
+		case IDENT, RBRACK, RPAREN, GTR, QUESTION:
+			return false
+		}
+	case RBRACE:
+		return prev.Type != LBRACE
+	}
+
+	switch prev.Type {
+	case LPAREN, LBRACK, PERIOD, LSS:
+		return false
+	}
+
+	return true
+}
+
+func isCommentToken(t *Token) bool {
+	return t.Type == COMMENT || t.Type == MULTILINE_COMMENT
+}
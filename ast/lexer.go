@@ -173,9 +173,9 @@ const (
 )
 
 type Position struct {
-	FileName string
-	Line     int
-	Column   int
+	FileName string `json:"fileName"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
 }
 
 func (p Position) String() string {
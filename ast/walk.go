@@ -0,0 +1,175 @@
+package ast
+
+// Inspect traverses the node in depth-first order calling f for the
+// node and all its children. If f returns false the children of the
+// node are not visited.
+func Inspect(node Node, f func(Node) bool) {
+	if isNil(node) || !f(node) {
+		return
+	}
+
+	switch t := node.(type) {
+	case *BlockStmt:
+		inspectStmts(t.List, f)
+
+	case *IfStmt:
+		for _, b := range t.IfBlocks {
+			Inspect(b.Condition, f)
+			Inspect(b.Body, f)
+		}
+		Inspect(t.Else, f)
+
+	case *WhileStmt:
+		Inspect(t.Expression, f)
+		Inspect(t.Body, f)
+
+	case *ForStmt:
+		inspectStmts(t.Declaration, f)
+		Inspect(t.Expression, f)
+		Inspect(t.Step, f)
+		Inspect(t.InExpression, f)
+		Inspect(t.OfExpression, f)
+		Inspect(t.Body, f)
+
+	case *SwitchStmt:
+		Inspect(t.Expression, f)
+		for _, b := range t.Blocks {
+			Inspect(b, f)
+		}
+		Inspect(t.Default, f)
+
+	case *CaseBlock:
+		Inspect(t.Expression, f)
+		inspectStmts(t.Stmts, f)
+
+	case *TryStmt:
+		Inspect(t.Body, f)
+		Inspect(t.CatchIdent, f)
+		Inspect(t.Catch, f)
+		Inspect(t.Finally, f)
+
+	case *AsignStmt:
+		Inspect(t.Left, f)
+		// in compound assignments the left side is shared
+		if b, ok := t.Value.(*BinaryExpr); ok && b.Left == t.Left {
+			Inspect(b.Right, f)
+		} else {
+			Inspect(t.Value, f)
+		}
+
+	case *IndexAsignStmt:
+		Inspect(t.IndexExpr, f)
+		Inspect(t.Value, f)
+
+	case *IncStmt:
+		Inspect(t.Left, f)
+
+	case *CallStmt:
+		Inspect(t.CallExpr, f)
+
+	case *TailCallStmt:
+		Inspect(t.CallExpr, f)
+
+	case *ReturnStmt:
+		Inspect(t.Value, f)
+
+	case *ThrowStmt:
+		Inspect(t.Value, f)
+
+	case *VarDeclStmt:
+		Inspect(t.Value, f)
+
+	case *FuncDeclStmt:
+		Inspect(t.Body, f)
+
+	case *ClassDeclStmt:
+		for _, v := range t.Fields {
+			Inspect(v, f)
+		}
+		for _, fn := range t.Functions {
+			Inspect(fn, f)
+		}
+		for _, fn := range t.Getters {
+			Inspect(fn, f)
+		}
+		for _, fn := range t.Setters {
+			Inspect(fn, f)
+		}
+
+	case *FuncDeclExpr:
+		Inspect(t.Body, f)
+
+	case *UnaryExpr:
+		Inspect(t.Operand, f)
+
+	case *BinaryExpr:
+		Inspect(t.Left, f)
+		Inspect(t.Right, f)
+
+	case *TernaryExpr:
+		Inspect(t.Condition, f)
+		Inspect(t.Left, f)
+		Inspect(t.Right, f)
+
+	case *NewInstanceExpr:
+		Inspect(t.Name, f)
+		inspectExprs(t.Args, f)
+
+	case *CallExpr:
+		Inspect(t.Ident, f)
+		inspectExprs(t.Args, f)
+
+	case *TypeAssertExpr:
+		Inspect(t.X, f)
+
+	case *TypeofExpr:
+		Inspect(t.Expr, f)
+
+	case *SelectorExpr:
+		Inspect(t.X, f)
+		Inspect(t.Sel, f)
+
+	case *IndexExpr:
+		Inspect(t.Left, f)
+		Inspect(t.Index, f)
+
+	case *MapDeclExpr:
+		for _, kv := range t.List {
+			Inspect(kv.Value, f)
+		}
+
+	case *ArrayDeclExpr:
+		inspectExprs(t.List, f)
+	}
+}
+
+func inspectStmts(list []Stmt, f func(Node) bool) {
+	for _, s := range list {
+		Inspect(s, f)
+	}
+}
+
+func inspectExprs(list []Expr, f func(Node) bool) {
+	for _, e := range list {
+		Inspect(e, f)
+	}
+}
+
+// isNil reports if the node is nil or a nil pointer of a node type.
+func isNil(node Node) bool {
+	switch t := node.(type) {
+	case nil:
+		return true
+	case *BlockStmt:
+		return t == nil
+	case *CaseBlock:
+		return t == nil
+	case *VarDeclStmt:
+		return t == nil
+	case *CallExpr:
+		return t == nil
+	case *IdentExpr:
+		return t == nil
+	}
+	return false
+}
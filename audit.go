@@ -0,0 +1,254 @@
+package dune
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Audit records the permissions that a program uses. A VM with an audit
+// grants all the permissions so the program runs as if it was trusted:
+//
+//	vm.Audit = dune.NewAudit()
+//	vm.Run()
+//	fmt.Println(vm.Audit.Directive())
+type Audit struct {
+	mu      sync.Mutex
+	records []*AuditRecord
+	index   map[string]*AuditRecord
+}
+
+// AuditRecord is a call that required a permission. Repeated calls from the
+// same position only increase the count.
+type AuditRecord struct {
+	Function   string   `json:"function"`
+	Permission string   `json:"permission"`
+	Arguments  []string `json:"arguments,omitempty"`
+	Position   string   `json:"position,omitempty"`
+	Count      int      `json:"count"`
+}
+
+func NewAudit() *Audit {
+	return &Audit{index: make(map[string]*AuditRecord)}
+}
+
+// auditCall is the native being called while auditing. The permissions
+// that it declares are pending until it checks a capability that
+// covers them or it returns.
+type auditCall struct {
+	function string
+	args     []Value
+	pending  []string
+}
+
+// Records returns the calls that required a permission.
+func (a *Audit) Records() []*AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*AuditRecord{}, a.records...)
+}
+
+// Permissions returns the minimal set of permissions that allow all the
+// recorded calls. Flat permissions are omitted if there are parameterized
+// capabilities that grant them and the resources of the capabilities that
+// can be collapsed are merged, like the files of a directory.
+func (a *Audit) Permissions() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	set := make(map[string]bool)
+	for _, r := range a.records {
+		set[r.Permission] = true
+	}
+
+	var perms []string
+	collapsible := make(map[string][]string)
+	for p := range set {
+		i := strings.Index(p, ":")
+		if i == -1 {
+			if coveredByCapability(p, set) {
+				continue
+			}
+		} else if c, ok := capabilities[p[:i]]; ok && c.Collapse != nil {
+			collapsible[c.Name] = append(collapsible[c.Name], p[i+1:])
+			continue
+		}
+		perms = append(perms, p)
+	}
+
+	for name, resources := range collapsible {
+		for _, r := range capabilities[name].Collapse(resources) {
+			perms = append(perms, name+":"+r)
+		}
+	}
+
+	sort.Strings(perms)
+	return perms
+}
+
+// coveredByCapability reports if a parameterized capability with the
+// name or an alias of the permission is in the set.
+func coveredByCapability(permission string, set map[string]bool) bool {
+	for p := range set {
+		i := strings.Index(p, ":")
+		if i == -1 {
+			continue
+		}
+		name := p[:i]
+		if name == permission {
+			return true
+		}
+		if c, ok := capabilities[name]; ok && containsString(c.Aliases, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Directive returns the permissions as a directive for the program.
+func (a *Audit) Directive() string {
+	perms := a.Permissions()
+	if len(perms) == 0 {
+		return ""
+	}
+	return "// [permissions " + strings.Join(perms, " ") + "]"
+}
+
+// WriteJSON writes the permissions, the directive and the records.
+func (a *Audit) WriteJSON(w io.Writer) error {
+	report := struct {
+		Permissions []string       `json:"permissions"`
+		Directive   string         `json:"directive"`
+		Calls       []*AuditRecord `json:"calls"`
+	}{
+		Permissions: a.Permissions(),
+		Directive:   a.Directive(),
+		Calls:       a.Records(),
+	}
+
+	if report.Permissions == nil {
+		report.Permissions = []string{}
+	}
+	if report.Calls == nil {
+		report.Calls = []*AuditRecord{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(report)
+}
+
+// record adds a use of the permission by the current native.
+func (a *Audit) record(vm *VM, permission string) {
+	var function string
+	var args []Value
+
+	if c := vm.auditing; c != nil {
+		function = c.function
+		args = c.args
+		c.pending = removePending(c.pending, permission)
+	}
+
+	var position string
+	if st := vm.stackTrace(); len(st) > 0 {
+		position = st[0].String()
+	}
+
+	key := function + "\x00" + permission + "\x00" + position
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r, ok := a.index[key]; ok {
+		r.Count++
+		return
+	}
+
+	r := &AuditRecord{
+		Function:   function,
+		Permission: permission,
+		Arguments:  summarizeArgs(args),
+		Position:   position,
+		Count:      1,
+	}
+
+	a.index[key] = r
+	a.records = append(a.records, r)
+}
+
+// removePending removes the declared permissions that the
+// permission or capability that has been checked covers.
+func removePending(pending []string, permission string) []string {
+	name := permission
+	if i := strings.Index(name, ":"); i != -1 {
+		name = name[:i]
+	}
+
+	var aliases []string
+	if c, ok := capabilities[name]; ok {
+		aliases = c.Aliases
+	}
+
+	var list []string
+	for _, p := range pending {
+		if p == name || containsString(aliases, p) {
+			continue
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+// callAudited calls a native while auditing recording the permissions it
+// declares if it doesn't check a more specific capability.
+func (vm *VM) callAudited(f NativeFunction, this Value, args []Value) (Value, error) {
+	parent := vm.auditing
+	c := &auditCall{function: f.Name, args: args, pending: f.Permissions}
+	vm.auditing = c
+
+	ret, err := vm.callNative(f, this, args)
+
+	for _, perm := range c.pending {
+		vm.Audit.record(vm, perm)
+	}
+
+	vm.auditing = parent
+	return ret, err
+}
+
+const maxAuditArg = 60
+
+func summarizeArgs(args []Value) []string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	s := make([]string, len(args))
+	for i, v := range args {
+		switch v.Type {
+		case String:
+			str := v.String()
+			if len(str) > maxAuditArg {
+				str = str[:maxAuditArg] + "..."
+			}
+			s[i] = strconv.Quote(str)
+		case Int, Float, Bool, Rune, Null, Undefined:
+			s[i] = v.String()
+		case Array:
+			s[i] = "array(" + strconv.Itoa(len(v.ToArray())) + ")"
+		case Map:
+			m := v.ToMap()
+			m.RLock()
+			s[i] = "map(" + strconv.Itoa(len(m.Map)) + ")"
+			m.RUnlock()
+		case Bytes:
+			s[i] = "bytes(" + strconv.Itoa(len(v.ToBytes())) + ")"
+		default:
+			s[i] = v.TypeName()
+		}
+	}
+	return s
+}
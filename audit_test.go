@@ -0,0 +1,142 @@
+package dune
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	AddNativeFunc(NativeFunction{
+		Name:        "auditTest.fetch",
+		Arguments:   1,
+		Permissions: []string{"networking"},
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			if err := vm.CheckCapability("net", args[0].String()); err != nil {
+				return NullValue, err
+			}
+			return TrueValue, nil
+		},
+	})
+	AddNativeFunc(NativeFunction{
+		Name:        "auditTest.secret",
+		Permissions: []string{"trusted"},
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return TrueValue, nil
+		},
+	})
+	AddNativeFunc(NativeFunction{
+		Name: "auditTest.free",
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return TrueValue, nil
+		},
+	})
+
+	p, err := CompileStr(`
+		function main() {
+			for (let i = 0; i < 3; i++) {
+				auditTest.fetch("a.com:443")
+			}
+			auditTest.fetch("b.com:80")
+			auditTest.free()
+			return auditTest.secret()
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+	vm.Audit = NewAudit()
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.ToBool() {
+		t.Fatal(v)
+	}
+
+	expected := "// [permissions net:a.com:443 net:b.com:80 trusted]"
+	if d := vm.Audit.Directive(); d != expected {
+		t.Fatalf("expected %s, got %s", expected, d)
+	}
+
+	records := vm.Audit.Records()
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	r := records[0]
+	if r.Function != "auditTest.fetch" || r.Count != 3 || r.Arguments[0] != `"a.com:443"` || !strings.HasSuffix(r.Position, "line 4") {
+		t.Fatalf("%+v", r)
+	}
+
+	var buf bytes.Buffer
+	if err := vm.Audit.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		Permissions []string
+		Calls       []*AuditRecord
+	}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Permissions) != 3 || len(report.Calls) != 3 {
+		t.Fatal(buf.String())
+	}
+
+	// the directive is enough to run the program.
+	p.AddPermission("net:a.com:443")
+	p.AddPermission("net:b.com:80")
+	p.AddPermission("trusted")
+	if _, err := NewVM(p).Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditFlatPermission(t *testing.T) {
+	p, err := CompileStr(`return 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAudit()
+	vm := NewVM(p)
+	a.record(vm, "networking")
+	a.record(vm, "exec")
+	a.record(vm, "net:a.com:443")
+
+	if d := a.Directive(); d != "// [permissions exec net:a.com:443]" {
+		t.Fatal(d)
+	}
+}
+
+func TestAuditCollapsePaths(t *testing.T) {
+	p, err := CompileStr(`return 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAudit()
+	vm := NewVM(p)
+	a.record(vm, "fs.read:/data/a.csv")
+	a.record(vm, "fs.read:/data/b.csv")
+	a.record(vm, "fs.read:/data/reports/today.csv")
+	a.record(vm, "fs.read:/etc/hosts")
+	a.record(vm, "fs.read:/a.txt")
+	a.record(vm, "fs.read:/b.txt")
+	a.record(vm, "fs.write:/tmp/out/a.csv")
+	a.record(vm, "exec:convert")
+	a.record(vm, "exec:zip")
+
+	expected := "// [permissions exec:convert exec:zip fs.read:/a.txt fs.read:/b.txt " +
+		"fs.read:/data fs.read:/etc/hosts fs.write:/tmp/out/a.csv]"
+
+	if d := a.Directive(); d != expected {
+		t.Fatalf("expected %s, got %s", expected, d)
+	}
+}
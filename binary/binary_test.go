@@ -2,6 +2,7 @@ package binary
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/dunelang/dune"
@@ -199,6 +200,42 @@ func TestBinaryNativeLib(t *testing.T) {
 	assertValue(t, 4, p)
 }
 
+func TestBinaryNativeRegistry(t *testing.T) {
+	r := dune.NewNativeRegistry()
+	r.Add(dune.NativeFunction{
+		Name:      "tenant.id",
+		Arguments: 0,
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			return dune.NewInt(7), nil
+		},
+	})
+
+	p, err := dune.CompileStrWith(`
+		function main() {
+			return tenant.id()
+		}
+	`, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+
+	// the native only exists in the registry
+	if _, err := Load(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "tenant.id") {
+		t.Fatal(err)
+	}
+
+	if p, err = LoadWith(buf.Bytes(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	assertValue(t, 7, p)
+}
+
 func TestConstants(t *testing.T) {
 	p := compile(t, `
 		function main() { 
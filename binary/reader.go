@@ -17,8 +17,20 @@ func Load(b []byte) (*dune.Program, error) {
 	return Read(r)
 }
 
+// LoadWith loads a program that uses the natives of the registry.
+func LoadWith(b []byte, natives *dune.NativeRegistry) (*dune.Program, error) {
+	r := bytes.NewReader(b)
+	return ReadWith(r, natives)
+}
+
 func Read(r io.Reader) (*dune.Program, error) {
-	p := &dune.Program{}
+	return ReadWith(r, nil)
+}
+
+// ReadWith reads a program that uses the natives of the registry.
+// Its native functions are resolved by name in the registry.
+func ReadWith(r io.Reader, natives *dune.NativeRegistry) (*dune.Program, error) {
+	p := &dune.Program{Natives: natives}
 
 	iKey, err := readInt32(r)
 	if err != nil {
@@ -359,7 +371,7 @@ func readFunctions(r io.Reader, key byte, p *dune.Program) error {
 		if f.Closures, err = readRegisters(r, key); err != nil {
 			return err
 		}
-		if f.Instructions, err = readInstructions(r, key); err != nil {
+		if f.Instructions, err = readInstructions(r, key, p); err != nil {
 			return err
 		}
 		if f.Positions, err = readPositions(r); err != nil {
@@ -464,7 +476,7 @@ func readRegisters(r io.Reader, key byte) ([]*dune.Register, error) {
 	return regs, nil
 }
 
-func readInstructions(r io.Reader, key byte) ([]*dune.Instruction, error) {
+func readInstructions(r io.Reader, key byte, p *dune.Program) ([]*dune.Instruction, error) {
 	s, err := readSection(r)
 	if err != nil {
 		return nil, err
@@ -486,19 +498,19 @@ func readInstructions(r io.Reader, key byte) ([]*dune.Instruction, error) {
 			return nil, err
 		}
 
-		addr, err := readAddress(r, key)
+		addr, err := readAddress(r, key, p)
 		if err != nil {
 			return nil, err
 		}
 		instr.A = addr
 
-		addr, err = readAddress(r, key)
+		addr, err = readAddress(r, key, p)
 		if err != nil {
 			return nil, err
 		}
 		instr.B = addr
 
-		addr, err = readAddress(r, key)
+		addr, err = readAddress(r, key, p)
 		if err != nil {
 			return nil, err
 		}
@@ -508,7 +520,7 @@ func readInstructions(r io.Reader, key byte) ([]*dune.Instruction, error) {
 	return instrs, nil
 }
 
-func readAddress(r io.Reader, key byte) (*dune.Address, error) {
+func readAddress(r io.Reader, key byte, p *dune.Program) (*dune.Address, error) {
 	a := &dune.Address{}
 
 	if err := binary.Read(r, binary.BigEndian, &a.Kind); err != nil {
@@ -520,7 +532,7 @@ func readAddress(r io.Reader, key byte) (*dune.Address, error) {
 		if err != nil {
 			return nil, err
 		}
-		f, ok := dune.NativeFuncFromName(v)
+		f, ok := p.NativeFuncFromName(v)
 		if !ok {
 			return nil, fmt.Errorf("invalid native function %s", v)
 		}
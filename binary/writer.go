@@ -32,7 +32,7 @@ func Write(w io.Writer, p *dune.Program) error {
 		return err
 	}
 
-	if err := writeFunctions(w, p.Functions, key); err != nil {
+	if err := writeFunctions(w, p, key); err != nil {
 		return err
 	}
 
@@ -233,7 +233,8 @@ func writeClassFields(w io.Writer, fields []*dune.Field, key byte) error {
 	return nil
 }
 
-func writeFunctions(w io.Writer, funcs []*dune.Function, key byte) error {
+func writeFunctions(w io.Writer, p *dune.Program, key byte) error {
+	funcs := p.Functions
 	if err := writeSection(w, section_functions, len(funcs)); err != nil {
 		return err
 	}
@@ -284,7 +285,7 @@ func writeFunctions(w io.Writer, funcs []*dune.Function, key byte) error {
 		if err := writeRegisters(w, f.Closures, key); err != nil {
 			return err
 		}
-		if err := writeInstructions(w, f.Instructions, key); err != nil {
+		if err := writeInstructions(w, p, f.Instructions, key); err != nil {
 			return err
 		}
 		if err := writePositions(w, f.Positions); err != nil {
@@ -295,7 +296,7 @@ func writeFunctions(w io.Writer, funcs []*dune.Function, key byte) error {
 	return nil
 }
 
-func writeInstructions(w io.Writer, ins []*dune.Instruction, key byte) error {
+func writeInstructions(w io.Writer, p *dune.Program, ins []*dune.Instruction, key byte) error {
 	if err := writeSection(w, section_instructions, len(ins)); err != nil {
 		return err
 	}
@@ -304,13 +305,13 @@ func writeInstructions(w io.Writer, ins []*dune.Instruction, key byte) error {
 		if err := binary.Write(w, binary.BigEndian, byte(i.Opcode)); err != nil {
 			return err
 		}
-		if err := writeAddress(w, i.A, key); err != nil {
+		if err := writeAddress(w, p, i.A, key); err != nil {
 			return err
 		}
-		if err := writeAddress(w, i.B, key); err != nil {
+		if err := writeAddress(w, p, i.B, key); err != nil {
 			return err
 		}
-		if err := writeAddress(w, i.C, key); err != nil {
+		if err := writeAddress(w, p, i.C, key); err != nil {
 			return err
 		}
 	}
@@ -318,13 +319,16 @@ func writeInstructions(w io.Writer, ins []*dune.Instruction, key byte) error {
 	return nil
 }
 
-func writeAddress(w io.Writer, a *dune.Address, key byte) error {
+func writeAddress(w io.Writer, p *dune.Program, a *dune.Address, key byte) error {
 	if err := binary.Write(w, binary.BigEndian, byte(a.Kind)); err != nil {
 		return err
 	}
 
 	if a.Kind == dune.AddrNativeFunc {
-		f := dune.NativeFuncFromIndex(int(a.Value))
+		f, err := p.NativeFunc(int(a.Value))
+		if err != nil {
+			return err
+		}
 		if err := writeString(w, f.Name, key); err != nil {
 			return err
 		}
@@ -0,0 +1,751 @@
+package dune
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Bind returns a value that exposes a Go value to the scripts. Pointers to
+// structs are native objects with the exported fields and methods in lower
+// camel case: Name is name, HTTPServer is httpServer. A `dune:"name"` tag
+// renames a field and `dune:"-"` hides it.
+//
+// Slices and arrays are converted to arrays, maps to maps and functions to
+// native methods. Methods that return an error as the last value throw it.
+func Bind(v interface{}) Value {
+	return goToValue(reflect.ValueOf(v))
+}
+
+// Converter maps a Go type that is not converted automatically, like
+// time.Time that is an object of the time library.
+type Converter struct {
+	TypeDef   string // the type in the declarations
+	ToValue   func(v interface{}) Value
+	FromValue func(v Value) (interface{}, error)
+}
+
+var converters = map[reflect.Type]*Converter{}
+
+// RegisterConverter sets the conversion of the type of v.
+func RegisterConverter(v interface{}, c *Converter) {
+	converters[reflect.TypeOf(v)] = c
+}
+
+var (
+	valueType = reflect.TypeOf(Value{})
+	vmType    = reflect.TypeOf(&VM{})
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+// Bindings collects Go functions and types to register them as natives
+// with their declarations:
+//
+//	b := &dune.Bindings{}
+//	b.Func("billing.invoice", billing.GetInvoice)
+//	b.Type(&billing.Invoice{})
+//	b.Register()
+type Bindings struct {
+	funcs []NativeFunction
+	decls []bindFunc
+	types []reflect.Type
+}
+
+type bindFunc struct {
+	name string
+	fn   reflect.Type
+}
+
+// Func adds a native function that calls fn converting the arguments and the
+// results. If the first parameter is a *VM it receives the calling VM.
+func (b *Bindings) Func(name string, fn interface{}) {
+	b.funcs = append(b.funcs, BindFunc(name, fn))
+	b.decls = append(b.decls, bindFunc{name, reflect.TypeOf(fn)})
+}
+
+// Type adds the declaration of the type of v. The types of its fields
+// and methods are added too.
+func (b *Bindings) Type(v interface{}) {
+	b.types = append(b.types, reflect.TypeOf(v))
+}
+
+// Natives returns the functions added with Func.
+func (b *Bindings) Natives() []NativeFunction {
+	return b.funcs
+}
+
+// Register adds the natives and their declarations to the global ones.
+func (b *Bindings) Register() {
+	RegisterLib(b.funcs, b.TypeDefs())
+}
+
+// TypeDefs returns the declarations of the functions and types.
+func (b *Bindings) TypeDefs() string {
+	w := &dtsWriter{declared: make(map[reflect.Type]bool)}
+
+	namespaces := make(map[string][]string)
+	var order []string
+
+	for _, d := range b.decls {
+		ns := ""
+		name := d.name
+		if i := strings.LastIndex(name, "."); i != -1 {
+			ns, name = name[:i], name[i+1:]
+		}
+		if _, ok := namespaces[ns]; !ok {
+			order = append(order, ns)
+		}
+		namespaces[ns] = append(namespaces[ns], "function "+name+w.signature(d.fn, false))
+	}
+
+	for _, t := range b.types {
+		w.typeName(t)
+	}
+
+	var sb strings.Builder
+
+	for _, ns := range order {
+		if ns == "" {
+			for _, f := range namespaces[ns] {
+				sb.WriteString("declare " + f + "\n")
+			}
+			continue
+		}
+		sb.WriteString("declare namespace " + ns + " {\n")
+		for _, f := range namespaces[ns] {
+			sb.WriteString("    export " + f + "\n")
+		}
+		sb.WriteString("}\n")
+	}
+
+	// the types are declared last because they are found while
+	// writing the signatures
+	for _, i := range w.interfaces {
+		sb.WriteString("\n" + i)
+	}
+
+	return sb.String()
+}
+
+// BindFunc returns a native function that calls fn converting the arguments
+// and the results. If the first parameter is a *VM it receives the calling VM.
+func BindFunc(name string, fn interface{}) NativeFunction {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func {
+		panic(fmt.Sprintf("BindFunc %s: expected a function, got %T", name, fn))
+	}
+
+	m := bindMethod(f)
+
+	args := -1
+	if t := f.Type(); !t.IsVariadic() {
+		args = t.NumIn()
+		if args > 0 && t.In(0) == vmType {
+			args--
+		}
+	}
+
+	return NativeFunction{
+		Name:      name,
+		Arguments: args,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return m(args, vm)
+		},
+	}
+}
+
+// bindMethod returns a native method that calls the function.
+func bindMethod(f reflect.Value) NativeMethod {
+	t := f.Type()
+
+	return func(args []Value, vm *VM) (Value, error) {
+		var in []reflect.Value
+
+		params := t.NumIn()
+		first := 0
+		if params > 0 && t.In(0) == vmType {
+			in = append(in, reflect.ValueOf(vm))
+			first = 1
+		}
+
+		fixed := params - first
+		if t.IsVariadic() {
+			fixed--
+			if len(args) < fixed {
+				return NullValue, fmt.Errorf("expected at least %d arguments, got %d", fixed, len(args))
+			}
+		} else if len(args) != fixed {
+			return NullValue, fmt.Errorf("expected %d arguments, got %d", fixed, len(args))
+		}
+
+		for i, a := range args {
+			var pt reflect.Type
+			if i < fixed {
+				pt = t.In(first + i)
+			} else {
+				pt = t.In(params - 1).Elem()
+			}
+
+			v, err := valueToGo(a, pt)
+			if err != nil {
+				return NullValue, fmt.Errorf("argument %d: %w", i+1, err)
+			}
+			in = append(in, v)
+		}
+
+		out := f.Call(in)
+
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err := out[n-1].Interface(); err != nil {
+				return NullValue, err.(error)
+			}
+			out = out[:n-1]
+		}
+
+		switch len(out) {
+		case 0:
+			return NullValue, nil
+		case 1:
+			return goToValue(out[0]), nil
+		default:
+			values := make([]Value, len(out))
+			for i, o := range out {
+				values[i] = goToValue(o)
+			}
+			return NewArrayValues(values), nil
+		}
+	}
+}
+
+// boundObject exposes a pointer to a struct.
+type boundObject struct {
+	v reflect.Value
+	t *bindType
+}
+
+func (o *boundObject) Type() string {
+	return o.t.name
+}
+
+func (o *boundObject) Export(recursionLevel int) interface{} {
+	return o.v.Interface()
+}
+
+func (o *boundObject) GetMethod(name string) NativeMethod {
+	m, ok := o.t.methods[name]
+	if !ok {
+		return nil
+	}
+	return bindMethod(o.v.MethodByName(m))
+}
+
+func (o *boundObject) GetField(name string, vm *VM) (Value, error) {
+	index, ok := o.t.fields[name]
+	if !ok {
+		return UndefinedValue, nil
+	}
+	return goToValue(o.v.Elem().FieldByIndex(index)), nil
+}
+
+func (o *boundObject) SetField(name string, v Value, vm *VM) error {
+	index, ok := o.t.fields[name]
+	if !ok {
+		return fmt.Errorf("readonly or undefined property '%s'", name)
+	}
+
+	f := o.v.Elem().FieldByIndex(index)
+
+	gv, err := valueToGo(v, f.Type())
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	f.Set(gv)
+	return nil
+}
+
+// bindType has the names in the scripts of the fields
+// and methods of a struct.
+type bindType struct {
+	name        string
+	fields      map[string][]int
+	methods     map[string]string
+	fieldNames  []string
+	methodNames []string
+}
+
+var bindTypes = struct {
+	sync.Mutex
+	m map[reflect.Type]*bindType
+}{m: make(map[reflect.Type]*bindType)}
+
+// getBindType returns the fields and methods of a pointer to a struct.
+func getBindType(t reflect.Type) *bindType {
+	bindTypes.Lock()
+	defer bindTypes.Unlock()
+
+	if b, ok := bindTypes.m[t]; ok {
+		return b
+	}
+
+	b := &bindType{
+		name:    t.Elem().Name(),
+		fields:  make(map[string][]int),
+		methods: make(map[string]string),
+	}
+
+	b.addFields(t.Elem(), nil)
+
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		name := scriptName(m.Name)
+		b.methods[name] = m.Name
+		b.methodNames = append(b.methodNames, name)
+	}
+
+	bindTypes.m[t] = b
+	return b
+}
+
+func (b *bindType) addFields(t reflect.Type, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			b.addFields(f.Type, fieldIndex)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := scriptName(f.Name)
+		if tag := f.Tag.Get("dune"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+
+		if _, ok := b.fields[name]; ok {
+			continue // shadowed by an outer field
+		}
+
+		b.fields[name] = fieldIndex
+		b.fieldNames = append(b.fieldNames, name)
+	}
+}
+
+// scriptName converts a Go name to lower camel case.
+func scriptName(name string) string {
+	r := []rune(name)
+
+	i := 0
+	for i < len(r) && unicode.IsUpper(r[i]) {
+		i++
+	}
+
+	switch {
+	case i == 0:
+		return name
+	case i == 1 || i == len(r):
+		// Name -> name, ID -> id
+	default:
+		// HTTPServer -> httpServer
+		i--
+	}
+
+	for j := 0; j < i; j++ {
+		r[j] = unicode.ToLower(r[j])
+	}
+	return string(r)
+}
+
+// goToValue converts a Go value to a Value.
+func goToValue(v reflect.Value) Value {
+	if !v.IsValid() {
+		return NullValue
+	}
+
+	t := v.Type()
+
+	if t == valueType {
+		return v.Interface().(Value)
+	}
+
+	if c, ok := converters[t]; ok {
+		return c.ToValue(v.Interface())
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return NewBool(v.Bool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInt64(v.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NewInt64(int64(v.Uint()))
+
+	case reflect.Float32, reflect.Float64:
+		return NewFloat(v.Float())
+
+	case reflect.String:
+		return NewString(v.String())
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return NullValue
+		}
+		if t == bytesType {
+			return NewBytes(v.Bytes())
+		}
+		fallthrough
+
+	case reflect.Array:
+		values := make([]Value, v.Len())
+		for i := range values {
+			values[i] = goToValue(v.Index(i))
+		}
+		return NewArrayValues(values)
+
+	case reflect.Map:
+		if v.IsNil() {
+			return NullValue
+		}
+		m := make(map[Value]Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[goToValue(iter.Key())] = goToValue(iter.Value())
+		}
+		return NewMapValues(m)
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return NullValue
+		}
+		if t.Elem().Kind() == reflect.Struct {
+			return NewObject(&boundObject{v: v, t: getBindType(t)})
+		}
+		return goToValue(v.Elem())
+
+	case reflect.Struct:
+		// bind a copy so it can be modified
+		p := reflect.New(t)
+		p.Elem().Set(v)
+		return NewObject(&boundObject{v: p, t: getBindType(p.Type())})
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return NullValue
+		}
+		if err, ok := v.Interface().(error); ok {
+			return NewString(err.Error())
+		}
+		return goToValue(v.Elem())
+
+	case reflect.Func:
+		if v.IsNil() {
+			return NullValue
+		}
+		return NewObject(bindMethod(v))
+
+	default:
+		return NewObject(v.Interface())
+	}
+}
+
+// valueToGo converts a Value to a Go value of the type t.
+func valueToGo(v Value, t reflect.Type) (reflect.Value, error) {
+	if t == valueType {
+		return reflect.ValueOf(v), nil
+	}
+
+	if c, ok := converters[t]; ok {
+		if v.IsNil() {
+			return reflect.Zero(t), nil
+		}
+		i, err := c.FromValue(v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(i), nil
+	}
+
+	// the object is the value itself
+	if v.Type == Object {
+		switch o := v.ToObject().(type) {
+		case *boundObject:
+			if o.v.Type() == t {
+				return o.v, nil
+			}
+			if o.v.Type().Elem() == t {
+				return o.v.Elem(), nil
+			}
+		default:
+			if ov := reflect.ValueOf(o); ov.Type().AssignableTo(t) {
+				return ov, nil
+			}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Type != Bool {
+			return reflect.Value{}, typeError(v, t)
+		}
+		return reflect.ValueOf(v.ToBool()).Convert(t), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch v.Type {
+		case Int, Rune:
+			return reflect.ValueOf(v.ToInt()).Convert(t), nil
+		case Float:
+			return reflect.ValueOf(v.ToFloat()).Convert(t), nil
+		}
+		return reflect.Value{}, typeError(v, t)
+
+	case reflect.Float32, reflect.Float64:
+		switch v.Type {
+		case Int, Float:
+			return reflect.ValueOf(v.ToFloat()).Convert(t), nil
+		}
+		return reflect.Value{}, typeError(v, t)
+
+	case reflect.String:
+		switch v.Type {
+		case String, Rune:
+			return reflect.ValueOf(v.String()).Convert(t), nil
+		}
+		return reflect.Value{}, typeError(v, t)
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.Zero(t), nil
+		}
+		if t == bytesType && (v.Type == Bytes || v.Type == String) {
+			return reflect.ValueOf(v.ToBytes()), nil
+		}
+		if v.Type != Array {
+			return reflect.Value{}, typeError(v, t)
+		}
+		values := v.ToArray()
+		s := reflect.MakeSlice(t, len(values), len(values))
+		for i, item := range values {
+			iv, err := valueToGo(item, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("[%d]: %w", i, err)
+			}
+			s.Index(i).Set(iv)
+		}
+		return s, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return reflect.Zero(t), nil
+		}
+		if v.Type != Map {
+			return reflect.Value{}, typeError(v, t)
+		}
+		mv := v.ToMap()
+		mv.RLock()
+		defer mv.RUnlock()
+		m := reflect.MakeMapWithSize(t, len(mv.Map))
+		for k, item := range mv.Map {
+			kv, err := valueToGo(k, t.Key())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %v: %w", k, err)
+			}
+			iv, err := valueToGo(item, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("%v: %w", k, err)
+			}
+			m.SetMapIndex(kv, iv)
+		}
+		return m, nil
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return reflect.Zero(t), nil
+		}
+		ev, err := valueToGo(v, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(ev)
+		return p, nil
+
+	case reflect.Struct:
+		if v.Type != Map {
+			return reflect.Value{}, typeError(v, t)
+		}
+		b := getBindType(reflect.PtrTo(t))
+		s := reflect.New(t).Elem()
+		mv := v.ToMap()
+		mv.RLock()
+		defer mv.RUnlock()
+		for k, item := range mv.Map {
+			index, ok := b.fields[k.String()]
+			if !ok {
+				continue
+			}
+			f := s.FieldByIndex(index)
+			fv, err := valueToGo(item, f.Type())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("%v: %w", k, err)
+			}
+			f.Set(fv)
+		}
+		return s, nil
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return reflect.Zero(t), nil
+		}
+		if t.NumMethod() == 0 {
+			if e := v.Export(0); e != nil {
+				return reflect.ValueOf(e), nil
+			}
+			return reflect.Zero(t), nil
+		}
+	}
+
+	return reflect.Value{}, typeError(v, t)
+}
+
+func typeError(v Value, t reflect.Type) error {
+	return fmt.Errorf("expected %s, got %s", tsType(t), v.TypeName())
+}
+
+// tsType returns the name of the type without declaring it.
+func tsType(t reflect.Type) string {
+	w := &dtsWriter{declared: make(map[reflect.Type]bool)}
+	return w.typeName(t)
+}
+
+// dtsWriter writes the TypeScript declarations of Go types.
+type dtsWriter struct {
+	declared   map[reflect.Type]bool
+	interfaces []string
+}
+
+func (w *dtsWriter) typeName(t reflect.Type) string {
+	if t == valueType {
+		return "any"
+	}
+
+	if c, ok := converters[t]; ok {
+		return c.TypeDef
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "byte[]"
+		}
+		return w.typeName(t.Elem()) + "[]"
+	case reflect.Map:
+		return "Map<" + w.typeName(t.Elem()) + ">"
+	case reflect.Ptr:
+		return w.typeName(t.Elem())
+	case reflect.Struct:
+		if t.Name() == "" {
+			return "any"
+		}
+		w.declare(t)
+		return t.Name()
+	case reflect.Func:
+		return "Function"
+	default:
+		return "any"
+	}
+}
+
+// declare writes the interface of the struct with its fields and the
+// methods of the pointer.
+func (w *dtsWriter) declare(t reflect.Type) {
+	if w.declared[t] {
+		return
+	}
+	w.declared[t] = true
+
+	pt := reflect.PtrTo(t)
+	b := getBindType(pt)
+
+	var lines []string
+
+	fields := append([]string{}, b.fieldNames...)
+	sort.Strings(fields)
+	for _, name := range fields {
+		f := t.FieldByIndex(b.fields[name])
+		lines = append(lines, "    "+name+": "+w.typeName(f.Type))
+	}
+
+	methods := append([]string{}, b.methodNames...)
+	sort.Strings(methods)
+	for _, name := range methods {
+		m, _ := pt.MethodByName(b.methods[name])
+		lines = append(lines, "    "+name+w.signature(m.Type, true))
+	}
+
+	decl := "interface " + t.Name() + " {\n" + strings.Join(lines, "\n") + "\n}\n"
+	w.interfaces = append(w.interfaces, decl)
+}
+
+// signature returns the parameters and the return type of a function.
+func (w *dtsWriter) signature(t reflect.Type, method bool) string {
+	var params []string
+
+	first := 0
+	if method {
+		first = 1 // the receiver
+	}
+	if t.NumIn() > first && t.In(first) == vmType {
+		first++
+	}
+
+	for i := first; i < t.NumIn(); i++ {
+		name := fmt.Sprintf("arg%d", i-first+1)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			params = append(params, "..."+name+": "+w.typeName(t.In(i)))
+		} else {
+			params = append(params, name+": "+w.typeName(t.In(i)))
+		}
+	}
+
+	var results []string
+	for i := 0; i < t.NumOut(); i++ {
+		if i == t.NumOut()-1 && t.Out(i) == errorType {
+			break
+		}
+		results = append(results, w.typeName(t.Out(i)))
+	}
+
+	ret := "void"
+	switch len(results) {
+	case 0:
+	case 1:
+		ret = results[0]
+	default:
+		ret = "any[]"
+	}
+
+	return "(" + strings.Join(params, ", ") + "): " + ret
+}
@@ -0,0 +1,170 @@
+package dune
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type bindAddress struct {
+	City string
+}
+
+type bindBase struct {
+	ID int
+}
+
+type bindPerson struct {
+	bindBase
+	Name    string
+	Tags    []string
+	Scores  map[string]float64
+	Address *bindAddress
+	Secret  string `dune:"-"`
+	Nick    string `dune:"alias"`
+	private int
+}
+
+func (p *bindPerson) Greet(other *bindPerson) string {
+	return "hi " + other.Name + ", I'm " + p.Name
+}
+
+func (p *bindPerson) Rename(name string) error {
+	if name == "" {
+		return errors.New("empty name")
+	}
+	p.Name = name
+	return nil
+}
+
+func (p *bindPerson) Sum(values ...int) int {
+	var total int
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func TestScriptName(t *testing.T) {
+	data := map[string]string{
+		"Name":       "name",
+		"ID":         "id",
+		"HTTPServer": "httpServer",
+		"getWd":      "getWd",
+	}
+
+	for k, v := range data {
+		if s := scriptName(k); s != v {
+			t.Errorf("%s: expected %s, got %s", k, v, s)
+		}
+	}
+}
+
+func TestBind(t *testing.T) {
+	b := &Bindings{}
+	b.Func("bindTest.newPerson", func(name string) *bindPerson {
+		return &bindPerson{
+			bindBase: bindBase{ID: 7},
+			Name:     name,
+			Tags:     []string{"a", "b"},
+			Scores:   map[string]float64{"math": 9.5},
+			Address:  &bindAddress{City: "Madrid"},
+			Secret:   "x",
+			Nick:     "nick",
+		}
+	})
+	b.Func("bindTest.count", func(p *bindPerson) int {
+		return len(p.Tags)
+	})
+	b.Func("bindTest.fail", func() (string, error) {
+		return "", fmt.Errorf("failed")
+	})
+	for _, f := range b.Natives() {
+		AddNativeFunc(f)
+	}
+
+	data := []struct {
+		code     string
+		expected string
+	}{
+		{`return bindTest.newPerson("ann").name`, "ann"},
+		{`return bindTest.newPerson("ann").id`, "7"},
+		{`return bindTest.newPerson("ann").tags[1]`, "b"},
+		{`return bindTest.newPerson("ann").scores.math`, "9.5"},
+		{`return bindTest.newPerson("ann").address.city`, "Madrid"},
+		{`return bindTest.newPerson("ann").secret`, "undefined"},
+		{`return bindTest.newPerson("ann").alias`, "nick"},
+		{`return bindTest.newPerson("ann").greet(bindTest.newPerson("bob"))`, "hi bob, I'm ann"},
+		{`return bindTest.newPerson("ann").sum(1, 2, 3)`, "6"},
+		{`let p = bindTest.newPerson("ann"); p.rename("eve"); return p.name`, "eve"},
+		{`let p = bindTest.newPerson("ann"); p.tags = ["x"]; return bindTest.count(p)`, "1"},
+	}
+
+	for _, d := range data {
+		p, err := CompileStr(d.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := NewVM(p).Run()
+		if err != nil {
+			t.Fatalf("%s: %v", d.code, err)
+		}
+		if v.String() != d.expected {
+			t.Fatalf("%s: expected %s, got %v", d.code, d.expected, v)
+		}
+	}
+
+	errs := []struct {
+		code string
+		err  string
+	}{
+		{`bindTest.newPerson("ann").rename("")`, "empty name"},
+		{`bindTest.fail()`, "failed"},
+		{`bindTest.newPerson(1)`, "argument 1: expected string, got int"},
+		{`bindTest.newPerson("ann").tags = [1]`, "tags: [0]: expected string, got int"},
+	}
+
+	for _, d := range errs {
+		p, err := CompileStr(d.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewVM(p).Run()
+		if err == nil || !strings.Contains(err.Error(), d.err) {
+			t.Fatalf("%s: expected %q, got %v", d.code, d.err, err)
+		}
+	}
+}
+
+func TestBindTypeDefs(t *testing.T) {
+	b := &Bindings{}
+	b.Func("bindTest.newPerson", func(name string) *bindPerson { return nil })
+	b.Func("bindTest.list", func(vm *VM, limit int) ([]*bindAddress, error) { return nil, nil })
+
+	expected := `declare namespace bindTest {
+    export function newPerson(arg1: string): bindPerson
+    export function list(arg1: number): bindAddress[]
+}
+
+interface bindAddress {
+    city: string
+}
+
+interface bindPerson {
+    address: bindAddress
+    alias: string
+    id: number
+    name: string
+    scores: Map<number>
+    tags: string[]
+    greet(arg1: bindPerson): string
+    rename(arg1: string): void
+    sum(...arg1: number[]): number
+}
+`
+
+	if s := b.TypeDefs(); s != expected {
+		t.Fatalf("got:\n%s", s)
+	}
+}
@@ -0,0 +1,225 @@
+package dune
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Capability is a permission that can be granted for some resources only
+// with a parameter after a colon:
+//
+//	// [permissions net:api.partner.com:443 fs.read:/data exec:convert]
+//
+// A grant without parameter, one of the aliases or "trusted" allow all.
+type Capability struct {
+	Name    string
+	Aliases []string
+
+	// Match reports if the resource is covered by the pattern of a grant.
+	Match func(pattern, resource string) bool
+
+	// Collapse merges the resources recorded by an audit into fewer
+	// grants. It is optional.
+	Collapse func(resources []string) []string
+}
+
+var capabilities = map[string]*Capability{}
+
+// RegisterCapability adds or replaces a parameterized capability.
+func RegisterCapability(c *Capability) {
+	capabilities[c.Name] = c
+}
+
+func init() {
+	RegisterCapability(&Capability{Name: "net", Aliases: []string{"networking"}, Match: matchHost})
+	RegisterCapability(&Capability{Name: "fs.read", Match: matchPath, Collapse: collapsePaths})
+	RegisterCapability(&Capability{Name: "fs.write", Match: matchPath, Collapse: collapsePaths})
+	RegisterCapability(&Capability{Name: "exec", Match: matchExact})
+}
+
+// CapabilityError is returned when a native is called without the
+// capability that grants access to the resource.
+type CapabilityError struct {
+	Capability string
+	Resource   string
+}
+
+func (e *CapabilityError) Error() string {
+	if e.Resource == "" {
+		return "unauthorized: missing permission " + e.Capability
+	}
+	return fmt.Sprintf("unauthorized: missing capability %s:%s", e.Capability, e.Resource)
+}
+
+// CheckCapability returns an error if the program or the current function
+// don't have a grant of the capability that covers the resource.
+func (vm *VM) CheckCapability(name, resource string) error {
+	if vm.Audit != nil {
+		vm.Audit.record(vm, name+":"+resource)
+		return nil
+	}
+
+	if vm.hasPermission(name) {
+		return nil
+	}
+
+	c := capabilities[name]
+	if c != nil {
+		for _, alias := range c.Aliases {
+			if vm.hasPermission(alias) {
+				return nil
+			}
+		}
+	}
+
+	for _, pattern := range vm.Grants(name) {
+		if c != nil && c.Match(pattern, resource) {
+			return nil
+		}
+	}
+
+	return &CapabilityError{Capability: name, Resource: resource}
+}
+
+// Grants returns the parameters of the grants of the capability. They are
+// empty if it has only been granted without parameters.
+func (vm *VM) Grants(name string) []string {
+	prefix := name + ":"
+
+	var grants []string
+	for _, perms := range [][]string{vm.Program.Permissions(), vm.CurrentFunc().Permissions()} {
+		for _, v := range perms {
+			if strings.HasPrefix(v, prefix) {
+				grants = append(grants, v[len(prefix):])
+			}
+		}
+	}
+	return grants
+}
+
+// hasGrantFor reports if a capability that is the permission or has it
+// as an alias has been granted. If it has parameters the native must then
+// check them against its arguments.
+func (vm *VM) hasGrantFor(permission string) bool {
+	for _, c := range capabilities {
+		if c.Name != permission && !containsString(c.Aliases, permission) {
+			continue
+		}
+		if vm.hasPermission(c.Name) || len(vm.Grants(c.Name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost matches "host:port" with "host", "host:port", "*.domain" and
+// "host:*". A pattern without port allows any port.
+func matchHost(pattern, resource string) bool {
+	host, port := splitHostPort(resource)
+	pHost, pPort := splitHostPort(pattern)
+
+	if pPort != "" && pPort != "*" && pPort != port {
+		return false
+	}
+
+	host = strings.ToLower(host)
+	pHost = strings.ToLower(pHost)
+
+	if pHost == "*" || pHost == host {
+		return true
+	}
+
+	if strings.HasPrefix(pHost, "*.") {
+		return strings.HasSuffix(host, pHost[1:])
+	}
+
+	return false
+}
+
+func splitHostPort(s string) (string, string) {
+	if strings.HasPrefix(s, "[") {
+		// [ipv6]:port
+		i := strings.Index(s, "]")
+		if i == -1 {
+			return s, ""
+		}
+		return s[1:i], strings.TrimPrefix(s[i+1:], ":")
+	}
+
+	if strings.Count(s, ":") > 1 {
+		// ipv6 without port
+		return s, ""
+	}
+
+	i := strings.LastIndex(s, ":")
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// matchPath matches the pattern and all the paths under it.
+func matchPath(pattern, resource string) bool {
+	pattern = path.Clean(pattern)
+	resource = path.Clean(resource)
+
+	if pattern == "/" || pattern == resource {
+		return true
+	}
+	return strings.HasPrefix(resource, pattern+"/")
+}
+
+// collapsePaths replaces the paths of the same directory with the directory,
+// except the root, and removes the paths that are under another one.
+func collapsePaths(paths []string) []string {
+	set := make(map[string]bool, len(paths))
+	dirs := make(map[string]int)
+	for _, p := range paths {
+		p = path.Clean(p)
+		if !set[p] {
+			set[p] = true
+			dirs[path.Dir(p)]++
+		}
+	}
+
+	var list []string
+	for p := range set {
+		if d := path.Dir(p); dirs[d] > 1 && d != "/" && d != "." {
+			p = d
+		}
+		list = append(list, p)
+	}
+
+	// the parents are sorted before the paths under them
+	sort.Strings(list)
+
+	var collapsed []string
+	for _, p := range list {
+		covered := false
+		for _, c := range collapsed {
+			if matchPath(c, p) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			collapsed = append(collapsed, p)
+		}
+	}
+	return collapsed
+}
+
+func matchExact(pattern, resource string) bool {
+	return pattern == resource
+}
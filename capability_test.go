@@ -0,0 +1,92 @@
+package dune
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchCapability(t *testing.T) {
+	data := []struct {
+		match    func(pattern, resource string) bool
+		pattern  string
+		resource string
+		expected bool
+	}{
+		{matchHost, "api.partner.com:443", "api.partner.com:443", true},
+		{matchHost, "api.partner.com:443", "API.partner.com:443", true},
+		{matchHost, "api.partner.com:443", "api.partner.com:80", false},
+		{matchHost, "api.partner.com", "api.partner.com:80", true},
+		{matchHost, "api.partner.com:*", "api.partner.com:8080", true},
+		{matchHost, "*.partner.com", "api.partner.com:443", true},
+		{matchHost, "*.partner.com", "partner.com:443", false},
+		{matchHost, "*.partner.com", "evilpartner.com:443", false},
+		{matchHost, "[::1]:80", "[::1]:80", true},
+		{matchPath, "/data", "/data", true},
+		{matchPath, "/data", "/data/reports/a.csv", true},
+		{matchPath, "/data/", "/data/a.csv", true},
+		{matchPath, "/data", "/database", false},
+		{matchPath, "/data", "/data/../etc/passwd", false},
+		{matchPath, "/", "/etc/passwd", true},
+		{matchExact, "convert", "convert", true},
+		{matchExact, "convert", "/usr/bin/convert", false},
+	}
+
+	for _, d := range data {
+		if v := d.match(d.pattern, d.resource); v != d.expected {
+			t.Errorf("%s %s: expected %v, got %v", d.pattern, d.resource, d.expected, v)
+		}
+	}
+}
+
+func TestCheckCapability(t *testing.T) {
+	var resource string
+
+	AddNativeFunc(NativeFunction{
+		Name:        "capabilityTest.fetch",
+		Arguments:   1,
+		Permissions: []string{"networking"},
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			resource = args[0].String()
+			if err := vm.CheckCapability("net", resource); err != nil {
+				return NullValue, err
+			}
+			return TrueValue, nil
+		},
+	})
+
+	run := func(code string, permissions ...string) (Value, error) {
+		p, err := CompileStr(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, perm := range permissions {
+			p.AddPermission(perm)
+		}
+		return NewVM(p).Run()
+	}
+
+	if _, err := run(`capabilityTest.fetch("a.com:443")`); err == nil || !strings.Contains(err.Error(), "unauthorized: missing permission networking") {
+		t.Fatal(err)
+	}
+
+	if _, err := run(`capabilityTest.fetch("b.com:443")`, "net:a.com:443"); err == nil || !strings.Contains(err.Error(), "missing capability net:b.com:443") {
+		t.Fatal(err)
+	}
+
+	for _, perm := range []string{"trusted", "networking", "net", "net:a.com:443"} {
+		if v, err := run(`return capabilityTest.fetch("a.com:443")`, perm); err != nil || !v.ToBool() {
+			t.Fatalf("%s: %v %v", perm, v, err)
+		}
+	}
+
+	// directives in the function
+	v, err := run(`
+		// [permissions net:a.com]
+		function main() {
+			return capabilityTest.fetch("a.com:80")
+		}
+	`)
+	if err != nil || !v.ToBool() {
+		t.Fatal(v, err)
+	}
+}
@@ -0,0 +1,146 @@
+package dune
+
+import "fmt"
+
+// Cloner is implemented by objects that can be passed to another VM.
+// Immutable values can return themselves.
+type Cloner interface {
+	CloneValue() Value
+}
+
+// StructuredClone returns a deep copy of the value that shares no state
+// with the original so it can be passed to a VM running in another
+// goroutine. Strings, numbers and functions are copied as they are
+// immutable. Arrays, maps, bytes and class instances are copied keeping
+// the references between them. Closures that capture variables and
+// objects that don't implement Cloner can't be cloned.
+func StructuredClone(v Value) (Value, error) {
+	c := &structuredCloner{seen: make(map[interface{}]Value)}
+	return c.clone(v)
+}
+
+type structuredCloner struct {
+	seen map[interface{}]Value
+}
+
+func (c *structuredCloner) clone(v Value) (Value, error) {
+	switch v.Type {
+	case Null, Undefined, Int, Float, Bool, Rune, String, Func, Enum, NativeFunc:
+		return v, nil
+
+	case Bytes:
+		b := v.ToBytes()
+		cp := make([]byte, len(b))
+		copy(cp, b)
+		return NewBytes(cp), nil
+
+	case Array:
+		a := v.ToArrayObject()
+		if r, ok := c.seen[a]; ok {
+			return r, nil
+		}
+		items := make([]Value, len(a.Array))
+		r := NewArrayValues(items)
+		c.seen[a] = r
+		for i, item := range a.Array {
+			cv, err := c.clone(item)
+			if err != nil {
+				return NullValue, err
+			}
+			items[i] = cv
+		}
+		return r, nil
+
+	case Map:
+		m := v.ToMap()
+		if r, ok := c.seen[m]; ok {
+			return r, nil
+		}
+		r := NewMap(0)
+		c.seen[m] = r
+
+		m.RLock()
+		defer m.RUnlock()
+
+		rm := r.ToMap().Map
+		for k, item := range m.Map {
+			ck, err := c.clone(k)
+			if err != nil {
+				return NullValue, err
+			}
+			cv, err := c.clone(item)
+			if err != nil {
+				return NullValue, err
+			}
+			rm[ck] = cv
+		}
+		return r, nil
+
+	case Object:
+		return c.cloneObject(v)
+
+	default:
+		return NullValue, fmt.Errorf("can't clone a %s", v.TypeName())
+	}
+}
+
+func (c *structuredCloner) cloneObject(v Value) (Value, error) {
+	switch t := v.ToObject().(type) {
+	case *instance:
+		if r, ok := c.seen[t]; ok {
+			return r, nil
+		}
+		i := &instance{iMap: make(map[string]Value), class: t.class}
+		r := NewObject(i)
+		c.seen[t] = r
+
+		t.RLock()
+		defer t.RUnlock()
+
+		for k, item := range t.iMap {
+			cv, err := c.clone(item)
+			if err != nil {
+				return NullValue, err
+			}
+			i.iMap[k] = cv
+		}
+		return r, nil
+
+	case *Closure:
+		if len(t.closures) > 0 {
+			return NullValue, fmt.Errorf("can't clone a closure that captures variables")
+		}
+		return NewObject(&Closure{FuncIndex: t.FuncIndex}), nil
+
+	case *Method:
+		this, err := c.clone(t.ThisObject)
+		if err != nil {
+			return NullValue, err
+		}
+		return NewObject(&Method{ThisObject: this, FuncIndex: t.FuncIndex}), nil
+
+	case Cloner:
+		return t.CloneValue(), nil
+
+	default:
+		return NullValue, fmt.Errorf("can't clone a %s", v.TypeName())
+	}
+}
+
+// IsolatedGlobals returns a structured clone of the globals of the VM to
+// initialize a VM that doesn't share state with this one. The globals
+// that can't be cloned, like native objects, are null in the copy.
+func (vm *VM) IsolatedGlobals() []Value {
+	globals := vm.Globals()
+	c := &structuredCloner{seen: make(map[interface{}]Value)}
+
+	values := make([]Value, len(globals))
+	for i, v := range globals {
+		cv, err := c.clone(v)
+		if err != nil {
+			cv = NullValue
+		}
+		values[i] = cv
+	}
+	return values
+}
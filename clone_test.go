@@ -0,0 +1,56 @@
+package dune
+
+import (
+	"testing"
+)
+
+func TestStructuredClone(t *testing.T) {
+	b := NewBytes([]byte("abc"))
+	inner := NewArrayValues([]Value{NewInt(1), b})
+	m := NewMapValues(map[Value]Value{
+		NewString("a"): inner,
+		NewString("b"): inner,
+	})
+
+	v, err := StructuredClone(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm := v.ToMap().Map
+	ca := cm[NewString("a")]
+
+	// the references between values are kept
+	if ca.ToArrayObject() != cm[NewString("b")].ToArrayObject() {
+		t.Fatal("expected the same array")
+	}
+
+	if ca.ToArrayObject() == inner.ToArrayObject() {
+		t.Fatal("expected a copy of the array")
+	}
+
+	b.ToBytes()[0] = 'x'
+	if string(ca.ToArray()[1].ToBytes()) != "abc" {
+		t.Fatal("expected a copy of the bytes")
+	}
+}
+
+func TestStructuredCloneCycle(t *testing.T) {
+	a := NewArray(1)
+	a.ToArray()[0] = a
+
+	v, err := StructuredClone(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.ToArray()[0].ToArrayObject() != v.ToArrayObject() {
+		t.Fatal("expected the cycle to be kept")
+	}
+}
+
+func TestStructuredCloneObject(t *testing.T) {
+	if _, err := StructuredClone(NewObject(&testCloser{})); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// attachRequest and attachResponse mirror the lines exchanged
+// with runtime.listenREPL.
+type attachRequest struct {
+	Code string `json:"code"`
+}
+
+type attachResponse struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// attach connects to a REPL opened by a running program with runtime.listenREPL
+// and evaluates the lines read from stdin until "quit" or EOF.
+func attach(addr, token string) error {
+	if token == "" {
+		token = os.Getenv("DUNE_TOKEN")
+	}
+	if token == "" {
+		return fmt.Errorf("a token is required: use -token or DUNE_TOKEN")
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network = "unix"
+		addr = strings.TrimPrefix(addr, "unix:")
+	}
+
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, token); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+
+	var greeting attachResponse
+	if err := dec.Decode(&greeting); err != nil {
+		return err
+	}
+	if greeting.Error != "" {
+		return fmt.Errorf("%s", greeting.Error)
+	}
+
+	fmt.Println(greeting.Output)
+	fmt.Println("commands: :globals, :vms, :caches, quit")
+
+	in := bufio.NewReader(os.Stdin)
+	var pending []string
+
+	for {
+		if len(pending) > 0 {
+			fmt.Print(CONTINUE_PROMPT)
+		} else {
+			fmt.Print(PROMPT)
+		}
+
+		line, err := in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				fmt.Println()
+				return nil
+			}
+			return err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if len(pending) == 0 {
+			switch strings.TrimSpace(line) {
+			case "":
+				continue
+			case "quit":
+				return nil
+			}
+		}
+
+		pending = append(pending, line)
+		code := strings.Join(pending, "\n")
+		if openBlocks(code) > 0 {
+			continue
+		}
+		pending = nil
+
+		if err := enc.Encode(attachRequest{Code: code}); err != nil {
+			return err
+		}
+
+		var resp attachResponse
+		if err := dec.Decode(&resp); err != nil {
+			return err
+		}
+
+		if resp.Output != "" {
+			fmt.Print(resp.Output)
+			if !strings.HasSuffix(resp.Output, "\n") {
+				fmt.Println()
+			}
+		}
+		if resp.Error != "" {
+			fmt.Println(resp.Error)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dunelang/dune"
+)
+
+// completions returns the names that start with word: globals, functions
+// and native namespaces or, if word has a dot, the members of the value.
+func completions(vm *dune.VM, natives *nativeIndex, word string) []string {
+	var names []string
+
+	i := strings.LastIndexByte(word, '.')
+	if i == -1 {
+		names = globalNames(vm)
+	} else {
+		base := word[:i]
+		for _, m := range memberNames(vm, natives, base) {
+			names = append(names, base+"."+m)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var list []string
+	for _, n := range names {
+		if strings.HasPrefix(n, word) && !seen[n] {
+			seen[n] = true
+			list = append(list, n)
+		}
+	}
+
+	sort.Strings(list)
+	return list
+}
+
+func globalNames(vm *dune.VM) []string {
+	var names []string
+
+	p := vm.Program
+	for _, r := range p.Functions[0].Registers {
+		if isIdentifier(r.Name) {
+			names = append(names, r.Name)
+		}
+	}
+
+	for _, f := range p.Functions[1:] {
+		if !f.IsClass && !f.Anonimous && isIdentifier(f.Name) {
+			names = append(names, f.Name)
+		}
+	}
+
+	for _, f := range vm.NativeFuncs() {
+		name := strings.TrimPrefix(f.Name, "->")
+		if i := strings.IndexByte(name, '.'); i != -1 {
+			name = name[:i]
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// memberNames returns the names that can follow base: the functions of a native
+// namespace or the members of the value of base.
+func memberNames(vm *dune.VM, natives *nativeIndex, base string) []string {
+	if names := nativeMembers(vm, base+"."); len(names) > 0 {
+		return names
+	}
+
+	// only evaluate names to avoid calling anything
+	for _, part := range strings.Split(base, ".") {
+		if !isIdentifier(part) {
+			return nil
+		}
+	}
+
+	v, err := dune.EvalValue(vm, base)
+	if err != nil {
+		return nil
+	}
+
+	names := vm.Members(v)
+
+	typeName := v.TypeName()
+	switch v.Type {
+	case dune.String, dune.Array, dune.Bytes, dune.Map:
+		typeName = strings.Title(typeName)
+	}
+
+	names = append(names, nativeMembers(vm, typeName+".prototype.")...)
+	for _, d := range natives.members(typeName) {
+		names = append(names, d.name)
+	}
+
+	return names
+}
+
+// nativeMembers returns the next part of the native functions of the vm that start with prefix.
+func nativeMembers(vm *dune.VM, prefix string) []string {
+	var names []string
+	for _, f := range vm.NativeFuncs() {
+		name := strings.TrimPrefix(f.Name, "->")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		name = name[len(prefix):]
+		if i := strings.IndexByte(name, '.'); i != -1 {
+			name = name[:i]
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// typeOf returns the declaration of a native function or the type of the value of expr.
+func typeOf(vm *dune.VM, natives *nativeIndex, expr string) (string, error) {
+	if expr == "" {
+		return "", fmt.Errorf("expected an expression")
+	}
+
+	parts := strings.Split(expr, ".")
+	if natives.isNamespace(parts[0]) || len(parts) == 1 && !isGlobal(vm, expr) {
+		qualifier := strings.Join(parts[:len(parts)-1], ".")
+		var lines []string
+		for _, d := range natives.lookup(qualifier, parts[len(parts)-1]) {
+			if d.container != qualifier {
+				// lookup searches all the interfaces if there is no match
+				continue
+			}
+			if d.doc != "" {
+				lines = append(lines, "// "+strings.Replace(d.doc, "\n", "\n// ", -1))
+			}
+			if d.container != "" {
+				lines = append(lines, d.container+"."+strings.TrimPrefix(d.signature, "function "))
+			} else {
+				lines = append(lines, d.signature)
+			}
+		}
+		if len(lines) > 0 {
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+
+	v, err := dune.EvalValue(vm, expr)
+	if err != nil {
+		return "", err
+	}
+
+	return v.TypeName(), nil
+}
+
+func isGlobal(vm *dune.VM, name string) bool {
+	for _, n := range globalNames(vm) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isIdentByte(s[i]) || i == 0 && s[i] >= '0' && s[i] <= '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func commonPrefix(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	prefix := list[0]
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// openBlocks returns how many braces, brackets and parenthesis are
+// not closed in the code, ignoring strings and comments.
+func openBlocks(code string) int {
+	var n int
+	var quote byte
+
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+
+		if quote != 0 {
+			switch c {
+			case '\\':
+				i++
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'', '`':
+			quote = c
+		case '/':
+			if i+1 < len(code) {
+				switch code[i+1] {
+				case '/':
+					j := strings.IndexByte(code[i:], '\n')
+					if j == -1 {
+						return n
+					}
+					i += j
+				case '*':
+					j := strings.Index(code[i+2:], "*/")
+					if j == -1 {
+						// the comment is not finished
+						return n + 1
+					}
+					i += j + 3
+				}
+			}
+		case '{', '[', '(':
+			n++
+		case '}', ']', ')':
+			n--
+		}
+	}
+
+	// an unterminated template string continues in the next line
+	if quote == '`' {
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/filesystem"
+)
+
+// serveDAP listens for Debug Adapter Protocol clients. If addr is "-"
+// the session runs over stdin/stdout.
+func serveDAP(addr string, programPath string, args []string) error {
+	if addr == "-" {
+		s := newDAPSession(os.Stdin, os.Stdout, programPath, args)
+		return s.serve()
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	fmt.Fprintf(os.Stderr, "DAP server listening on %s\n", l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			s := newDAPSession(conn, conn, programPath, args)
+			if err := s.serve(); err != nil && err != io.EOF {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+}
+
+type dapMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    *bool           `json:"success,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Body       interface{}     `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+const dapThreadID = 1
+
+type dapSession struct {
+	r           *bufio.Reader
+	w           io.Writer
+	wmu         sync.Mutex
+	seq         int
+	programPath string
+	args        []string
+	stopOnEntry bool
+
+	vm       *dune.VM
+	debugger *dune.Debugger
+	resume   chan dune.StepMode
+	mu       sync.Mutex
+	running  bool
+	paused   bool
+	stopping bool
+
+	// values that can be expanded in the variables view. They
+	// are only valid while the VM is paused.
+	handles []dune.Value
+}
+
+func newDAPSession(r io.Reader, w io.Writer, programPath string, args []string) *dapSession {
+	return &dapSession{
+		r:           bufio.NewReader(r),
+		w:           w,
+		programPath: programPath,
+		args:        args,
+		resume:      make(chan dune.StepMode, 1),
+	}
+}
+
+func (s *dapSession) serve() error {
+	for {
+		msg, err := s.read()
+		if err != nil {
+			return err
+		}
+
+		if msg.Type != "request" {
+			continue
+		}
+
+		done, err := s.handle(msg)
+		if err != nil {
+			s.respondError(msg, err)
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+func (s *dapSession) read() (*dapMessage, error) {
+	b, err := readFrame(s.r)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg dapMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// readFrame reads a message with a Content-Length header as used by
+// the debug adapter and the language server protocols.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var length int
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, "Content-Length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header: %s", line)
+			}
+		}
+	}
+
+	if length == 0 {
+		return nil, fmt.Errorf("missing Content-Length")
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func writeFrame(w io.Writer, b []byte) {
+	fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(b))
+	w.Write(b)
+}
+
+func (s *dapSession) send(msg *dapMessage) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	msg.Seq = s.seq
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	writeFrame(s.w, b)
+}
+
+func (s *dapSession) respond(req *dapMessage, body interface{}) {
+	success := true
+	s.send(&dapMessage{
+		Type:       "response",
+		Command:    req.Command,
+		RequestSeq: req.Seq,
+		Success:    &success,
+		Body:       body,
+	})
+}
+
+func (s *dapSession) respondError(req *dapMessage, err error) {
+	success := false
+	s.send(&dapMessage{
+		Type:       "response",
+		Command:    req.Command,
+		RequestSeq: req.Seq,
+		Success:    &success,
+		Message:    err.Error(),
+	})
+}
+
+func (s *dapSession) event(name string, body interface{}) {
+	s.send(&dapMessage{Type: "event", Event: name, Body: body})
+}
+
+// handle processes a request and returns true if the session has ended.
+func (s *dapSession) handle(req *dapMessage) (bool, error) {
+	switch req.Command {
+	case "stackTrace", "scopes", "variables", "evaluate":
+		// the state of the VM can only be read while it is paused
+		s.mu.Lock()
+		paused := s.paused
+		s.mu.Unlock()
+		if !paused {
+			return false, fmt.Errorf("the program is not paused")
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+			"exceptionBreakpointFilters": []map[string]interface{}{
+				{"filter": "uncaught", "label": "Uncaught Exceptions", "default": true},
+			},
+		})
+		s.event("initialized", nil)
+
+	case "launch", "attach":
+		var args struct {
+			Program     string   `json:"program"`
+			Args        []string `json:"args"`
+			StopOnEntry bool     `json:"stopOnEntry"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, err
+		}
+		if args.Program != "" {
+			s.programPath = args.Program
+			s.args = args.Args
+		}
+		s.stopOnEntry = args.StopOnEntry
+		if err := s.load(); err != nil {
+			return false, err
+		}
+		s.respond(req, nil)
+
+	case "setBreakpoints":
+		var args struct {
+			Source      dapSource `json:"source"`
+			Breakpoints []struct {
+				Line int `json:"line"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, err
+		}
+		if s.debugger == nil {
+			return false, fmt.Errorf("no program loaded")
+		}
+
+		s.debugger.ClearBreakpoints(args.Source.Path)
+
+		bps := make([]map[string]interface{}, len(args.Breakpoints))
+		for i, v := range args.Breakpoints {
+			b := s.debugger.SetBreakpoint(args.Source.Path, v.Line)
+			line := b.Resolved
+			if !b.Verified {
+				line = b.Line
+			}
+			bps[i] = map[string]interface{}{
+				"id":       b.ID,
+				"verified": b.Verified,
+				"line":     line,
+			}
+		}
+		s.respond(req, map[string]interface{}{"breakpoints": bps})
+
+	case "setExceptionBreakpoints":
+		var args struct {
+			Filters []string `json:"filters"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, err
+		}
+		if s.debugger == nil {
+			return false, fmt.Errorf("no program loaded")
+		}
+		s.debugger.PauseOnExceptions = false
+		for _, f := range args.Filters {
+			if f == "uncaught" {
+				s.debugger.PauseOnExceptions = true
+			}
+		}
+		s.respond(req, nil)
+
+	case "configurationDone":
+		s.respond(req, nil)
+		s.start()
+
+	case "threads":
+		s.respond(req, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThreadID, "name": "main"}},
+		})
+
+	case "stackTrace":
+		var frames []map[string]interface{}
+		for _, f := range s.debugger.StackFrames() {
+			frame := map[string]interface{}{
+				"id":     f.Index,
+				"name":   f.Function,
+				"line":   f.Line,
+				"column": f.Column,
+			}
+			if f.File != "" {
+				frame["source"] = dapSource{Name: filepath.Base(f.File), Path: f.File}
+			}
+			frames = append(frames, frame)
+		}
+		s.respond(req, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, err
+		}
+		locals, err := s.debugger.Locals(args.FrameID)
+		if err != nil {
+			return false, err
+		}
+		s.respond(req, map[string]interface{}{
+			"scopes": []map[string]interface{}{
+				{"name": "Locals", "variablesReference": s.addHandleVars(locals), "expensive": false},
+				{"name": "Globals", "variablesReference": s.addHandleVars(s.debugger.Globals()), "expensive": true},
+			},
+		})
+
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, err
+		}
+		vars, err := s.variables(args.VariablesReference)
+		if err != nil {
+			return false, err
+		}
+		s.respond(req, map[string]interface{}{"variables": vars})
+
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+			FrameID    int    `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, err
+		}
+		v, err := s.debugger.Evaluate(args.FrameID, args.Expression)
+		if err != nil {
+			return false, fmt.Errorf("%s", firstLine(err.Error()))
+		}
+		s.respond(req, map[string]interface{}{
+			"result":             formatValue(v),
+			"type":               v.TypeName(),
+			"variablesReference": s.addHandle(v),
+		})
+
+	case "continue":
+		s.respond(req, map[string]interface{}{"allThreadsContinued": true})
+		s.doResume(dune.StepContinue)
+
+	case "next":
+		s.respond(req, nil)
+		s.doResume(dune.StepOver)
+
+	case "stepIn":
+		s.respond(req, nil)
+		s.doResume(dune.StepInto)
+
+	case "stepOut":
+		s.respond(req, nil)
+		s.doResume(dune.StepOut)
+
+	case "pause":
+		if s.debugger != nil {
+			s.debugger.Pause()
+		}
+		s.respond(req, nil)
+
+	case "disconnect", "terminate":
+		s.doResume(dune.StepStop)
+		s.respond(req, nil)
+		return req.Command == "disconnect", nil
+
+	default:
+		return false, fmt.Errorf("unsupported command: %s", req.Command)
+	}
+
+	return false, nil
+}
+
+func (s *dapSession) load() error {
+	if s.programPath == "" {
+		return fmt.Errorf("no program specified")
+	}
+
+	p, err := loadProgram(s.programPath, false)
+	if err != nil {
+		return err
+	}
+
+	p.AddPermission("trusted")
+
+	vm := dune.NewVM(p)
+	vm.FileSystem = filesystem.OS
+	vm.Stdout = &dapOutput{s, "stdout"}
+	vm.Stderr = &dapOutput{s, "stderr"}
+
+	d := dune.NewDebugger(s)
+	d.PauseOnExceptions = true
+	if s.stopOnEntry {
+		d.Pause()
+	}
+
+	vm.SetDebugger(d)
+
+	s.vm = vm
+	s.debugger = d
+	return nil
+}
+
+func (s *dapSession) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.vm == nil || s.running {
+		return
+	}
+
+	s.running = true
+
+	values := make([]dune.Value, len(s.args))
+	for i, v := range s.args {
+		values[i] = dune.NewValue(v)
+	}
+
+	go func() {
+		_, err := s.vm.Run(values...)
+		exitCode := 0
+		if err != nil && err != dune.ErrDebuggerStop {
+			s.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+			exitCode = 1
+		}
+		s.event("exited", map[string]interface{}{"exitCode": exitCode})
+		s.event("terminated", nil)
+	}()
+}
+
+func (s *dapSession) doResume(mode dune.StepMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paused {
+		s.paused = false
+		s.resume <- mode
+		return
+	}
+
+	// if it is running stop it in the next line
+	if mode == dune.StepStop && s.running {
+		s.stopping = true
+		s.debugger.Pause()
+	}
+}
+
+// Paused implements dune.DebugHandler. It notifies the client and waits
+// until it sends a command to continue.
+func (s *dapSession) Paused(d *dune.Debugger, e *dune.DebugEvent) dune.StepMode {
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		return dune.StepStop
+	}
+	s.paused = true
+	s.mu.Unlock()
+
+	s.handles = nil
+
+	reason := e.Reason.String()
+	if e.Reason == dune.PauseRequest && s.stopOnEntry {
+		reason = "entry"
+		s.stopOnEntry = false
+	}
+
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          dapThreadID,
+		"allThreadsStopped": true,
+	}
+
+	if e.Error != nil {
+		body["text"] = firstLine(e.Error.Error())
+		body["description"] = e.Error.Error()
+	}
+
+	if e.Breakpoint != nil {
+		body["hitBreakpointIds"] = []int{e.Breakpoint.ID}
+	}
+
+	s.event("stopped", body)
+	return <-s.resume
+}
+
+func (s *dapSession) addHandleVars(vars []dune.Variable) int {
+	m := dune.NewMap(len(vars))
+	mv := m.ToMap()
+	for _, v := range vars {
+		mv.Map[dune.NewString(v.Name)] = v.Value
+	}
+	return s.addHandle(m)
+}
+
+// addHandle registers a value that can be expanded and returns
+// its reference or 0 if it is a simple value.
+func (s *dapSession) addHandle(v dune.Value) int {
+	switch v.Type {
+	case dune.Array, dune.Map:
+		s.handles = append(s.handles, v)
+		return len(s.handles)
+	}
+	return 0
+}
+
+func (s *dapSession) variables(ref int) ([]dapVariable, error) {
+	if ref < 1 || ref > len(s.handles) {
+		return nil, fmt.Errorf("invalid variables reference %d", ref)
+	}
+
+	v := s.handles[ref-1]
+
+	vars := []dapVariable{}
+
+	switch v.Type {
+	case dune.Array:
+		for i, item := range v.ToArray() {
+			vars = append(vars, s.newVariable(strconv.Itoa(i), item))
+		}
+	case dune.Map:
+		m := v.ToMap()
+		m.RLock()
+		keys := make([]dune.Value, 0, len(m.Map))
+		for k := range m.Map {
+			keys = append(keys, k)
+		}
+		m.RUnlock()
+
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, k := range keys {
+			m.RLock()
+			item := m.Map[k]
+			m.RUnlock()
+			vars = append(vars, s.newVariable(k.String(), item))
+		}
+	}
+
+	return vars, nil
+}
+
+func (s *dapSession) newVariable(name string, v dune.Value) dapVariable {
+	return dapVariable{
+		Name:               name,
+		Value:              formatValue(v),
+		Type:               v.TypeName(),
+		VariablesReference: s.addHandle(v),
+	}
+}
+
+// dapOutput sends the program output as events to the client.
+type dapOutput struct {
+	s        *dapSession
+	category string
+}
+
+func (o *dapOutput) Write(p []byte) (int, error) {
+	o.s.event("output", map[string]interface{}{"category": o.category, "output": string(p)})
+	return len(p), nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/filesystem"
+)
+
+const debugHelp = `commands:
+  c, continue         continue the execution
+  n, next             step over
+  s, step             step into
+  o, out              step out
+  b [file:]line       set a breakpoint
+  bl                  list breakpoints
+  bd id               delete a breakpoint
+  bt                  print the stack trace
+  f n                 select frame n
+  l, locals           print the local variables of the frame
+  g, globals          print the global variables
+  p expr              evaluate an expression in the frame
+  w expr              watch an expression
+  wd n                delete a watch
+  names               print the registers of the program
+  list                print the source around the current line
+  q, quit             stop the program`
+
+func debug(programPath string, args []string) error {
+	p, err := loadProgram(programPath, false)
+	if err != nil {
+		return err
+	}
+
+	p.AddPermission("trusted")
+
+	vm := dune.NewVM(p)
+	vm.FileSystem = filesystem.OS
+
+	t := &terminalDebugger{
+		in:  bufio.NewReader(os.Stdin),
+		out: os.Stdout,
+	}
+
+	d := dune.NewDebugger(t)
+	d.PauseOnExceptions = true
+
+	// stop in the first line
+	d.Pause()
+
+	vm.SetDebugger(d)
+
+	fmt.Fprintln(t.out, "type 'help' for a list of commands")
+
+	ln := len(args)
+	values := make([]dune.Value, ln)
+	for i := 0; i < ln; i++ {
+		values[i] = dune.NewValue(args[i])
+	}
+
+	_, err = vm.Run(values...)
+	if err == dune.ErrDebuggerStop {
+		return nil
+	}
+	return err
+}
+
+type terminalDebugger struct {
+	in    *bufio.Reader
+	out   io.Writer
+	frame int
+	line  dune.TraceLine
+}
+
+func (t *terminalDebugger) Paused(d *dune.Debugger, e *dune.DebugEvent) dune.StepMode {
+	t.frame = 0
+	t.line = e.Line
+
+	switch e.Reason {
+	case dune.PauseException:
+		fmt.Fprintf(t.out, "\nuncaught exception: %s\n", firstLine(e.Error.Error()))
+	case dune.PauseBreakpoint:
+		fmt.Fprintf(t.out, "\nbreakpoint %d\n", e.Breakpoint.ID)
+	}
+
+	t.printLocation(e.Line)
+	t.printWatches(d)
+
+	for {
+		fmt.Fprint(t.out, "(debug) ")
+		line, err := t.in.ReadString('\n')
+		if err != nil {
+			return dune.StepStop
+		}
+
+		cmd, arg := splitCommand(line)
+
+		switch cmd {
+		case "":
+			continue
+		case "c", "continue":
+			return dune.StepContinue
+		case "n", "next":
+			return dune.StepOver
+		case "s", "step":
+			return dune.StepInto
+		case "o", "out":
+			return dune.StepOut
+		case "q", "quit":
+			return dune.StepStop
+		case "help", "h":
+			fmt.Fprintln(t.out, debugHelp)
+		case "b", "break":
+			t.setBreakpoint(d, arg)
+		case "bl":
+			for _, b := range d.Breakpoints() {
+				fmt.Fprintf(t.out, "%d %s:%d verified=%v\n", b.ID, b.File, b.Resolved, b.Verified)
+			}
+		case "bd":
+			id, err := strconv.Atoi(arg)
+			if err != nil || !d.RemoveBreakpoint(id) {
+				fmt.Fprintf(t.out, "invalid breakpoint: %s\n", arg)
+			}
+		case "bt":
+			for _, f := range d.StackFrames() {
+				marker := " "
+				if f.Index == t.frame {
+					marker = "*"
+				}
+				fmt.Fprintf(t.out, "%s %d %s %s:%d\n", marker, f.Index, f.Function, f.File, f.Line)
+			}
+		case "f", "frame":
+			t.selectFrame(d, arg)
+		case "l", "locals":
+			vars, err := d.Locals(t.frame)
+			if err != nil {
+				fmt.Fprintln(t.out, err)
+				continue
+			}
+			t.printVariables(vars)
+		case "g", "globals":
+			t.printVariables(d.Globals())
+		case "p", "print":
+			v, err := d.Evaluate(t.frame, arg)
+			if err != nil {
+				fmt.Fprintln(t.out, firstLine(err.Error()))
+				continue
+			}
+			fmt.Fprintln(t.out, formatValue(v))
+		case "w", "watch":
+			d.AddWatch(arg)
+			t.printWatches(d)
+		case "wd":
+			i, err := strconv.Atoi(arg)
+			if err != nil || !d.RemoveWatch(i) {
+				fmt.Fprintf(t.out, "invalid watch: %s\n", arg)
+			}
+		case "names":
+			s, _ := dune.SprintNames(d.VM().Program, true)
+			fmt.Fprintln(t.out, s)
+		case "list":
+			t.printSource(t.line, 5)
+		default:
+			fmt.Fprintf(t.out, "unknown command: %s\n", cmd)
+		}
+	}
+}
+
+func (t *terminalDebugger) setBreakpoint(d *dune.Debugger, arg string) {
+	file := t.line.File
+	lineStr := arg
+
+	if i := strings.LastIndexByte(arg, ':'); i != -1 {
+		file = arg[:i]
+		lineStr = arg[i+1:]
+	}
+
+	line, err := strconv.Atoi(lineStr)
+	if err != nil {
+		fmt.Fprintf(t.out, "invalid line: %s\n", lineStr)
+		return
+	}
+
+	b := d.SetBreakpoint(file, line)
+	if !b.Verified {
+		fmt.Fprintf(t.out, "breakpoint %d set at %s:%d but there is no code there\n", b.ID, file, line)
+		return
+	}
+	fmt.Fprintf(t.out, "breakpoint %d at %s:%d\n", b.ID, file, b.Resolved)
+}
+
+func (t *terminalDebugger) selectFrame(d *dune.Debugger, arg string) {
+	i, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Fprintf(t.out, "invalid frame: %s\n", arg)
+		return
+	}
+
+	for _, f := range d.StackFrames() {
+		if f.Index == i {
+			t.frame = i
+			t.printLocation(dune.TraceLine{Function: f.Function, File: f.File, Line: f.Line})
+			return
+		}
+	}
+
+	fmt.Fprintf(t.out, "invalid frame: %s\n", arg)
+}
+
+func (t *terminalDebugger) printLocation(line dune.TraceLine) {
+	fmt.Fprintf(t.out, "> %s %s\n", line.Function, line.String())
+	t.printSource(line, 0)
+}
+
+func (t *terminalDebugger) printSource(line dune.TraceLine, context int) {
+	lines := sourceLines(line.File)
+	if lines == nil {
+		return
+	}
+
+	from := line.Line - context
+	if from < 1 {
+		from = 1
+	}
+
+	to := line.Line + context
+	if to > len(lines) {
+		to = len(lines)
+	}
+
+	for i := from; i <= to; i++ {
+		marker := "  "
+		if i == line.Line {
+			marker = "=>"
+		}
+		fmt.Fprintf(t.out, "%s %4d  %s\n", marker, i, lines[i-1])
+	}
+}
+
+func (t *terminalDebugger) printWatches(d *dune.Debugger) {
+	for i, w := range d.Watches() {
+		v, err := d.Evaluate(t.frame, w)
+		if err != nil {
+			fmt.Fprintf(t.out, "  %d %s: %s\n", i, w, firstLine(err.Error()))
+			continue
+		}
+		fmt.Fprintf(t.out, "  %d %s: %s\n", i, w, formatValue(v))
+	}
+}
+
+func (t *terminalDebugger) printVariables(vars []dune.Variable) {
+	for _, v := range vars {
+		fmt.Fprintf(t.out, "  %s = %s\n", v.Name, formatValue(v.Value))
+	}
+}
+
+func sourceLines(file string) []string {
+	if file == "" {
+		return nil
+	}
+
+	b, err := filesystem.ReadAll(filesystem.OS, file)
+	if err != nil {
+		return nil
+	}
+
+	return strings.Split(string(b), "\n")
+}
+
+func splitCommand(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	i := strings.IndexByte(line, ' ')
+	if i == -1 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		return s[:i]
+	}
+	return s
+}
+
+func formatValue(v dune.Value) string {
+	switch v.Type {
+	case dune.String:
+		return strconv.Quote(v.String())
+	case dune.Int, dune.Float, dune.Bool, dune.Null, dune.Undefined, dune.Rune:
+		return v.String()
+	}
+
+	b, err := json.Marshal(v.ExportMarshal(0))
+	if err != nil {
+		return v.String()
+	}
+	return string(b)
+}
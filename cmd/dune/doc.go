@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/ast"
+	"github.com/dunelang/dune/parser"
+)
+
+// generateDocs implements "dune doc [-o dir] [-md] [-std=false] paths...".
+// It writes the documentation of the exported declarations of the files
+// and of the native standard library.
+func generateDocs(args []string) error {
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	out := flags.String("o", "docs", "output directory")
+	markdown := flags.Bool("md", false, "generate markdown instead of html")
+	std := flags.Bool("std", true, "include the native standard library")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 && !*std {
+		return fmt.Errorf("no files specified")
+	}
+
+	var pages []*docPage
+
+	for _, path := range flags.Args() {
+		list, err := modulePages(path)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, list...)
+	}
+
+	if *std {
+		pages = append(pages, stdlibPages(newNativeIndex(dune.TypeDefs()))...)
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+
+	w := &docWriter{pages: pages, markdown: *markdown}
+	return w.write(*out)
+}
+
+// docPage is a module or a namespace of the standard library.
+type docPage struct {
+	name  string // the file name without extension
+	title string
+	std   bool
+	items []*docItem
+}
+
+type docItem struct {
+	kind      string
+	name      string
+	signature string
+	doc       string
+	members   []*docItem
+}
+
+// modulePages returns a page for each source file in path.
+func modulePages(path string) ([]*docPage, error) {
+	var files []string
+
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(p, ".d.ts") {
+			return nil
+		}
+		if p == path || filepath.Ext(p) == ".ts" {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []*docPage
+
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := parser.ParseSource(string(b), file)
+		if err != nil {
+			return nil, err
+		}
+
+		title := filepath.ToSlash(strings.TrimSuffix(file, filepath.Ext(file)))
+		title = strings.TrimPrefix(title, "./")
+
+		page := &docPage{
+			name:  "module." + strings.ReplaceAll(title, "/", "."),
+			title: title,
+		}
+
+		for _, d := range ast.Docs(f) {
+			page.items = append(page.items, moduleItem(d))
+		}
+
+		pages = append(pages, page)
+	}
+
+	return pages, nil
+}
+
+func moduleItem(d *ast.DocDecl) *docItem {
+	item := &docItem{
+		kind:      d.Kind,
+		name:      d.Name,
+		signature: d.Signature,
+		doc:       d.Doc,
+	}
+	for _, m := range d.Members {
+		item.members = append(item.members, moduleItem(m))
+	}
+	return item
+}
+
+// stdlibPages returns a page for each namespace of the native
+// libraries and one for the global declarations.
+func stdlibPages(x *nativeIndex) []*docPage {
+	// the interfaces are the containers that are not namespaces
+	var interfaces []string
+	seen := make(map[string]bool)
+	for _, d := range x.defs {
+		c := d.container
+		if c == "" || seen[c] || x.isNamespace(c) {
+			continue
+		}
+		seen[c] = true
+		interfaces = append(interfaces, c)
+	}
+	sort.Strings(interfaces)
+
+	page := func(ns string) *docPage {
+		p := &docPage{name: "std." + ns, title: ns, std: true}
+
+		var defs []*nativeDef
+		if ns == "global" {
+			defs = x.globals()
+		} else {
+			defs = x.members(ns)
+		}
+
+		for _, d := range defs {
+			if !d.namespace {
+				p.items = append(p.items, nativeItem(d))
+			}
+		}
+
+		for _, c := range interfaces {
+			name := c
+			if ns != "global" {
+				name = strings.TrimPrefix(c, ns+".")
+				if name == c {
+					continue
+				}
+			}
+			if strings.Contains(name, ".") {
+				// it belongs to a namespace inside ns
+				continue
+			}
+
+			item := &docItem{kind: "interface", name: name, signature: "interface " + name}
+			for _, d := range x.members(c) {
+				item.members = append(item.members, nativeItem(d))
+			}
+			p.items = append(p.items, item)
+		}
+
+		return p
+	}
+
+	pages := []*docPage{page("global")}
+	for _, ns := range x.namespaces() {
+		pages = append(pages, page(ns))
+	}
+
+	// namespaces inside other namespaces
+	for _, d := range x.defs {
+		if d.namespace && d.container != "" {
+			pages = append(pages, page(d.container+"."+d.name))
+		}
+	}
+
+	return pages
+}
+
+func nativeItem(d *nativeDef) *docItem {
+	kind := "const"
+	if d.function {
+		kind = "function"
+	}
+	return &docItem{kind: kind, name: d.name, signature: d.signature, doc: d.doc}
+}
+
+// docWriter writes the pages, the index and the search index.
+type docWriter struct {
+	pages    []*docPage
+	markdown bool
+	links    map[string]string // the urls of the pages and items by name
+}
+
+func (w *docWriter) ext() string {
+	if w.markdown {
+		return ".md"
+	}
+	return ".html"
+}
+
+func (w *docWriter) write(dir string) error {
+	w.links = make(map[string]string)
+
+	// the modules go after the standard library so their names take precedence
+	for _, std := range []bool{true, false} {
+		for _, p := range w.pages {
+			if p.std != std {
+				continue
+			}
+			url := p.name + w.ext()
+			w.links[p.title] = url
+			for _, item := range p.items {
+				w.links[item.name] = url + "#" + item.name
+				w.links[p.title+"."+item.name] = url + "#" + item.name
+			}
+		}
+	}
+
+	for _, p := range w.pages {
+		var b strings.Builder
+		if w.markdown {
+			w.markdownPage(&b, p)
+		} else {
+			w.htmlPage(&b, p)
+		}
+		if err := os.WriteFile(filepath.Join(dir, p.name+w.ext()), []byte(b.String()), 0644); err != nil {
+			return err
+		}
+	}
+
+	var b strings.Builder
+	if w.markdown {
+		w.markdownIndex(&b)
+	} else {
+		w.htmlIndex(&b)
+		if err := os.WriteFile(filepath.Join(dir, "search.js"), w.searchIndex(), 0644); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(filepath.Join(dir, "index"+w.ext()), []byte(b.String()), 0644)
+}
+
+// references returns the documented types used in a signature: the
+// names that are capitalized or qualified with a namespace.
+func (w *docWriter) references(item *docItem, sig string) []string {
+	var refs []string
+	seen := make(map[string]bool)
+
+	for _, word := range identifiers(sig) {
+		if seen[word] || word == item.name {
+			continue
+		}
+		if !strings.Contains(word, ".") && (word[0] < 'A' || word[0] > 'Z') {
+			continue
+		}
+		if _, ok := w.links[word]; !ok {
+			continue
+		}
+		seen[word] = true
+		refs = append(refs, word)
+	}
+
+	return refs
+}
+
+// identifiers returns the words of a signature, including the qualified ones.
+func identifiers(s string) []string {
+	var words []string
+	for i := 0; i < len(s); {
+		if !isIdentByte(s[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(s) && (isIdentByte(s[j]) || s[j] == '.') {
+			j++
+		}
+		words = append(words, strings.TrimSuffix(s[i:j], "."))
+		i = j
+	}
+	return words
+}
+
+func (w *docWriter) markdownPage(b *strings.Builder, p *docPage) {
+	fmt.Fprintf(b, "# %s\n\n[Index](index.md)\n\n", p.title)
+
+	for _, item := range p.items {
+		fmt.Fprintf(b, "<a id=\"%s\"></a>\n\n## %s %s\n\n", item.name, item.kind, item.name)
+		w.markdownItem(b, p, item)
+
+		for _, m := range item.members {
+			fmt.Fprintf(b, "<a id=\"%s.%s\"></a>\n\n### %s.%s\n\n", item.name, m.name, item.name, m.name)
+			w.markdownItem(b, p, m)
+		}
+	}
+}
+
+func (w *docWriter) markdownItem(b *strings.Builder, p *docPage, item *docItem) {
+	fmt.Fprintf(b, "```typescript\n%s\n```\n\n", item.signature)
+	if item.doc != "" {
+		b.WriteString(item.doc + "\n\n")
+	}
+
+	if refs := w.references(item, item.signature); len(refs) > 0 {
+		var links []string
+		for _, r := range refs {
+			links = append(links, fmt.Sprintf("[%s](%s)", r, w.links[r]))
+		}
+		fmt.Fprintf(b, "See %s\n\n", strings.Join(links, ", "))
+	}
+}
+
+func (w *docWriter) markdownIndex(b *strings.Builder) {
+	b.WriteString("# API documentation\n\n")
+
+	for _, std := range []bool{false, true} {
+		var list []string
+		for _, p := range w.pages {
+			if p.std == std {
+				list = append(list, fmt.Sprintf("- [%s](%s.md)", p.title, p.name))
+			}
+		}
+		if len(list) == 0 {
+			continue
+		}
+		if std {
+			b.WriteString("## Standard library\n\n")
+		} else {
+			b.WriteString("## Modules\n\n")
+		}
+		b.WriteString(strings.Join(list, "\n") + "\n\n")
+	}
+
+	// an alphabetical list of all the names to search them
+	b.WriteString("## All names\n\n")
+	for _, e := range w.entries() {
+		fmt.Fprintf(b, "- [%s](%s) %s\n", e.Name, e.URL, e.Kind)
+	}
+}
+
+const docStyle = `<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; }
+pre { background: #f4f4f4; padding: .6em; overflow-x: auto; }
+h3 { font-size: 1em; }
+#results a { display: block; }
+</style>`
+
+const docSearch = `<input id="search" placeholder="Search" autocomplete="off">
+<div id="results"></div>
+<script src="search.js"></script>
+<script>
+document.getElementById("search").addEventListener("input", function (e) {
+    var q = e.target.value.toLowerCase();
+    var html = "";
+    if (q) {
+        docIndex.filter(function (d) { return d.name.toLowerCase().indexOf(q) != -1 })
+            .slice(0, 50)
+            .forEach(function (d) { html += '<a href="' + d.url + '">' + d.name + '</a>' });
+    }
+    document.getElementById("results").innerHTML = html;
+});
+</script>`
+
+func (w *docWriter) htmlHeader(b *strings.Builder, title string) {
+	fmt.Fprintf(b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n%s\n</head>\n<body>\n",
+		html.EscapeString(title), docStyle)
+	fmt.Fprintf(b, "<p><a href=\"index.html\">Index</a></p>\n%s\n", docSearch)
+}
+
+func (w *docWriter) htmlPage(b *strings.Builder, p *docPage) {
+	w.htmlHeader(b, p.title)
+	fmt.Fprintf(b, "<h1>%s</h1>\n", html.EscapeString(p.title))
+
+	for _, item := range p.items {
+		fmt.Fprintf(b, "<h2 id=\"%s\">%s %s</h2>\n", item.name, item.kind, item.name)
+		w.htmlItem(b, p, item)
+
+		for _, m := range item.members {
+			fmt.Fprintf(b, "<h3 id=\"%s.%s\">%s.%s</h3>\n", item.name, m.name, item.name, m.name)
+			w.htmlItem(b, p, m)
+		}
+	}
+
+	b.WriteString("</body>\n</html>\n")
+}
+
+func (w *docWriter) htmlItem(b *strings.Builder, p *docPage, item *docItem) {
+	sig := html.EscapeString(item.signature)
+
+	// link the names of other declarations
+	for _, r := range w.references(item, item.signature) {
+		sig = replaceWord(sig, r, fmt.Sprintf("<a href=\"%s\">%s</a>", w.links[r], r))
+	}
+
+	fmt.Fprintf(b, "<pre>%s</pre>\n", sig)
+
+	for _, par := range strings.Split(item.doc, "\n\n") {
+		if par = strings.TrimSpace(par); par != "" {
+			fmt.Fprintf(b, "<p>%s</p>\n", strings.ReplaceAll(html.EscapeString(par), "\n", "<br>\n"))
+		}
+	}
+}
+
+// replaceWord replaces the occurrences of word that are not part of a longer name.
+func replaceWord(s, word, repl string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, word)
+		if i == -1 {
+			b.WriteString(s)
+			return b.String()
+		}
+
+		end := i + len(word)
+		if (i > 0 && (isIdentByte(s[i-1]) || s[i-1] == '.')) || (end < len(s) && (isIdentByte(s[end]) || s[end] == '.')) {
+			b.WriteString(s[:end])
+		} else {
+			b.WriteString(s[:i] + repl)
+		}
+		s = s[end:]
+	}
+}
+
+func (w *docWriter) htmlIndex(b *strings.Builder) {
+	w.htmlHeader(b, "API documentation")
+	b.WriteString("<h1>API documentation</h1>\n")
+
+	for _, std := range []bool{false, true} {
+		var list []string
+		for _, p := range w.pages {
+			if p.std == std {
+				list = append(list, fmt.Sprintf("<li><a href=\"%s.html\">%s</a></li>", p.name, html.EscapeString(p.title)))
+			}
+		}
+		if len(list) == 0 {
+			continue
+		}
+		if std {
+			b.WriteString("<h2>Standard library</h2>\n")
+		} else {
+			b.WriteString("<h2>Modules</h2>\n")
+		}
+		fmt.Fprintf(b, "<ul>\n%s\n</ul>\n", strings.Join(list, "\n"))
+	}
+
+	b.WriteString("</body>\n</html>\n")
+}
+
+type docEntry struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+}
+
+// entries returns all the documented names sorted.
+func (w *docWriter) entries() []docEntry {
+	var list []docEntry
+	for _, p := range w.pages {
+		url := p.name + w.ext()
+		for _, item := range p.items {
+			name := item.name
+			if p.std && p.title != "global" {
+				name = p.title + "." + name
+			}
+			list = append(list, docEntry{Name: name, Kind: item.kind, URL: url + "#" + item.name})
+			for _, m := range item.members {
+				list = append(list, docEntry{
+					Name: name + "." + m.name,
+					Kind: m.kind,
+					URL:  url + "#" + item.name + "." + m.name,
+				})
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (w *docWriter) searchIndex() []byte {
+	b, _ := json.Marshal(w.entries())
+	return []byte("var docIndex = " + string(b) + ";\n")
+}
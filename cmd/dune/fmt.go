@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/dunelang/dune/parser"
+)
+
+// formatFiles implements "dune fmt [-w] files...".
+func formatFiles(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	w := flags.Bool("w", false, "write the result to the source file instead of stdout")
+	flags.Parse(args)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		return fmt.Errorf("no files specified")
+	}
+
+	for _, path := range files {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		b, err := parser.Format(string(src), path)
+		if err != nil {
+			return err
+		}
+
+		if !*w {
+			os.Stdout.Write(b)
+			continue
+		}
+
+		if bytes.Equal(src, b) {
+			continue
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(path, b, fi.Mode()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dunelang/dune"
+)
+
+// printCFG prints the control-flow graphs of the functions of the program.
+// If names is not empty only those functions are printed.
+func printCFG(path string, names []string, asJSON bool) error {
+	p, err := loadProgram(path, false)
+	if err != nil {
+		return err
+	}
+
+	var graphs []*dune.CFG
+	for _, f := range p.Functions {
+		g := dune.NewCFG(p, f)
+		if len(names) > 0 && !contains(names, g.Function) {
+			continue
+		}
+		graphs = append(graphs, g)
+	}
+
+	if len(names) > 0 && len(graphs) == 0 {
+		return fmt.Errorf("function not found: %v", names)
+	}
+
+	if asJSON {
+		return printJSON(graphs)
+	}
+
+	dune.WriteCFGDot(os.Stdout, graphs)
+	return nil
+}
+
+// printCallGraph prints the static call graph of the program.
+func printCallGraph(path string, asJSON bool) error {
+	p, err := loadProgram(path, false)
+	if err != nil {
+		return err
+	}
+
+	g := dune.NewCallGraph(p)
+
+	if asJSON {
+		return printJSON(g)
+	}
+
+	g.WriteDot(os.Stdout)
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/filesystem"
+)
+
+// lintFiles implements "dune lint [-json] [-rules r1,r2] [-disable r1,r2] files...".
+// It returns the number of issues found.
+func lintFiles(args []string) (int, error) {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the issues as JSON")
+	rules := flags.String("rules", "", "comma separated list of rules to check. All by default: "+strings.Join(dune.LintRules, ","))
+	disable := flags.String("disable", "", "comma separated list of rules to skip")
+	flags.Parse(args)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no files specified")
+	}
+
+	enabled := dune.LintRules
+	if *rules != "" {
+		enabled = strings.Split(*rules, ",")
+	}
+
+	if *disable != "" {
+		skip := strings.Split(*disable, ",")
+		var list []string
+		for _, r := range enabled {
+			if !contains(skip, r) {
+				list = append(list, r)
+			}
+		}
+		if len(list) == 0 {
+			return 0, fmt.Errorf("all rules are disabled")
+		}
+		enabled = list
+	}
+
+	issues := []*dune.LintIssue{}
+
+	for _, path := range files {
+		list, err := dune.Lint(filesystem.OS, path, enabled...)
+		issues = append(issues, list...)
+		if err != nil {
+			printIssues(issues, *asJSON)
+			return len(issues), err
+		}
+	}
+
+	printIssues(issues, *asJSON)
+	return len(issues), nil
+}
+
+func printIssues(issues []*dune.LintIssue, asJSON bool) {
+	if asJSON {
+		b, err := json.MarshalIndent(issues, "", "    ")
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
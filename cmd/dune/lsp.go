@@ -0,0 +1,1115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/ast"
+	"github.com/dunelang/dune/filesystem"
+)
+
+// serveLSP runs a Language Server Protocol session over stdin/stdout.
+func serveLSP() error {
+	s := newLSPSession(os.Stdin, os.Stdout)
+	return s.serve()
+}
+
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+// completion item kinds
+const (
+	lspKindMethod   = 2
+	lspKindFunction = 3
+	lspKindField    = 5
+	lspKindVariable = 6
+	lspKindClass    = 7
+	lspKindModule   = 9
+	lspKindProperty = 10
+	lspKindEnum     = 13
+	lspKindConstant = 21
+)
+
+type lspDocument struct {
+	path    string
+	text    string
+	program *dune.Program
+	symbols *dune.Symbols
+}
+
+type lspSession struct {
+	r         *bufio.Reader
+	w         io.Writer
+	fs        *overlayFS
+	docs      map[string]*lspDocument
+	published map[string]bool
+	natives   *nativeIndex
+	shutdown  bool
+}
+
+func newLSPSession(r io.Reader, w io.Writer) *lspSession {
+	return &lspSession{
+		r:         bufio.NewReader(r),
+		w:         w,
+		fs:        newOverlayFS(filesystem.OS),
+		docs:      make(map[string]*lspDocument),
+		published: make(map[string]bool),
+		natives:   newNativeIndex(dune.TypeDefs()),
+	}
+}
+
+func (s *lspSession) serve() error {
+	for {
+		b, err := readFrame(s.r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var msg lspMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(&msg)
+
+		// notifications don't have a response
+		if msg.ID == nil {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			continue
+		}
+
+		resp := &lspMessage{JSONRPC: "2.0", ID: msg.ID}
+		if err != nil {
+			resp.Error = &lspError{Code: -32603, Message: err.Error()}
+		} else if result == nil {
+			resp.Result = json.RawMessage("null")
+		} else {
+			resp.Result = result
+		}
+		s.send(resp)
+	}
+}
+
+func (s *lspSession) send(msg *lspMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	writeFrame(s.w, b)
+}
+
+func (s *lspSession) notify(method string, params interface{}) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	s.send(&lspMessage{JSONRPC: "2.0", Method: method, Params: b})
+}
+
+var errMethodNotFound = errors.New("method not found")
+
+func (s *lspSession) handle(msg *lspMessage) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"."},
+				},
+			},
+			"serverInfo": map[string]string{
+				"name":    "dune",
+				"version": dune.VERSION,
+			},
+		}, nil
+
+	case "initialized", "$/cancelRequest", "workspace/didChangeConfiguration":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			// the sync mode is full so the last change has the whole text
+			s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didSave":
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		s.close(params.TextDocument.URI)
+		return nil, nil
+
+	case "textDocument/hover":
+		var params lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params.TextDocument.URI, params.Position), nil
+
+	case "textDocument/definition":
+		var params lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params.TextDocument.URI, params.Position), nil
+
+	case "textDocument/references":
+		var params struct {
+			lspTextDocumentPosition
+			Context struct {
+				IncludeDeclaration bool `json:"includeDeclaration"`
+			} `json:"context"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.references(params.TextDocument.URI, params.Position, params.Context.IncludeDeclaration), nil
+
+	case "textDocument/completion":
+		var params lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params.TextDocument.URI, params.Position), nil
+	}
+
+	if msg.ID == nil {
+		// ignore unknown notifications
+		return nil, nil
+	}
+
+	return nil, errMethodNotFound
+}
+
+func (s *lspSession) update(uri, text string) {
+	path := uriToPath(uri)
+
+	doc, ok := s.docs[uri]
+	if !ok {
+		doc = &lspDocument{path: path}
+		s.docs[uri] = doc
+	}
+
+	doc.text = text
+	s.fs.set(path, []byte(text))
+
+	s.analyze(uri, doc)
+}
+
+func (s *lspSession) close(uri string) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return
+	}
+
+	delete(s.docs, uri)
+	s.fs.remove(doc.path)
+
+	if s.published[uri] {
+		delete(s.published, uri)
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         uri,
+			"diagnostics": []lspDiagnostic{},
+		})
+	}
+}
+
+// analyze compiles the document and publishes the errors found.
+func (s *lspSession) analyze(uri string, doc *lspDocument) {
+	p, symbols, err := dune.CompileSymbols(s.fs, doc.path)
+	if p != nil && err == nil {
+		doc.program = p
+	}
+	if symbols != nil {
+		doc.symbols = symbols
+	}
+
+	diagnostics := make(map[string][]lspDiagnostic)
+
+	// clear the previous errors
+	for u := range s.published {
+		diagnostics[u] = []lspDiagnostic{}
+	}
+	diagnostics[uri] = []lspDiagnostic{}
+
+	if err != nil {
+		d, file := s.diagnostic(err, doc.path)
+		u := pathToURI(file)
+		diagnostics[u] = append(diagnostics[u], d)
+	}
+
+	s.published = make(map[string]bool)
+
+	for u, list := range diagnostics {
+		if len(list) > 0 {
+			s.published[u] = true
+		}
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         u,
+			"diagnostics": list,
+		})
+	}
+}
+
+func (s *lspSession) diagnostic(err error, file string) (lspDiagnostic, string) {
+	msg := firstLine(err.Error())
+	if e, ok := err.(interface{ ErrorMessage() string }); ok {
+		msg = e.ErrorMessage()
+	}
+
+	d := lspDiagnostic{Severity: 1, Source: "dune", Message: msg}
+
+	e, ok := err.(interface{ Position() ast.Position })
+	if !ok {
+		return d, file
+	}
+
+	pos := e.Position()
+	if pos.FileName != "" {
+		file = pos.FileName
+	}
+
+	line := pos.Line - 1
+	if line < 0 {
+		line = 0
+	}
+
+	d.Range = lspRange{
+		Start: lspPosition{Line: line, Character: 0},
+		End:   lspPosition{Line: line, Character: len(s.line(file, line))},
+	}
+
+	return d, file
+}
+
+func (s *lspSession) hover(uri string, pos lspPosition) interface{} {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil
+	}
+
+	line := lineAt(doc.text, pos.Line)
+	qualifier, word, start, end := wordAt(line, pos.Character)
+	if word == "" {
+		return nil
+	}
+
+	var contents string
+
+	if doc.symbols != nil {
+		if sym := doc.symbols.Find(doc.path, pos.Line+1, word); sym != nil {
+			contents = fmt.Sprintf("(%s) %s", sym.Kind, sym.Name)
+		}
+	}
+
+	if contents == "" {
+		defs := s.natives.lookup(qualifier, word)
+		if len(defs) == 0 {
+			return nil
+		}
+		var parts []string
+		for _, d := range defs {
+			parts = append(parts, d.markdown())
+		}
+		contents = strings.Join(parts, "\n\n---\n\n")
+	} else {
+		contents = "```typescript\n" + contents + "\n```"
+	}
+
+	return map[string]interface{}{
+		"contents": map[string]string{
+			"kind":  "markdown",
+			"value": contents,
+		},
+		"range": lspRange{
+			Start: lspPosition{Line: pos.Line, Character: start},
+			End:   lspPosition{Line: pos.Line, Character: end},
+		},
+	}
+}
+
+func (s *lspSession) symbolAt(uri string, pos lspPosition) (*dune.Symbol, bool) {
+	doc, ok := s.docs[uri]
+	if !ok || doc.symbols == nil {
+		return nil, false
+	}
+
+	_, word, _, _ := wordAt(lineAt(doc.text, pos.Line), pos.Character)
+	if word == "" {
+		return nil, false
+	}
+
+	sym := doc.symbols.Find(doc.path, pos.Line+1, word)
+	return sym, sym != nil
+}
+
+func (s *lspSession) definition(uri string, pos lspPosition) interface{} {
+	sym, ok := s.symbolAt(uri, pos)
+	if !ok || sym.Pos.FileName == "" {
+		return nil
+	}
+	return s.location(sym.Name, sym.Pos)
+}
+
+func (s *lspSession) references(uri string, pos lspPosition, includeDeclaration bool) interface{} {
+	sym, ok := s.symbolAt(uri, pos)
+	if !ok {
+		return nil
+	}
+
+	locations := []lspLocation{}
+
+	if includeDeclaration && sym.Pos.FileName != "" {
+		locations = append(locations, s.location(sym.Name, sym.Pos))
+	}
+
+	for _, r := range sym.References {
+		locations = append(locations, s.location(sym.Name, r))
+	}
+
+	return locations
+}
+
+func (s *lspSession) completion(uri string, pos lspPosition) interface{} {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil
+	}
+
+	line := lineAt(doc.text, pos.Line)
+	if pos.Character < len(line) {
+		line = line[:pos.Character]
+	}
+
+	// the partial identifier being written
+	i := len(line)
+	for i > 0 && isIdentByte(line[i-1]) {
+		i--
+	}
+
+	items := []lspCompletionItem{}
+	seen := make(map[string]bool)
+
+	add := func(label string, kind int, detail string) {
+		if label == "" || seen[label] {
+			return
+		}
+		seen[label] = true
+		items = append(items, lspCompletionItem{Label: label, Kind: kind, Detail: detail})
+	}
+
+	if i > 0 && line[i-1] == '.' {
+		j := i - 1
+		for j > 0 && isIdentByte(line[j-1]) {
+			j--
+		}
+		qualifier := line[j : i-1]
+
+		switch {
+		case qualifier == "this":
+			for _, m := range classMembers(doc, pos.Line+1) {
+				add(m.name, m.kind, "")
+			}
+		case s.natives.isNamespace(qualifier):
+			for _, d := range s.natives.members(qualifier) {
+				add(d.name, d.completionKind(), d.signature)
+			}
+		default:
+			if e := findEnum(doc, qualifier); e != nil {
+				for _, v := range e.Values {
+					add(v.Name, lspKindEnum, "")
+				}
+				break
+			}
+			// the type is unknown so offer all members of classes
+			// and native prototypes.
+			for _, m := range classMembers(doc, 0) {
+				add(m.name, m.kind, "")
+			}
+			for _, d := range s.natives.prototypeMembers() {
+				add(d.name, d.completionKind(), d.signature)
+			}
+		}
+		return items
+	}
+
+	if doc.symbols != nil {
+		for _, sym := range doc.symbols.List {
+			if sym.Name == "" || !visible(sym, doc.path, pos.Line+1) {
+				continue
+			}
+			add(sym.Name, symbolCompletionKind(sym.Kind), sym.Kind.String())
+		}
+	}
+
+	for _, ns := range s.natives.namespaces() {
+		add(ns, lspKindModule, "namespace")
+	}
+
+	for _, d := range s.natives.globals() {
+		add(d.name, d.completionKind(), d.signature)
+	}
+
+	return items
+}
+
+// visible returns true if the symbol can be referenced from the line. Local
+// symbols are only offered if they are declared in the same file before the line.
+func visible(sym *dune.Symbol, file string, line int) bool {
+	switch sym.Kind {
+	case dune.SymbolFunction, dune.SymbolClass, dune.SymbolEnum:
+		return true
+	}
+	return sym.Pos.FileName == file && sym.Pos.Line <= line
+}
+
+func symbolCompletionKind(k dune.SymbolKind) int {
+	switch k {
+	case dune.SymbolFunction:
+		return lspKindFunction
+	case dune.SymbolClass:
+		return lspKindClass
+	case dune.SymbolEnum:
+		return lspKindEnum
+	case dune.SymbolConstant:
+		return lspKindConstant
+	default:
+		return lspKindVariable
+	}
+}
+
+type classMember struct {
+	name string
+	kind int
+}
+
+// classMembers returns the members of the class declared before the line or all
+// the classes of the program if line is 0.
+func classMembers(doc *lspDocument, line int) []classMember {
+	if doc.program == nil {
+		return nil
+	}
+
+	var name string
+	if line > 0 && doc.symbols != nil {
+		var declLine int
+		for _, sym := range doc.symbols.InFile(doc.path) {
+			if sym.Kind == dune.SymbolClass && sym.Pos.Line <= line && sym.Pos.Line > declLine {
+				name = sym.Name
+				declLine = sym.Pos.Line
+			}
+		}
+		if name == "" {
+			return nil
+		}
+	}
+
+	p := doc.program
+
+	var members []classMember
+	for _, c := range p.Classes {
+		if name != "" && c.Name != name && !strings.HasSuffix(c.Name, "."+name) {
+			continue
+		}
+		for _, f := range c.Fields {
+			members = append(members, classMember{f.Name, lspKindField})
+		}
+		for _, i := range c.Functions {
+			members = append(members, classMember{memberName(p.Functions[i].Name), lspKindMethod})
+		}
+		for _, i := range c.Getters {
+			members = append(members, classMember{memberName(p.Functions[i].Name), lspKindProperty})
+		}
+	}
+
+	return members
+}
+
+func memberName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i != -1 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func findEnum(doc *lspDocument, name string) *dune.EnumList {
+	if doc.program == nil {
+		return nil
+	}
+	for _, e := range doc.program.Enums {
+		if e.Name == name || strings.HasSuffix(e.Name, "."+name) {
+			return e
+		}
+	}
+	return nil
+}
+
+// location returns the range of a name at a position of the compiler.
+func (s *lspSession) location(name string, pos ast.Position) lspLocation {
+	line := pos.Line - 1
+	if line < 0 {
+		line = 0
+	}
+	start, end := nameRange(s.line(pos.FileName, line), pos.Column, name)
+	return lspLocation{
+		URI: pathToURI(pos.FileName),
+		Range: lspRange{
+			Start: lspPosition{Line: line, Character: start},
+			End:   lspPosition{Line: line, Character: end},
+		},
+	}
+}
+
+// line returns the text of a line of a file, from the open documents
+// or from the file system.
+func (s *lspSession) line(file string, line int) string {
+	b, err := filesystem.ReadAll(s.fs, file)
+	if err != nil {
+		return ""
+	}
+	return lineAt(string(b), line)
+}
+
+// nameRange finds name in the line. The compiler reports the column where
+// identifiers end but for declarations it can be the position of the keyword,
+// so search for the first occurrence from there.
+func nameRange(line string, column int, name string) (int, int) {
+	if name == "" {
+		return column, column
+	}
+
+	start := column - len(name) + 1
+	if start >= 0 && start+len(name) <= len(line) && line[start:start+len(name)] == name {
+		return start, start + len(name)
+	}
+
+	from := start
+	if from < 0 {
+		from = 0
+	}
+
+	for _, offset := range []int{from, 0} {
+		if offset > len(line) {
+			continue
+		}
+		if i := indexWord(line[offset:], name); i != -1 {
+			return offset + i, offset + i + len(name)
+		}
+	}
+
+	return 0, len(line)
+}
+
+// indexWord returns the index of the first occurrence of name as a whole word.
+func indexWord(s, name string) int {
+	var offset int
+	for {
+		i := strings.Index(s[offset:], name)
+		if i == -1 {
+			return -1
+		}
+		i += offset
+		end := i + len(name)
+		if (i == 0 || !isIdentByte(s[i-1])) && (end == len(s) || !isIdentByte(s[end])) {
+			return i
+		}
+		offset = i + 1
+	}
+}
+
+func lineAt(text string, line int) string {
+	for i := 0; i < line; i++ {
+		j := strings.IndexByte(text, '\n')
+		if j == -1 {
+			return ""
+		}
+		text = text[j+1:]
+	}
+	if j := strings.IndexByte(text, '\n'); j != -1 {
+		text = text[:j]
+	}
+	return strings.TrimSuffix(text, "\r")
+}
+
+// wordAt returns the identifier under the column, the identifier before
+// it if they are separated by a dot and the range of the word.
+func wordAt(line string, column int) (string, string, int, int) {
+	if column > len(line) {
+		column = len(line)
+	}
+
+	start := column
+	for start > 0 && isIdentByte(line[start-1]) {
+		start--
+	}
+
+	end := column
+	for end < len(line) && isIdentByte(line[end]) {
+		end++
+	}
+
+	word := line[start:end]
+
+	var qualifier string
+	if start > 0 && line[start-1] == '.' {
+		i := start - 1
+		for i > 0 && isIdentByte(line[i-1]) {
+			i--
+		}
+		qualifier = line[i : start-1]
+	}
+
+	return qualifier, word, start, end
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
+
+// overlayFS serves the documents open in the editor from memory
+// and the rest of the files from the underlying file system.
+type overlayFS struct {
+	filesystem.FS
+	docs *filesystem.VirtualFS
+}
+
+func newOverlayFS(fs filesystem.FS) *overlayFS {
+	return &overlayFS{FS: fs, docs: filesystem.NewVirtualFS()}
+}
+
+func (o *overlayFS) set(path string, data []byte) {
+	filesystem.WritePath(o.docs, path, data)
+}
+
+func (o *overlayFS) remove(path string) {
+	o.docs.RemoveAll(path)
+}
+
+func (o *overlayFS) isOpen(name string) bool {
+	abs, err := o.FS.Abs(name)
+	if err != nil {
+		return false
+	}
+	fi, err := o.docs.Stat(abs)
+	return err == nil && !fi.IsDir()
+}
+
+func (o *overlayFS) Open(name string) (filesystem.File, error) {
+	if o.isOpen(name) {
+		abs, _ := o.FS.Abs(name)
+		return o.docs.Open(abs)
+	}
+	return o.FS.Open(name)
+}
+
+func (o *overlayFS) OpenIfExists(name string) (filesystem.File, error) {
+	if o.isOpen(name) {
+		abs, _ := o.FS.Abs(name)
+		return o.docs.Open(abs)
+	}
+	return o.FS.OpenIfExists(name)
+}
+
+func (o *overlayFS) Stat(name string) (os.FileInfo, error) {
+	if o.isOpen(name) {
+		abs, _ := o.FS.Abs(name)
+		return o.docs.Stat(abs)
+	}
+	return o.FS.Stat(name)
+}
+
+// nativeDef is a declaration in the type definitions of the native libraries.
+type nativeDef struct {
+	container string // the namespace or interface
+	name      string
+	signature string
+	doc       string
+	function  bool
+	global    bool
+	namespace bool
+}
+
+func (d *nativeDef) markdown() string {
+	s := "```typescript\n" + d.signature + "\n```"
+	if d.container != "" {
+		s = "```typescript\n" + d.container + "." + strings.TrimPrefix(d.signature, "function ") + "\n```"
+	}
+	if d.doc != "" {
+		s += "\n\n" + d.doc
+	}
+	return s
+}
+
+func (d *nativeDef) completionKind() int {
+	switch {
+	case d.namespace:
+		return lspKindModule
+	case d.function && d.container != "" && !d.global:
+		return lspKindMethod
+	case d.function:
+		return lspKindFunction
+	default:
+		return lspKindProperty
+	}
+}
+
+// nativeIndex indexes the declarations of the d.ts registered by the
+// native libraries.
+type nativeIndex struct {
+	defs []*nativeDef
+}
+
+func newNativeIndex(dts string) *nativeIndex {
+	x := &nativeIndex{}
+
+	type block struct {
+		name      string
+		namespace bool
+	}
+
+	var stack []block
+	var doc []string
+	var inComment bool
+
+	for _, line := range strings.Split(dts, "\n") {
+		line = strings.TrimSpace(line)
+
+		if inComment {
+			if strings.HasPrefix(line, "*/") || strings.HasSuffix(line, "*/") {
+				inComment = false
+				continue
+			}
+			doc = append(doc, strings.TrimSpace(strings.TrimPrefix(line, "*")))
+			continue
+		}
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "/**"):
+			doc = nil
+			text := strings.TrimSpace(strings.TrimPrefix(line, "/**"))
+			if strings.HasSuffix(text, "*/") {
+				text = strings.TrimSpace(strings.TrimSuffix(text, "*/"))
+				if text != "" {
+					doc = append(doc, text)
+				}
+				continue
+			}
+			if text != "" {
+				doc = append(doc, text)
+			}
+			inComment = true
+			continue
+		case strings.HasPrefix(line, "//"):
+			continue
+		}
+
+		// close blocks
+		if strings.HasPrefix(line, "}") {
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			doc = nil
+			continue
+		}
+
+		container := ""
+		for _, b := range stack {
+			if container != "" {
+				container += "."
+			}
+			container += b.name
+		}
+
+		decl := strings.TrimPrefix(line, "export ")
+		decl = strings.TrimPrefix(decl, "declare ")
+
+		if strings.HasSuffix(line, "{") {
+			fields := strings.Fields(strings.TrimSuffix(decl, "{"))
+			if len(fields) >= 2 {
+				kind, name := fields[0], fields[1]
+				if i := strings.IndexAny(name, "<"); i != -1 {
+					name = name[:i]
+				}
+				isNamespace := kind == "namespace" || kind == "module"
+				if isNamespace && name != "global" {
+					full := name
+					if container != "" {
+						full = container + "." + name
+					}
+					x.defs = append(x.defs, &nativeDef{
+						container: container,
+						name:      name,
+						signature: "namespace " + full,
+						namespace: true,
+					})
+				}
+				if name == "global" {
+					// declare global adds declarations to the top level
+					name = ""
+				}
+				stack = append(stack, block{name: name, namespace: isNamespace})
+			} else {
+				stack = append(stack, block{})
+			}
+			doc = nil
+			continue
+		}
+
+		d := parseNativeDecl(decl)
+		if d == nil {
+			doc = nil
+			continue
+		}
+
+		d.container = container
+		d.global = container == ""
+		if len(stack) > 0 {
+			// members of namespaces are accessed as functions, not methods
+			d.global = d.global || stack[len(stack)-1].namespace
+		}
+		d.doc = strings.Join(doc, "\n")
+		doc = nil
+
+		x.defs = append(x.defs, d)
+	}
+
+	return x
+}
+
+// parseNativeDecl parses a line of a declaration like "function f(a: string): void",
+// "const x: number" or "method(): void".
+func parseNativeDecl(line string) *nativeDef {
+	d := &nativeDef{signature: strings.TrimSuffix(line, ";")}
+
+	for _, prefix := range []string{"function ", "const ", "let ", "var ", "readonly "} {
+		if strings.HasPrefix(line, prefix) {
+			line = strings.TrimPrefix(line, prefix)
+			d.function = prefix == "function "
+			break
+		}
+	}
+
+	i := 0
+	for i < len(line) && isIdentByte(line[i]) {
+		i++
+	}
+
+	if i == 0 {
+		return nil
+	}
+
+	d.name = line[:i]
+	rest := strings.TrimSpace(line[i:])
+
+	switch {
+	case strings.HasPrefix(rest, "("), strings.HasPrefix(rest, "<"):
+		d.function = true
+	case strings.HasPrefix(rest, ":"), strings.HasPrefix(rest, "?:"):
+	default:
+		return nil
+	}
+
+	switch d.name {
+	case "type", "interface", "class", "enum":
+		return nil
+	}
+
+	return d
+}
+
+// lookup returns the declarations of name. If there is qualifier only the
+// declarations of that namespace or interface are returned.
+func (x *nativeIndex) lookup(qualifier, name string) []*nativeDef {
+	var matches []*nativeDef
+	for _, d := range x.defs {
+		if d.name != name {
+			continue
+		}
+		if qualifier != "" && d.container != qualifier && !strings.HasSuffix(d.container, "."+qualifier) {
+			continue
+		}
+		matches = append(matches, d)
+	}
+
+	if len(matches) == 0 && qualifier != "" {
+		// the qualifier is probably a variable so search in all interfaces.
+		return x.lookup("", name)
+	}
+
+	return matches
+}
+
+func (x *nativeIndex) isNamespace(name string) bool {
+	for _, d := range x.defs {
+		if d.namespace && (d.name == name || d.container+"."+d.name == name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *nativeIndex) members(container string) []*nativeDef {
+	var list []*nativeDef
+	for _, d := range x.defs {
+		if d.container == container {
+			list = append(list, d)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+func (x *nativeIndex) namespaces() []string {
+	var list []string
+	for _, d := range x.defs {
+		if d.namespace && d.container == "" {
+			list = append(list, d.name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (x *nativeIndex) globals() []*nativeDef {
+	var list []*nativeDef
+	for _, d := range x.defs {
+		if d.container == "" && !d.namespace {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// prototypeMembers returns the members of interfaces, which
+// are the methods and properties of native values and objects.
+func (x *nativeIndex) prototypeMembers() []*nativeDef {
+	var list []*nativeDef
+	for _, d := range x.defs {
+		if d.container != "" && !d.global && !d.namespace {
+			list = append(list, d)
+		}
+	}
+	return list
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -24,17 +26,58 @@ func printVersion() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lsp":
+			if err := serveLSP(); err != nil {
+				fatal(err)
+			}
+			return
+		case "fmt":
+			if err := formatFiles(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		case "doc":
+			if err := generateDocs(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		case "lint":
+			n, err := lintFiles(os.Args[2:])
+			if err != nil {
+				fatal(err)
+			}
+			if n > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	v := flag.Bool("v", false, "version")
 	c := flag.Bool("c", false, "compile")
 	s := flag.Bool("s", false, "strip")
 	e := flag.Bool("e", false, "eval")
 	o := flag.String("o", "", "output file")
 	d := flag.Bool("d", false, "decompile")
+	asm := flag.Bool("asm", false, "assemble a file written by -d into a binary")
 	a := flag.Bool("a", false, "show AST")
 	r := flag.Bool("r", false, "list resources")
 	n := flag.Bool("n", false, "no optimizations")
 	i := flag.Bool("i", false, "generate native.d.ts and tsconfig.json")
 	dts := flag.Bool("dts", false, "generate native.d.ts")
+	dbg := flag.Bool("debug", false, "debug the program")
+	dap := flag.String("dap", "", "start a Debug Adapter Protocol server in the address or '-' for stdio")
+	cfg := flag.Bool("cfg", false, "print the control-flow graph of the functions in DOT. Optionally filter by function names")
+	calls := flag.Bool("calls", false, "print the call graph in DOT")
+	asJSON := flag.Bool("json", false, "print -cfg and -calls as JSON")
+	attachAddr := flag.String("attach", "", "attach to a REPL opened with runtime.listenREPL in the address")
+	token := flag.String("token", "", "token for -attach. Defaults to DUNE_TOKEN")
+	record := flag.String("record", "", "run deterministically logging the results of the natives to the file")
+	replay := flag.String("replay", "", "run deterministically replaying the log written by -record")
+	audit := flag.Bool("audit", false, "run recording the permissions used. Writes the JSON report to -o or stderr")
+	errFormat := flag.String("errors", "text", "format of the errors of the program: text or json")
 	flag.Parse()
 
 	args := flag.Args()
@@ -68,6 +111,36 @@ func main() {
 		return
 	}
 
+	if *audit {
+		if aLen == 0 {
+			fatal("no program specified")
+		}
+		if err := auditProgram(args[0], args[1:], *o); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *cfg {
+		if aLen == 0 {
+			fatal("no program specified")
+		}
+		if err := printCFG(args[0], args[1:], *asJSON); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *calls {
+		if aLen == 0 {
+			fatal("no program specified")
+		}
+		if err := printCallGraph(args[0], *asJSON); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
 	if *a {
 		at, err := parser.Parse(filesystem.OS, args[0])
 		if err != nil {
@@ -77,6 +150,31 @@ func main() {
 		return
 	}
 
+	if *asm {
+		if aLen != 1 {
+			fatal("only one parameter allowed")
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			fatal(err)
+		}
+		p, err := dune.Assemble(f)
+		f.Close()
+		if err != nil {
+			fatal(err)
+		}
+
+		out := *o
+		if out == "" {
+			n := filepath.Base(args[0])
+			out = strings.TrimSuffix(n, filepath.Ext(n)) + ".bin"
+		}
+		if err := build(p, out); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
 	if *c {
 		p, err := loadProgram(args[0], *s)
 		if err != nil {
@@ -124,9 +222,49 @@ func main() {
 		return
 	}
 
+	if *dap != "" {
+		var path string
+		var programArgs []string
+		if aLen > 0 {
+			path = args[0]
+			programArgs = args[1:]
+		}
+		if err := serveDAP(*dap, path, programArgs); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *attachAddr != "" {
+		if err := attach(*attachAddr, *token); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *dbg {
+		if aLen == 0 {
+			fatal("no program specified")
+		}
+		if err := debug(args[0], args[1:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *record != "" || *replay != "" {
+		if aLen == 0 {
+			fatal("no program specified")
+		}
+		if err := execDeterministic(args[0], args[1:], *record, *replay); err != nil {
+			fatalError(err, *errFormat)
+		}
+		return
+	}
+
 	if aLen > 0 {
 		if err := exec(args[0], args[1:]); err != nil {
-			fatal(err)
+			fatalError(err, *errFormat)
 		}
 		return
 	}
@@ -187,6 +325,96 @@ func exec(programPath string, args []string) error {
 	return err
 }
 
+// execDeterministic runs the program recording the results of the natives
+// to a log or replaying them from it.
+func execDeterministic(programPath string, args []string, record, replay string) error {
+	if record != "" && replay != "" {
+		return fmt.Errorf("-record and -replay can't be used together")
+	}
+
+	p, err := loadProgram(programPath, false)
+	if err != nil {
+		return err
+	}
+
+	p.AddPermission("trusted")
+
+	vm := dune.NewVM(p)
+	vm.FileSystem = filesystem.OS
+
+	if record != "" {
+		f, err := os.Create(record)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := vm.Record(f); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(replay)
+		if err != nil {
+			return err
+		}
+		err = vm.Replay(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	values := make([]dune.Value, len(args))
+	for i, arg := range args {
+		values[i] = dune.NewValue(arg)
+	}
+
+	_, err = vm.Run(values...)
+	return err
+}
+
+// auditProgram runs the program granting all permissions and prints the
+// directive with the ones that it used.
+func auditProgram(programPath string, args []string, out string) error {
+	p, err := loadProgram(programPath, false)
+	if err != nil {
+		return err
+	}
+
+	vm := dune.NewVM(p)
+	vm.FileSystem = filesystem.OS
+	vm.Audit = dune.NewAudit()
+
+	values := make([]dune.Value, len(args))
+	for i, arg := range args {
+		values[i] = dune.NewValue(arg)
+	}
+
+	_, runErr := vm.Run(values...)
+
+	if d := vm.Audit.Directive(); d != "" {
+		fmt.Fprintln(os.Stderr, d)
+	} else {
+		fmt.Fprintln(os.Stderr, "// no permissions required")
+	}
+
+	if out == "" {
+		if err := vm.Audit.WriteJSON(os.Stderr); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := vm.Audit.WriteJSON(f); err != nil {
+			return err
+		}
+	}
+
+	return runErr
+}
+
 func loadProgram(path string, strip bool) (*dune.Program, error) {
 	path, ok := find(path)
 	if !ok {
@@ -233,6 +461,21 @@ func doLoadProgram(path string, strip bool) (*dune.Program, error) {
 	ext := filepath.Ext(path)
 
 	switch ext {
+	case ".dasm":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %w", path, err)
+		}
+		defer f.Close()
+		p, err := dune.Assemble(f)
+		if err != nil {
+			return nil, fmt.Errorf("error assembling %s: %w", path, err)
+		}
+		if strip {
+			p.Strip()
+		}
+		return p, nil
+
 	case ".bin":
 		f, err := os.Open(path)
 		if err != nil {
@@ -297,6 +540,28 @@ func writeIfNotExists(fs filesystem.FS, name string, data []byte) {
 	filesystem.WritePath(fs, name, data)
 }
 
+// fatalError prints the error of the program with the line of code
+// where it happened, or as JSON for logs, and exits.
+func fatalError(err error, format string) {
+	var e *dune.VMError
+	if !errors.As(err, &e) {
+		fatal(err)
+	}
+
+	switch format {
+	case "json":
+		b, err := json.Marshal(e.Report())
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Fprintln(os.Stderr, string(b))
+	default:
+		fmt.Fprint(os.Stderr, e.Render())
+	}
+
+	os.Exit(1)
+}
+
 func fatal(values ...interface{}) {
 	fmt.Println(values...)
 	os.Exit(1)
@@ -3,10 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/dunelang/dune"
@@ -17,6 +19,7 @@ import (
 )
 
 const PROMPT = "> "
+const CONTINUE_PROMPT = "... "
 
 var stdOut *bytes.Buffer
 
@@ -62,26 +65,32 @@ func startREPL() error {
 		},
 	})
 
-	p, err := dune.CompileStr("")
+	vm, err := newREPLVM()
 	if err != nil {
 		return err
 	}
 
-	p.AddPermission("trusted")
+	defer func() {
+		vm.FinalizeGlobals()
+	}()
 
-	stdOut = &bytes.Buffer{}
-	vm := dune.NewVM(p)
-	vm.FileSystem = filesystem.OS
-	vm.Stdout = stdOut
-	vm.Stderr = stdOut
+	// run the code and print the result
+	evalCode := func(code string) bool {
+		if err := dune.Eval(vm, code); err != nil {
+			s.PrintError(err)
+			return false
+		}
 
-	if _, err = vm.Run(); err != nil {
-		return err
+		if stdOut.Len() > 0 {
+			s.Print(stdOut.String())
+			stdOut.Reset()
+		}
+
+		s.Source = append(s.Source, code)
+		return true
 	}
 
-	defer func() {
-		vm.FinalizeGlobals()
-	}()
+	var natives *nativeIndex
 
 loop:
 	for {
@@ -89,6 +98,13 @@ loop:
 		case termbox.EventKey:
 			switch ev.Key {
 			case termbox.KeyCtrlC:
+				// cancel the multi-line input
+				if len(s.pending) > 0 {
+					s.pending = nil
+					s.Prompt = PROMPT
+					s.Print("// canceled")
+					continue
+				}
 				break loop
 
 			case termbox.KeyArrowLeft:
@@ -104,10 +120,12 @@ loop:
 				s.DeleteRuneForward()
 
 			case termbox.KeyTab:
-				s.InsertRune(' ')
-				s.InsertRune(' ')
-				s.InsertRune(' ')
-				s.InsertRune(' ')
+				if natives == nil {
+					natives = newNativeIndex(dune.TypeDefs())
+				}
+				s.Complete(func(word string) []string {
+					return completions(vm, natives, word)
+				})
 
 			case termbox.KeySpace:
 				s.InsertRune(' ')
@@ -140,18 +158,7 @@ loop:
 				s.historyIndex = len(s.History)
 				s.pasted = nil
 				s.Print("// Exiting paste mode, now interpreting.")
-
-				if err := dune.Eval(vm, code); err != nil {
-					s.PrintError(err)
-					continue
-				}
-
-				if stdOut.Len() > 0 {
-					s.Print(stdOut.String())
-					stdOut.Reset()
-				}
-
-				s.Source = append(s.Source, code)
+				evalCode(code)
 				continue
 
 			case termbox.KeyEnter:
@@ -164,16 +171,35 @@ loop:
 				}
 
 				s.Print(s.Prompt + code)
-				if code == "" {
+				if code == "" && len(s.pending) == 0 {
 					continue
 				}
 
-				s.AddToHistory(code)
+				if code != "" {
+					s.AddToHistory(code)
+				}
 				s.text = nil
 				s.lastText = nil
 
+				// keep reading lines while there are blocks open
+				if len(s.pending) > 0 || openBlocks(code) > 0 {
+					s.pending = append(s.pending, code)
+					code = strings.Join(s.pending, "\n")
+					if openBlocks(code) > 0 {
+						s.Prompt = CONTINUE_PROMPT
+						s.Redraw()
+						continue
+					}
+					s.pending = nil
+					s.Prompt = PROMPT
+					s.Redraw()
+					evalCode(code)
+					continue
+				}
+
 				// custom commands from the REPL
-				switch code {
+				command, arg := splitCommand(code)
+				switch command {
 
 				case ":paste":
 					s.pasteMode = true
@@ -182,6 +208,48 @@ loop:
 					s.Print("// Entering paste mode (ctrl-D to finish)")
 					continue
 
+				case ":load":
+					b, err := ioutil.ReadFile(arg)
+					if err != nil {
+						s.PrintError(err)
+						continue
+					}
+					if evalCode(string(b)) {
+						s.Print("// loaded " + arg)
+					}
+					continue
+
+				case ":type":
+					if natives == nil {
+						natives = newNativeIndex(dune.TypeDefs())
+					}
+					t, err := typeOf(vm, natives, arg)
+					if err != nil {
+						s.PrintError(err)
+						continue
+					}
+					s.Print(t)
+					continue
+
+				case ":time":
+					start := time.Now()
+					if evalCode(arg) {
+						s.Print(fmt.Sprintf("// %v", time.Since(start)))
+					}
+					continue
+
+				case ":reset":
+					vm.FinalizeGlobals()
+					vm, err = newREPLVM()
+					if err != nil {
+						return err
+					}
+					s.Source = nil
+					s.Print("// session reset")
+					continue
+				}
+
+				switch code {
 				case "list":
 					s.Print(strings.Join(s.Source, "\n"))
 					continue
@@ -198,21 +266,11 @@ loop:
 				}
 
 				if strings.HasPrefix(code, "help ") {
-					s.showHelp(strings.TrimPrefix(code, "help "))
+					s.showHelp(vm, strings.TrimPrefix(code, "help "))
 					continue
 				}
 
-				if err := dune.Eval(vm, code); err != nil {
-					s.PrintError(err)
-					continue
-				}
-
-				if stdOut.Len() > 0 {
-					s.Print(stdOut.String())
-					stdOut.Reset()
-				}
-
-				s.Source = append(s.Source, code)
+				evalCode(code)
 
 			default:
 				if ev.Ch != 0 {
@@ -230,6 +288,28 @@ loop:
 	return nil
 }
 
+// newREPLVM returns a vm with an empty program to evaluate the code.
+func newREPLVM() (*dune.VM, error) {
+	p, err := dune.CompileStr("")
+	if err != nil {
+		return nil, err
+	}
+
+	p.AddPermission("trusted")
+
+	stdOut = &bytes.Buffer{}
+	vm := dune.NewVM(p)
+	vm.FileSystem = filesystem.OS
+	vm.Stdout = stdOut
+	vm.Stderr = stdOut
+
+	if _, err = vm.Run(); err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
+
 func NewScreen() *screen {
 	s := &screen{
 		ColorFG: termbox.ColorDefault,
@@ -237,7 +317,7 @@ func NewScreen() *screen {
 		Prompt:  PROMPT,
 		Lines: []string{
 			dune.VERSION,
-			"commands: :paste, help, list, asm, quit",
+			"commands: :paste, :load, :type, :time, :reset, help, list, asm, quit",
 			"",
 		},
 	}
@@ -261,6 +341,7 @@ type screen struct {
 	Prompt       string
 	pasteMode    bool
 	pasted       []string
+	pending      []string // lines of an incomplete multi-line input
 	text         []byte
 	lastText     []byte
 	historyIndex int
@@ -592,8 +673,42 @@ func (s *screen) loadHistory() {
 	s.historyIndex = s.historyStart - 1
 }
 
-func (s *screen) showHelp(value string) {
-	for _, f := range dune.AllNativeFuncs() {
+// Complete replaces the word before the cursor with its completion. If
+// there are many candidates it inserts the common prefix and prints them.
+func (s *screen) Complete(candidates func(word string) []string) {
+	i := s.byteIndexAtCell(s.text, s.cursorX)
+	start := i
+	for start > 0 && (isIdentByte(s.text[start-1]) || s.text[start-1] == '.') {
+		start--
+	}
+
+	word := string(s.text[start:i])
+	if word == "" {
+		for j := 0; j < 4; j++ {
+			s.InsertRune(' ')
+		}
+		return
+	}
+
+	list := candidates(word)
+	if len(list) == 0 {
+		return
+	}
+
+	for _, r := range strings.TrimPrefix(commonPrefix(list), word) {
+		s.InsertRune(r)
+	}
+
+	if len(list) > 1 {
+		text, x := s.text, s.cursorX
+		s.Print(strings.Join(list, "  "))
+		s.text, s.cursorX = text, x
+		s.RedrawLine(false)
+	}
+}
+
+func (s *screen) showHelp(vm *dune.VM, value string) {
+	for _, f := range vm.NativeFuncs() {
 		n := strings.TrimPrefix(f.Name, "->")
 		if strings.HasPrefix(n, value) {
 			s.Print(n)
@@ -44,6 +44,32 @@ func CompileStr(code string) (*Program, error) {
 	return c.Compile(a)
 }
 
+// CompileWith compiles the program with the natives of the registry
+// instead of the global ones.
+func CompileWith(fs filesystem.FS, path string, natives *NativeRegistry) (*Program, error) {
+	a, err := parser.Parse(fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCompiler()
+	c.SetNatives(natives)
+	return c.Compile(a)
+}
+
+// CompileStrWith compiles the code with the natives of the registry
+// instead of the global ones.
+func CompileStrWith(code string, natives *NativeRegistry) (*Program, error) {
+	a, err := parser.ParseStr(code)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCompiler()
+	c.SetNatives(natives)
+	return c.Compile(a)
+}
+
 func NewCompiler() *compiler {
 	program := &Program{}
 
@@ -66,6 +92,22 @@ func NewCompiler() *compiler {
 	return c
 }
 
+// SetNatives makes the program use the natives of the registry.
+func (c *compiler) SetNatives(natives *NativeRegistry) {
+	c.natives = natives
+	c.program.Natives = natives
+}
+
+// lookupNative returns the native with the name in the registry
+// of the program or the global one.
+func (c *compiler) lookupNative(name string) (NativeFunction, bool) {
+	if c.natives != nil {
+		return c.natives.Lookup(name)
+	}
+	f, ok := allNativeMap[name]
+	return f, ok
+}
+
 type selector struct {
 	optChains []*Instruction
 }
@@ -86,6 +128,9 @@ type compiler struct {
 	builtinFuncs      []string
 	builtinProperties []string
 	selectors         []*selector
+	symbols           *Symbols
+	lastSymbol        interface{}
+	natives           *NativeRegistry
 }
 
 func (c *compiler) Compile(mod *ast.Program) (*Program, error) {
@@ -121,9 +166,25 @@ func (c *compiler) Compile(mod *ast.Program) (*Program, error) {
 	// make sure that the last instruction is a return
 	c.emit(op_return, Void, Void, Void, ast.Position{})
 
+	c.setSources(mod)
+
 	return c.program, nil
 }
 
+// setSources keeps the code of the files to show it in errors.
+func (c *compiler) setSources(mod *ast.Program) {
+	sources := map[string]string{mod.File.Path: mod.File.Source}
+	for _, f := range mod.Modules {
+		sources[f.Path] = f.Source
+	}
+
+	p := c.program
+	p.Sources = make([]string, len(p.Files))
+	for i, file := range p.Files {
+		p.Sources[i] = sources[file]
+	}
+}
+
 func (c *compiler) compileModule(path string, modules map[string]*ast.File, compiled map[string]bool) error {
 	if compiled[path] {
 		return nil
@@ -219,12 +280,19 @@ func (c *compiler) compileVarDeclStmt(t *ast.VarDeclStmt) error {
 				return err
 			}
 			c.newRegister(name, t.Exported, k)
+			c.declare(name, SymbolConstant, t.Pos)
 			return nil
 		}
 	}
 
 	// the right hand is a expression
 	i := c.newRegister(name, t.Exported, nil)
+	if t.Const {
+		c.declare(name, SymbolConstant, t.Pos)
+	} else {
+		c.declare(name, SymbolVariable, t.Pos)
+	}
+
 	if _, err := c.compileExpr(t.Value, i); err != nil {
 		return err
 	}
@@ -253,6 +321,7 @@ func (c *compiler) compileEnumDeclStmt(t *ast.EnumDeclStmt) error {
 	}
 
 	c.program.Enums = append(c.program.Enums, enum)
+	c.declareSymbol(enum, t.Name, SymbolEnum, t.Pos)
 
 	return nil
 }
@@ -296,6 +365,10 @@ func (c *compiler) compileFuncDecl(t *ast.FuncDeclStmt, isClass bool) (*function
 	fi.receiverType = t.ReceiverType
 
 	f := fi.function
+	if !isClass && !t.Anonymous {
+		c.declareSymbol(f, t.Name, SymbolFunction, t.Pos)
+	}
+
 	f.Arguments = len(t.Args.List)
 	for _, arg := range t.Args.List {
 		if arg.Optional {
@@ -332,6 +405,7 @@ func (c *compiler) compileFuncBody(t *ast.FuncDeclStmt, fi *functionInfo) error
 	// they are copied directly to the beginning of the values.
 	for _, arg := range t.Args.List {
 		c.newRegister(arg.Name, false, nil)
+		c.declare(arg.Name, SymbolParameter, arg.Pos)
 	}
 
 	// if it is a method reserve a register for the "this" object.
@@ -645,6 +719,7 @@ func (c *compiler) compileForInOfStmt(t *ast.ForStmt, in bool) error {
 
 	// this is the key variable
 	key := c.newRegister(dec.Name, false, nil)
+	c.declare(dec.Name, SymbolVariable, dec.Pos)
 
 	// create a temp array with the keys/index or values
 	items := c.newTempRegister()
@@ -885,6 +960,7 @@ func (c *compiler) compileTryStmt(t *ast.TryStmt) error {
 
 	if t.CatchIdent != nil {
 		try.B = c.newRegister(t.CatchIdent.Name, true, nil)
+		c.declare(t.CatchIdent.Name, SymbolVariable, t.CatchIdent.Pos)
 
 		// make the err register on scope from the beginning of the current scope
 		regs := c.currentFunc.function.Registers
@@ -1164,7 +1240,7 @@ func (c *compiler) compileIfBlockStmt(t *ast.IfStmt) error {
 // compileExpr compiles the expression and stores the result in dest
 func (c *compiler) compileExpr(t ast.Expr, dest *Address) (*Address, error) {
 	if dest.Kind == AddrConstant {
-		return nil, fmt.Errorf("can't modify a constant")
+		return nil, newError(t.Position(), "can't modify a constant")
 	}
 
 	switch t := t.(type) {
@@ -1330,7 +1406,7 @@ func (c *compiler) compileIncIdentExpr(t *ast.IncStmt) error {
 	}
 
 	if i.Kind == AddrConstant {
-		return fmt.Errorf("can't modify a constant")
+		return newError(t.Position(), "can't modify a constant")
 	}
 
 	switch t.Operator {
@@ -1444,6 +1520,8 @@ func (c *compiler) compileIdentExpr(t *ast.IdentExpr, dest *Address) (*Address,
 
 	if i == Void {
 		i = c.getUnresolved(t.Name, t.Pos)
+	} else {
+		c.reference(t.Pos)
 	}
 
 	if dest != Void {
@@ -1559,6 +1637,8 @@ func (c *compiler) compileNewInstanceExpr(t *ast.NewInstanceExpr, dest *Address)
 		}
 		if addr == Void {
 			addr = c.getUnresolved(tp.Name, tp.Pos)
+		} else {
+			c.reference(tp.Pos)
 		}
 
 	case *ast.SelectorExpr:
@@ -1894,14 +1974,17 @@ func (c *compiler) compileSelectorExpr(t *ast.SelectorExpr, dest *Address) (*Add
 			return Void, err
 		}
 		if addr.Kind == AddrEnum {
+			c.reference(ident.Pos)
 			return c.compileEnumValueExpr(addr, t.Sel.Name, dest, t.Position())
 		}
 
+		c.lastSymbol = nil
 		addr, err = c.compileModuleExpr(ident.Name, t.Sel.Name, dest, t.Position())
 		if err != nil {
 			return Void, err
 		}
 		if addr != Void {
+			c.reference(t.Sel.Pos)
 			return addr, nil
 		}
 
@@ -1923,7 +2006,7 @@ func (c *compiler) compileSelectorExpr(t *ast.SelectorExpr, dest *Address) (*Add
 
 		// if it is a built-in native property
 		builtInField := "->" + ident.Name
-		f, ok := allNativeMap[builtInField]
+		f, ok := c.lookupNative(builtInField)
 		if ok {
 			addr := NewAddress(AddrNativeFunc, f.Index)
 			x = c.newTempRegister()
@@ -2024,7 +2107,7 @@ func (c *compiler) compileNativeFunction(pkg, name string, dest *Address, pos as
 		fullName = name
 	}
 
-	f, ok := allNativeMap[fullName]
+	f, ok := c.lookupNative(fullName)
 	if !ok {
 		return Void, nil
 	}
@@ -2041,7 +2124,7 @@ func (c *compiler) compileNativeFunction(pkg, name string, dest *Address, pos as
 func (c *compiler) compileNativeField(pkg, name string, dest *Address, pos ast.Position) (*Address, error) {
 	fullName := "->" + pkg + "." + name
 
-	f, ok := allNativeMap[fullName]
+	f, ok := c.lookupNative(fullName)
 	if !ok {
 		return Void, nil
 	}
@@ -2204,6 +2287,7 @@ func (c *compiler) compileClassDeclStmt(t *ast.ClassDeclStmt) error {
 	c.closures = nil
 
 	index := len(c.program.Classes)
+	c.declareSymbol(cl, t.Name, SymbolClass, t.Pos)
 
 	c.currentClass = index
 
@@ -2477,6 +2561,14 @@ func (c *compiler) newRegister(name string, exported bool, kAddress *Address) *A
 
 // find a register in the current scope.
 func (c *compiler) findRegister(name string, fi *functionInfo) (*Address, error) {
+	addr, sym, err := c.lookupRegister(name, fi)
+	c.lastSymbol = sym
+	return addr, err
+}
+
+// lookupRegister returns the address of a name and the declaration
+// it resolves to: a register, function, class or enum.
+func (c *compiler) lookupRegister(name string, fi *functionInfo) (*Address, interface{}, error) {
 	// search local registers
 	if !fi.function.IsGlobal {
 		f := fi.function
@@ -2488,9 +2580,9 @@ func (c *compiler) findRegister(name string, fi *functionInfo) (*Address, error)
 			}
 			if r.Name == name && (r.EndPC == 0 || pc <= r.EndPC) {
 				if r.KAddress != nil {
-					return r.KAddress, nil
+					return r.KAddress, r, nil
 				}
-				return NewAddress(AddrLocal, r.Index), nil
+				return NewAddress(AddrLocal, r.Index), r, nil
 			}
 		}
 
@@ -2509,14 +2601,14 @@ func (c *compiler) findRegister(name string, fi *functionInfo) (*Address, error)
 				r := parentFn.Registers[i]
 				if r.Name == name && pc >= r.StartPC && (r.EndPC == 0 || pc <= r.EndPC) {
 					if r.KAddress != nil {
-						return r.KAddress, nil
+						return r.KAddress, r, nil
 					}
 					// we can't know in advance the index in the global array of closures
 					// because previous registers can be referenced later and thus marked
 					// as closure so keep a index (ix) in the compiler and after
 					// compiled the top function, update all in updateClosureIndexes.
 					ix := c.markAsClosure(parentFn, r)
-					return NewAddress(AddrClosure, ix), nil
+					return NewAddress(AddrClosure, ix), r, nil
 				}
 			}
 
@@ -2543,9 +2635,9 @@ func (c *compiler) findRegister(name string, fi *functionInfo) (*Address, error)
 				continue
 			}
 			if r.KAddress != nil {
-				return r.KAddress, nil
+				return r.KAddress, r, nil
 			}
-			return NewAddress(AddrGlobal, r.Index), nil
+			return NewAddress(AddrGlobal, r.Index), r, nil
 		}
 	}
 
@@ -2557,7 +2649,7 @@ func (c *compiler) findRegister(name string, fi *functionInfo) (*Address, error)
 			if e.Module != c.modulePrefix && !e.Exported {
 				continue
 			}
-			return NewAddress(AddrEnum, i), nil
+			return NewAddress(AddrEnum, i), e, nil
 		}
 	}
 
@@ -2567,7 +2659,7 @@ func (c *compiler) findRegister(name string, fi *functionInfo) (*Address, error)
 			if cl.Module != c.modulePrefix && !cl.Exported {
 				continue
 			}
-			return NewAddress(AddrClass, i), nil
+			return NewAddress(AddrClass, i), cl, nil
 		}
 	}
 
@@ -2576,23 +2668,25 @@ func (c *compiler) findRegister(name string, fi *functionInfo) (*Address, error)
 		f := fi.function
 		if !strings.ContainsRune(name, '@') {
 			if fi.module != c.modulePrefix && !f.Exported {
-				return Void, fmt.Errorf("%s is not exported", name)
+				return Void, nil, fmt.Errorf("%s is not exported", name)
 			}
 		}
-		return NewAddress(AddrFunc, f.Index), nil
+		return NewAddress(AddrFunc, f.Index), f, nil
 	}
 
 	// search built-in functions
 	for _, k := range c.builtinFuncs {
 		if name == k {
-			return c.compileNativeFunction("", name, Void, ast.Position{})
+			addr, err := c.compileNativeFunction("", name, Void, ast.Position{})
+			return addr, nil, err
 		}
 	}
 
 	// search built-in properties
 	for _, k := range c.builtinProperties {
 		if name == k {
-			return c.compileNativeField("", name, Void, ast.Position{})
+			addr, err := c.compileNativeField("", name, Void, ast.Position{})
+			return addr, nil, err
 		}
 	}
 
@@ -2610,19 +2704,19 @@ func (c *compiler) findRegister(name string, fi *functionInfo) (*Address, error)
 		}
 		if r.Name == gnsName && (r.EndPC == 0 || pc <= r.EndPC) {
 			if r.KAddress != nil {
-				return r.KAddress, nil
+				return r.KAddress, r, nil
 			}
-			return NewAddress(AddrGlobal, r.Index), nil
+			return NewAddress(AddrGlobal, r.Index), r, nil
 		}
 	}
 
 	for i, e := range c.program.Enums {
 		if gnsName == e.Name {
-			return NewAddress(AddrEnum, i), nil
+			return NewAddress(AddrEnum, i), e, nil
 		}
 	}
 
-	return Void, nil
+	return Void, nil, nil
 }
 
 func (c *compiler) findModuleRegister(moduleAlias, name string, pos ast.Position) (*Address, error) {
@@ -2808,6 +2902,8 @@ func (c *compiler) fixUnresolved() error {
 			return newError(u.pos, "Undeclared identifier: %s", u.name)
 		}
 
+		c.reference(u.pos)
+
 		// Check that a global variable in the same module is not used before is declared.
 		if v.Kind == AddrGlobal {
 			// globals called from inside a function are always in scope
@@ -0,0 +1,529 @@
+package dune
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ToValue converts a Go value to plain values. Structs are converted to maps
+// with the names of their json tags, pointers to the value they point to and
+// the types with a converter, like time.Time, to their native objects.
+// Unlike Bind the result doesn't reference the Go value.
+func ToValue(v interface{}) (Value, error) {
+	return toValue(reflect.ValueOf(v), "", 0)
+}
+
+// FromValue decodes a value into the Go value that target points to. Maps
+// and class instances are decoded into structs matching the keys with the
+// fields like encoding/json does. The errors include the path of the value
+// that can't be converted: "items[2].price: expected float64, got string".
+func FromValue(v Value, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("FromValue: expected a non nil pointer")
+	}
+	return fromValue(v, rv.Elem(), "")
+}
+
+// ConvertError is returned when a value can't be converted.
+type ConvertError struct {
+	Path     string
+	Expected string
+	Got      string
+}
+
+func (e *ConvertError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("expected %s, got %s", e.Expected, e.Got)
+	}
+	return fmt.Sprintf("%s: expected %s, got %s", e.Path, e.Expected, e.Got)
+}
+
+func toValue(v reflect.Value, path string, depth int) (Value, error) {
+	if depth > MAX_EXPORT_RECURSION {
+		return NullValue, fmt.Errorf("%s: max recursion exceeded", path)
+	}
+	depth++
+
+	if !v.IsValid() {
+		return NullValue, nil
+	}
+
+	t := v.Type()
+
+	if t == valueType {
+		return v.Interface().(Value), nil
+	}
+
+	if c, ok := converters[t]; ok {
+		return c.ToValue(v.Interface()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return NewBool(v.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInt64(v.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NewInt64(int64(v.Uint())), nil
+
+	case reflect.Float32, reflect.Float64:
+		return NewFloat(v.Float()), nil
+
+	case reflect.String:
+		return NewString(v.String()), nil
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return NullValue, nil
+		}
+		if t == bytesType {
+			return NewBytes(v.Bytes()), nil
+		}
+		fallthrough
+
+	case reflect.Array:
+		values := make([]Value, v.Len())
+		for i := range values {
+			iv, err := toValue(v.Index(i), indexPath(path, i), depth)
+			if err != nil {
+				return NullValue, err
+			}
+			values[i] = iv
+		}
+		return NewArrayValues(values), nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return NullValue, nil
+		}
+		m := make(map[Value]Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			k, err := toValue(iter.Key(), path, depth)
+			if err != nil {
+				return NullValue, err
+			}
+			iv, err := toValue(iter.Value(), fieldPath(path, k.String()), depth)
+			if err != nil {
+				return NullValue, err
+			}
+			m[k] = iv
+		}
+		return NewMapValues(m), nil
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NullValue, nil
+		}
+		if err, ok := v.Interface().(error); ok {
+			return NewString(err.Error()), nil
+		}
+		return toValue(v.Elem(), path, depth)
+
+	case reflect.Struct:
+		fields := jsonFields(t)
+		m := make(map[Value]Value, len(fields))
+		for _, f := range fields {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			iv, err := toValue(fv, fieldPath(path, f.name), depth)
+			if err != nil {
+				return NullValue, err
+			}
+			m[NewString(f.name)] = iv
+		}
+		return NewMapValues(m), nil
+	}
+
+	if path == "" {
+		return NullValue, fmt.Errorf("unsupported type %v", t)
+	}
+	return NullValue, fmt.Errorf("%s: unsupported type %v", path, t)
+}
+
+func fromValue(v Value, dst reflect.Value, path string) error {
+	t := dst.Type()
+
+	if t == valueType {
+		dst.Set(reflect.ValueOf(v))
+		return nil
+	}
+
+	if v.IsNil() {
+		dst.Set(reflect.Zero(t))
+		return nil
+	}
+
+	if c, ok := converters[t]; ok {
+		i, err := c.FromValue(v)
+		if err != nil {
+			if path == "" {
+				return err
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		dst.Set(reflect.ValueOf(i))
+		return nil
+	}
+
+	if v.Type == Object {
+		if o, ok := v.ToObject().(*boundObject); ok {
+			if o.v.Type() == t {
+				dst.Set(o.v)
+				return nil
+			}
+			if o.v.Type().Elem() == t {
+				dst.Set(o.v.Elem())
+				return nil
+			}
+		}
+	}
+
+	mismatch := func() error {
+		return &ConvertError{Path: path, Expected: t.String(), Got: v.TypeName()}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Type != Bool {
+			return mismatch()
+		}
+		dst.SetBool(v.ToBool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch v.Type {
+		case Int, Rune:
+		case Float:
+			if f := v.ToFloat(); f != float64(int64(f)) {
+				return mismatch()
+			}
+		default:
+			return mismatch()
+		}
+		i := v.ToInt()
+		if dst.OverflowInt(i) {
+			return &ConvertError{Path: path, Expected: t.String(), Got: strconv.FormatInt(i, 10)}
+		}
+		dst.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch v.Type {
+		case Int, Rune:
+		case Float:
+			if f := v.ToFloat(); f != float64(int64(f)) {
+				return mismatch()
+			}
+		default:
+			return mismatch()
+		}
+		i := v.ToInt()
+		if i < 0 || dst.OverflowUint(uint64(i)) {
+			return &ConvertError{Path: path, Expected: t.String(), Got: strconv.FormatInt(i, 10)}
+		}
+		dst.SetUint(uint64(i))
+
+	case reflect.Float32, reflect.Float64:
+		switch v.Type {
+		case Int, Float:
+			dst.SetFloat(v.ToFloat())
+		default:
+			return mismatch()
+		}
+
+	case reflect.String:
+		switch v.Type {
+		case String, Rune:
+			dst.SetString(v.String())
+		default:
+			return mismatch()
+		}
+
+	case reflect.Slice:
+		if t == bytesType && (v.Type == Bytes || v.Type == String) {
+			dst.SetBytes(append([]byte(nil), v.ToBytes()...))
+			return nil
+		}
+		if v.Type != Array {
+			return mismatch()
+		}
+		values := v.ToArray()
+		s := reflect.MakeSlice(t, len(values), len(values))
+		for i, item := range values {
+			if err := fromValue(item, s.Index(i), indexPath(path, i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+
+	case reflect.Array:
+		if v.Type != Array {
+			return mismatch()
+		}
+		values := v.ToArray()
+		if len(values) > t.Len() {
+			return &ConvertError{Path: path, Expected: t.String(), Got: fmt.Sprintf("%d items", len(values))}
+		}
+		for i, item := range values {
+			if err := fromValue(item, dst.Index(i), indexPath(path, i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		fields, ok := valueFields(v)
+		if !ok {
+			return mismatch()
+		}
+		m := reflect.MakeMapWithSize(t, len(fields))
+		for k, item := range fields {
+			kv := reflect.New(t.Key()).Elem()
+			if err := fromValue(k, kv, path); err != nil {
+				return err
+			}
+			iv := reflect.New(t.Elem()).Elem()
+			if err := fromValue(item, iv, fieldPath(path, k.String())); err != nil {
+				return err
+			}
+			m.SetMapIndex(kv, iv)
+		}
+		dst.Set(m)
+
+	case reflect.Ptr:
+		p := reflect.New(t.Elem())
+		if err := fromValue(v, p.Elem(), path); err != nil {
+			return err
+		}
+		dst.Set(p)
+
+	case reflect.Struct:
+		fields, ok := valueFields(v)
+		if !ok {
+			return mismatch()
+		}
+		for _, f := range jsonFields(t) {
+			item, ok := lookupField(fields, f.name)
+			if !ok {
+				continue
+			}
+			fv, ok := fieldByIndexAlloc(dst, f.index)
+			if !ok {
+				continue
+			}
+			if err := fromValue(item, fv, fieldPath(path, f.name)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Interface:
+		e := v.Export(0)
+		if e == nil {
+			dst.Set(reflect.Zero(t))
+			return nil
+		}
+		ev := reflect.ValueOf(e)
+		if !ev.Type().AssignableTo(t) {
+			return mismatch()
+		}
+		dst.Set(ev)
+
+	default:
+		return mismatch()
+	}
+
+	return nil
+}
+
+// valueFields returns the keys and values of a map or the fields
+// of a class instance.
+func valueFields(v Value) (map[Value]Value, bool) {
+	switch v.Type {
+	case Map:
+		m := v.ToMap()
+		m.RLock()
+		defer m.RUnlock()
+		fields := make(map[Value]Value, len(m.Map))
+		for k, item := range m.Map {
+			fields[k] = item
+		}
+		return fields, true
+
+	case Object:
+		i, ok := v.ToObject().(*instance)
+		if !ok {
+			return nil, false
+		}
+		i.RLock()
+		defer i.RUnlock()
+		fields := make(map[Value]Value, len(i.iMap))
+		for k, item := range i.iMap {
+			fields[NewString(k)] = item
+		}
+		return fields, true
+	}
+
+	return nil, false
+}
+
+// lookupField finds the key with the name, preferring an exact match
+// but accepting a case-insensitive match like encoding/json.
+func lookupField(fields map[Value]Value, name string) (Value, bool) {
+	if v, ok := fields[NewString(name)]; ok {
+		return v, true
+	}
+	for k, v := range fields {
+		if k.Type == String && strings.EqualFold(k.String(), name) {
+			return v, true
+		}
+	}
+	return NullValue, false
+}
+
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+type jsonField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var jsonFieldCache sync.Map
+
+// jsonFields returns the fields of a struct with the names that encoding/json
+// uses. The fields of embedded structs are promoted unless they are shadowed.
+func jsonFields(t reflect.Type) []jsonField {
+	if f, ok := jsonFieldCache.Load(t); ok {
+		return f.([]jsonField)
+	}
+
+	seen := make(map[string]bool)
+	var fields []jsonField
+	addJSONFields(t, nil, seen, &fields)
+
+	jsonFieldCache.Store(t, fields)
+	return fields
+}
+
+func addJSONFields(t reflect.Type, index []int, seen map[string]bool, fields *[]jsonField) {
+	var embedded []int
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if j := strings.Index(tag, ","); j != -1 {
+			name, opts = tag[:j], tag[j+1:]
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, i)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		*fields = append(*fields, jsonField{
+			name:      name,
+			index:     append(append([]int{}, index...), i),
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+
+	// the fields of the outer struct shadow the embedded ones
+	for _, i := range embedded {
+		f := t.Field(i)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		addJSONFields(ft, append(append([]int{}, index...), i), seen, fields)
+	}
+}
+
+// fieldByIndex returns the field or false if it is in a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc returns the field allocating the embedded pointers or
+// false if it is in a pointer to an unexported struct that can't be set.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
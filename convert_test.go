@@ -0,0 +1,157 @@
+package dune
+
+import (
+	"reflect"
+	"testing"
+)
+
+type convertItem struct {
+	SKU   string  `json:"sku"`
+	Price float64 `json:"price"`
+	Qty   int     `json:"qty,omitempty"`
+}
+
+type ConvertMeta struct {
+	Source string `json:"source"`
+}
+
+type convertOrder struct {
+	*ConvertMeta
+	ID       int               `json:"id"`
+	Customer string            `json:"customer"`
+	Items    []convertItem     `json:"items"`
+	Labels   map[string]string `json:"labels"`
+	Parent   *convertOrder     `json:"parent"`
+	Ignored  string            `json:"-"`
+	Note     string
+	Data     []byte `json:"data"`
+}
+
+func TestToValue(t *testing.T) {
+	o := &convertOrder{
+		ConvertMeta: &ConvertMeta{Source: "web"},
+		ID:          1,
+		Customer:    "ann",
+		Items:       []convertItem{{SKU: "a", Price: 1.5, Qty: 2}, {SKU: "b", Price: 3}},
+		Labels:      map[string]string{"vip": "yes"},
+		Ignored:     "x",
+		Note:        "n",
+		Data:        []byte("abc"),
+	}
+
+	v, err := ToValue(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := CompileStr(`
+		function main(o) {
+			let values = [
+				o.id, o.customer, o.items.length, o.items[0].qty, o.items[1].qty,
+				o.labels.vip, o.parent, o.ignored, o.Note, o.source, o.data.length
+			]
+			let s = ""
+			for (let v of values) {
+				s += v + ","
+			}
+			return s
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewVM(p).Run(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.String() != "1,ann,2,2,,yes,,,n,web,3," {
+		t.Fatal(r)
+	}
+
+	if _, err := ToValue(map[string]interface{}{"f": func() {}}); err == nil || err.Error() != "f: unsupported type func()" {
+		t.Fatal(err)
+	}
+}
+
+func TestFromValue(t *testing.T) {
+	p, err := CompileStr(`
+		class Item {
+			sku: string
+			price: number
+			constructor(sku: string, price: number) {
+				this.sku = sku
+				this.price = price
+			}
+		}
+
+		function main() {
+			return {
+				id: 7,
+				CUSTOMER: "bob",
+				source: "api",
+				items: [new Item("a", 2), { sku: "b", price: 1.25, qty: 3 }],
+				labels: { a: "1" },
+				parent: { id: 6 },
+				data: "xyz"
+			}
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVM(p).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var o convertOrder
+	if err := FromValue(v, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := convertOrder{
+		ConvertMeta: &ConvertMeta{Source: "api"},
+		ID:          7,
+		Customer:    "bob",
+		Items:       []convertItem{{SKU: "a", Price: 2}, {SKU: "b", Price: 1.25, Qty: 3}},
+		Labels:      map[string]string{"a": "1"},
+		Parent:      &convertOrder{ID: 6},
+		Data:        []byte("xyz"),
+	}
+
+	if !reflect.DeepEqual(o, expected) {
+		t.Fatalf("%+v", o)
+	}
+}
+
+func TestFromValueErrors(t *testing.T) {
+	data := []struct {
+		code   string
+		target interface{}
+		err    string
+	}{
+		{`return { items: [{ sku: "a" }, { sku: 2 }] }`, &convertOrder{}, "items[1].sku: expected string, got int"},
+		{`return { parent: { labels: { x: 1 } } }`, &convertOrder{}, "parent.labels.x: expected string, got int"},
+		{`return { id: 1.5 }`, &convertOrder{}, "id: expected int, got float"},
+		{`return 300`, new(int8), "expected int8, got 300"},
+		{`return "a"`, &convertOrder{}, "expected dune.convertOrder, got string"},
+	}
+
+	for _, d := range data {
+		p, err := CompileStr(d.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := NewVM(p).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = FromValue(v, d.target)
+		if err == nil || err.Error() != d.err {
+			t.Fatalf("%s: expected %q, got %v", d.code, d.err, err)
+		}
+	}
+}
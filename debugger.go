@@ -0,0 +1,563 @@
+package dune
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrDebuggerStop is returned by the VM when the debugger aborts the execution.
+var ErrDebuggerStop = errors.New("execution stopped by the debugger")
+
+type PauseReason int
+
+const (
+	PauseBreakpoint PauseReason = iota
+	PauseStep
+	PauseException
+	PauseRequest
+)
+
+func (r PauseReason) String() string {
+	switch r {
+	case PauseBreakpoint:
+		return "breakpoint"
+	case PauseStep:
+		return "step"
+	case PauseException:
+		return "exception"
+	case PauseRequest:
+		return "pause"
+	default:
+		return fmt.Sprintf("PauseReason(%d)", int(r))
+	}
+}
+
+// StepMode is how the execution continues after a pause.
+type StepMode int
+
+const (
+	StepContinue StepMode = iota
+	StepOver
+	StepInto
+	StepOut
+	StepStop
+)
+
+// DebugEvent describes why the VM has paused.
+type DebugEvent struct {
+	Reason     PauseReason
+	Breakpoint *Breakpoint
+	Error      error
+	Line       TraceLine
+}
+
+// DebugHandler is notified when a debugged VM pauses. It is called from the
+// goroutine of the VM and the execution resumes when it returns so it is safe
+// to inspect the VM state from it.
+type DebugHandler interface {
+	Paused(d *Debugger, e *DebugEvent) StepMode
+}
+
+type Breakpoint struct {
+	ID       int
+	File     string
+	Line     int  // the line requested
+	Verified bool // if it could be resolved to an instruction
+	Resolved int  // the line where the execution will stop
+}
+
+// DebugFrame is a frame of the call stack. Index 0 is the top of the stack.
+type DebugFrame struct {
+	Index    int
+	Function string
+	File     string
+	Line     int
+	Column   int
+}
+
+type Variable struct {
+	Name  string
+	Value Value
+}
+
+type breakLocation struct {
+	funcIndex int
+	pc        int
+}
+
+// Debugger is attached to a VM to pause the execution in breakpoints,
+// step through the code and inspect the values of the registers.
+type Debugger struct {
+	Handler           DebugHandler
+	PauseOnExceptions bool
+
+	mu          sync.Mutex
+	vm          *VM
+	program     *Program
+	breakpoints []*Breakpoint
+	locations   map[breakLocation]*Breakpoint
+	watches     []string
+	lastID      int
+	pauseFlag   int32
+
+	mode     StepMode
+	stepFp   int
+	stepFunc int
+	stepLine int
+}
+
+func NewDebugger(h DebugHandler) *Debugger {
+	return &Debugger{Handler: h}
+}
+
+// SetDebugger attaches a debugger to the VM. Pass nil to detach it.
+func (vm *VM) SetDebugger(d *Debugger) {
+	vm.debugger = d
+	if d != nil {
+		d.mu.Lock()
+		d.vm = vm
+		d.resolveBreakpoints()
+		d.mu.Unlock()
+	}
+}
+
+func (vm *VM) Debugger() *Debugger {
+	return vm.debugger
+}
+
+// VM returns the attached VM.
+func (d *Debugger) VM() *VM {
+	return d.vm
+}
+
+// Pause stops the execution before the next line. It can be called
+// from any goroutine.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.pauseFlag, 1)
+}
+
+// SetBreakpoint adds a breakpoint. If there is no code in that line it
+// is moved to the next line that has code.
+func (d *Debugger) SetBreakpoint(file string, line int) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastID++
+	b := &Breakpoint{ID: d.lastID, File: file, Line: line}
+	d.breakpoints = append(d.breakpoints, b)
+	d.resolveBreakpoints()
+	return b
+}
+
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, b := range d.breakpoints {
+		if b.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			d.resolveBreakpoints()
+			return true
+		}
+	}
+	return false
+}
+
+// ClearBreakpoints removes all the breakpoints of a file or all of
+// them if file is empty.
+func (d *Debugger) ClearBreakpoints(file string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var bps []*Breakpoint
+	if file != "" {
+		for _, b := range d.breakpoints {
+			if !sameFile(b.File, file) {
+				bps = append(bps, b)
+			}
+		}
+	}
+
+	d.breakpoints = bps
+	d.resolveBreakpoints()
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	bps := make([]*Breakpoint, len(d.breakpoints))
+	copy(bps, d.breakpoints)
+	return bps
+}
+
+func (d *Debugger) AddWatch(expr string) {
+	d.mu.Lock()
+	d.watches = append(d.watches, expr)
+	d.mu.Unlock()
+}
+
+func (d *Debugger) RemoveWatch(i int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if i < 0 || i >= len(d.watches) {
+		return false
+	}
+	d.watches = append(d.watches[:i], d.watches[i+1:]...)
+	return true
+}
+
+func (d *Debugger) Watches() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w := make([]string, len(d.watches))
+	copy(w, d.watches)
+	return w
+}
+
+// resolveBreakpoints maps each breakpoint to the first instruction of the line
+// using the positions of the functions. It must be called with the lock held.
+func (d *Debugger) resolveBreakpoints() {
+	d.locations = make(map[breakLocation]*Breakpoint)
+
+	if d.vm == nil {
+		return
+	}
+
+	p := d.vm.Program
+	d.program = p
+
+	for _, b := range d.breakpoints {
+		b.Verified = false
+		b.Resolved = 0
+
+		fileIndex := -1
+		for i, f := range p.Files {
+			if sameFile(f, b.File) {
+				fileIndex = i
+				break
+			}
+		}
+		if fileIndex == -1 {
+			continue
+		}
+
+		// find the closest line with code equal or after the requested one
+		var loc breakLocation
+		var bestLine int
+		for _, f := range p.Functions {
+			for pc, pos := range f.Positions {
+				if pos.File != fileIndex || pos.Line < b.Line {
+					continue
+				}
+				if bestLine == 0 || pos.Line < bestLine {
+					bestLine = pos.Line
+					loc = breakLocation{f.Index, pc}
+				}
+			}
+		}
+
+		if bestLine == 0 {
+			continue
+		}
+
+		b.Verified = true
+		b.Resolved = bestLine
+		d.locations[loc] = b
+	}
+}
+
+func sameFile(a, b string) bool {
+	if a == b {
+		return true
+	}
+	a = filepath.ToSlash(filepath.Clean(a))
+	b = filepath.ToSlash(filepath.Clean(b))
+	if a == b {
+		return true
+	}
+	return strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
+
+// onInstruction is called by the VM before executing each instruction.
+func (d *Debugger) onInstruction(vm *VM) error {
+	frame := vm.callStack[vm.fp]
+	f := vm.Program.Functions[frame.funcIndex]
+	pc := frame.pc
+
+	if pc >= len(f.Positions) {
+		return nil
+	}
+
+	pos := f.Positions[pc]
+	if pos.Line == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	if d.program != vm.Program {
+		// the program has been replaced, by an eval for example.
+		d.resolveBreakpoints()
+	}
+	bp := d.locations[breakLocation{f.Index, pc}]
+	d.mu.Unlock()
+
+	e := &DebugEvent{Breakpoint: bp}
+
+	switch {
+	case atomic.CompareAndSwapInt32(&d.pauseFlag, 1, 0):
+		e.Reason = PauseRequest
+	case bp != nil:
+		e.Reason = PauseBreakpoint
+	case d.isStepEnd(vm.fp, f.Index, pos.Line):
+		e.Reason = PauseStep
+	default:
+		return nil
+	}
+
+	return d.pause(vm, e)
+}
+
+func (d *Debugger) isStepEnd(fp, funcIndex, line int) bool {
+	switch d.mode {
+	case StepInto:
+		return fp != d.stepFp || funcIndex != d.stepFunc || line != d.stepLine
+	case StepOver:
+		if fp < d.stepFp {
+			return true
+		}
+		return fp == d.stepFp && (funcIndex != d.stepFunc || line != d.stepLine)
+	case StepOut:
+		return fp < d.stepFp
+	}
+	return false
+}
+
+// onException is called by the VM when an error is not handled.
+func (d *Debugger) onException(vm *VM, err error) {
+	if !d.PauseOnExceptions {
+		return
+	}
+
+	e := &DebugEvent{Reason: PauseException, Error: err}
+
+	// the execution can't continue so only allow to inspect
+	d.pause(vm, e)
+	d.mode = StepContinue
+}
+
+func (d *Debugger) pause(vm *VM, e *DebugEvent) error {
+	frame := vm.callStack[vm.fp]
+	f := vm.Program.Functions[frame.funcIndex]
+	e.Line = vm.Program.ToTraceLine(f, frame.pc)
+
+	mode := StepContinue
+	if d.Handler != nil {
+		mode = d.Handler.Paused(d, e)
+	}
+
+	if mode == StepStop {
+		d.mode = StepContinue
+		return ErrDebuggerStop
+	}
+
+	d.mode = mode
+	d.stepFp = vm.fp
+	d.stepFunc = f.Index
+	d.stepLine = e.Line.Line
+	return nil
+}
+
+// StackFrames returns the call stack starting from the top.
+func (d *Debugger) StackFrames() []DebugFrame {
+	vm := d.vm
+	p := vm.Program
+
+	var frames []DebugFrame
+
+	for i := vm.fp; i >= 0; i-- {
+		frame := vm.callStack[i]
+		f := p.Functions[frame.funcIndex]
+
+		if f.IsGlobal && vm.initialized {
+			// the global function has ended
+			continue
+		}
+
+		pc := d.framePC(i)
+
+		df := DebugFrame{Index: vm.fp - i, Function: f.Name}
+		if pc < len(f.Positions) {
+			t := p.ToTraceLine(f, pc)
+			df.File = t.File
+			df.Line = t.Line
+			df.Column = f.Positions[pc].Column
+		}
+
+		if df.Function == "" {
+			df.Function = fmt.Sprintf("%dF", f.Index)
+		}
+
+		frames = append(frames, df)
+	}
+
+	return frames
+}
+
+// framePC returns the pc that is being executed in the frame. The frames
+// that are not the top have already advanced the pc after the call.
+func (d *Debugger) framePC(fp int) int {
+	vm := d.vm
+	frame := vm.callStack[fp]
+	if vm.fp == fp || frame.pc == 0 {
+		return frame.pc
+	}
+	return frame.pc - 1
+}
+
+func (d *Debugger) stackFrame(index int) (*stackFrame, int, error) {
+	fp := d.vm.fp - index
+	if fp < 0 || fp > d.vm.fp {
+		return nil, 0, fmt.Errorf("invalid frame %d", index)
+	}
+	return d.vm.callStack[fp], fp, nil
+}
+
+// Locals returns the values of the registers and closures that are in
+// scope in a frame. Index 0 is the top of the stack.
+func (d *Debugger) Locals(index int) ([]Variable, error) {
+	frame, fp, err := d.stackFrame(index)
+	if err != nil {
+		return nil, err
+	}
+
+	f := d.vm.Program.Functions[frame.funcIndex]
+	if f.IsGlobal {
+		return d.Globals(), nil
+	}
+
+	pc := d.framePC(fp)
+
+	var vars []Variable
+	for _, r := range scopeRegisters(f, pc) {
+		vars = append(vars, Variable{Name: r.Name, Value: frame.values[r.Index]})
+	}
+
+	for _, c := range frame.closures {
+		name := c.register.Name
+		if name == "" || name[0] == '@' || hasVariable(vars, name) {
+			continue
+		}
+		vars = append(vars, Variable{Name: name, Value: c.get()})
+	}
+
+	return vars, nil
+}
+
+// Globals returns the values of the global registers.
+func (d *Debugger) Globals() []Variable {
+	return d.vm.GlobalVariables()
+}
+
+// Value returns the value of a register by name in a frame, searching
+// first the local scope and then the globals.
+func (d *Debugger) Value(index int, name string) (Value, bool) {
+	locals, err := d.Locals(index)
+	if err != nil {
+		return NullValue, false
+	}
+
+	for _, v := range locals {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+
+	for _, v := range d.Globals() {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+
+	return NullValue, false
+}
+
+func hasVariable(vars []Variable, name string) bool {
+	for _, v := range vars {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeRegisters returns the named registers visible in the pc. If a name is
+// declared more than once, the innermost one is returned.
+func scopeRegisters(f *Function, pc int) []*Register {
+	var regs []*Register
+	seen := make(map[string]int)
+
+	for _, r := range f.Registers {
+		if r.Name == "" || r.Name[0] == '@' {
+			continue
+		}
+		if pc < r.StartPC || (r.EndPC != 0 && pc > r.EndPC) {
+			continue
+		}
+		if i, ok := seen[r.Name]; ok {
+			regs[i] = r
+			continue
+		}
+		seen[r.Name] = len(regs)
+		regs = append(regs, r)
+	}
+
+	return regs
+}
+
+// Evaluate evaluates an expression in the scope of a frame. The locals are
+// passed by value so assignments to them are not visible in the program.
+func (d *Debugger) Evaluate(index int, expr string) (Value, error) {
+	locals, err := d.Locals(index)
+	if err != nil {
+		return NullValue, err
+	}
+
+	frame, _, _ := d.stackFrame(index)
+	if d.vm.Program.Functions[frame.funcIndex].IsGlobal {
+		locals = nil
+	}
+
+	var names []string
+	var args []Value
+	for _, v := range locals {
+		if isIdentifier(v.Name) {
+			names = append(names, v.Name)
+			args = append(args, v.Value)
+		}
+	}
+
+	return evalFunc(d.vm, "return "+expr, names, args)
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || s == "this" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || c == '$':
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,275 @@
+package dune
+
+import (
+	"testing"
+
+	"github.com/dunelang/dune/filesystem"
+)
+
+type testDebugHandler struct {
+	events []*DebugEvent
+	fn     func(d *Debugger, e *DebugEvent) StepMode
+}
+
+func (h *testDebugHandler) Paused(d *Debugger, e *DebugEvent) StepMode {
+	h.events = append(h.events, e)
+	if h.fn != nil {
+		return h.fn(d, e)
+	}
+	return StepContinue
+}
+
+func compileDebugTest(t *testing.T, code string) *Program {
+	fs := filesystem.NewVirtualFS()
+	filesystem.WritePath(fs, "main.ts", []byte(code))
+
+	p, err := Compile(fs, "main.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const debugTestCode = `
+function add(a: number, b: number) {
+	let c = a + b
+	return c
+}
+
+function main() {
+	let x = 1
+
+	let y = add(x, 2)
+	return y
+}
+`
+
+func TestDebuggerBreakpoint(t *testing.T) {
+	p := compileDebugTest(t, debugTestCode)
+
+	var value Value
+	h := &testDebugHandler{
+		fn: func(d *Debugger, e *DebugEvent) StepMode {
+			value, _ = d.Value(0, "x")
+			return StepContinue
+		},
+	}
+
+	d := NewDebugger(h)
+
+	// there is no code in line 9, it must move to the next line
+	b := d.SetBreakpoint("main.ts", 9)
+
+	vm := NewVM(p)
+	vm.SetDebugger(d)
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.ToInt() != 3 {
+		t.Fatal(v)
+	}
+
+	if !b.Verified || b.Resolved != 10 {
+		t.Fatalf("%+v", b)
+	}
+
+	if len(h.events) != 1 {
+		t.Fatal(len(h.events))
+	}
+
+	e := h.events[0]
+	if e.Reason != PauseBreakpoint || e.Line.Line != 10 {
+		t.Fatalf("%+v", e)
+	}
+
+	if value.ToInt() != 1 {
+		t.Fatal(value)
+	}
+}
+
+func TestDebuggerStep(t *testing.T) {
+	p := compileDebugTest(t, debugTestCode)
+
+	var lines []int
+	var frames [][]DebugFrame
+
+	steps := []StepMode{StepOver, StepInto, StepOut, StepOver}
+
+	h := &testDebugHandler{
+		fn: func(d *Debugger, e *DebugEvent) StepMode {
+			lines = append(lines, e.Line.Line)
+			frames = append(frames, d.StackFrames())
+			mode := steps[0]
+			steps = steps[1:]
+			return mode
+		},
+	}
+
+	d := NewDebugger(h)
+	d.SetBreakpoint("main.ts", 8)
+
+	vm := NewVM(p)
+	vm.SetDebugger(d)
+
+	if _, err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int{8, 10, 3, 11}
+	if len(lines) != len(expected) {
+		t.Fatal(lines)
+	}
+
+	for i, l := range expected {
+		if lines[i] != l {
+			t.Fatalf("step %d: expected line %d, got %d", i, l, lines[i])
+		}
+	}
+
+	if len(frames[2]) != 2 || frames[2][0].Function != "add" || frames[2][1].Function != "main" {
+		t.Fatal(frames[2])
+	}
+}
+
+func TestDebuggerLocals(t *testing.T) {
+	p := compileDebugTest(t, debugTestCode)
+
+	var locals []Variable
+	var parent Value
+
+	h := &testDebugHandler{
+		fn: func(d *Debugger, e *DebugEvent) StepMode {
+			var err error
+			locals, err = d.Locals(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parent, _ = d.Value(1, "x")
+			return StepContinue
+		},
+	}
+
+	d := NewDebugger(h)
+	d.SetBreakpoint("main.ts", 4)
+
+	vm := NewVM(p)
+	vm.SetDebugger(d)
+
+	if _, err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]int64)
+	for _, v := range locals {
+		values[v.Name] = v.Value.ToInt()
+	}
+
+	if len(values) != 3 || values["a"] != 1 || values["b"] != 2 || values["c"] != 3 {
+		t.Fatal(locals)
+	}
+
+	if parent.ToInt() != 1 {
+		t.Fatal(parent)
+	}
+}
+
+func TestDebuggerEvaluate(t *testing.T) {
+	p := compileDebugTest(t, `
+let g = 10
+
+function main() {
+	let x = 5
+	return x
+}
+`)
+
+	var value Value
+	var evalErr error
+
+	h := &testDebugHandler{
+		fn: func(d *Debugger, e *DebugEvent) StepMode {
+			value, evalErr = d.Evaluate(0, "x * 2 + g")
+			return StepContinue
+		},
+	}
+
+	d := NewDebugger(h)
+	d.SetBreakpoint("main.ts", 6)
+
+	vm := NewVM(p)
+	vm.SetDebugger(d)
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if evalErr != nil {
+		t.Fatal(evalErr)
+	}
+
+	if value.ToInt() != 20 {
+		t.Fatal(value)
+	}
+
+	if v.ToInt() != 5 {
+		t.Fatal(v)
+	}
+}
+
+func TestDebuggerException(t *testing.T) {
+	p := compileDebugTest(t, `
+function main() {
+	let x = 1
+	throw "snap"
+}
+`)
+
+	h := &testDebugHandler{}
+
+	d := NewDebugger(h)
+	d.PauseOnExceptions = true
+
+	vm := NewVM(p)
+	vm.SetDebugger(d)
+
+	_, err := vm.Run()
+	assertError(t, "snap", err)
+
+	if len(h.events) != 1 {
+		t.Fatal(len(h.events))
+	}
+
+	e := h.events[0]
+	if e.Reason != PauseException || e.Line.Line != 4 || e.Error == nil {
+		t.Fatalf("%+v", e)
+	}
+}
+
+func TestDebuggerStop(t *testing.T) {
+	p := compileDebugTest(t, debugTestCode)
+
+	h := &testDebugHandler{
+		fn: func(d *Debugger, e *DebugEvent) StepMode {
+			return StepStop
+		},
+	}
+
+	d := NewDebugger(h)
+	d.Pause()
+
+	vm := NewVM(p)
+	vm.SetDebugger(d)
+
+	_, err := vm.Run()
+	if err != ErrDebuggerStop {
+		t.Fatal(err)
+	}
+
+	if len(h.events) != 1 || h.events[0].Reason != PauseRequest {
+		t.Fatal(h.events)
+	}
+}
@@ -0,0 +1,397 @@
+package dune
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// replayable are the natives that read from the outside world. Their
+// results are logged when recording and returned again when replaying.
+var replayable = struct {
+	sync.RWMutex
+	names map[string]bool
+}{names: make(map[string]bool)}
+
+// RegisterReplayable marks the natives to log when a VM is recording
+// and to replay without calling them when a VM is replaying.
+func RegisterReplayable(names ...string) {
+	replayable.Lock()
+	for _, name := range names {
+		replayable.names[name] = true
+	}
+	replayable.Unlock()
+}
+
+func isReplayable(name string) bool {
+	replayable.RLock()
+	defer replayable.RUnlock()
+	return replayable.names[name]
+}
+
+// nonReplayable are the natives that give access to the outside world
+// through the objects that they return, like files or connections.
+var nonReplayable = struct {
+	sync.RWMutex
+	names map[string]bool
+}{names: make(map[string]bool)}
+
+// RegisterNonReplayable marks the natives that read from the outside world
+// through the objects that they return. The calls to those objects are not
+// logged so a VM that is replaying refuses to call them.
+func RegisterNonReplayable(names ...string) {
+	nonReplayable.Lock()
+	for _, name := range names {
+		nonReplayable.names[name] = true
+	}
+	nonReplayable.Unlock()
+}
+
+func isNonReplayable(name string) bool {
+	nonReplayable.RLock()
+	defer nonReplayable.RUnlock()
+	return nonReplayable.names[name]
+}
+
+// SetDeterministic makes the runs of the VM reproducible: the random
+// generator is seeded with seed, maps are iterated in key order and the
+// functions launched with go() run one at a time in a fixed order.
+// The clock is fixed with Now.
+func (vm *VM) SetDeterministic(seed int64) {
+	vm.deterministic = true
+	vm.rand = rand.New(rand.NewSource(seed))
+	vm.scheduler = newScheduler()
+	vm.task = vm.scheduler.root
+}
+
+func (vm *VM) Deterministic() bool {
+	return vm.deterministic
+}
+
+// Rand returns the random generator of the VM. Unless the VM is
+// deterministic it is seeded with the current time.
+func (vm *VM) Rand() *rand.Rand {
+	if vm.rand == nil {
+		vm.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return vm.rand
+}
+
+// inheritDeterminism makes a VM cloned from this one deterministic too
+// with a seed taken from this VM and sharing the native log.
+func (vm *VM) inheritDeterminism(m *VM) {
+	if vm.deterministic {
+		m.SetDeterministic(vm.Rand().Int63())
+		m.scheduler = vm.scheduler
+		m.task = nil
+	}
+	m.nativeLog = vm.nativeLog
+}
+
+// MapKeys returns the keys of the map. They are sorted if the VM
+// is deterministic.
+func (vm *VM) MapKeys(m *MapValue) []Value {
+	m.RLock()
+	keys := make([]Value, 0, len(m.Map))
+	for k := range m.Map {
+		keys = append(keys, k)
+	}
+	m.RUnlock()
+
+	if vm.deterministic {
+		sortValues(keys)
+	}
+	return keys
+}
+
+// sortValues sorts by type and then by value.
+func sortValues(values []Value) {
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i], values[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		switch a.Type {
+		case Int, Rune, Float:
+			return a.ToFloat() < b.ToFloat()
+		default:
+			return a.String() < b.String()
+		}
+	})
+}
+
+// Record makes the VM deterministic and writes to w the seed, the clock
+// and the results of the replayable natives that it calls.
+func (vm *VM) Record(w io.Writer) error {
+	if vm.Now.IsZero() {
+		vm.Now = time.Now()
+	}
+
+	seed := time.Now().UnixNano()
+
+	l := &nativeLog{enc: json.NewEncoder(w)}
+	if err := l.enc.Encode(logHeader{Seed: seed, Now: vm.Now}); err != nil {
+		return err
+	}
+
+	vm.SetDeterministic(seed)
+	vm.nativeLog = l
+	return nil
+}
+
+// Replay reads a log written by Record and prepares the VM to run the
+// same way. The replayable natives are not called and return the
+// logged results instead.
+func (vm *VM) Replay(r io.Reader) error {
+	dec := json.NewDecoder(r)
+
+	var h logHeader
+	if err := dec.Decode(&h); err != nil {
+		return fmt.Errorf("invalid replay log: %w", err)
+	}
+
+	l := &nativeLog{replay: true}
+	for {
+		var e logEntry
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("invalid replay log: %w", err)
+		}
+		l.entries = append(l.entries, &e)
+	}
+
+	vm.SetDeterministic(h.Seed)
+	vm.Now = h.Now
+	vm.nativeLog = l
+	return nil
+}
+
+// callNative calls the function logging or replaying its result.
+func (vm *VM) callNative(f NativeFunction, this Value, args []Value) (Value, error) {
+	if vm.nativeLog == nil {
+		return f.Function(this, args, vm)
+	}
+	if !isReplayable(f.Name) {
+		if vm.nativeLog.replay && isNonReplayable(f.Name) {
+			return NullValue, fmt.Errorf("%s can't be replayed: the calls to the objects that it returns are not recorded", f.Name)
+		}
+		return f.Function(this, args, vm)
+	}
+	if vm.nativeLog.replay {
+		return vm.nativeLog.next(f, this, args, vm)
+	}
+	return vm.nativeLog.record(f, this, args, vm)
+}
+
+type logHeader struct {
+	Seed int64     `json:"seed"`
+	Now  time.Time `json:"now"`
+}
+
+type logEntry struct {
+	Function string       `json:"function"`
+	Result   *loggedValue `json:"result,omitempty"`
+	Error    string       `json:"error,omitempty"`
+
+	// Opaque results, like files or connections, can't be logged
+	// and the native is called again when replaying.
+	Opaque bool `json:"opaque,omitempty"`
+}
+
+type nativeLog struct {
+	mu      sync.Mutex
+	replay  bool
+	enc     *json.Encoder
+	entries []*logEntry
+	pos     int
+}
+
+func (l *nativeLog) record(f NativeFunction, this Value, args []Value, vm *VM) (Value, error) {
+	ret, err := f.Function(this, args, vm)
+
+	e := &logEntry{Function: f.Name}
+	if err != nil {
+		e.Error = err.Error()
+	} else if v, ok := logValue(ret); ok {
+		e.Result = v
+	} else {
+		e.Opaque = true
+	}
+
+	l.mu.Lock()
+	encErr := l.enc.Encode(e)
+	l.mu.Unlock()
+
+	if encErr != nil {
+		return NullValue, fmt.Errorf("error recording %s: %w", f.Name, encErr)
+	}
+
+	return ret, err
+}
+
+func (l *nativeLog) next(f NativeFunction, this Value, args []Value, vm *VM) (Value, error) {
+	l.mu.Lock()
+	if l.pos >= len(l.entries) {
+		l.mu.Unlock()
+		return NullValue, fmt.Errorf("replay diverged: unexpected call to %s after the end of the log", f.Name)
+	}
+	e := l.entries[l.pos]
+	l.pos++
+	n := l.pos
+	l.mu.Unlock()
+
+	if e.Function != f.Name {
+		return NullValue, fmt.Errorf("replay diverged at call %d: expected %s, got %s", n, e.Function, f.Name)
+	}
+
+	if e.Opaque {
+		return f.Function(this, args, vm)
+	}
+
+	if e.Error != "" {
+		return NullValue, errors.New(e.Error)
+	}
+
+	return e.Result.value()
+}
+
+// loggedValue is a value encoded keeping its type.
+type loggedValue struct {
+	Type  string         `json:"type"`
+	Value string         `json:"value,omitempty"`
+	Items []*loggedValue `json:"items,omitempty"`
+	Keys  []*loggedValue `json:"keys,omitempty"`
+}
+
+// logValue encodes the value. It returns false if the
+// value contains objects or functions.
+func logValue(v Value) (*loggedValue, bool) {
+	switch v.Type {
+	case Null:
+		return &loggedValue{Type: "null"}, true
+	case Undefined:
+		return &loggedValue{Type: "undefined"}, true
+	case Int:
+		return &loggedValue{Type: "int", Value: strconv.FormatInt(v.ToInt(), 10)}, true
+	case Float:
+		return &loggedValue{Type: "float", Value: strconv.FormatFloat(v.ToFloat(), 'g', -1, 64)}, true
+	case Bool:
+		return &loggedValue{Type: "bool", Value: strconv.FormatBool(v.ToBool())}, true
+	case Rune:
+		return &loggedValue{Type: "rune", Value: string(v.ToRune())}, true
+	case String:
+		return &loggedValue{Type: "string", Value: v.String()}, true
+	case Bytes:
+		return &loggedValue{Type: "bytes", Value: base64.StdEncoding.EncodeToString(v.ToBytes())}, true
+	case Array:
+		a := v.ToArray()
+		l := &loggedValue{Type: "array", Items: make([]*loggedValue, len(a))}
+		for i, item := range a {
+			lv, ok := logValue(item)
+			if !ok {
+				return nil, false
+			}
+			l.Items[i] = lv
+		}
+		return l, true
+	case Map:
+		m := v.ToMap()
+		m.RLock()
+		defer m.RUnlock()
+		l := &loggedValue{Type: "map"}
+		for k, item := range m.Map {
+			lk, ok := logValue(k)
+			if !ok {
+				return nil, false
+			}
+			lv, ok := logValue(item)
+			if !ok {
+				return nil, false
+			}
+			l.Keys = append(l.Keys, lk)
+			l.Items = append(l.Items, lv)
+		}
+		return l, true
+	default:
+		return nil, false
+	}
+}
+
+func (l *loggedValue) value() (Value, error) {
+	if l == nil {
+		return NullValue, nil
+	}
+
+	switch l.Type {
+	case "null":
+		return NullValue, nil
+	case "undefined":
+		return UndefinedValue, nil
+	case "int":
+		i, err := strconv.ParseInt(l.Value, 10, 64)
+		if err != nil {
+			return NullValue, err
+		}
+		return NewInt64(i), nil
+	case "float":
+		f, err := strconv.ParseFloat(l.Value, 64)
+		if err != nil {
+			return NullValue, err
+		}
+		return NewFloat(f), nil
+	case "bool":
+		return NewBool(l.Value == "true"), nil
+	case "rune":
+		r := []rune(l.Value)
+		if len(r) != 1 {
+			return NullValue, fmt.Errorf("invalid logged rune: %q", l.Value)
+		}
+		return NewRune(r[0]), nil
+	case "string":
+		return NewString(l.Value), nil
+	case "bytes":
+		b, err := base64.StdEncoding.DecodeString(l.Value)
+		if err != nil {
+			return NullValue, err
+		}
+		return NewBytes(b), nil
+	case "array":
+		a := make([]Value, len(l.Items))
+		for i, item := range l.Items {
+			v, err := item.value()
+			if err != nil {
+				return NullValue, err
+			}
+			a[i] = v
+		}
+		return NewArrayValues(a), nil
+	case "map":
+		if len(l.Keys) != len(l.Items) {
+			return NullValue, fmt.Errorf("invalid logged map")
+		}
+		m := make(map[Value]Value, len(l.Keys))
+		for i, k := range l.Keys {
+			kv, err := k.value()
+			if err != nil {
+				return NullValue, err
+			}
+			v, err := l.Items[i].value()
+			if err != nil {
+				return NullValue, err
+			}
+			m[kv] = v
+		}
+		return NewMapValues(m), nil
+	default:
+		return NullValue, fmt.Errorf("invalid logged type: %s", l.Type)
+	}
+}
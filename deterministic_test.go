@@ -0,0 +1,163 @@
+package dune
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDeterministicMapKeys(t *testing.T) {
+	p, err := CompileStr(`
+		function main() {
+			let m = { d: 1, b: 2, a: 3, c: 4, 2: 5, 1: 6 }
+			let s = ""
+			for (let k in m) {
+				s += k
+			}
+			for (let v of m) {
+				s += v
+			}
+			return s
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		vm := NewVM(p)
+		vm.SetDeterministic(1)
+		v, err := vm.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.String() != "12abcd653241" {
+			t.Fatal(v)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	var calls int
+
+	RegisterReplayable("replayTest.read", "replayTest.fail")
+	AddNativeFunc(NativeFunction{
+		Name: "replayTest.read",
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			calls++
+			m := NewMap(1)
+			m.ToMap().Map[NewString("n")] = NewArrayValues([]Value{NewInt(calls), NewFloat(0.5), NewBytes([]byte("x"))})
+			return m, nil
+		},
+	})
+	AddNativeFunc(NativeFunction{
+		Name: "replayTest.fail",
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			calls++
+			return NullValue, ErrFunctionNotExist
+		},
+	})
+	AddNativeFunc(NativeFunction{
+		Name: "replayTest.rand",
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NewInt(vm.Rand().Intn(1000000)), nil
+		},
+	})
+
+	p, err := CompileStr(`
+		function main() {
+			let a = replayTest.read()
+			let b = replayTest.read()
+			let e
+			try {
+				replayTest.fail()
+			} catch (err) {
+				e = err.message
+			}
+			return a.n[0] + " " + b.n[0] + " " + a.n[1] + " " + a.n[2].length + " " + e + " " + replayTest.rand()
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log bytes.Buffer
+	vm := NewVM(p)
+	if err := vm.Record(&log); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+
+	vm = NewVM(p)
+	if err := vm.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("the natives were called when replaying: %d", calls)
+	}
+	if replayed.String() != recorded.String() {
+		t.Fatalf("expected %s, got %s", recorded, replayed)
+	}
+
+	p, err = CompileStr(`replayTest.fail()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm = NewVM(p)
+	if err := vm.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.Run(); err == nil || !strings.Contains(err.Error(), "replay diverged at call 1: expected replayTest.read, got replayTest.fail") {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayNonReplayable(t *testing.T) {
+	var calls int
+
+	RegisterNonReplayable("replayTest.open")
+	AddNativeFunc(NativeFunction{
+		Name: "replayTest.open",
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			calls++
+			return NullValue, nil
+		},
+	})
+
+	p, err := CompileStr(`replayTest.open()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log bytes.Buffer
+	vm := NewVM(p)
+	if err := vm.Record(&log); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	vm = NewVM(p)
+	if err := vm.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.Run(); err == nil || !strings.Contains(err.Error(), "replayTest.open can't be replayed") {
+		t.Fatal(err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+}
@@ -32,14 +32,25 @@ func Wrap(msg string, err error) error {
 }
 
 type VMError struct {
-	Code        int
-	Message     string
-	TraceLines  []TraceLine
-	Wrapped     *VMError
-	IsRethrow   bool
+	Code       int
+	Message    string
+	TraceLines []TraceLine
+	Wrapped    *VMError
+	IsRethrow  bool
+
+	// Thrown is the value passed to throw if it was not an error, like
+	// an instance of an error class. It is null otherwise. Hosts can
+	// decode its fields with FromValue.
+	Thrown Value
+
+	// Origin is the stack that launched the async work, like a
+	// goroutine or a timer, where the error happened.
+	Origin []TraceLine
+
 	pc          int
 	instruction *Instruction
 	goError     error
+	program     *Program
 }
 
 func (e *VMError) Type() string {
@@ -69,6 +80,13 @@ func (e *VMError) Error() string {
 		}
 	}
 
+	if len(e.Origin) > 0 {
+		if len(e.TraceLines) == 0 {
+			b.WriteRune('\n')
+		}
+		writeOrigin(b, e.Origin)
+	}
+
 	wrap := e.Wrapped
 	for wrap != nil {
 		b.WriteRune('\n')
@@ -104,9 +122,159 @@ func (e *VMError) Stack() string {
 		fmt.Fprintf(b, " -> %s\n", s.String())
 	}
 
+	writeOrigin(b, e.Origin)
+
 	return b.String()
 }
 
+func writeOrigin(b *bytes.Buffer, origin []TraceLine) {
+	if len(origin) == 0 {
+		return
+	}
+
+	b.WriteString("launched from:\n")
+	for _, s := range origin {
+		if s.Line == 0 {
+			continue
+		}
+		fmt.Fprintf(b, " -> %s\n", s.String())
+	}
+}
+
+// Render returns the error with the line of code where it happened and a
+// caret under the column, if the program has its sources, followed by the
+// stack trace and the errors that it wraps.
+func (e *VMError) Render() string {
+	var b = &bytes.Buffer{}
+
+	b.WriteString(e.Message)
+	b.WriteRune('\n')
+	b.WriteString(e.snippet())
+	b.WriteString(e.Stack())
+
+	if e.Wrapped != nil {
+		b.WriteString("caused by: ")
+		b.WriteString(e.Wrapped.Render())
+	}
+
+	return b.String()
+}
+
+// snippet returns the source line where the error happened.
+func (e *VMError) snippet() string {
+	if e.program == nil || len(e.TraceLines) == 0 {
+		return ""
+	}
+
+	t := e.TraceLines[0]
+	code, ok := e.program.SourceLine(t.File, t.Line)
+	if !ok {
+		return ""
+	}
+
+	num := strconv.Itoa(t.Line)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, " %s | %s\n", num, code)
+
+	// keep the tabs so the caret is aligned with the code
+	indent := make([]rune, 0, t.Column)
+	for i, r := range code {
+		if i >= t.Column {
+			break
+		}
+		if r == '\t' {
+			indent = append(indent, r)
+		} else {
+			indent = append(indent, ' ')
+		}
+	}
+
+	fmt.Fprintf(&b, " %s | %s^\n", strings.Repeat(" ", len(num)), string(indent))
+	return b.String()
+}
+
+// ErrorReport is the JSON format of an error for log ingestion.
+type ErrorReport struct {
+	Message string        `json:"message"`
+	Code    int           `json:"code,omitempty"`
+	Source  string        `json:"source,omitempty"`
+	Stack   []ReportFrame `json:"stack"`
+	Origin  []ReportFrame `json:"origin,omitempty"`
+	Cause   *ErrorReport  `json:"cause,omitempty"`
+	Thrown  interface{}   `json:"thrown,omitempty"`
+}
+
+// ReportFrame is a line of the stack trace of a report. The column is
+// zero based like in the positions of the program.
+type ReportFrame struct {
+	Function string `json:"function,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+}
+
+// Report returns the error in the format for logs. Source is the
+// line of code where it happened if the program has its sources.
+func (e *VMError) Report() *ErrorReport {
+	r := &ErrorReport{
+		Message: e.Message,
+		Code:    e.Code,
+		Stack:   reportFrames(e.TraceLines),
+		Origin:  reportFrames(e.Origin),
+	}
+
+	if e.program != nil && len(e.TraceLines) > 0 {
+		t := e.TraceLines[0]
+		if code, ok := e.program.SourceLine(t.File, t.Line); ok {
+			r.Source = code
+		}
+	}
+
+	if r.Stack == nil {
+		r.Stack = []ReportFrame{}
+	}
+
+	if e.Thrown.Type != Null {
+		r.Thrown = e.Thrown.ExportMarshal(0)
+		if _, ok := e.Thrown.ToObjectOrNil().(*instance); ok {
+			r.Thrown = instanceFields(e.Thrown)
+		}
+	}
+
+	if e.Wrapped != nil {
+		r.Cause = e.Wrapped.Report()
+	}
+
+	return r
+}
+
+func reportFrames(lines []TraceLine) []ReportFrame {
+	var frames []ReportFrame
+	for _, t := range lines {
+		if t.Line == 0 {
+			continue
+		}
+		frames = append(frames, ReportFrame{
+			Function: t.Function,
+			File:     t.File,
+			Line:     t.Line,
+			Column:   t.Column,
+		})
+	}
+	return frames
+}
+
+// instanceFields exports the fields of a class instance.
+func instanceFields(v Value) map[string]interface{} {
+	fields, _ := valueFields(v)
+	m := make(map[string]interface{}, len(fields))
+	for k, item := range fields {
+		m[k.String()] = item.ExportMarshal(0)
+	}
+	return m
+}
+
 // func (e *VMError) stackLines() []string {
 // 	lines := make([]string, len(e.TraceLines))
 
@@ -131,9 +299,72 @@ func (e *VMError) GetField(name string, vm *VM) (Value, error) {
 	case "stackTrace":
 		return NewString(e.Stack()), nil
 	}
+
+	// the fields of a thrown error class are accessible from the error.
+	if i, ok := e.Thrown.ToObjectOrNil().(*instance); ok && i.hasMember(name, vm.Program) {
+		return i.GetField(name, vm)
+	}
+
 	return UndefinedValue, nil
 }
 
+// AsClass returns the first value in the chain of wrapped errors that is an
+// instance of the class. The class is matched by the name in its declaration
+// or by its full name, prefixed with the module, so classes with the same
+// name declared in different modules are all matched by the short name.
+func (e *VMError) AsClass(class string) (Value, bool) {
+	for err := e; err != nil; err = err.Wrapped {
+		if i, ok := err.Thrown.ToObjectOrNil().(*instance); ok && matchClass(i.class, class) {
+			return err.Thrown, true
+		}
+	}
+	return NullValue, false
+}
+
+func matchClass(c *Class, name string) bool {
+	if c.Name == name {
+		return true
+	}
+	i := strings.LastIndexByte(c.Name, '.')
+	return i != -1 && c.Name[i+1:] == name
+}
+
+// thrownError creates the error for a value thrown that is not an error.
+// The message and the code of an instance are taken from its message and
+// code fields and an error in its cause field is wrapped.
+func (vm *VM) thrownError(v Value) *VMError {
+	i, ok := v.ToObjectOrNil().(*instance)
+	if !ok {
+		err := vm.NewError(v.String())
+		err.Thrown = v
+		return err
+	}
+
+	i.RLock()
+	msg := i.iMap["message"]
+	code := i.iMap["code"]
+	cause := i.iMap["cause"]
+	i.RUnlock()
+
+	var err *VMError
+	if msg.Type == String {
+		err = vm.NewError(msg.String())
+	} else {
+		err = vm.NewError(i.class.Name)
+	}
+
+	if code.Type == Int {
+		err.Code = int(code.ToInt())
+	}
+
+	if w, ok := cause.ToObjectOrNil().(*VMError); ok {
+		err.Wrapped = w
+	}
+
+	err.Thrown = v
+	return err
+}
+
 func (e *VMError) GetMethod(name string) NativeMethod {
 	switch name {
 	case "is":
@@ -164,10 +395,12 @@ func (e *VMError) MarshalJSON() ([]byte, error) {
 		Code       int
 		Message    string
 		TraceLines []TraceLine
+		Origin     []TraceLine `json:",omitempty"`
 	}{
 		Code:       e.Code,
 		Message:    e.Message,
 		TraceLines: e.TraceLines,
+		Origin:     e.Origin,
 	})
 }
 
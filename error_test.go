@@ -0,0 +1,106 @@
+package dune
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const errorCode = `
+function fail() {
+	let a = null
+	return a.foo
+}
+
+function main() {
+	fail()
+}
+`
+
+func runError(t *testing.T, p *Program) *VMError {
+	_, err := NewVM(p).Run()
+
+	var e *VMError
+	if !errors.As(err, &e) {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestErrorRender(t *testing.T) {
+	p, err := CompileStr(errorCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := runError(t, p).Render()
+
+	expected := "Cant read property foo of null\n" +
+		" 4 | \treturn a.foo\n" +
+		"   | \t       ^\n" +
+		" -> line 4\n" +
+		" -> line 8\n"
+
+	if s != expected {
+		t.Fatalf("%q", s)
+	}
+}
+
+func TestErrorRenderStripped(t *testing.T) {
+	p, err := CompileStr(errorCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Strip()
+
+	s := runError(t, p).Render()
+	if strings.Contains(s, "|") {
+		t.Fatal(s)
+	}
+}
+
+func TestErrorReport(t *testing.T) {
+	p, err := CompileStr(errorCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(runError(t, p).Report())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"message":"Cant read property foo of null","source":"\treturn a.foo",` +
+		`"stack":[{"function":"fail","line":4,"column":8},{"function":"main","line":8,"column":4}]}`
+
+	if string(b) != expected {
+		t.Fatal(string(b))
+	}
+}
+
+func TestErrorOrigin(t *testing.T) {
+	p, err := CompileStr(errorCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+	vm.Origin = []TraceLine{{Function: "main", Line: 20}}
+
+	_, err = vm.Run()
+
+	var e *VMError
+	if !errors.As(err, &e) {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(e.Error(), "launched from:\n -> line 20\n") {
+		t.Fatalf("%q", e.Error())
+	}
+
+	if len(e.Report().Origin) != 1 {
+		t.Fatal(e.Report().Origin)
+	}
+}
@@ -0,0 +1,403 @@
+package dune
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// BasicBlock is a sequence of instructions of a function that is only
+// entered by the first one and only left by the last one.
+type BasicBlock struct {
+	Index     int         `json:"index"`
+	Start     int         `json:"start"` // the pc of the first instruction
+	End       int         `json:"end"`   // the pc after the last instruction
+	Succs     []BlockEdge `json:"succs"`
+	Reachable bool        `json:"reachable"`
+}
+
+// BlockEdge is a transition to another block. Kind is "next" when it falls
+// through to the next instruction, "jump", "branch" when the jump is
+// conditional, "catch" and "finally" for the handlers of a try and
+// "optchain" when an optional chain finds a null value.
+type BlockEdge struct {
+	Block int    `json:"block"`
+	Kind  string `json:"kind"`
+}
+
+// CFG is the control-flow graph of a function.
+type CFG struct {
+	Function string        `json:"function"`
+	Index    int           `json:"index"`
+	Blocks   []*BasicBlock `json:"blocks"`
+
+	f *Function
+}
+
+// NewCFG builds the control-flow graph of a function from its jumps.
+func NewCFG(p *Program, f *Function) *CFG {
+	g := &CFG{Function: functionName(p, f), Index: f.Index, f: f}
+
+	n := len(f.Instructions)
+	if n == 0 {
+		return g
+	}
+
+	targets := make([][]BlockEdge, n)
+	leaders := map[int]bool{0: true}
+
+	addTarget := func(pc, target int, kind string) {
+		if target < 0 || target >= n {
+			return
+		}
+		targets[pc] = append(targets[pc], BlockEdge{Block: target, Kind: kind})
+		leaders[target] = true
+		if pc+1 < n {
+			leaders[pc+1] = true
+		}
+	}
+
+	// the pc where the optional chain opened by the last setRegister ends
+	optChain := -1
+
+	for pc, in := range f.Instructions {
+		switch in.Opcode {
+		case op_jump:
+			addTarget(pc, pc+int(in.A.Value)+1, "jump")
+		case op_jumpBack:
+			addTarget(pc, pc-int(in.A.Value), "jump")
+		case op_jumpIfEqual, op_jumpIfNotEqual:
+			addTarget(pc, pc+int(in.C.Value)+1, "branch")
+		case op_testJump:
+			addTarget(pc, pc+int(in.B.Value)+1, "branch")
+		case op_setRegister:
+			optChain = pc + 1 + int(in.A.Value)
+		case op_getOptChain, op_calOptChain, op_calOptChainSingleArg:
+			if optChain != -1 {
+				addTarget(pc, optChain, "optchain")
+				optChain = -1
+			}
+		case op_try:
+			if in.A.Kind != AddrVoid {
+				addTarget(pc, int(in.A.Value), "catch")
+			}
+			if in.C.Kind == AddrData {
+				addTarget(pc, int(in.C.Value), "finally")
+			}
+		case op_return, op_throw:
+			if pc+1 < n {
+				leaders[pc+1] = true
+			}
+		}
+	}
+
+	starts := make([]int, 0, len(leaders))
+	for pc := range leaders {
+		starts = append(starts, pc)
+	}
+	sort.Ints(starts)
+
+	blockAt := make(map[int]int, len(starts))
+	for i, start := range starts {
+		end := n
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		blockAt[start] = i
+		g.Blocks = append(g.Blocks, &BasicBlock{Index: i, Start: start, End: end})
+	}
+
+	for _, b := range g.Blocks {
+		last := b.End - 1
+		in := f.Instructions[last]
+
+		for _, t := range targets[last] {
+			b.Succs = append(b.Succs, BlockEdge{Block: blockAt[t.Block], Kind: t.Kind})
+		}
+
+		switch in.Opcode {
+		case op_jump, op_jumpBack, op_return, op_throw:
+		default:
+			if b.End < n {
+				b.Succs = append(b.Succs, BlockEdge{Block: blockAt[b.End], Kind: "next"})
+			}
+		}
+	}
+
+	g.markReachable(0)
+	return g
+}
+
+func (g *CFG) markReachable(i int) {
+	b := g.Blocks[i]
+	if b.Reachable {
+		return
+	}
+	b.Reachable = true
+	for _, e := range b.Succs {
+		g.markReachable(e.Block)
+	}
+}
+
+// Unreachable returns the blocks that can't be reached from the entry.
+// The return added by the compiler at the end of the functions is not
+// reported when there is already a return before it.
+func (g *CFG) Unreachable() []*BasicBlock {
+	var list []*BasicBlock
+	n := len(g.f.Instructions)
+	for _, b := range g.Blocks {
+		if b.Start == n-1 {
+			if in := g.f.Instructions[b.Start]; in.Opcode == op_return && in.A.Kind == AddrVoid {
+				continue
+			}
+		}
+		if !b.Reachable {
+			list = append(list, b)
+		}
+	}
+	return list
+}
+
+// WriteCFGDot writes the control-flow graphs of the functions in the Graphviz
+// DOT format, each one in a cluster. Unreachable blocks are drawn dashed.
+func WriteCFGDot(w io.Writer, graphs []*CFG) {
+	fmt.Fprintln(w, "digraph cfg {")
+	fmt.Fprintln(w, "  node [shape=box fontname=monospace];")
+
+	for _, g := range graphs {
+		fmt.Fprintf(w, "  subgraph cluster_%d {\n", g.Index)
+		fmt.Fprintf(w, "    label=%q;\n", g.Function)
+
+		for _, b := range g.Blocks {
+			var lines []string
+			for pc := b.Start; pc < b.End; pc++ {
+				lines = append(lines, fmt.Sprintf("%-4d %s", pc, strings.Join(strings.Fields(g.f.Instructions[pc].String()), " ")))
+			}
+
+			style := ""
+			if !b.Reachable {
+				style = " style=dashed color=gray"
+			}
+			fmt.Fprintf(w, "    b%d_%d [label=%s%s];\n", g.Index, b.Index, dotLabel(lines), style)
+		}
+
+		for _, b := range g.Blocks {
+			for _, e := range b.Succs {
+				fmt.Fprintf(w, "    b%d_%d -> b%d_%d [label=%q];\n", g.Index, b.Index, g.Index, e.Block, e.Kind)
+			}
+		}
+
+		fmt.Fprintln(w, "  }")
+	}
+
+	fmt.Fprintln(w, "}")
+}
+
+// dotLabel returns the lines as a left aligned DOT label.
+func dotLabel(lines []string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, l := range lines {
+		l = strings.ReplaceAll(l, `\`, `\\`)
+		l = strings.ReplaceAll(l, `"`, `\"`)
+		b.WriteString(l)
+		b.WriteString(`\l`)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// CallGraph is the static call graph of a program. The calls to methods
+// of instances are resolved at runtime so creating an instance of a
+// class is considered to reach all its functions.
+type CallGraph struct {
+	Nodes []*CallNode `json:"nodes"`
+	Edges []*CallEdge `json:"edges"`
+}
+
+// CallNode is a function of the program or a native function.
+type CallNode struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Native      bool     `json:"native,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Reachable   bool     `json:"reachable"`
+}
+
+// CallEdge is a reference from a function to another. Kind is "call",
+// "new" for class instances, "closure" or "ref" when the function is
+// used as a value.
+type CallEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// NewCallGraph builds the call graph of the program. The global function,
+// main and the exported functions are the entry points.
+func NewCallGraph(p *Program) *CallGraph {
+	g := &CallGraph{}
+	nodes := make(map[string]*CallNode)
+	edges := make(map[CallEdge]bool)
+
+	funcID := func(i int) string { return fmt.Sprintf("F%d", i) }
+
+	for _, f := range p.Functions {
+		n := &CallNode{ID: funcID(f.Index), Name: functionName(p, f)}
+		nodes[n.ID] = n
+		g.Nodes = append(g.Nodes, n)
+	}
+
+	addEdge := func(from, to, kind string) {
+		e := CallEdge{From: from, To: to, Kind: kind}
+		if !edges[e] {
+			edges[e] = true
+			g.Edges = append(g.Edges, &e)
+		}
+	}
+
+	for _, f := range p.Functions {
+		from := funcID(f.Index)
+
+		for _, in := range f.Instructions {
+			for i, addr := range []*Address{in.A, in.B, in.C} {
+				if addr == nil {
+					continue
+				}
+
+				kind := "ref"
+				if i == 0 {
+					switch in.Opcode {
+					case op_call, op_callSingleArg, op_calOptChain, op_calOptChainSingleArg:
+						kind = "call"
+					}
+				}
+
+				switch addr.Kind {
+				case AddrFunc:
+					if in.Opcode == op_createClosure {
+						kind = "closure"
+					}
+					addEdge(from, funcID(int(addr.Value)), kind)
+
+				case AddrNativeFunc:
+					nf, err := p.NativeFunc(int(addr.Value))
+					if err != nil {
+						continue
+					}
+					id := "N" + nf.Name
+					if _, ok := nodes[id]; !ok {
+						n := &CallNode{ID: id, Name: nf.Name, Native: true, Permissions: nf.Permissions}
+						nodes[id] = n
+						g.Nodes = append(g.Nodes, n)
+					}
+					addEdge(from, id, kind)
+
+				case AddrClass:
+					if in.Opcode != op_newClass && in.Opcode != op_newClassSingleArg {
+						continue
+					}
+					c := p.Classes[addr.Value]
+					for _, list := range [][]int{c.Functions, c.Getters, c.Setters} {
+						for _, fi := range list {
+							addEdge(from, funcID(fi), "new")
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// mark the nodes reachable from the entry points
+	succs := make(map[string][]string)
+	for _, e := range g.Edges {
+		succs[e.From] = append(succs[e.From], e.To)
+	}
+
+	var mark func(id string)
+	mark = func(id string) {
+		n := nodes[id]
+		if n.Reachable {
+			return
+		}
+		n.Reachable = true
+		for _, s := range succs[id] {
+			mark(s)
+		}
+	}
+
+	for _, f := range p.Functions {
+		if f.Index == 0 || (f.Exported && !f.IsClass) || (f.Name == "main" && !f.IsClass) {
+			mark(funcID(f.Index))
+		}
+	}
+
+	return g
+}
+
+// ReachableNatives returns the native functions that can be called from the
+// entry points of the program.
+func (g *CallGraph) ReachableNatives() []*CallNode {
+	var list []*CallNode
+	for _, n := range g.Nodes {
+		if n.Native && n.Reachable {
+			list = append(list, n)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Unreachable returns the functions of the program that can't be
+// called from the entry points.
+func (g *CallGraph) Unreachable() []*CallNode {
+	var list []*CallNode
+	for _, n := range g.Nodes {
+		if !n.Native && !n.Reachable {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// WriteDot writes the call graph in the Graphviz DOT format. Natives are
+// drawn as ellipses and unreachable functions dashed.
+func (g *CallGraph) WriteDot(w io.Writer) {
+	fmt.Fprintln(w, "digraph calls {")
+	fmt.Fprintln(w, "  node [shape=box];")
+
+	for _, n := range g.Nodes {
+		var attrs []string
+		label := n.Name
+		if n.Native {
+			attrs = append(attrs, "shape=ellipse")
+			if len(n.Permissions) > 0 {
+				label += "\n[" + strings.Join(n.Permissions, ", ") + "]"
+			}
+		}
+		if !n.Reachable {
+			attrs = append(attrs, "style=dashed", "color=gray")
+		}
+		attrs = append([]string{fmt.Sprintf("label=%q", label)}, attrs...)
+		fmt.Fprintf(w, "  %q [%s];\n", n.ID, strings.Join(attrs, " "))
+	}
+
+	for _, e := range g.Edges {
+		if e.Kind == "call" {
+			fmt.Fprintf(w, "  %q -> %q;\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(w, "  %q -> %q [label=%q style=dashed];\n", e.From, e.To, e.Kind)
+		}
+	}
+
+	fmt.Fprintln(w, "}")
+}
+
+// functionName returns the name of the function including its class.
+func functionName(p *Program, f *Function) string {
+	if f.IsClass {
+		return p.Classes[f.Class].Name + "." + f.Name
+	}
+	return f.Name
+}
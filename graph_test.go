@@ -0,0 +1,129 @@
+package dune
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCFG(t *testing.T) {
+	p, err := CompileStr(`
+		function main() {
+			let a = 1
+			if (a > 0) {
+				a = 2
+			} else {
+				a = 3
+			}
+			while (a < 10) {
+				a++
+			}
+			try {
+				a = 4
+			} catch (e) {
+				a = 5
+			}
+			return a
+		}
+
+		function dead() {
+			return 1
+			let x = 2
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	main, _ := p.Function("main")
+	g := NewCFG(p, main)
+
+	if u := g.Unreachable(); len(u) != 0 {
+		t.Fatalf("%+v", u)
+	}
+
+	kinds := make(map[string]int)
+	for _, b := range g.Blocks {
+		for _, e := range b.Succs {
+			if e.Block < 0 || e.Block >= len(g.Blocks) {
+				t.Fatalf("invalid edge %v", e)
+			}
+			kinds[e.Kind]++
+		}
+	}
+
+	if kinds["branch"] != 2 || kinds["catch"] != 1 || kinds["jump"] < 3 {
+		t.Fatalf("%v", kinds)
+	}
+
+	dead, _ := p.Function("dead")
+	g = NewCFG(p, dead)
+
+	if u := g.Unreachable(); len(u) != 1 || u[0].Start != 1 {
+		t.Fatalf("%+v", u)
+	}
+
+	var b strings.Builder
+	WriteCFGDot(&b, []*CFG{g})
+	if !strings.Contains(b.String(), "style=dashed") {
+		t.Fatal(b.String())
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	AddNativeFunc(NativeFunction{
+		Name:        "graphTest.secret",
+		Permissions: []string{"secrets"},
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NullValue, nil
+		},
+	})
+
+	p, err := CompileStr(`
+		function main() {
+			let x = 1
+			let f = () => foo(x)
+			let p = new Point()
+			return f()
+		}
+
+		function foo(x) {
+			graphTest.secret()
+		}
+
+		function unused() {
+			foo(1)
+		}
+
+		class Point {
+			move() {
+				bar()
+			}
+		}
+
+		function bar() { }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewCallGraph(p)
+
+	var unreachable []string
+	for _, n := range g.Unreachable() {
+		unreachable = append(unreachable, n.Name)
+	}
+	if strings.Join(unreachable, ",") != "unused" {
+		t.Fatal(unreachable)
+	}
+
+	natives := g.ReachableNatives()
+	if len(natives) != 1 || natives[0].Name != "graphTest.secret" || natives[0].Permissions[0] != "secrets" {
+		t.Fatalf("%+v", natives)
+	}
+
+	var b strings.Builder
+	g.WriteDot(&b)
+	if !strings.Contains(b.String(), `label="closure"`) || !strings.Contains(b.String(), `label="new"`) {
+		t.Fatal(b.String())
+	}
+}
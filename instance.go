@@ -58,6 +58,48 @@ func (i *instance) Function(name string, p *Program) (*Function, bool) {
 	return nil, false
 }
 
+// members returns the names of the fields, properties and methods.
+func (i *instance) members(p *Program) []string {
+	seen := make(map[string]bool)
+	var names []string
+
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	i.RLock()
+	for k := range i.iMap {
+		add(k)
+	}
+	i.RUnlock()
+
+	for _, f := range i.class.Fields {
+		add(f.Name)
+	}
+
+	for _, list := range [][]int{i.class.Getters, i.class.Setters, i.class.Functions} {
+		for _, j := range list {
+			add(p.Functions[j].Name)
+		}
+	}
+
+	return names
+}
+
+// hasMember returns true if the class declares a field,
+// property or method with the name.
+func (i *instance) hasMember(name string, p *Program) bool {
+	for _, m := range i.members(p) {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
 // returns true if the pc is class code
 func (i *instance) isSelfPC(vm *VM) bool {
 	frame := vm.callStack[vm.fp]
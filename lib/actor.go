@@ -0,0 +1,453 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+func init() {
+	dune.RegisterLib(Actor, `
+
+declare namespace runtime {
+    /**
+     * Runs the function in an isolated vm with its own copy of the globals.
+     * The arguments, the messages and the result are copied between the
+     * isolates so they never share state. Closures that capture variables
+     * and native objects like files can't be copied. Globals that hold
+     * them are null in the isolate.
+     */
+    export function spawn(fn: Function, ...args: any[]): Actor
+
+    /**
+     * Sends a message to the parent of the actor running the function.
+     */
+    export function send(msg: any): void
+
+    /**
+     * Waits for a message sent to the actor running the function.
+     * Returns null after the timeout.
+     */
+    export function receive(timeout?: time.Duration | number): any
+
+    export interface Actor {
+        /**
+         * true when the function has returned or failed.
+         */
+        readonly done: boolean
+        /**
+         * the error of the function if it failed.
+         */
+        readonly error: errors.Error
+        /**
+         * sends a message to the actor.
+         */
+        send(msg: any): void
+        /**
+         * waits for a message sent by the actor. Returns null after the
+         * timeout or when the actor ends. If the actor failed it throws
+         * its error.
+         */
+        receive(timeout?: time.Duration | number): any
+        /**
+         * waits until the function ends and returns its result or
+         * throws its error.
+         */
+        wait(): any
+        /**
+         * stops the actor and closes the resources that it opened.
+         */
+        kill(): void
+    }
+}
+
+`)
+}
+
+var Actor = []dune.NativeFunction{
+	{
+		Name:        "runtime.spawn",
+		Arguments:   -1,
+		Permissions: []string{"async"},
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			if len(args) == 0 {
+				return dune.NullValue, fmt.Errorf("expected at least 1 argument")
+			}
+
+			a, err := spawnActor(vm, args[0], args[1:])
+			if err != nil {
+				return dune.NullValue, err
+			}
+
+			return dune.NewObject(a), nil
+		},
+	},
+	{
+		Name:      "runtime.send",
+		Arguments: 1,
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			a, err := currentActor(vm)
+			if err != nil {
+				return dune.NullValue, err
+			}
+
+			msg, err := dune.StructuredClone(args[0])
+			if err != nil {
+				return dune.NullValue, err
+			}
+
+			a.outbox.put(msg)
+			return dune.NullValue, nil
+		},
+	},
+	{
+		Name:      "runtime.receive",
+		Arguments: -1,
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			timeout, err := receiveTimeout(args)
+			if err != nil {
+				return dune.NullValue, err
+			}
+
+			a, err := currentActor(vm)
+			if err != nil {
+				return dune.NullValue, err
+			}
+
+			v, ok := a.inbox.get(timeout, a.killed)
+			if !ok && isClosedChan(a.killed) {
+				return dune.NullValue, dune.ErrClosed
+			}
+			return v, nil
+		},
+	},
+}
+
+// actors are indexed by the resources of their vm so the
+// goroutines launched by an actor also reach its mailbox.
+var actors = struct {
+	sync.Mutex
+	running map[*dune.Resources]*actor
+}{running: make(map[*dune.Resources]*actor)}
+
+func currentActor(vm *dune.VM) (*actor, error) {
+	actors.Lock()
+	a, ok := actors.running[vm.Resources]
+	actors.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("not running in an actor")
+	}
+	return a, nil
+}
+
+// actor is a function running in an isolated vm. The isolate only
+// receives copies of the values of its parent and the parent learns
+// how it ended from wait or receive.
+type actor struct {
+	vm     *dune.VM
+	inbox  *mailbox
+	outbox *mailbox
+
+	// done is closed when the function ends. After
+	// that result and err can be read.
+	done   chan struct{}
+	result dune.Value
+	err    error
+
+	killOnce sync.Once
+	killed   chan struct{}
+}
+
+func spawnActor(vm *dune.VM, fn dune.Value, args []dune.Value) (*actor, error) {
+	switch fn.Type {
+	case dune.Func:
+	case dune.Object:
+		switch fn.ToObject().(type) {
+		case *dune.Closure, *dune.Method:
+		default:
+			return nil, fmt.Errorf("%v is not a function", fn.TypeName())
+		}
+	default:
+		return nil, fmt.Errorf("%v is not a function", fn.TypeName())
+	}
+
+	fn, err := dune.StructuredClone(fn)
+	if err != nil {
+		return nil, fmt.Errorf("can't spawn the function: %w", err)
+	}
+
+	values := make([]dune.Value, len(args))
+	for i, v := range args {
+		cv, err := dune.StructuredClone(v)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		values[i] = cv
+	}
+
+	m := vm.CloneInitialized(vm.Program, vm.IsolatedGlobals())
+	m.Origin = vm.SpawnTrace()
+	copyResourceLimits(m, vm)
+
+	if c, err := dune.StructuredClone(vm.Context); err == nil {
+		m.Context = c
+	} else {
+		m.Context = dune.NullValue
+	}
+
+	if err := m.AddSteps(vm.Steps()); err != nil {
+		return nil, err
+	}
+
+	a := &actor{
+		vm:     m,
+		inbox:  newMailbox(),
+		outbox: newMailbox(),
+		done:   make(chan struct{}),
+		killed: make(chan struct{}),
+	}
+
+	// the actor counts as a goroutine of the parent and
+	// is killed if the parent is closed.
+	if err := vm.Resources.Track(dune.ResourceGoroutine, a); err != nil {
+		return nil, err
+	}
+
+	actors.Lock()
+	actors.running[m.Resources] = a
+	actors.Unlock()
+
+	go a.run(vm, fn, values)
+
+	return a, nil
+}
+
+func (a *actor) run(parent *dune.VM, fn dune.Value, args []dune.Value) {
+	defer trackAsync(a.vm, fn)()
+
+	ret, err := a.call(fn, args)
+	if err == nil {
+		// the result is copied here so it doesn't share
+		// state with the values that the isolate keeps.
+		ret, err = dune.StructuredClone(ret)
+	}
+
+	a.result = ret
+	a.err = err
+
+	actors.Lock()
+	delete(actors.running, a.vm.Resources)
+	actors.Unlock()
+
+	parent.Resources.Untrack(a)
+
+	// close what the isolate left open.
+	a.vm.Close()
+
+	close(a.done)
+}
+
+func (a *actor) call(fn dune.Value, args []dune.Value) (dune.Value, error) {
+	switch fn.Type {
+	case dune.Func:
+		return a.vm.RunFuncIndex(fn.ToFunction(), args...)
+	case dune.Object:
+		switch t := fn.ToObject().(type) {
+		case *dune.Closure:
+			return a.vm.RunClosure(t, args...)
+		case *dune.Method:
+			return a.vm.RunMethod(t, args...)
+		}
+	}
+	return dune.NullValue, fmt.Errorf("%v is not a function", fn.TypeName())
+}
+
+// Close kills the actor.
+func (a *actor) Close() error {
+	a.killOnce.Do(func() {
+		close(a.killed)
+	})
+	return a.vm.Close()
+}
+
+func (a *actor) Type() string {
+	return "runtime.Actor"
+}
+
+func (a *actor) GetField(name string, vm *dune.VM) (dune.Value, error) {
+	switch name {
+	case "done":
+		return dune.NewBool(isClosedChan(a.done)), nil
+	case "error":
+		if !isClosedChan(a.done) {
+			return dune.NullValue, nil
+		}
+		return dune.NewObject(a.err), nil
+	}
+	return dune.UndefinedValue, nil
+}
+
+func (a *actor) GetMethod(name string) dune.NativeMethod {
+	switch name {
+	case "send":
+		return a.send
+	case "receive":
+		return a.receive
+	case "wait":
+		return a.wait
+	case "kill":
+		return a.kill
+	}
+	return nil
+}
+
+func (a *actor) send(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, nil); err != nil {
+		return dune.NullValue, err
+	}
+
+	if isClosedChan(a.done) {
+		return dune.NullValue, fmt.Errorf("the actor has ended")
+	}
+
+	msg, err := dune.StructuredClone(args[0])
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	a.inbox.put(msg)
+	return dune.NullValue, nil
+}
+
+func (a *actor) receive(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	timeout, err := receiveTimeout(args)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	v, ok := a.outbox.get(timeout, a.done)
+	if !ok && isClosedChan(a.done) && a.err != nil {
+		return dune.NullValue, a.err
+	}
+	return v, nil
+}
+
+func (a *actor) wait(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+
+	<-a.done
+
+	if a.err != nil {
+		return dune.NullValue, a.err
+	}
+	return a.result, nil
+}
+
+func (a *actor) kill(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+
+	if err := a.Close(); err != nil {
+		return dune.NullValue, err
+	}
+	return dune.NullValue, nil
+}
+
+func receiveTimeout(args []dune.Value) (time.Duration, error) {
+	switch len(args) {
+	case 0:
+		return 0, nil
+	case 1:
+		if args[0].IsNilOrEmpty() {
+			return 0, nil
+		}
+		return ToDuration(args[0])
+	default:
+		return 0, fmt.Errorf("expected 0 or 1 arguments, got %d", len(args))
+	}
+}
+
+func isClosedChan(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// mailbox is an unbounded queue of messages so
+// senders never block.
+type mailbox struct {
+	mu     sync.Mutex
+	items  []dune.Value
+	notify chan struct{}
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{notify: make(chan struct{}, 1)}
+}
+
+func (m *mailbox) put(v dune.Value) {
+	m.mu.Lock()
+	m.items = append(m.items, v)
+	m.mu.Unlock()
+	m.signal()
+}
+
+func (m *mailbox) signal() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mailbox) take() (dune.Value, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.items) == 0 {
+		return dune.NullValue, false
+	}
+
+	v := m.items[0]
+	m.items[0] = dune.NullValue
+	m.items = m.items[1:]
+
+	// wake up other receivers if there are more.
+	if len(m.items) > 0 {
+		m.signal()
+	}
+	return v, true
+}
+
+// get waits for a message until the timeout, if it is not zero, or
+// until stop is closed. It returns false if there is no message.
+func (m *mailbox) get(timeout time.Duration, stop chan struct{}) (dune.Value, bool) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	for {
+		if v, ok := m.take(); ok {
+			return v, true
+		}
+
+		select {
+		case <-m.notify:
+		case <-stop:
+			return m.take()
+		case <-expired:
+			return dune.NullValue, false
+		}
+	}
+}
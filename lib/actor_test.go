@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dunelang/dune"
+)
+
+func TestSpawnIsolatesGlobals(t *testing.T) {
+	v := runTest(t, `
+		let counter = 10
+		let items = [1, 2]
+
+		function work(n) {
+			counter += n
+			items.push(n)
+			return counter + ":" + items.length
+		}
+
+		function main() {
+			let a = runtime.spawn(work, 5)
+			let r = a.wait()
+			return r + "," + counter + ":" + items.length
+		}
+	`)
+
+	if v.String() != "15:3,10:2" {
+		t.Fatal(v)
+	}
+}
+
+func TestSpawnMailbox(t *testing.T) {
+	v := runTest(t, `
+		function echo() {
+			while (true) {
+				let msg = runtime.receive()
+				if (msg.stop) {
+					return "bye"
+				}
+				msg.n++
+				runtime.send(msg)
+			}
+		}
+
+		function main() {
+			let a = runtime.spawn(echo)
+			let m = { n: 1 }
+			a.send(m)
+			let r = a.receive()
+			a.send({ stop: true })
+			return m.n + "," + r.n + "," + a.wait() + "," + a.done
+		}
+	`)
+
+	if v.String() != "1,2,bye,true" {
+		t.Fatal(v)
+	}
+}
+
+func TestSpawnSupervision(t *testing.T) {
+	v := runTest(t, `
+		function fail() {
+			runtime.send(1)
+			throw "boom"
+		}
+
+		function main() {
+			let a = runtime.spawn(fail)
+			let first = a.receive()
+			try {
+				a.receive()
+			} catch (e) {
+				return first + ":" + e.message + ":" + (a.error != null)
+			}
+		}
+	`)
+
+	if !strings.HasPrefix(v.String(), "1:boom") || !strings.HasSuffix(v.String(), ":true") {
+		t.Fatal(v)
+	}
+}
+
+func TestSpawnKill(t *testing.T) {
+	v := runTest(t, `
+		function main() {
+			let a = runtime.spawn(() => runtime.receive())
+			a.kill()
+			try {
+				a.wait()
+			} catch (e) {
+				return e.message
+			}
+		}
+	`)
+
+	if !strings.Contains(v.String(), dune.ErrClosed.Error()) {
+		t.Fatal(v)
+	}
+}
+
+func TestSpawnCapturedClosure(t *testing.T) {
+	_, err := runExpr(t, `
+		let x = 1
+		function main() {
+			let y = 2
+			runtime.spawn(() => y)
+		}
+	`)
+
+	if err == nil || !strings.Contains(err.Error(), "captures variables") {
+		t.Fatal(err)
+	}
+}
+
+func TestSpawnReceiveOutsideActor(t *testing.T) {
+	_, err := runExpr(t, `
+		function main() {
+			runtime.receive()
+		}
+	`)
+
+	if err == nil || !strings.Contains(err.Error(), "not running in an actor") {
+		t.Fatal(err)
+	}
+}
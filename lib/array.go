@@ -84,11 +84,23 @@ var libArray = []dune.NativeFunction{
 			switch len(args) {
 			case 1:
 				size = args[0].ToInt()
+				if err := validateMakeSize(size, size); err != nil {
+					return dune.NullValue, err
+				}
+				if err := vm.AddAllocations(int(size)); err != nil {
+					return dune.NullValue, err
+				}
 				return dune.NewArray(int(size)), nil
 
 			case 2:
 				size = args[0].ToInt()
 				cap = args[1].ToInt()
+				if err := validateMakeSize(size, cap); err != nil {
+					return dune.NullValue, err
+				}
+				if err := vm.AddAllocations(int(cap)); err != nil {
+					return dune.NullValue, err
+				}
 				a := make([]dune.Value, size, cap)
 				return dune.NewArrayValues(a), nil
 
@@ -119,11 +131,23 @@ var libArray = []dune.NativeFunction{
 			switch len(args) {
 			case 1:
 				size = args[0].ToInt()
+				if err := validateMakeSize(size, size); err != nil {
+					return dune.NullValue, err
+				}
+				if err := vm.AddAllocations(int(size)); err != nil {
+					return dune.NullValue, err
+				}
 				return dune.NewBytes(make([]byte, size)), nil
 
 			case 2:
 				size = args[0].ToInt()
 				cap = args[1].ToInt()
+				if err := validateMakeSize(size, cap); err != nil {
+					return dune.NullValue, err
+				}
+				if err := vm.AddAllocations(int(cap)); err != nil {
+					return dune.NullValue, err
+				}
 				return dune.NewBytes(make([]byte, size, cap)), nil
 
 			default:
@@ -1325,7 +1349,7 @@ var libArray = []dune.NativeFunction{
 				a.Array = append(a.Array, items...)
 
 				if vm.MaxAllocations > 0 {
-					var allocs int
+					allocs := len(items)
 					for _, v := range items {
 						allocs += v.Size()
 					}
@@ -1350,8 +1374,9 @@ var libArray = []dune.NativeFunction{
 				a := this.ToArrayObject()
 				a.Array = append(a.Array, args...)
 				if vm.MaxAllocations > 0 {
-					var allocs int
-					for _, v := range a.Array {
+					// only what has been added
+					allocs := len(args)
+					for _, v := range args {
 						allocs += v.Size()
 					}
 					if err := vm.AddAllocations(allocs); err != nil {
@@ -1379,6 +1404,10 @@ var libArray = []dune.NativeFunction{
 			obj := this.ToArrayObject()
 			i := int(args[0].ToInt())
 
+			if err := vm.AddAllocations(args[1].Size() + 1); err != nil {
+				return dune.NullValue, err
+			}
+
 			a := obj.Array
 			a = append(a, dune.NullValue)
 			copy(a[i+1:], a[i:])
@@ -1687,3 +1716,15 @@ func (c *closureComparer) Less(i, j int) bool {
 	}
 	return v.ToBool()
 }
+
+// validateMakeSize checks the size and capacity of a new array
+// before counting its allocation.
+func validateMakeSize(size, cap int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid size: %d", size)
+	}
+	if cap < size {
+		return fmt.Errorf("invalid capacity %d for size %d", cap, size)
+	}
+	return nil
+}
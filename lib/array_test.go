@@ -1,7 +1,10 @@
 package lib
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/dunelang/dune"
 )
 
 func TestArraySum(t *testing.T) {
@@ -31,3 +34,49 @@ func TestArrayWhere(t *testing.T) {
 		t.Fatal(v)
 	}
 }
+
+func TestArrayPushAllocations(t *testing.T) {
+	p, err := dune.CompileStr(`
+		function main() {
+			let a = []
+			for (let i = 0; i < 1000; i++) {
+				a.push(i)
+			}
+
+			try {
+				array.make(100000)
+			} catch (e) {
+				return a.length + " " + e.message
+			}
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := dune.NewVM(p)
+	vm.MaxAllocations = 10000
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(v.String(), "1000 Max allocations reached") {
+		t.Fatal(v)
+	}
+}
+
+func TestArrayMakeInvalidSize(t *testing.T) {
+	for _, code := range []string{
+		"array.make(-1)",
+		"array.make(2, 1)",
+		"array.bytes(-1)",
+		"array.bytes(0, -5)",
+	} {
+		_, err := runExpr(t, "function main() { "+code+" }")
+		if err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Fatal(code, err)
+		}
+	}
+}
@@ -2,6 +2,9 @@ package lib
 
 import (
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/dunelang/dune"
 )
@@ -26,63 +29,32 @@ var Async = []dune.NativeFunction{
 }
 
 func launchGoroutine(args []dune.Value, vm *dune.VM, t *waitGroup) (dune.Value, error) {
-	m, err := cloneForAsync(vm)
+	m, err := cloneForAsync(vm, vm.SpawnTrace())
 	if err != nil {
 		return dune.NullValue, err
 	}
 
+	var run func() error
+
 	a := args[0]
 	switch a.Type {
 	case dune.Func:
-		if t != nil {
-			t.w.Add(1)
-		}
-		go func() {
+		run = func() error {
 			_, err := m.RunFuncIndex(a.ToFunction())
-			if err != nil {
-				fmt.Fprintln(vm.GetStderr(), err)
-			}
-			if t != nil {
-				t.w.Done()
-				if t.limit != nil {
-					<-t.limit
-				}
-			}
-		}()
+			return err
+		}
 
 	case dune.Object:
 		if c, ok := a.ToObjectOrNil().(*dune.Closure); ok {
-			if t != nil {
-				t.w.Add(1)
-			}
-			go func() {
+			run = func() error {
 				_, err := m.RunClosure(c)
-				if err != nil {
-					fmt.Fprintln(vm.GetStderr(), err)
-				}
-				if t != nil {
-					t.w.Done()
-					if t.limit != nil {
-						<-t.limit
-					}
-				}
-			}()
-		} else if c, ok := a.ToObjectOrNil().(*dune.Method); ok {
-			if t != nil {
-				t.w.Add(1)
+				return err
 			}
-			go func() {
+		} else if c, ok := a.ToObjectOrNil().(*dune.Method); ok {
+			run = func() error {
 				_, err := m.RunMethod(c)
-				if err != nil {
-					fmt.Fprintln(vm.GetStderr(), err)
-				}
-				if t != nil {
-					t.w.Done()
-					if t.limit != nil {
-						<-t.limit
-					}
-				}
-			}()
+				return err
+			}
 		} else {
 			return dune.NullValue, fmt.Errorf("%v is not a function", a.TypeName())
 		}
@@ -91,15 +63,45 @@ func launchGoroutine(args []dune.Value, vm *dune.VM, t *waitGroup) (dune.Value,
 		return dune.NullValue, fmt.Errorf("%v is not a function", a.TypeName())
 	}
 
+	if err := vm.Resources.Acquire(dune.ResourceGoroutine); err != nil {
+		return dune.NullValue, err
+	}
+
+	if t != nil {
+		t.add(1)
+	}
+
+	f := func() {
+		defer vm.Resources.Release(dune.ResourceGoroutine)
+		defer trackAsync(m, a)()
+		if err := run(); err != nil {
+			fmt.Fprintln(vm.GetStderr(), err)
+		}
+		if t != nil {
+			t.add(-1)
+			if t.limit != nil {
+				<-t.limit
+			}
+		}
+	}
+
+	// a deterministic vm runs its goroutines one at a time
+	// so the order of the calls is always the same.
+	m.Go(f)
+
 	return dune.NullValue, nil
 }
 
-func runAsyncFuncOrClosure(vm *dune.VM, fn dune.Value, args ...dune.Value) error {
-	m, err := cloneForAsync(vm)
+// runAsyncFuncOrClosure runs the function in a clone of the vm. Origin is
+// the stack where the function was scheduled, if it runs in a goroutine.
+func runAsyncFuncOrClosure(vm *dune.VM, origin []dune.TraceLine, fn dune.Value, args ...dune.Value) error {
+	m, err := cloneForAsync(vm, origin)
 	if err != nil {
 		return err
 	}
 
+	defer trackAsync(m, fn)()
+
 	switch fn.Type {
 	case dune.Func:
 		_, err := m.RunFuncIndex(fn.ToFunction(), args...)
@@ -123,20 +125,13 @@ func runAsyncFuncOrClosure(vm *dune.VM, fn dune.Value, args ...dune.Value) error
 	}
 }
 
-func cloneForAsync(vm *dune.VM) (*dune.VM, error) {
-	m := dune.NewInitializedVM(vm.Program, vm.Globals())
-	m.MaxAllocations = vm.MaxAllocations
-	m.MaxFrames = vm.MaxFrames
-	m.MaxSteps = vm.MaxSteps
-	m.FileSystem = vm.FileSystem
-	m.Context = vm.Context
-	m.Language = vm.Language
-	m.Localizer = vm.Localizer
-	m.Location = vm.Location
-	m.Now = vm.Now
-	m.Stdin = vm.Stdin
-	m.Stdout = vm.Stdout
-	m.Stderr = vm.Stderr
+func cloneForAsync(vm *dune.VM, origin []dune.TraceLine) (*dune.VM, error) {
+	m := vm.CloneInitialized(vm.Program, vm.Globals())
+	m.Origin = origin
+
+	// goroutines share the resources of the vm
+	// and stop when it is closed.
+	m.Resources = vm.Resources
 
 	if err := m.AddSteps(vm.Steps()); err != nil {
 		return nil, err
@@ -144,3 +139,72 @@ func cloneForAsync(vm *dune.VM) (*dune.VM, error) {
 
 	return m, nil
 }
+
+// asyncVM is a vm running a function in a goroutine.
+type asyncVM struct {
+	id       int
+	vm       *dune.VM
+	function string
+	started  time.Time
+}
+
+var asyncVMs = struct {
+	sync.Mutex
+	lastID  int
+	running map[*dune.VM]*asyncVM
+}{running: make(map[*dune.VM]*asyncVM)}
+
+// trackAsync registers the vm as running until the returned function is called.
+func trackAsync(m *dune.VM, fn dune.Value) func() {
+	asyncVMs.Lock()
+	asyncVMs.lastID++
+	asyncVMs.running[m] = &asyncVM{
+		id:       asyncVMs.lastID,
+		vm:       m,
+		function: asyncFuncName(m, fn),
+		started:  time.Now(),
+	}
+	asyncVMs.Unlock()
+
+	return func() {
+		asyncVMs.Lock()
+		delete(asyncVMs.running, m)
+		asyncVMs.Unlock()
+	}
+}
+
+// runningAsync returns the vms running in goroutines sorted by start.
+func runningAsync() []*asyncVM {
+	asyncVMs.Lock()
+	list := make([]*asyncVM, 0, len(asyncVMs.running))
+	for _, v := range asyncVMs.running {
+		list = append(list, v)
+	}
+	asyncVMs.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+func asyncFuncName(vm *dune.VM, fn dune.Value) string {
+	index := -1
+
+	switch fn.Type {
+	case dune.Func:
+		index = fn.ToFunction()
+	case dune.Object:
+		switch t := fn.ToObjectOrNil().(type) {
+		case *dune.Closure:
+			index = t.FuncIndex
+		case *dune.Method:
+			index = t.FuncIndex
+		}
+	}
+
+	funcs := vm.Program.Functions
+	if index < 0 || index >= len(funcs) {
+		return fn.TypeName()
+	}
+
+	return funcs[index].Name
+}
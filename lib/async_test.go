@@ -1,7 +1,11 @@
 package lib
 
 import (
+	"bytes"
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dunelang/dune"
 )
@@ -38,3 +42,178 @@ func TestAsyncClosure(t *testing.T) {
 		t.Fatalf("Returned: %v", v)
 	}
 }
+
+func TestAsyncDeterministic(t *testing.T) {
+	p, err := dune.CompileStr(`
+		let s = ""
+
+		function work(n: number) {
+			return () => { s += n + ":" + math.rand(1000) + "," }
+		}
+
+		function main() {
+			let wg = sync.newWaitGroup()
+			for (let i = 0; i < 5; i++) {
+				wg.go(work(i))
+			}
+			wg.wait()
+			return s
+		}
+	`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.AddPermission("trusted")
+
+	var results []string
+	for i := 0; i < 2; i++ {
+		vm := dune.NewVM(p)
+		vm.SetDeterministic(42)
+
+		v, err := vm.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, v.String())
+	}
+
+	if results[0] != results[1] || !strings.HasPrefix(results[0], "0:") || strings.Count(results[0], ",") != 5 {
+		t.Fatalf("Returned: %v", results)
+	}
+}
+
+func TestAsyncDeterministicChannels(t *testing.T) {
+	data := []struct {
+		code     string
+		expected string
+	}{
+		{`
+			function main() {
+				let ch = sync.newChannel()
+				go(() => ch.receive())
+				ch.send(1)
+				return "ok"
+			}
+		`, "ok"},
+		{`
+			function main() {
+				let s = ""
+				let ch = sync.newChannel()
+				let done = sync.newChannel()
+				go(() => {
+					for (let i = 0; i < 3; i++) {
+						s += "r" + ch.receive() + ","
+					}
+					done.send(true)
+				})
+				for (let i = 0; i < 3; i++) {
+					s += "s" + i + ","
+					ch.send(i)
+				}
+				done.receive()
+				return s
+			}
+		`, "s0,r0,s1,r1,s2,r2,"},
+		{`
+			function main() {
+				let s = ""
+				let m = sync.newMutex()
+				let ch = sync.newChannel()
+				let wg = sync.newWaitGroup()
+				wg.go(() => {
+					m.lock()
+					s += "a,"
+					ch.receive()
+					s += "b,"
+					m.unlock()
+				})
+				wg.go(() => {
+					// waits for the lock while the next one runs
+					m.lock()
+					s += "c,"
+					m.unlock()
+				})
+				wg.go(() => ch.send(1))
+				wg.wait()
+				return s
+			}
+		`, "a,b,c,"},
+		{`
+			function main() {
+				// the goroutine is blocked when the program ends
+				let ch = sync.newChannel()
+				go(() => ch.receive())
+				return "ok"
+			}
+		`, "ok"},
+	}
+
+	for i, d := range data {
+		p, err := dune.CompileStr(d.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.AddPermission("trusted")
+
+		vm := dune.NewVM(p)
+		vm.MaxSteps = 10000
+		if err := vm.Record(ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan struct{})
+		var v dune.Value
+		go func() {
+			v, err = vm.Run()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: timeout", i)
+		}
+
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if v.String() != d.expected {
+			t.Fatalf("%d: expected %q, got %q", i, d.expected, v.String())
+		}
+	}
+}
+
+func TestAsyncErrorOrigin(t *testing.T) {
+	p, err := dune.CompileStr(`
+		function fail() {
+			throw "boom"
+		}
+
+		function main() {
+			let wg = sync.newWaitGroup()
+			wg.go(fail)
+			wg.wait()
+		}
+	`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.AddPermission("trusted")
+
+	var stderr bytes.Buffer
+	vm := dune.NewVM(p)
+	vm.Stderr = &stderr
+
+	if _, err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := stderr.String()
+	if !strings.Contains(s, "boom\n -> line 3\nlaunched from:\n -> line 8\n") {
+		t.Fatal(s)
+	}
+}
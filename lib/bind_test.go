@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+func TestBindTime(t *testing.T) {
+	dune.AddNativeFunc(dune.BindFunc("bindTest.add", func(t time.Time, d time.Duration) time.Time {
+		return t.Add(d)
+	}))
+
+	v := runTest(t, `
+		function main() {
+			let t = time.date(2020, 1, 2, 3, 4, 5)
+			return bindTest.add(t, 2 * time.Hour).unix - t.unix
+		}
+	`)
+
+	if v.ToInt() != 7200 {
+		t.Fatal(v)
+	}
+}
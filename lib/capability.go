@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/filesystem"
+)
+
+// checkURL verifies that the program can connect to the host of the url.
+func checkURL(vm *dune.VM, u *url.URL) error {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https", "wss":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+	return vm.CheckCapability("net", u.Hostname()+":"+port)
+}
+
+func checkRawURL(vm *dune.VM, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	return checkURL(vm, u)
+}
+
+// checkDial verifies the net capability for the address of a connection.
+// The dial natives don't require the networking permission so it is only
+// restricted when the program has been granted hosts or is being audited.
+func checkDial(vm *dune.VM, addr string) error {
+	if vm.Audit == nil && len(vm.Grants("net")) == 0 {
+		return nil
+	}
+	return vm.CheckCapability("net", addr)
+}
+
+// checkRedirect doesn't follow redirects to hosts that the program can't access.
+func checkRedirect(vm *dune.VM) func(r *http.Request, via []*http.Request) error {
+	return func(r *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return checkURL(vm, r.URL)
+	}
+}
+
+// checkedFS returns the file system that the natives of the vm use. Access
+// is only restricted when the program has been granted paths with fs.read
+// or fs.write or is being audited.
+func checkedFS(vm *dune.VM, fs filesystem.FS) filesystem.FS {
+	if fs == nil {
+		return nil
+	}
+
+	if vm.Audit == nil && len(vm.Grants("fs.read")) == 0 && len(vm.Grants("fs.write")) == 0 {
+		return fs
+	}
+
+	if c, ok := fs.(*capabilityFS); ok && c.vm == vm {
+		return fs
+	}
+
+	return &capabilityFS{fs: fs, vm: vm}
+}
+
+// checked returns the file system checking the capabilities of the vm.
+func (f *FileSystemObj) checked(vm *dune.VM) filesystem.FS {
+	return checkedFS(vm, f.FS)
+}
+
+// capabilityFS verifies the fs.read or fs.write capability for the
+// paths before passing the calls to the file system.
+type capabilityFS struct {
+	fs filesystem.FS
+	vm *dune.VM
+}
+
+func (c *capabilityFS) check(capability, name string) error {
+	abs, err := c.fs.Abs(name)
+	if err != nil {
+		return err
+	}
+
+	return c.vm.CheckCapability(capability, filepath.ToSlash(filepath.Clean(abs)))
+}
+
+func (c *capabilityFS) SetHome(name string) error {
+	return c.fs.SetHome(name)
+}
+
+func (c *capabilityFS) Abs(name string) (string, error) {
+	return c.fs.Abs(name)
+}
+
+func (c *capabilityFS) Chdir(name string) error {
+	if err := c.check("fs.read", name); err != nil {
+		return err
+	}
+	return c.fs.Chdir(name)
+}
+
+func (c *capabilityFS) Getwd() (string, error) {
+	return c.fs.Getwd()
+}
+
+func (c *capabilityFS) Open(name string) (filesystem.File, error) {
+	if err := c.check("fs.read", name); err != nil {
+		return nil, err
+	}
+	return c.fs.Open(name)
+}
+
+func (c *capabilityFS) OpenIfExists(name string) (filesystem.File, error) {
+	if err := c.check("fs.read", name); err != nil {
+		return nil, err
+	}
+	return c.fs.OpenIfExists(name)
+}
+
+func (c *capabilityFS) Stat(name string) (os.FileInfo, error) {
+	if err := c.check("fs.read", name); err != nil {
+		return nil, err
+	}
+	return c.fs.Stat(name)
+}
+
+func (c *capabilityFS) OpenForWrite(name string) (filesystem.File, error) {
+	if err := c.check("fs.write", name); err != nil {
+		return nil, err
+	}
+	return c.fs.OpenForWrite(name)
+}
+
+func (c *capabilityFS) OpenForAppend(name string) (filesystem.File, error) {
+	if err := c.check("fs.write", name); err != nil {
+		return nil, err
+	}
+	return c.fs.OpenForAppend(name)
+}
+
+func (c *capabilityFS) Write(name string, data []byte) error {
+	if err := c.check("fs.write", name); err != nil {
+		return err
+	}
+	return c.fs.Write(name, data)
+}
+
+func (c *capabilityFS) Append(name string, data []byte) error {
+	if err := c.check("fs.write", name); err != nil {
+		return err
+	}
+	return c.fs.Append(name, data)
+}
+
+func (c *capabilityFS) AppendPath(name string, data []byte) error {
+	if err := c.check("fs.write", name); err != nil {
+		return err
+	}
+	return c.fs.AppendPath(name, data)
+}
+
+func (c *capabilityFS) Rename(oldPath, newPath string) error {
+	if err := c.check("fs.write", oldPath); err != nil {
+		return err
+	}
+	if err := c.check("fs.write", newPath); err != nil {
+		return err
+	}
+	return c.fs.Rename(oldPath, newPath)
+}
+
+func (c *capabilityFS) RemoveAll(name string) error {
+	if err := c.check("fs.write", name); err != nil {
+		return err
+	}
+	return c.fs.RemoveAll(name)
+}
+
+func (c *capabilityFS) Mkdir(name string) error {
+	if err := c.check("fs.write", name); err != nil {
+		return err
+	}
+	return c.fs.Mkdir(name)
+}
+
+func (c *capabilityFS) MkdirAll(name string) error {
+	if err := c.check("fs.write", name); err != nil {
+		return err
+	}
+	return c.fs.MkdirAll(name)
+}
+
+func isCapabilityError(err error) bool {
+	var e *dune.CapabilityError
+	return errors.As(err, &e)
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/filesystem"
+)
+
+func runWithPermissions(code string, fs filesystem.FS, permissions ...string) (dune.Value, error) {
+	p, err := dune.CompileStr(code)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	for _, perm := range permissions {
+		p.AddPermission(perm)
+	}
+
+	vm := dune.NewVM(p)
+	vm.FileSystem = fs
+	vm.MaxSteps = 1000
+	return vm.Run()
+}
+
+func TestCapabilityNet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	code := `function main() { return http.get("` + ts.URL + `") }`
+
+	data := []struct {
+		permissions []string
+		err         string
+	}{
+		{nil, "missing permission networking"},
+		{[]string{"networking"}, ""},
+		{[]string{"net"}, ""},
+		{[]string{"net:" + u.Host}, ""},
+		{[]string{"net:" + u.Hostname()}, ""},
+		{[]string{"net:" + u.Hostname() + ":*"}, ""},
+		{[]string{"net:api.partner.com:443"}, "missing capability net:" + u.Host},
+		{[]string{"net:" + u.Hostname() + ":1"}, "missing capability net:" + u.Host},
+	}
+
+	for _, d := range data {
+		v, err := runWithPermissions(code, nil, d.permissions...)
+		if d.err == "" {
+			if err != nil {
+				t.Fatalf("%v: %v", d.permissions, err)
+			}
+			if v.String() != "ok" {
+				t.Fatalf("%v: got %v", d.permissions, v)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), d.err) {
+			t.Fatalf("%v: expected %q, got %v", d.permissions, d.err, err)
+		}
+	}
+}
+
+func TestCapabilityNetDirective(t *testing.T) {
+	_, err := runWithPermissions(`
+		// [permissions net:*.partner.com:443]
+		function main() {
+			net.dial("tcp", "evil.com:443")
+		}
+	`, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "missing capability net:evil.com:443") {
+		t.Fatal(err)
+	}
+}
+
+func TestCapabilityNetDialWithoutGrants(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// programs without net grants dial as before
+	_, err = runWithPermissions(`
+		function main() {
+			net.dial("tcp", "`+ln.Addr().String()+`")
+		}
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapabilityFS(t *testing.T) {
+	fs := filesystem.NewVirtualFS()
+	if err := filesystem.WritePath(fs, "/data/reports/a.txt", []byte("report")); err != nil {
+		t.Fatal(err)
+	}
+	if err := filesystem.WritePath(fs, "/etc/passwd", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := runWithPermissions(`function main() { return os.readString("/data/reports/a.txt") }`, fs, "fs.read:/data/reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "report" {
+		t.Fatal(v)
+	}
+
+	data := []struct {
+		code string
+		err  string
+	}{
+		{`os.readString("/etc/passwd")`, "missing capability fs.read:/etc/passwd"},
+		{`os.readString("/data/reports/../../etc/passwd")`, "missing capability fs.read:/etc/passwd"},
+		{`os.readString("/data/reportsX/a.txt")`, "missing capability fs.read:/data/reportsX/a.txt"},
+		{`os.write("/data/reports/a.txt", "x")`, "missing capability fs.write:/data/reports/a.txt"},
+		{`os.removeAll("/data")`, "missing capability fs.write:/data"},
+		{`fileutil.copy("/etc/passwd", "/data/reports/b.txt")`, "missing capability fs.read:/etc/passwd"},
+		{`fileutil.copy("/data/reports/a.txt", "/data/reports/b.txt")`, "missing capability fs.write:/data/reports/b.txt"},
+		{`fileutil.isDirEmpty("/etc", os.fileSystem)`, "missing capability fs.read:/etc"},
+		{`zip.open("/etc/passwd")`, "missing capability fs.read:/etc/passwd"},
+	}
+
+	for _, d := range data {
+		_, err := runWithPermissions(`function main() { `+d.code+` }`, fs, "fs.read:/data/reports")
+		if err == nil || !strings.Contains(err.Error(), d.err) {
+			t.Fatalf("%s: expected %q, got %v", d.code, d.err, err)
+		}
+	}
+
+	// without fs grants the file system is not restricted
+	if _, err := runWithPermissions(`function main() { return os.readString("/etc/passwd") }`, fs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapabilityExec(t *testing.T) {
+	data := []struct {
+		permissions []string
+		err         string
+	}{
+		{nil, "missing permission exec"},
+		{[]string{"exec:convert"}, "missing capability exec:rm"},
+	}
+
+	for _, d := range data {
+		_, err := runWithPermissions(`function main() { os.exec("rm", "-rf", "/tmp/x") }`, nil, d.permissions...)
+		if err == nil || !strings.Contains(err.Error(), d.err) {
+			t.Fatalf("%v: expected %q, got %v", d.permissions, d.err, err)
+		}
+	}
+}
+
+func TestAuditGoroutine(t *testing.T) {
+	p, err := dune.CompileStr(`
+		function main() {
+			let wg = sync.newWaitGroup()
+			wg.go(() => { os.getEnv("X"); os.exec("true") })
+			wg.wait()
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := dune.NewVM(p)
+	vm.Audit = dune.NewAudit()
+
+	if _, err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the permissions used in the goroutine are audited too
+	d := vm.Audit.Directive()
+	if !strings.Contains(d, "sync") || !strings.Contains(d, "trusted") {
+		t.Fatal(d)
+	}
+}
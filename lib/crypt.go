@@ -28,6 +28,10 @@ import (
 )
 
 func init() {
+	// the random values are never derived from the seed of a deterministic
+	// vm because they would be predictable. They are logged instead.
+	dune.RegisterReplayable("crypto.rand", "crypto.random", "crypto.randomAlphanumeric")
+
 	dune.RegisterLib(Crypt, `
 
 declare namespace crypto {
@@ -1,6 +1,11 @@
 package lib
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dunelang/dune"
+)
 
 func TestMustFail(t *testing.T) {
 	e, err := Encrypts("The world wonders", "Nimitz")
@@ -56,3 +61,54 @@ func BenchmarkEncrypt(b *testing.B) {
 		}
 	}
 }
+
+func TestCryptoRandomDeterministic(t *testing.T) {
+	p, err := dune.CompileStr(`
+		function main() {
+			return crypto.randomAlphanumeric(16) + crypto.rand(1000000000)
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the seed of a deterministic vm doesn't predict the values
+	var results []string
+	for i := 0; i < 2; i++ {
+		vm := dune.NewVM(p)
+		vm.SetDeterministic(42)
+		v, err := vm.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, v.String())
+	}
+
+	if results[0] == results[1] {
+		t.Fatal(results)
+	}
+
+	// they are logged and replayed
+	var log bytes.Buffer
+	vm := dune.NewVM(p)
+	if err := vm.Record(&log); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm = dune.NewVM(p)
+	if err := vm.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorded.String() != replayed.String() {
+		t.Fatal(recorded, replayed)
+	}
+}
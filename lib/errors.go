@@ -15,6 +15,8 @@ var ErrInvalidType = errors.New("invalid value type")
 var ErrFileNotFound = errors.New("file not found")
 var ErrUnauthorized = errors.New("unauthorized")
 var ErrNoFileSystem = errors.New("there is no filesystem")
+var ErrClientDisconnected = errors.New("client disconnected")
+var ErrResponseStreamed = errors.New("the response is being streamed, write to the stream")
 
 func init() {
 	dune.RegisterLib(Errors, `
@@ -24,6 +26,19 @@ declare namespace errors {
 	export function newCodeError(code: number, msg: string, ...args: any[]): Error
 	export function unwrap(err: Error): Error
 	export function is(err: Error, type: string): Error
+	/**
+	 * returns the instance of the error class with the name thrown in the error or
+	 * in the errors that it wraps or null if there is none.
+	 *
+	 *   class NotFoundError { message: string; resource: string }
+	 *
+	 *   let e = errors.as<NotFoundError>(err, "NotFoundError")
+	 *
+	 * The class is passed by name because classes are not values. The name is the
+	 * one in the declaration so classes with the same name in different modules
+	 * all match.
+	 */
+	export function as<T>(err: Error, className: string): T
 	export function rethrow(err: Error): void
 
 	export interface Error {
@@ -72,6 +87,21 @@ var Errors = []dune.NativeFunction{
 			return dune.NewBool(e.Is(args[1].String())), nil
 		},
 	},
+	{
+		Name:      "errors.as",
+		Arguments: 2,
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			if err := ValidateArgs(args, nil, dune.String); err != nil {
+				return dune.NullValue, err
+			}
+			e, ok := args[0].ToObjectOrNil().(*dune.VMError)
+			if !ok {
+				return dune.NullValue, nil
+			}
+			v, _ := e.AsClass(args[1].String())
+			return v, nil
+		},
+	},
 	{
 		Name:      "errors.unwrap",
 		Arguments: 1,
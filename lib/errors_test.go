@@ -1,10 +1,13 @@
 package lib
 
 import (
+	"errors"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/filesystem"
 )
 
 func TestErrorIs(t *testing.T) {
@@ -69,3 +72,162 @@ func TestErrorWrap2(t *testing.T) {
 		t.Fatal("code", err.Wrapped.Code)
 	}
 }
+
+func TestErrorClass(t *testing.T) {
+	v := runTest(t, `
+		class NotFoundError {
+			message: string
+			code = 404
+			resource: string
+
+			constructor(resource: string) {
+				this.message = resource + " not found"
+				this.resource = resource
+			}
+		}
+
+		function main() {
+			try {
+				throw new NotFoundError("user")
+			} catch (e) {
+				let nf = errors.as(e, "NotFoundError")
+				return e.message + "," + e.code + "," + e.resource + "," + nf.resource + "," + (errors.as(e, "Foo") == null)
+			}
+		}
+
+		class Foo {}
+	`)
+
+	if v.String() != "user not found,404,user,user,true" {
+		t.Fatal(v)
+	}
+}
+
+func TestErrorClassCause(t *testing.T) {
+	v := runTest(t, `
+		class NotFoundError {
+			resource: string
+			constructor(resource: string) {
+				this.resource = resource
+			}
+		}
+
+		class ApiError {
+			message = "api error"
+			cause: errors.Error
+			constructor(cause: errors.Error) {
+				this.cause = cause
+			}
+		}
+
+		function load() {
+			throw new NotFoundError("user")
+		}
+
+		function main() {
+			try {
+				try {
+					load()
+				} catch (e) {
+					throw new ApiError(e)
+				}
+			} catch (e) {
+				return e.message + "," + errors.unwrap(e).message + "," + errors.as(e, "NotFoundError").resource
+			}
+		}
+	`)
+
+	if v.String() != "api error,NotFoundError,user" {
+		t.Fatal(v)
+	}
+}
+
+func TestErrorClassFromGo(t *testing.T) {
+	p, err := dune.CompileStr(`
+		class ValidationError {
+			field: string
+			message = "invalid"
+			constructor(field: string) {
+				this.field = field
+			}
+		}
+
+		function validate() {
+			throw new ValidationError("email")
+		}
+
+		function main() {
+			validate()
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dune.NewVM(p).Run()
+
+	var e *dune.VMError
+	if !errors.As(err, &e) {
+		t.Fatal(err)
+	}
+
+	v, ok := e.AsClass("ValidationError")
+	if !ok || v != e.Thrown {
+		t.Fatal(e.Thrown)
+	}
+
+	var ve struct {
+		Field   string
+		Message string
+	}
+	if err := dune.FromValue(v, &ve); err != nil {
+		t.Fatal(err)
+	}
+	if ve.Field != "email" || ve.Message != "invalid" {
+		t.Fatal(ve)
+	}
+
+	// the trace starts where it was thrown
+	if !strings.HasPrefix(e.Stack(), " -> line 11") {
+		t.Fatal(e.Stack())
+	}
+}
+
+func TestErrorClassFromModule(t *testing.T) {
+	fs := filesystem.NewVirtualFS()
+	filesystem.WritePath(fs, "lib.ts", []byte(`
+		export class NotFoundError {
+			message = "not found"
+		}
+
+		export function load() {
+			throw new NotFoundError()
+		}
+	`))
+	filesystem.WritePath(fs, "main.ts", []byte(`
+		import * as lib from "lib"
+
+		function main() {
+			try {
+				lib.load()
+			} catch (e) {
+				return errors.as(e, "NotFoundError").message
+			}
+		}
+	`))
+
+	p, err := dune.Compile(fs, "main.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := dune.NewVM(p).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the class is found by the name in its declaration
+	if v.String() != "not found" {
+		t.Fatal(v)
+	}
+}
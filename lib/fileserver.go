@@ -0,0 +1,305 @@
+package lib
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+func init() {
+	dune.RegisterLib(FileServer, `
+
+declare namespace http {
+    export interface FileServerOptions {
+        /**
+         * The directory of the file system with the files. By default
+         * the working directory of the file system.
+         */
+        root?: string
+        /**
+         * A prefix of the url that is removed to get the file name,
+         * like "/assets" for "/assets/app.js".
+         */
+        prefix?: string
+        /**
+         * The file served for directories. "index.html" by default.
+         */
+        index?: string
+        /**
+         * A file served when the file doesn't exist, like the
+         * index of a single page application.
+         */
+        fallback?: string
+        /**
+         * Sets Cache-Control to public with this max-age.
+         */
+        maxAge?: time.Duration | number
+        /**
+         * Serves the .gz variant of the file if it exists and the
+         * client accepts gzip. true by default.
+         */
+        precompressed?: boolean
+    }
+
+    /**
+     * Returns a handler that serves the files. It validates ETag and
+     * Last-Modified, supports range requests and doesn't serve files
+     * outside the root or the fs.read grants of the program.
+     */
+    export function fileServer(fs: io.FileSystem, options?: FileServerOptions): Handler
+}
+
+`)
+}
+
+var FileServer = []dune.NativeFunction{
+	{
+		Name:      "http.fileServer",
+		Arguments: -1,
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			if err := ValidateOptionalArgs(args, dune.Object, dune.Map); err != nil {
+				return dune.NullValue, err
+			}
+
+			fs, ok := args[0].ToObjectOrNil().(*FileSystemObj)
+			if !ok {
+				return dune.NullValue, fmt.Errorf("expected a FileSystem, got %v", args[0].TypeName())
+			}
+
+			s := &fileServer{
+				fs:            fs,
+				root:          ".",
+				index:         "index.html",
+				precompressed: true,
+			}
+
+			if len(args) == 2 && args[1].Type == dune.Map {
+				if err := s.setOptions(args[1].ToMap()); err != nil {
+					return dune.NullValue, err
+				}
+			}
+
+			return dune.NewObject(dune.NativeMethod(s.serve)), nil
+		},
+	},
+}
+
+type fileServer struct {
+	fs            *FileSystemObj
+	root          string
+	prefix        string
+	index         string
+	fallback      string
+	maxAge        time.Duration
+	precompressed bool
+}
+
+func (s *fileServer) setOptions(m *dune.MapValue) error {
+	m.RLock()
+	defer m.RUnlock()
+
+	for k, v := range m.Map {
+		switch k.String() {
+		case "root", "prefix", "index", "fallback":
+			if v.Type != dune.String {
+				return fmt.Errorf("invalid %s: expected a string, got %v", k, v.TypeName())
+			}
+			switch k.String() {
+			case "root":
+				s.root = v.String()
+			case "prefix":
+				s.prefix = strings.TrimSuffix(v.String(), "/")
+			case "index":
+				s.index = v.String()
+			case "fallback":
+				s.fallback = v.String()
+			}
+		case "maxAge":
+			d, err := ToDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid maxAge: %w", err)
+			}
+			s.maxAge = d
+		case "precompressed":
+			if v.Type != dune.Bool {
+				return fmt.Errorf("invalid precompressed: expected a boolean, got %v", v.TypeName())
+			}
+			s.precompressed = v.ToBool()
+		default:
+			return fmt.Errorf("invalid option %s", k)
+		}
+	}
+
+	return nil
+}
+
+func (s *fileServer) serve(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if len(args) < 2 {
+		return dune.NullValue, fmt.Errorf("expected a ResponseWriter and a Request")
+	}
+
+	w, ok := args[0].ToObjectOrNil().(*responseWriter)
+	if !ok {
+		return dune.NullValue, fmt.Errorf("expected a ResponseWriter, got %v", args[0].TypeName())
+	}
+
+	r, ok := args[1].ToObjectOrNil().(*request)
+	if !ok {
+		return dune.NullValue, fmt.Errorf("expected a Request, got %v", args[1].TypeName())
+	}
+
+	w.handled = true
+
+	name, ok := s.fileName(r.request.URL.Path)
+	if !ok {
+		w.status = http.StatusNotFound
+		http.NotFound(w.writer, r.request)
+		return dune.NullValue, nil
+	}
+
+	fi, err := s.stat(vm, name)
+	if err == nil && fi.IsDir() {
+		name = path.Join(name, s.index)
+		fi, err = s.stat(vm, name)
+	}
+
+	// files outside the fs.read grants are never served.
+	if isCapabilityError(err) {
+		w.status = http.StatusForbidden
+		http.Error(w.writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return dune.NullValue, nil
+	}
+
+	if err != nil || fi.IsDir() {
+		if s.fallback == "" {
+			w.status = http.StatusNotFound
+			http.NotFound(w.writer, r.request)
+			return dune.NullValue, nil
+		}
+
+		name = path.Join(s.root, s.fallback)
+		fi, err = s.stat(vm, name)
+		if err != nil {
+			return dune.NullValue, err
+		}
+	}
+
+	return dune.NullValue, s.serveFile(vm, w, r.request, name, fi)
+}
+
+// stat checks the fs.read capability before accessing the file.
+func (s *fileServer) stat(vm *dune.VM, name string) (os.FileInfo, error) {
+	return s.fs.checked(vm).Stat(name)
+}
+
+// fileName returns the name of the file in the file system. The url is
+// cleaned so it can't point outside the root.
+func (s *fileServer) fileName(urlPath string) (string, bool) {
+	if s.prefix != "" {
+		if urlPath != s.prefix && !strings.HasPrefix(urlPath, s.prefix+"/") {
+			return "", false
+		}
+		urlPath = strings.TrimPrefix(urlPath, s.prefix)
+	}
+
+	if strings.Contains(urlPath, "\x00") || containsDotDot(urlPath) {
+		return "", false
+	}
+
+	return path.Join(s.root, path.Clean("/"+urlPath)), true
+}
+
+func containsDotDot(v string) bool {
+	for _, s := range strings.FieldsFunc(v, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if s == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fileServer) serveFile(vm *dune.VM, w *responseWriter, r *http.Request, name string, fi os.FileInfo) error {
+	h := w.writer.Header()
+
+	if s.maxAge > 0 {
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(s.maxAge/time.Second)))
+	}
+
+	if s.precompressed {
+		gz, gzi, ok := s.gzipVariant(vm, name)
+		if ok {
+			h.Add("Vary", "Accept-Encoding")
+
+			// the content type is the one of the original file. If it is
+			// unknown ServeContent would sniff the compressed content.
+			ctype := mime.TypeByExtension(path.Ext(name))
+			if ctype != "" && acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				h.Set("Content-Type", ctype)
+				h.Set("Content-Encoding", "gzip")
+				name, fi = gz, gzi
+			}
+		}
+	}
+
+	f, err := s.fs.checked(vm).Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h.Set("ETag", fileETag(fi))
+
+	http.ServeContent(w.writer, r, path.Base(name), fi.ModTime(), f)
+
+	// ServeContent writes the status: 200, 206, 304...
+	if tw, ok := w.writer.(*trackingWriter); ok {
+		w.status = tw.status
+	} else {
+		w.status = http.StatusOK
+	}
+	return nil
+}
+
+func (s *fileServer) gzipVariant(vm *dune.VM, name string) (string, os.FileInfo, bool) {
+	gz := name + ".gz"
+	fi, err := s.stat(vm, gz)
+	if err != nil || fi.IsDir() {
+		return "", nil, false
+	}
+	return gz, fi, true
+}
+
+// fileETag is calculated from the size and the modification time
+// so it doesn't need to read the file.
+func fileETag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.Size(), fi.ModTime().UnixNano())
+}
+
+// acceptsGzip returns true if the Accept-Encoding header
+// includes gzip without a zero quality.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != "gzip" {
+			continue
+		}
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				if err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -8,6 +8,8 @@ import (
 )
 
 func init() {
+	dune.RegisterReplayable("fileutil.isDirEmpty")
+
 	dune.RegisterLib(FileUtil, `
 
 declare namespace fileutil { 
@@ -38,7 +40,7 @@ var FileUtil = []dune.NativeFunction{
 
 			name := args[0].String()
 
-			f, err := fs.FS.Open(name)
+			f, err := fs.checked(vm).Open(name)
 			if err != nil {
 				return dune.NullValue, err
 			}
@@ -66,7 +68,7 @@ var FileUtil = []dune.NativeFunction{
 			src := args[0].String()
 			dst := args[1].String()
 
-			fs := vm.FileSystem
+			fs := checkedFS(vm, vm.FileSystem)
 			if fs == nil {
 				return dune.NullValue, fmt.Errorf("no filesystem")
 			}
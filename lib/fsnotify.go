@@ -12,6 +12,8 @@ import (
 )
 
 func init() {
+	dune.RegisterNonReplayable("fsnotify.newWatcher")
+
 	dune.RegisterLib(FSNotify, `
 
 declare namespace fsnotify {
@@ -74,7 +76,7 @@ func newFileWatcher(fn dune.Value, vm *dune.VM) (*fsWatcher, error) {
 	w := &fsWatcher{watcher: watcher}
 	vm.SetGlobalFinalizer(w)
 
-	w.start(fn, vm)
+	w.start(fn, vm, vm.SpawnTrace())
 
 	return w, nil
 }
@@ -102,7 +104,7 @@ func (w *fsWatcher) GetMethod(name string) dune.NativeMethod {
 	return nil
 }
 
-func (w *fsWatcher) start(fn dune.Value, vm *dune.VM) {
+func (w *fsWatcher) start(fn dune.Value, vm *dune.VM, origin []dune.TraceLine) {
 	var buf []fsEvent
 	var timer *time.Timer
 
@@ -142,7 +144,7 @@ func (w *fsWatcher) start(fn dune.Value, vm *dune.VM) {
 					go func() {
 						<-timer.C
 						for _, event := range buf {
-							if err := runAsyncFuncOrClosure(vm, fn, dune.NewObject(event)); err != nil {
+							if err := runAsyncFuncOrClosure(vm, origin, fn, dune.NewObject(event)); err != nil {
 								fmt.Fprintln(vm.GetStdout(), err)
 							}
 						}
@@ -26,6 +26,9 @@ import (
 const genericError = "We are sorry, something went wrong"
 
 func init() {
+	dune.RegisterReplayable("http.get", "http.post", "http.getJSON")
+	dune.RegisterNonReplayable("http.newRequest", "http.newServer")
+
 	// set a default timeout for the whole app
 	http.DefaultClient.Timeout = time.Second * 60
 
@@ -64,7 +67,48 @@ declare namespace http {
 
     export type Handler = (w: ResponseWriter, r: Request, routeData?: any) => void
 
-    export interface Server {
+    /**
+     * Middleware runs before the handler of the request. Calling next runs
+     * the rest of the chain, optionally with a wrapped writer or request,
+     * and the code after it runs when the chain ends. If next is not
+     * called the request ends there.
+     */
+    export type Middleware = (w: ResponseWriter, r: Request, next: (w?: ResponseWriter, r?: Request) => void) => void
+
+    /**
+     * Routes are matched by method and path. Paths can have parameters
+     * like /users/:id, read with r.param("id"), and end with a wildcard.
+     * The last function is the handler and the ones before it are
+     * middleware of the route.
+     */
+    export interface Routes {
+        /**
+         * Adds middleware. In the server it runs for all the requests and
+         * in a group only for its routes.
+         */
+        use(...middleware: Middleware[]): void
+        get(path: string, ...handlers: (Middleware | Handler)[]): void
+        post(path: string, ...handlers: (Middleware | Handler)[]): void
+        put(path: string, ...handlers: (Middleware | Handler)[]): void
+        patch(path: string, ...handlers: (Middleware | Handler)[]): void
+        delete(path: string, ...handlers: (Middleware | Handler)[]): void
+        options(path: string, ...handlers: (Middleware | Handler)[]): void
+        head(path: string, ...handlers: (Middleware | Handler)[]): void
+        /**
+         * Adds a route for the method. "*" matches any method.
+         */
+        handle(method: METHOD | "*", path: string, ...handlers: (Middleware | Handler)[]): void
+        /**
+         * Returns a group of routes under the prefix that share the middleware.
+         */
+        group(prefix: string, ...middleware: Middleware[]): Routes
+    }
+
+    /**
+     * If no route matches the request the handler is used. Without it
+     * the response is 404, or 405 if the path matches other methods.
+     */
+    export interface Server extends Routes {
         address: string
         addressTLS: string
         tlsConfig: tls.Config
@@ -73,6 +117,21 @@ declare namespace http {
         writeTimeout: time.Duration | number
 		readTimeout: time.Duration | number
         idleTimeout: time.Duration | number
+        /**
+         * Writes the response when a handler throws or panics. If it doesn't
+         * write anything the default response is used. It is not called if
+         * the response has already started.
+         */
+        errorHandler: (w: ResponseWriter, r: Request, err: errors.Error) => void
+        /**
+         * Hides the errors from the clients with a generic message.
+         */
+        production: boolean
+        /**
+         * Logs the failed requests with their stack trace as json lines
+         * to stderr. true by default.
+         */
+        logErrors: boolean
         start(): void
         close(): void
         shutdown(duration?: time.Duration | number): void
@@ -137,6 +196,13 @@ declare namespace http {
 		routeInt(segment: number): number
 		routeString(segment: number): string
 
+		/**
+		 * The parameters of the route of the server that matched the request.
+		 */
+		readonly params: StringMap
+		param(name: string): string
+		paramInt(name: string): number
+
         headers(): string[]
         header(key: string): string
         setHeader(key: string, value: string): void
@@ -293,6 +359,24 @@ declare namespace http {
         writeJSONError(status: number, msg?: string): void
 
         redirect(url: string, status?: number): void
+
+        /**
+         * Sends to the client what is written so far.
+         */
+        flush(): void
+
+        /**
+         * Starts a chunked response that is sent as it is written. From then
+         * on the methods that write to the response throw and only the stream
+         * can write. Long streams need a bigger writeTimeout in the server or 0.
+         */
+        stream(options?: StreamOptions): Stream
+
+        /**
+         * Starts a response of server-sent events.
+         * Long streams need a bigger writeTimeout in the server or 0.
+         */
+        sse(options?: EventStreamOptions): EventStream
     }
 
 
@@ -406,8 +490,12 @@ var HTTP = []dune.NativeFunction{
 				readTimeout:  5 * time.Second,
 				writeTimeout: 10 * time.Second,
 				idleTimeout:  180 * time.Second,
+				routes:       newServerRoutes(),
+				logErrors:    true,
 			}
 
+			s.root = &routeGroup{routes: s.routes}
+
 			return dune.NewObject(s), nil
 		},
 	},
@@ -547,6 +635,10 @@ var HTTP = []dune.NativeFunction{
 				return dune.NullValue, err
 			}
 
+			if err := checkURL(vm, r.URL); err != nil {
+				return dune.NullValue, err
+			}
+
 			switch method {
 			case "POST", "PUT", "PATCH":
 				if contentType != "" {
@@ -577,7 +669,7 @@ var HTTP = []dune.NativeFunction{
 		Arguments:   -1,
 		Permissions: []string{"networking"},
 		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
-			client := &http.Client{}
+			client := &http.Client{CheckRedirect: checkRedirect(vm)}
 			timeout := 20 * time.Second
 
 			ln := len(args)
@@ -592,6 +684,10 @@ var HTTP = []dune.NativeFunction{
 			}
 			url := a.String()
 
+			if err := checkRawURL(vm, url); err != nil {
+				return dune.NullValue, err
+			}
+
 			if ln == 0 {
 			} else if ln > 1 {
 				a := args[1]
@@ -667,7 +763,12 @@ var HTTP = []dune.NativeFunction{
 			}
 			m.RUnlock()
 
-			resp, err := http.PostForm(u, data)
+			if err := checkRawURL(vm, u); err != nil {
+				return dune.NullValue, err
+			}
+
+			client := &http.Client{CheckRedirect: checkRedirect(vm)}
+			resp, err := client.PostForm(u, data)
 			if err != nil {
 				return dune.NullValue, err
 			}
@@ -691,7 +792,12 @@ var HTTP = []dune.NativeFunction{
 			}
 			url := args[0].String()
 
-			resp, err := http.Get(url)
+			if err := checkRawURL(vm, url); err != nil {
+				return dune.NullValue, err
+			}
+
+			client := &http.Client{CheckRedirect: checkRedirect(vm)}
+			resp, err := client.Get(url)
 			if err != nil {
 				return dune.NullValue, err
 			}
@@ -781,6 +887,7 @@ type server struct {
 	handler           int
 	closureHandler    *dune.Closure
 	methodHandler     *dune.Method
+	nativeHandler     dune.NativeMethod
 	tlsConfig         *tlsConfig
 	server            *http.Server
 	tlsServer         *http.Server
@@ -788,6 +895,11 @@ type server struct {
 	writeTimeout      time.Duration
 	readTimeout       time.Duration
 	idleTimeout       time.Duration
+	routes            *serverRoutes
+	root              *routeGroup
+	errorHandler      dune.Value
+	production        bool
+	logErrors         bool
 	vm                *dune.VM
 }
 
@@ -807,10 +919,22 @@ func (s *server) GetField(name string, vm *dune.VM) (dune.Value, error) {
 		if s.closureHandler != nil {
 			return dune.NewObject(s.closureHandler), nil
 		}
+		if s.methodHandler != nil {
+			return dune.NewObject(s.methodHandler), nil
+		}
+		if s.nativeHandler != nil {
+			return dune.NewObject(s.nativeHandler), nil
+		}
 		if s.handler != -1 {
 			return dune.NewFunction(s.handler), nil
 		}
 		return dune.NullValue, nil
+	case "errorHandler":
+		return s.errorHandler, nil
+	case "production":
+		return dune.NewBool(s.production), nil
+	case "logErrors":
+		return dune.NewBool(s.logErrors), nil
 	case "readHeaderTimeout":
 		return dune.NewObject(Duration(s.readHeaderTimeout)), nil
 	case "writeTimeout":
@@ -853,6 +977,9 @@ func (s *server) SetField(name string, v dune.Value, vm *dune.VM) error {
 			case *dune.Method:
 				s.methodHandler = t
 				return nil
+			case dune.NativeMethod:
+				s.nativeHandler = t
+				return nil
 			default:
 				return fmt.Errorf("invalid handler type %v", v.TypeName())
 			}
@@ -861,6 +988,27 @@ func (s *server) SetField(name string, v dune.Value, vm *dune.VM) error {
 			return fmt.Errorf("invalid type, %v is not a function", v.TypeName())
 		}
 
+	case "errorHandler":
+		if !v.IsNilOrEmpty() && !isFunc(v) {
+			return fmt.Errorf("invalid type, %v is not a function", v.TypeName())
+		}
+		s.errorHandler = v
+		return nil
+
+	case "production":
+		if v.Type != dune.Bool {
+			return fmt.Errorf("invalid type, expected bool")
+		}
+		s.production = v.ToBool()
+		return nil
+
+	case "logErrors":
+		if v.Type != dune.Bool {
+			return fmt.Errorf("invalid type, expected bool")
+		}
+		s.logErrors = v.ToBool()
+		return nil
+
 	case "tlsConfig":
 		if v.Type != dune.Object {
 			return fmt.Errorf("invalid type, expected a tls object")
@@ -917,7 +1065,7 @@ func (s *server) GetMethod(name string) dune.NativeMethod {
 	case "shutdown":
 		return s.shutdown
 	}
-	return nil
+	return s.root.GetMethod(name)
 }
 
 func (s *server) shutdown(args []dune.Value, vm *dune.VM) (dune.Value, error) {
@@ -1041,36 +1189,59 @@ func (s *server) start(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	return dune.NullValue, s.server.ListenAndServe()
 }
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	vm := s.vm.CloneInitialized(s.vm.Program, s.vm.Globals())
+	vm := s.newVM()
+
+	tw := &trackingWriter{ResponseWriter: w}
 
 	rr := &responseWriter{
-		writer:  w,
+		writer:  tw,
 		request: r,
 	}
 
 	req := &request{
 		request: r,
-		writer:  w,
+		writer:  tw,
 	}
 
-	if s.closureHandler != nil {
-		if _, err := vm.RunClosure(s.closureHandler, dune.NewObject(rr), dune.NewObject(req)); err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte(err.Error()))
+	defer func() {
+		if v := recover(); v != nil {
+			// stop the heartbeats before handling the error
+			rr.closeStreams()
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			s.handleError(tw, rr, req, panicError(vm, v))
 		}
+	}()
+
+	err := s.serveRoutes(vm, rr, req)
+	rr.closeStreams()
+
+	if err != nil {
+		s.handleError(tw, rr, req, err)
+	}
+}
+
+func (s *server) newVM() *dune.VM {
+	return s.vm.CloneInitialized(s.vm.Program, s.vm.Globals())
+}
+
+func (s *server) hasHandler() bool {
+	return s.closureHandler != nil || s.methodHandler != nil || s.nativeHandler != nil || s.handler >= 0
+}
+
+func (s *server) runHandler(vm *dune.VM, w, r dune.Value) error {
+	var err error
+	if s.closureHandler != nil {
+		_, err = vm.RunClosure(s.closureHandler, w, r)
 	} else if s.methodHandler != nil {
-		if _, err := vm.RunMethod(s.methodHandler, dune.NewObject(rr), dune.NewObject(req)); err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte(err.Error()))
-		}
-	} else if s.handler >= 0 {
-		if _, err := vm.RunFuncIndex(s.handler, dune.NewObject(rr), dune.NewObject(req)); err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte(err.Error()))
-		}
+		_, err = vm.RunMethod(s.methodHandler, w, r)
+	} else if s.nativeHandler != nil {
+		_, err = s.nativeHandler([]dune.Value{w, r}, vm)
 	} else {
-		fmt.Println("no handler")
+		_, err = vm.RunFuncIndex(s.handler, w, r)
 	}
+	return err
 }
 
 type cookie struct {
@@ -1188,6 +1359,9 @@ func (c *cookie) SetField(name string, v dune.Value, vm *dune.VM) error {
 type response struct {
 	r       *http.Response
 	handled bool
+
+	// the resources of the vm if the response is tracked
+	resources *dune.Resources
 }
 
 func (r *response) Type() string {
@@ -1204,10 +1378,32 @@ func (r *response) GetField(name string, vm *dune.VM) (dune.Value, error) {
 		return dune.NewString(r.r.Proto), nil
 	case "body":
 		return dune.NewObject(&readerCloser{r.r.Body}), nil
+	case "ok":
+		return dune.NewBool(isHTTPSuccess(r.r.StatusCode)), nil
+	case "url":
+		if r.r.Request == nil {
+			return dune.NullValue, nil
+		}
+		return dune.NewString(r.r.Request.URL.String()), nil
 	}
 	return dune.UndefinedValue, nil
 }
 
+// Close closes the body of the response.
+func (r *response) Close() error {
+	if r.resources != nil {
+		r.resources.Untrack(r)
+	}
+	return r.r.Body.Close()
+}
+
+func (r *response) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+	return dune.NullValue, r.Close()
+}
+
 func (r *response) SetField(name string, v dune.Value, vm *dune.VM) error {
 	switch name {
 	case "handled":
@@ -1234,6 +1430,8 @@ func (r *response) GetMethod(name string) dune.NativeMethod {
 		return r.bytes
 	case "cookies":
 		return r.cookies
+	case "close":
+		return r.close
 	}
 	return nil
 }
@@ -1309,7 +1507,7 @@ func (r *response) string(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 
 	b, err := ioutil.ReadAll(resp.Body)
 
-	resp.Body.Close()
+	r.Close()
 
 	if err != nil {
 		return dune.NullValue, err
@@ -1327,7 +1525,7 @@ func (r *response) json(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 
 	b, err := ioutil.ReadAll(resp.Body)
 
-	resp.Body.Close()
+	r.Close()
 
 	if err != nil {
 		return dune.NullValue, err
@@ -1345,7 +1543,7 @@ func (r *response) bytes(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 
 	b, err := ioutil.ReadAll(resp.Body)
 
-	resp.Body.Close()
+	r.Close()
 
 	if err != nil {
 		return dune.NullValue, err
@@ -1361,6 +1559,9 @@ type request struct {
 	// to allow get them multiple times
 	requestValues dune.Value
 
+	// the route of the server that matched the request
+	route *routeMatch
+
 	// for testing
 	tls bool
 }
@@ -1405,6 +1606,11 @@ func (r *request) GetField(name string, vm *dune.VM) (dune.Value, error) {
 		return dune.NewObject(url), nil
 	case "extension":
 		return dune.NewString(filepath.Ext(r.request.URL.Path)), nil
+	case "params":
+		if r.route == nil {
+			return dune.NewMap(0), nil
+		}
+		return r.route.GetField("values", vm)
 	}
 
 	return dune.UndefinedValue, nil
@@ -1468,6 +1674,10 @@ func (r *request) GetMethod(name string) dune.NativeMethod {
 		return r.routeString
 	case "routeInt":
 		return r.routeInt
+	case "param":
+		return r.param
+	case "paramInt":
+		return r.paramInt
 	case "file":
 		return r.file
 	case "cookie":
@@ -1589,6 +1799,26 @@ func (r *request) routeString(args []dune.Value, vm *dune.VM) (dune.Value, error
 	return dune.NullValue, nil
 }
 
+func (r *request) param(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if r.route == nil {
+		if err := ValidateArgs(args, dune.String); err != nil {
+			return dune.NullValue, err
+		}
+		return dune.NullValue, nil
+	}
+	return r.route.string(args, vm)
+}
+
+func (r *request) paramInt(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if r.route == nil {
+		if err := ValidateArgs(args, dune.String); err != nil {
+			return dune.NullValue, err
+		}
+		return dune.NullValue, nil
+	}
+	return r.route.int(args, vm)
+}
+
 func (r *request) basicAuth(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	if err := ValidateArgs(args); err != nil {
 		return dune.NullValue, err
@@ -1619,7 +1849,7 @@ func (r *request) setBasicAuth(args []dune.Value, vm *dune.VM) (dune.Value, erro
 }
 
 func (r *request) execute(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	client := &http.Client{}
+	client := &http.Client{CheckRedirect: checkRedirect(vm)}
 
 	ln := len(args)
 
@@ -1647,6 +1877,10 @@ func (r *request) execute(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 		client.Transport = getTransport(tlsc.conf)
 	}
 
+	if err := checkURL(vm, r.request.URL); err != nil {
+		return dune.NullValue, err
+	}
+
 	resp, err := client.Do(r.request)
 	if err != nil {
 		return dune.NullValue, err
@@ -1656,7 +1890,7 @@ func (r *request) execute(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 }
 
 func (r *request) executeString(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	client := &http.Client{}
+	client := &http.Client{CheckRedirect: checkRedirect(vm)}
 
 	ln := len(args)
 
@@ -1684,6 +1918,10 @@ func (r *request) executeString(args []dune.Value, vm *dune.VM) (dune.Value, err
 		client.Transport = getTransport(tlsc.conf)
 	}
 
+	if err := checkURL(vm, r.request.URL); err != nil {
+		return dune.NullValue, err
+	}
+
 	resp, err := client.Do(r.request)
 	if err != nil {
 		return dune.NullValue, err
@@ -1705,7 +1943,7 @@ func (r *request) executeString(args []dune.Value, vm *dune.VM) (dune.Value, err
 }
 
 func (r *request) executeJSON(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	client := &http.Client{}
+	client := &http.Client{CheckRedirect: checkRedirect(vm)}
 
 	ln := len(args)
 
@@ -1733,6 +1971,10 @@ func (r *request) executeJSON(args []dune.Value, vm *dune.VM) (dune.Value, error
 		client.Transport = getTransport(tlsc.conf)
 	}
 
+	if err := checkURL(vm, r.request.URL); err != nil {
+		return dune.NullValue, err
+	}
+
 	resp, err := client.Do(r.request)
 	if err != nil {
 		return dune.NullValue, err
@@ -2206,6 +2448,7 @@ type responseWriter struct {
 	request *http.Request
 	status  int
 	handled bool
+	streams []io.Closer
 }
 
 func (*responseWriter) Type() string {
@@ -2246,6 +2489,15 @@ func (r *responseWriter) SetField(name string, v dune.Value, vm *dune.VM) error
 }
 
 func (r *responseWriter) GetMethod(name string) dune.NativeMethod {
+	if len(r.streams) > 0 {
+		switch name {
+		case "addCookie", "write", "writeGziped", "writeJSON", "writeJSONStatus", "writeFile",
+			"redirect", "setContentType", "setHeader", "setStatus", "writeError", "writeJSONError",
+			"flush", "stream", "sse":
+			return r.streaming
+		}
+	}
+
 	switch name {
 	case "addCookie":
 		r.handled = true
@@ -2291,6 +2543,15 @@ func (r *responseWriter) GetMethod(name string) dune.NativeMethod {
 	case "writeJSONError":
 		r.handled = true
 		return r.writeJSONError
+	case "flush":
+		r.handled = true
+		return r.flush
+	case "stream":
+		r.handled = true
+		return r.stream
+	case "sse":
+		r.handled = true
+		return r.sse
 	case "string":
 		return r.string
 	case "json":
@@ -2301,7 +2562,65 @@ func (r *responseWriter) GetMethod(name string) dune.NativeMethod {
 	return nil
 }
 
+func (r *responseWriter) streaming(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	return dune.NullValue, ErrResponseStreamed
+}
+
+// closeStreams stops the streams of the response when the handler ends.
+func (r *responseWriter) closeStreams() {
+	for _, s := range r.streams {
+		s.Close()
+	}
+}
+
+func (r *responseWriter) flush(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+
+	f, ok := r.writer.(http.Flusher)
+	if !ok {
+		return dune.NullValue, fmt.Errorf("the response can't be flushed")
+	}
+
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	f.Flush()
+	return dune.NullValue, nil
+}
+
+func (r *responseWriter) stream(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	opts, err := parseStreamOptions(args, false)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	s, err := newResponseStream(r, vm, opts)
+	if err != nil {
+		return dune.NullValue, err
+	}
+	return dune.NewObject(s), nil
+}
+
+func (r *responseWriter) sse(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	opts, err := parseStreamOptions(args, true)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	s, err := newEventStream(r, vm, opts)
+	if err != nil {
+		return dune.NullValue, err
+	}
+	return dune.NewObject(s), nil
+}
+
 func (r *responseWriter) Write(p []byte) (n int, err error) {
+	if len(r.streams) > 0 {
+		return 0, ErrResponseStreamed
+	}
 
 	// set 200 by default when anything is written
 	if r.status == 0 {
@@ -2779,12 +3098,12 @@ func (r *responseWriter) writeFile(args []dune.Value, vm *dune.VM) (dune.Value,
 			if !ok {
 				return dune.NullValue, ErrInvalidType
 			}
-			fi, err := fs.FS.Stat(name)
+			fi, err := fs.checked(vm).Stat(name)
 			if err != nil {
 				return dune.NullValue, err
 			}
 			lastModified = fi.ModTime()
-			f, err := fs.FS.Open(name)
+			f, err := fs.checked(vm).Open(name)
 			if err != nil {
 				return dune.NullValue, err
 			}
@@ -0,0 +1,621 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+// newTestServer runs the code, that must return a server, and
+// returns it to send requests to it with ServeHTTP.
+func newTestServer(t *testing.T, code string) *server {
+	p, err := dune.CompileStr(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.AddPermission("trusted")
+
+	vm := dune.NewVM(p)
+	vm.MaxSteps = 10000
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := v.ToObjectOrNil().(*server)
+	if !ok {
+		t.Fatalf("expected a server, got %v", v.TypeName())
+	}
+	return s
+}
+
+func serveTest(s *server, method, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	return w
+}
+
+func TestServerRoutes(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+			s.get("/users/:id", (w, r) => w.write("get " + r.param("id")))
+			s.post("/users/:id", (w, r) => w.write("post " + r.paramInt("id") * 2))
+			s.handle("*", "/any", (w, r) => w.write(r.method))
+			s.get("/files/*", (w, r) => w.write("files"))
+			return s
+		}
+	`)
+
+	var tests = []struct {
+		method string
+		url    string
+		status int
+		body   string
+	}{
+		{"GET", "/users/7", 200, "get 7"},
+		{"POST", "/users/7", 200, "post 14"},
+		{"PUT", "/any", 200, "PUT"},
+		{"GET", "/files/a/b.txt", 200, "files"},
+		{"HEAD", "/users/7", 200, "get 7"}, // the recorder keeps the body
+		{"GET", "/nothing", 404, "404 page not found\n"},
+		{"DELETE", "/users/7", 405, "Method Not Allowed\n"},
+	}
+
+	for _, test := range tests {
+		w := serveTest(s, test.method, test.url)
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Fatalf("%s %s: %d %q", test.method, test.url, w.Code, w.Body.String())
+		}
+	}
+
+	w := serveTest(s, "DELETE", "/users/7")
+	if w.Header().Get("Allow") != "GET, POST" {
+		t.Fatal(w.Header())
+	}
+}
+
+func TestServerMiddleware(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+
+			s.use((w, r, next) => {
+				w.setHeader("X-Before", "1")
+				next()
+				w.write(" after:" + w.status)
+			})
+
+			let auth = (w, r, next) => {
+				if (r.header("Authorization") != "secret") {
+					w.writeError(401)
+					return
+				}
+				next()
+			}
+
+			let api = s.group("/api", auth)
+			api.get("/items/:id", (w, r, next) => {
+				w.setHeader("X-Route", r.params.id)
+				next()
+			}, (w, r) => w.write("item " + r.param("id")))
+
+			s.handler = (w, r) => w.write("fallback")
+			return s
+		}
+	`)
+
+	w := serveTest(s, "GET", "/api/items/3")
+	if w.Code != 401 || w.Header().Get("X-Before") != "1" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/api/items/3", nil)
+	r.Header.Set("Authorization", "secret")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Body.String() != "item 3 after:200" || w.Header().Get("X-Route") != "3" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	w = serveTest(s, "GET", "/other")
+	if w.Body.String() != "fallback after:200" {
+		t.Fatal(w.Body.String())
+	}
+}
+
+func TestServerMiddlewareWrapWriter(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+
+			s.use((w, r, next) => {
+				let rec = http.newResponseRecorder(r)
+				next(rec)
+				w.setHeader("X-Length", "" + rec.string().length)
+				w.write(rec.string().toUpper())
+			})
+
+			s.get("/", (w, r) => w.write("hello"))
+			return s
+		}
+	`)
+
+	w := serveTest(s, "GET", "/")
+	if w.Code != http.StatusOK || w.Body.String() != "HELLO" || w.Header().Get("X-Length") != "5" {
+		t.Fatal(w.Code, w.Body.String(), w.Header())
+	}
+}
+
+func TestServerErrorHandler(t *testing.T) {
+	s := newTestServer(t, `
+		class NotFoundError {
+			message = "not found"
+			code = 404
+		}
+
+		function main() {
+			let s = http.newServer()
+			s.get("/missing", (w, r) => { throw new NotFoundError() })
+			s.get("/fail", (w, r) => { throw "internal detail" })
+			s.get("/partial", (w, r) => {
+				w.write("partial")
+				throw "too late"
+			})
+			s.errorHandler = (w, r, err) => {
+				if (err.code == 404) {
+					w.writeJSONStatus(404, { error: err.message })
+				}
+			}
+			return s
+		}
+	`)
+
+	var log bytes.Buffer
+	s.vm.Stderr = &log
+
+	w := serveTest(s, "GET", "/missing")
+	if w.Code != 404 || w.Body.String() != "{\"error\":\"not found\"}\n" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// the handler didn't write anything so the default response is used
+	w = serveTest(s, "GET", "/fail")
+	if w.Code != 500 || !strings.HasPrefix(w.Body.String(), "internal detail") {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	s.production = true
+
+	w = serveTest(s, "GET", "/fail")
+	if w.Code != 500 || w.Body.String() != genericError+"\n" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// a partial response is not modified
+	w = serveTest(s, "GET", "/partial")
+	if w.Code != 200 || w.Body.String() != "partial" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatal(log.String())
+	}
+
+	var e requestError
+	if err := json.Unmarshal([]byte(lines[3]), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Method != "GET" || e.URL != "/partial" || e.Status != 200 ||
+		e.Error.Message != "too late" || len(e.Error.Stack) == 0 {
+		t.Fatal(lines[3])
+	}
+}
+
+func TestServerPanic(t *testing.T) {
+	dune.AddNativeFunc(dune.NativeFunction{
+		Name:      "httptest.panic",
+		Arguments: 0,
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			panic("boom")
+		},
+	})
+
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+			s.production = true
+			s.handler = (w, r) => httptest.panic()
+			return s
+		}
+	`)
+
+	var log bytes.Buffer
+	s.vm.Stderr = &log
+
+	w := serveTest(s, "GET", "/")
+	if w.Code != 500 || w.Body.String() != genericError+"\n" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(log.String(), "boom") {
+		t.Fatal(log.String())
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let fs = io.newVirtualFS()
+			fs.mkdir("/www/docs")
+			fs.write("/www/index.html", "<p>home</p>")
+			fs.write("/www/app.js", "console.log(1)")
+			fs.write("/www/app.js.gz", "gzipped")
+			fs.write("/www/docs/index.html", "docs")
+			fs.write("/secret.txt", "secret")
+
+			let s = http.newServer()
+			s.get("/static/*", http.fileServer(fs, { root: "/www", prefix: "/static", maxAge: 60 * time.Second }))
+			s.handler = http.fileServer(fs, { root: "/www", fallback: "index.html" })
+			return s
+		}
+	`)
+
+	w := serveTest(s, "GET", "/static/app.js")
+	if w.Code != 200 || w.Body.String() != "console.log(1)" ||
+		w.Header().Get("Cache-Control") != "public, max-age=60" ||
+		w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatal(w.Code, w.Body.String(), w.Header())
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Last-Modified") == "" {
+		t.Fatal(w.Header())
+	}
+
+	// the precompressed variant
+	r := httptest.NewRequest("GET", "/static/app.js", nil)
+	r.Header.Set("Accept-Encoding", "br, gzip")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Body.String() != "gzipped" || w.Header().Get("Content-Encoding") != "gzip" ||
+		!strings.Contains(w.Header().Get("Content-Type"), "javascript") {
+		t.Fatal(w.Body.String(), w.Header())
+	}
+
+	// validation
+	r = httptest.NewRequest("GET", "/static/app.js", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Fatal(w.Code)
+	}
+
+	// ranges
+	r = httptest.NewRequest("GET", "/static/app.js", nil)
+	r.Header.Set("Range", "bytes=0-6")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "console" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// directory index
+	w = serveTest(s, "GET", "/static/docs/")
+	if w.Code != 200 || w.Body.String() != "docs" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// traversal
+	w = serveTest(s, "GET", "/static/../secret.txt")
+	if w.Code != 404 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// the fallback of a single page application
+	w = serveTest(s, "GET", "/users/1")
+	if w.Code != 200 || w.Body.String() != "<p>home</p>" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
+
+func TestServerEventStream(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+			s.handler = (w, r) => {
+				let e = w.sse()
+				e.sendEvent("tick", "a\nb", 1)
+				e.sendEvent(null, { n: 2 })
+			}
+			return s
+		}
+	`)
+
+	w := serveTest(s, "GET", "/")
+
+	if w.Header().Get("Content-Type") != "text/event-stream" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatal(w.Header())
+	}
+
+	expected := "event: tick\nid: 1\ndata: a\ndata: b\n\ndata: {\"n\":2}\n\n"
+	if w.Code != 200 || w.Body.String() != expected {
+		t.Fatalf("%d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+			s.handler = (w, r) => {
+				w.setContentType("text/plain")
+				let st = w.stream()
+				for (let i = 0; i < 3; i++) {
+					st.write("chunk " + i + "\n")
+				}
+			}
+			return s
+		}
+	`)
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.TransferEncoding) != 1 || res.TransferEncoding[0] != "chunked" {
+		t.Fatal(res.TransferEncoding)
+	}
+
+	if string(b) != "chunk 0\nchunk 1\nchunk 2\n" {
+		t.Fatal(string(b))
+	}
+}
+
+func TestServerStreamDisconnected(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+			s.handler = (w, r) => {
+				let e = w.sse()
+				if (!e.disconnected) {
+					throw "expected the client to be disconnected"
+				}
+				e.sendEvent("tick", "a")
+			}
+			return s
+		}
+	`)
+
+	var log bytes.Buffer
+	s.vm.Stderr = &log
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+
+	if w.Body.String() != "" || !strings.Contains(log.String(), ErrClientDisconnected.Error()) {
+		t.Fatal(w.Body.String(), log.String())
+	}
+}
+
+func TestServerStreamCancelOnDisconnect(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+			s.handler = (w, r) => {
+				let e = w.sse({ heartbeat: 5 * time.Millisecond, cancelOnDisconnect: true })
+				e.sendEvent("start", "1")
+				while (true) {
+					time.sleep(10 * time.Millisecond)
+				}
+			}
+			return s
+		}
+	`)
+
+	var log bytes.Buffer
+	s.vm.Stderr = &log
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(w, r)
+		close(done)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read the event and a heartbeat
+	b := make([]byte, 256)
+	var received string
+	for !strings.Contains(received, ": heartbeat") {
+		n, err := res.Body.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received += string(b[:n])
+	}
+
+	if !strings.HasPrefix(received, "event: start\ndata: 1\n\n") {
+		t.Fatalf("%q", received)
+	}
+
+	cancel()
+	res.Body.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler didn't stop")
+	}
+
+	if !strings.Contains(log.String(), dune.ErrClosed.Error()) {
+		t.Fatal(log.String())
+	}
+}
+
+func TestFileServerCapabilities(t *testing.T) {
+	p, err := dune.CompileStr(`
+		// [permissions netListen fs.read:/www/public fs.write:/www]
+
+		function main() {
+			let fs = io.newVirtualFS()
+			fs.mkdir("/www/public")
+			fs.mkdir("/www/private")
+			fs.write("/www/public/index.html", "public")
+			fs.write("/www/private/secret.txt", "secret")
+			fs.write("/www/config.json", "config")
+
+			let s = http.newServer()
+			s.handler = http.fileServer(fs, { root: "/www" })
+			return s
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := dune.NewVM(p)
+	vm.MaxSteps = 10000
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := v.ToObjectOrNil().(*server)
+
+	w := serveTest(s, "GET", "/public/")
+	if w.Code != 200 || w.Body.String() != "public" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// sibling paths are outside the grant and ../ is never followed
+	var tests = []struct {
+		url    string
+		status int
+	}{
+		{"/private/secret.txt", 403},
+		{"/config.json", 403},
+		{"/public/../private/secret.txt", 404},
+		{"/public/%2e%2e/config.json", 404},
+	}
+
+	for _, test := range tests {
+		w := serveTest(s, "GET", test.url)
+		if w.Code != test.status || strings.Contains(w.Body.String(), "secret") {
+			t.Fatal(test.url, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestWriteFileCapabilities(t *testing.T) {
+	p, err := dune.CompileStr(`
+		// [permissions netListen fs.read:/www/public fs.write:/www]
+
+		function main() {
+			let fs = io.newVirtualFS()
+			fs.mkdir("/www/public")
+			fs.write("/www/public/index.html", "public")
+			fs.write("/www/config.json", "s3cr3t")
+
+			let s = http.newServer()
+			s.handler = (w, r) => w.writeFile("/www" + r.url.path, fs)
+			return s
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := dune.NewVM(p)
+	vm.MaxSteps = 10000
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := v.ToObjectOrNil().(*server)
+
+	w := serveTest(s, "GET", "/public/index.html")
+	if w.Code != 200 || w.Body.String() != "public" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	w = serveTest(s, "GET", "/config.json")
+	if w.Code != 500 || strings.Contains(w.Body.String(), "s3cr3t") {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
+
+func TestServerStreamWriterLocked(t *testing.T) {
+	s := newTestServer(t, `
+		function main() {
+			let s = http.newServer()
+			s.handler = (w, r) => {
+				let e = w.sse({ heartbeat: 1 * time.Millisecond })
+				let errors = 0
+				for (let i = 0; i < 20; i++) {
+					try {
+						w.write("raw")
+					} catch {
+						errors++
+					}
+					e.sendEvent("n", "" + i)
+				}
+				e.sendEvent("errors", "" + errors)
+			}
+			return s
+		}
+	`)
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer can't be used while the stream writes the heartbeats
+	if strings.Contains(string(b), "raw") || !strings.Contains(string(b), "event: errors\ndata: 20\n\n") {
+		t.Fatal(string(b))
+	}
+}
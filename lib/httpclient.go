@@ -0,0 +1,952 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+func init() {
+	dune.RegisterNonReplayable("http.newClient")
+
+	dune.RegisterLib(HTTPClient, `
+
+declare namespace http {
+    export interface ClientOptions {
+        /**
+         * The time limit for the requests, including reading the body.
+         * 60 seconds by default.
+         */
+        timeout?: time.Duration | number
+        /**
+         * The url of the proxy. By default the one of the environment.
+         */
+        proxy?: string
+        tlsConfig?: tls.Config
+        /**
+         * Keeps the cookies of the responses and sends them back.
+         */
+        cookies?: boolean
+        /**
+         * The redirects that are followed, 10 by default. With 0 the
+         * redirect is returned as the response.
+         */
+        maxRedirects?: number
+        /**
+         * The urls of the requests are relative to it.
+         */
+        baseURL?: string
+        /**
+         * Headers sent in all the requests.
+         */
+        headers?: StringMap
+        retry?: RetryPolicy
+    }
+
+    /**
+     * Failed requests are retried after a delay that doubles in each retry.
+     * Only GET, HEAD, PUT, DELETE and OPTIONS are retried, unless the
+     * request sets its own policy, and never if the body is a stream.
+     * If the response has a Retry-After header in seconds it is used
+     * as the delay.
+     */
+    export interface RetryPolicy {
+        /**
+         * The number of retries after the first attempt.
+         */
+        retries: number
+        /**
+         * The delay before the first retry, 100ms by default.
+         */
+        delay?: time.Duration | number
+        /**
+         * 10s by default.
+         */
+        maxDelay?: time.Duration | number
+        /**
+         * The status codes that are retried besides the network errors.
+         * 429, 502, 503 and 504 by default.
+         */
+        statuses?: number[]
+    }
+
+    export function newClient(options?: ClientOptions): Client
+
+    export interface Client {
+        request(method: METHOD | "HEAD", url: string): ClientRequest
+        get(url: string): ClientRequest
+        post(url: string): ClientRequest
+        put(url: string): ClientRequest
+        patch(url: string): ClientRequest
+        delete(url: string): ClientRequest
+        head(url: string): ClientRequest
+        /**
+         * The cookies stored for the url if the client has cookies enabled.
+         */
+        cookies(url: string): Cookie[]
+        /**
+         * Closes the idle connections.
+         */
+        close(): void
+    }
+
+    /**
+     * A request of a client. The methods return the request
+     * so they can be chained until it is sent.
+     */
+    export interface ClientRequest {
+        header(name: string, value: string): ClientRequest
+        query(name: string, value: any): ClientRequest
+        basicAuth(user: string, password: string): ClientRequest
+        bearer(token: string): ClientRequest
+        timeout(d: time.Duration | number): ClientRequest
+        retry(policy: RetryPolicy): ClientRequest
+
+        /**
+         * Sends the value as json.
+         */
+        json(v: any): ClientRequest
+        /**
+         * Sends the values url encoded.
+         */
+        form(values: any): ClientRequest
+        /**
+         * Adds a field to a multipart body.
+         */
+        field(name: string, value: any): ClientRequest
+        /**
+         * Adds a file to a multipart body.
+         */
+        file(field: string, fileName: string, data: string | byte[] | io.Reader): ClientRequest
+        /**
+         * Sets the body. A reader is streamed.
+         */
+        body(data: string | byte[] | io.Reader, contentType?: string): ClientRequest
+
+        /**
+         * Sends the request. The body of the response must be
+         * closed if it is not read with string, json or bytes.
+         */
+        send(): ClientResponse
+    }
+
+    export interface ClientResponse extends Response {
+        /**
+         * true if the status is 2xx.
+         */
+        readonly ok: boolean
+        /**
+         * The url of the response after the redirects.
+         */
+        readonly url: string
+        close(): void
+    }
+}
+
+`)
+}
+
+var HTTPClient = []dune.NativeFunction{
+	{
+		Name:        "http.newClient",
+		Arguments:   -1,
+		Permissions: []string{"networking"},
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			if err := ValidateOptionalArgs(args, dune.Map); err != nil {
+				return dune.NullValue, err
+			}
+
+			c := &httpClient{
+				client:  &http.Client{Timeout: 60 * time.Second},
+				headers: make(http.Header),
+				retry:   defaultRetryPolicy(),
+			}
+
+			var opts *clientOptions
+			if len(args) == 1 && args[0].Type == dune.Map {
+				o, err := parseClientOptions(args[0].ToMap())
+				if err != nil {
+					return dune.NullValue, err
+				}
+				opts = o
+			} else {
+				opts = &clientOptions{maxRedirects: 10}
+			}
+
+			if err := c.configure(vm, opts); err != nil {
+				return dune.NullValue, err
+			}
+
+			return dune.NewObject(c), nil
+		},
+	},
+}
+
+type clientOptions struct {
+	timeout      time.Duration
+	proxy        string
+	tlsConfig    *tlsConfig
+	cookies      bool
+	maxRedirects int
+	baseURL      string
+	headers      http.Header
+	retry        *retryPolicy
+}
+
+func parseClientOptions(m *dune.MapValue) (*clientOptions, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	o := &clientOptions{maxRedirects: 10}
+
+	for k, v := range m.Map {
+		switch k.String() {
+		case "timeout":
+			d, err := ToDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout: %w", err)
+			}
+			o.timeout = d
+		case "proxy":
+			if v.Type != dune.String {
+				return nil, fmt.Errorf("invalid proxy: expected a string, got %v", v.TypeName())
+			}
+			o.proxy = v.String()
+		case "tlsConfig":
+			t, ok := v.ToObjectOrNil().(*tlsConfig)
+			if !ok {
+				return nil, fmt.Errorf("invalid tlsConfig: expected a tls.Config, got %v", v.TypeName())
+			}
+			o.tlsConfig = t
+		case "cookies":
+			if v.Type != dune.Bool {
+				return nil, fmt.Errorf("invalid cookies: expected a boolean, got %v", v.TypeName())
+			}
+			o.cookies = v.ToBool()
+		case "maxRedirects":
+			if v.Type != dune.Int {
+				return nil, fmt.Errorf("invalid maxRedirects: expected an int, got %v", v.TypeName())
+			}
+			o.maxRedirects = int(v.ToInt())
+		case "baseURL":
+			if v.Type != dune.String {
+				return nil, fmt.Errorf("invalid baseURL: expected a string, got %v", v.TypeName())
+			}
+			o.baseURL = v.String()
+		case "headers":
+			if v.Type != dune.Map {
+				return nil, fmt.Errorf("invalid headers: expected a map, got %v", v.TypeName())
+			}
+			h, err := toHeader(v.ToMap())
+			if err != nil {
+				return nil, err
+			}
+			o.headers = h
+		case "retry":
+			if v.Type != dune.Map {
+				return nil, fmt.Errorf("invalid retry: expected a map, got %v", v.TypeName())
+			}
+			p, err := parseRetryPolicy(v.ToMap())
+			if err != nil {
+				return nil, err
+			}
+			o.retry = p
+		default:
+			return nil, fmt.Errorf("invalid option %s", k)
+		}
+	}
+
+	return o, nil
+}
+
+func toHeader(m *dune.MapValue) (http.Header, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	h := make(http.Header, len(m.Map))
+	for k, v := range m.Map {
+		s, err := serialize(v)
+		if err != nil {
+			return nil, err
+		}
+		h.Set(k.String(), s)
+	}
+	return h, nil
+}
+
+type retryPolicy struct {
+	retries  int
+	delay    time.Duration
+	maxDelay time.Duration
+	statuses []int
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		delay:    100 * time.Millisecond,
+		maxDelay: 10 * time.Second,
+		statuses: []int{429, 502, 503, 504},
+	}
+}
+
+func parseRetryPolicy(m *dune.MapValue) (*retryPolicy, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	p := defaultRetryPolicy()
+
+	for k, v := range m.Map {
+		switch k.String() {
+		case "retries":
+			if v.Type != dune.Int {
+				return nil, fmt.Errorf("invalid retries: expected an int, got %v", v.TypeName())
+			}
+			p.retries = int(v.ToInt())
+		case "delay":
+			d, err := ToDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid delay: %w", err)
+			}
+			p.delay = d
+		case "maxDelay":
+			d, err := ToDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid maxDelay: %w", err)
+			}
+			p.maxDelay = d
+		case "statuses":
+			if v.Type != dune.Array {
+				return nil, fmt.Errorf("invalid statuses: expected an array, got %v", v.TypeName())
+			}
+			var statuses []int
+			for _, s := range v.ToArray() {
+				if s.Type != dune.Int {
+					return nil, fmt.Errorf("invalid status: %v", s)
+				}
+				statuses = append(statuses, int(s.ToInt()))
+			}
+			p.statuses = statuses
+		default:
+			return nil, fmt.Errorf("invalid retry option %s", k)
+		}
+	}
+
+	return &p, nil
+}
+
+// shouldRetry returns true for timeouts, connections that could not be
+// established or were reset and the status codes of the policy.
+func (p retryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return isTransient(err)
+	}
+
+	for _, s := range p.statuses {
+		if resp.StatusCode == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransient returns true if the error is a timeout or a connection error
+// that can succeed if it is tried again. Errors like too many redirects,
+// invalid certificates or missing capabilities are not.
+func isTransient(err error) bool {
+	if isCapabilityError(err) {
+		return false
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	var op *net.OpError
+	if errors.As(err, &op) && op.Op == "dial" {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// backoff returns the delay before the retry. It doubles in each
+// attempt unless the server sends how much to wait.
+func (p retryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+			d := time.Duration(s) * time.Second
+			if p.maxDelay > 0 && d > p.maxDelay {
+				d = p.maxDelay
+			}
+			return d
+		}
+	}
+
+	d := p.delay
+	for i := 0; i < attempt; i++ {
+		d *= 2
+		if p.maxDelay > 0 && d > p.maxDelay {
+			return p.maxDelay
+		}
+	}
+	return d
+}
+
+type httpClient struct {
+	client  *http.Client
+	baseURL *url.URL
+	headers http.Header
+	retry   retryPolicy
+}
+
+func (c *httpClient) configure(vm *dune.VM, o *clientOptions) error {
+	var conf *tls.Config
+	if o.tlsConfig != nil {
+		conf = o.tlsConfig.conf
+	}
+
+	t := getTransport(conf)
+
+	if o.proxy != "" {
+		u, err := url.Parse(o.proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
+		}
+		if err := checkURL(vm, u); err != nil {
+			return err
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	c.client.Transport = t
+
+	if o.timeout > 0 {
+		c.client.Timeout = o.timeout
+	}
+
+	if o.cookies {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		c.client.Jar = jar
+	}
+
+	maxRedirects := o.maxRedirects
+	c.client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+		if maxRedirects == 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return checkURL(vm, r.URL)
+	}
+
+	if o.baseURL != "" {
+		u, err := url.Parse(o.baseURL)
+		if err != nil {
+			return fmt.Errorf("invalid baseURL: %w", err)
+		}
+		c.baseURL = u
+	}
+
+	if o.headers != nil {
+		c.headers = o.headers
+	}
+
+	if o.retry != nil {
+		c.retry = *o.retry
+	}
+
+	return nil
+}
+
+func (c *httpClient) Type() string {
+	return "http.Client"
+}
+
+func (c *httpClient) GetMethod(name string) dune.NativeMethod {
+	switch name {
+	case "request":
+		return c.request
+	case "get":
+		return c.methodRequest(http.MethodGet)
+	case "post":
+		return c.methodRequest(http.MethodPost)
+	case "put":
+		return c.methodRequest(http.MethodPut)
+	case "patch":
+		return c.methodRequest(http.MethodPatch)
+	case "delete":
+		return c.methodRequest(http.MethodDelete)
+	case "head":
+		return c.methodRequest(http.MethodHead)
+	case "cookies":
+		return c.cookies
+	case "close":
+		return c.close
+	}
+	return nil
+}
+
+func (c *httpClient) request(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.String, dune.String); err != nil {
+		return dune.NullValue, err
+	}
+	return c.newRequest(strings.ToUpper(args[0].String()), args[1].String())
+}
+
+func (c *httpClient) methodRequest(method string) dune.NativeMethod {
+	return func(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+		if err := ValidateArgs(args, dune.String); err != nil {
+			return dune.NullValue, err
+		}
+		return c.newRequest(method, args[0].String())
+	}
+}
+
+func (c *httpClient) newRequest(method, rawURL string) (dune.Value, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	if c.baseURL != nil {
+		u = c.baseURL.ResolveReference(u)
+	}
+
+	r := &clientRequest{
+		client: c,
+		method: method,
+		url:    u,
+		header: make(http.Header),
+		query:  make(url.Values),
+	}
+
+	return dune.NewObject(r), nil
+}
+
+func (c *httpClient) cookies(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.String); err != nil {
+		return dune.NullValue, err
+	}
+
+	if c.client.Jar == nil {
+		return dune.NullValue, fmt.Errorf("the client doesn't have cookies enabled")
+	}
+
+	u, err := url.Parse(args[0].String())
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	cookies := c.client.Jar.Cookies(u)
+	values := make([]dune.Value, len(cookies))
+	for i, k := range cookies {
+		values[i] = dune.NewObject(&cookie{name: k.Name, value: k.Value})
+	}
+
+	return dune.NewArrayValues(values), nil
+}
+
+func (c *httpClient) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+	c.client.CloseIdleConnections()
+	return dune.NullValue, nil
+}
+
+type clientRequest struct {
+	client      *httpClient
+	method      string
+	url         *url.URL
+	header      http.Header
+	query       url.Values
+	body        []byte
+	reader      io.Reader
+	contentType string
+	parts       []multipartPart
+	timeout     time.Duration
+	retry       *retryPolicy
+}
+
+// multipartPart is a field, or a file if it has a name.
+type multipartPart struct {
+	field    string
+	fileName string
+	value    string
+	data     dune.Value
+}
+
+func (r *clientRequest) Type() string {
+	return "http.ClientRequest"
+}
+
+func (r *clientRequest) GetMethod(name string) dune.NativeMethod {
+	switch name {
+	case "header":
+		return r.setHeader
+	case "query":
+		return r.setQuery
+	case "basicAuth":
+		return r.basicAuth
+	case "bearer":
+		return r.bearer
+	case "timeout":
+		return r.setTimeout
+	case "retry":
+		return r.setRetry
+	case "json":
+		return r.json
+	case "form":
+		return r.form
+	case "field":
+		return r.field
+	case "file":
+		return r.file
+	case "body":
+		return r.setBody
+	case "send":
+		return r.send
+	}
+	return nil
+}
+
+func (r *clientRequest) setHeader(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.String, dune.String); err != nil {
+		return dune.NullValue, err
+	}
+	r.header.Add(args[0].String(), args[1].String())
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) setQuery(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.String, nil); err != nil {
+		return dune.NullValue, err
+	}
+	v, err := serialize(args[1])
+	if err != nil {
+		return dune.NullValue, err
+	}
+	r.query.Add(args[0].String(), v)
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) basicAuth(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.String, dune.String); err != nil {
+		return dune.NullValue, err
+	}
+	auth := args[0].String() + ":" + args[1].String()
+	r.header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) bearer(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.String); err != nil {
+		return dune.NullValue, err
+	}
+	r.header.Set("Authorization", "Bearer "+args[0].String())
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) setTimeout(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, nil); err != nil {
+		return dune.NullValue, err
+	}
+	d, err := ToDuration(args[0])
+	if err != nil {
+		return dune.NullValue, err
+	}
+	r.timeout = d
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) setRetry(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.Map); err != nil {
+		return dune.NullValue, err
+	}
+	p, err := parseRetryPolicy(args[0].ToMap())
+	if err != nil {
+		return dune.NullValue, err
+	}
+	r.retry = p
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) json(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, nil); err != nil {
+		return dune.NullValue, err
+	}
+	b, err := json.Marshal(args[0].ExportMarshal(0))
+	if err != nil {
+		return dune.NullValue, err
+	}
+	r.setContent(b, nil, "application/json")
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) form(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.Map); err != nil {
+		return dune.NullValue, err
+	}
+
+	m := args[0].ToMap()
+	m.RLock()
+	defer m.RUnlock()
+
+	data := url.Values{}
+	for k, v := range m.Map {
+		s, err := serialize(v)
+		if err != nil {
+			return dune.NullValue, err
+		}
+		data.Add(k.String(), s)
+	}
+
+	r.setContent([]byte(data.Encode()), nil, "application/x-www-form-urlencoded")
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) field(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.String, nil); err != nil {
+		return dune.NullValue, err
+	}
+	v, err := serialize(args[1])
+	if err != nil {
+		return dune.NullValue, err
+	}
+	r.parts = append(r.parts, multipartPart{field: args[0].String(), value: v})
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) file(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, dune.String, dune.String, nil); err != nil {
+		return dune.NullValue, err
+	}
+	switch args[2].Type {
+	case dune.String, dune.Bytes:
+	case dune.Object:
+		if _, ok := args[2].ToObject().(io.Reader); !ok {
+			return dune.NullValue, fmt.Errorf("expected a reader, got %v", args[2].TypeName())
+		}
+	default:
+		return dune.NullValue, fmt.Errorf("expected string, bytes or a reader, got %v", args[2].TypeName())
+	}
+	r.parts = append(r.parts, multipartPart{field: args[0].String(), fileName: args[1].String(), data: args[2]})
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) setBody(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgRange(args, 1, 2); err != nil {
+		return dune.NullValue, err
+	}
+
+	var contentType string
+	if len(args) == 2 {
+		if args[1].Type != dune.String {
+			return dune.NullValue, fmt.Errorf("expected the content type to be a string, got %v", args[1].TypeName())
+		}
+		contentType = args[1].String()
+	}
+
+	a := args[0]
+	switch a.Type {
+	case dune.String, dune.Bytes:
+		r.setContent(a.ToBytes(), nil, contentType)
+	case dune.Object:
+		reader, ok := a.ToObject().(io.Reader)
+		if !ok {
+			return dune.NullValue, fmt.Errorf("expected a reader, got %v", a.TypeName())
+		}
+		r.setContent(nil, reader, contentType)
+	default:
+		return dune.NullValue, fmt.Errorf("expected string, bytes or a reader, got %v", a.TypeName())
+	}
+
+	return dune.NewObject(r), nil
+}
+
+func (r *clientRequest) setContent(b []byte, reader io.Reader, contentType string) {
+	r.body = b
+	r.reader = reader
+	r.parts = nil
+	r.contentType = contentType
+}
+
+// multipartBody writes the fields and the files.
+func (r *clientRequest) multipartBody() ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for _, p := range r.parts {
+		if p.fileName == "" {
+			if err := w.WriteField(p.field, p.value); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		fw, err := w.CreateFormFile(p.field, p.fileName)
+		if err != nil {
+			return nil, "", err
+		}
+
+		switch p.data.Type {
+		case dune.String, dune.Bytes:
+			_, err = fw.Write(p.data.ToBytes())
+		default:
+			_, err = io.Copy(fw, p.data.ToObject().(io.Reader))
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+func (r *clientRequest) send(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+
+	u := *r.url
+	if len(r.query) > 0 {
+		q := u.Query()
+		for k, v := range r.query {
+			q[k] = append(q[k], v...)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	if err := checkURL(vm, &u); err != nil {
+		return dune.NullValue, err
+	}
+
+	if len(r.parts) > 0 {
+		b, ctype, err := r.multipartBody()
+		if err != nil {
+			return dune.NullValue, err
+		}
+		r.setContent(b, nil, ctype)
+	}
+
+	client := r.client.client
+	if r.timeout > 0 {
+		c := *client
+		c.Timeout = r.timeout
+		client = &c
+	}
+
+	policy := r.client.retry
+	if r.retry != nil {
+		policy = *r.retry
+	} else if r.method == http.MethodPost || r.method == http.MethodPatch {
+		policy.retries = 0
+	}
+
+	// a stream can't be sent again.
+	if r.reader != nil {
+		policy.retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := r.newHTTPRequest(&u)
+		if err != nil {
+			return dune.NullValue, err
+		}
+
+		resp, err := client.Do(req)
+
+		if attempt < policy.retries && policy.shouldRetry(resp, err) {
+			d := policy.backoff(attempt, resp)
+			if resp != nil {
+				io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+				resp.Body.Close()
+			}
+			time.Sleep(d)
+			continue
+		}
+
+		if err != nil {
+			return dune.NullValue, err
+		}
+
+		resp.Body = dataBeforeEOF{resp.Body}
+
+		res := &response{r: resp, resources: vm.Resources}
+		if err := vm.Resources.Track(dune.ResourceConnection, res); err != nil {
+			resp.Body.Close()
+			return dune.NullValue, err
+		}
+		vm.SetGlobalFinalizer(res)
+
+		return dune.NewObject(res), nil
+	}
+}
+
+// dataBeforeEOF returns the last bytes of the body without EOF. The
+// read method of the scripts returns 0 on EOF so they would be lost
+// when the body is read in chunks.
+type dataBeforeEOF struct {
+	io.ReadCloser
+}
+
+func (r dataBeforeEOF) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *clientRequest) newHTTPRequest(u *url.URL) (*http.Request, error) {
+	var body io.Reader
+	if r.reader != nil {
+		body = r.reader
+	} else if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+
+	req, err := http.NewRequest(r.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range r.client.headers {
+		req.Header[k] = v
+	}
+
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+
+	return req, nil
+}
@@ -0,0 +1,242 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dunelang/dune"
+)
+
+func TestClientRequestBuilder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(map[string]string{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"query":  r.URL.RawQuery,
+			"auth":   r.Header.Get("Authorization"),
+			"agent":  r.Header.Get("X-Agent"),
+			"type":   r.Header.Get("Content-Type"),
+			"body":   string(b),
+		})
+	}))
+	defer ts.Close()
+
+	v := runTest(t, `
+		function main(url: string) {
+			let c = http.newClient({ baseURL: url, headers: { "X-Agent": "dune" } })
+			let res = c.post("/items")
+				.query("page", 2)
+				.bearer("token")
+				.json({ name: "a" })
+				.send()
+			return [res.ok, res.status, res.json()]
+		}
+	`, dune.NewString(ts.URL))
+
+	a := v.ToArray()
+	if !a[0].ToBool() || a[1].ToInt() != 200 {
+		t.Fatal(v)
+	}
+
+	m := a[2].ToMap().Map
+	get := func(k string) string { return m[dune.NewString(k)].String() }
+
+	if get("method") != "POST" || get("path") != "/items" || get("query") != "page=2" ||
+		get("auth") != "Bearer token" || get("agent") != "dune" ||
+		get("type") != "application/json" || get("body") != `{"name":"a"}` {
+		t.Fatal(a[2])
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("done"))
+	}))
+	defer ts.Close()
+
+	v := runTest(t, `
+		function main(url: string) {
+			let c = http.newClient({ retry: { retries: 3, delay: 1 * time.Millisecond } })
+			let post = c.post(url).send()
+			let get = c.get(url).send()
+			return post.status + "," + get.status + "," + get.string()
+		}
+	`, dune.NewString(ts.URL))
+
+	// POST is not retried without its own policy
+	if v.String() != "503,200,done" || atomic.LoadInt32(&calls) != 3 {
+		t.Fatal(v, calls)
+	}
+}
+
+func TestClientRetryOnlyTransientErrors(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Redirect(w, r, "/", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	v := runTest(t, `
+		function main(url: string) {
+			let c = http.newClient({ retry: { retries: 3, delay: 1 * time.Millisecond } })
+			try {
+				c.get(url).send()
+			} catch (e) {
+				return e.message
+			}
+		}
+	`, dune.NewString(ts.URL))
+
+	// the redirect loop is an error but it is not retried
+	if !strings.Contains(v.String(), "stopped after 10 redirects") || atomic.LoadInt32(&calls) != 10 {
+		t.Fatal(v, calls)
+	}
+}
+
+func TestClientResponseFinalized(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	p, err := dune.CompileStr(`
+		function main(url: string) {
+			let c = http.newClient()
+			c.get(url).send()
+			return c.get(url).send().status
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.AddPermission("trusted")
+
+	vm := dune.NewVM(p)
+	v, err := vm.Run(dune.NewString(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.ToInt() != 200 {
+		t.Fatal(v)
+	}
+
+	// the responses that are not closed are released by the finalizers of the run
+	if n := vm.Resources.Count(dune.ResourceConnection); n != 0 {
+		t.Fatalf("expected 0 connections, got %d", n)
+	}
+}
+
+func TestClientCookiesAndRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		http.Redirect(w, r, "/me", http.StatusFound)
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("session")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(c.Value))
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	v := runTest(t, `
+		function main(url: string) {
+			let c = http.newClient({ baseURL: url, cookies: true })
+			let res = c.get("/login").send()
+			let body = res.string()
+
+			let noRedirects = http.newClient({ baseURL: url, maxRedirects: 0 })
+			let r = noRedirects.get("/login").send()
+			r.close()
+
+			return body + "," + res.url.hasSuffix("/me") + "," + r.status + "," + c.cookies(url)[0].value
+		}
+	`, dune.NewString(ts.URL))
+
+	if v.String() != "abc,true,302,abc" {
+		t.Fatal(v)
+	}
+}
+
+func TestClientMultipartAndStreaming(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		f, h, err := r.FormFile("doc")
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		b, _ := ioutil.ReadAll(f)
+		w.Write([]byte(r.FormValue("title") + ":" + h.Filename + ":" + string(b)))
+	}))
+	defer ts.Close()
+
+	v := runTest(t, `
+		function main(url: string) {
+			let c = http.newClient()
+			let res = c.post(url)
+				.field("title", "report")
+				.file("doc", "a.txt", "content")
+				.send()
+
+			// read the body as a stream
+			let buf = convert.toBytes("....")
+			let s = ""
+			while (true) {
+				let n = res.body.read(buf)
+				if (n == 0) {
+					break
+				}
+				s += convert.toString(buf.slice(0, n))
+			}
+			res.close()
+			return s
+		}
+	`, dune.NewString(ts.URL))
+
+	if v.String() != "report:a.txt:content" {
+		t.Fatal(v)
+	}
+}
+
+func TestClientStreamingBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(strings.ToUpper(string(b)) + ":" + r.Header.Get("Content-Type")))
+	}))
+	defer ts.Close()
+
+	v := runTest(t, `
+		function main(url: string) {
+			let b = io.newBuffer()
+			b.write("streamed")
+			return http.newClient().put(url).body(b, "text/plain").send().string()
+		}
+	`, dune.NewString(ts.URL))
+
+	if v.String() != "STREAMED:text/plain" {
+		t.Fatal(v)
+	}
+}
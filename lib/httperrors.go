@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+// trackingWriter records if the response has started so an error
+// doesn't change the status or append to a partial response.
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+	status  int
+}
+
+func (w *trackingWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *trackingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.written {
+			w.written = true
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack allows to upgrade the connection to websockets.
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response can't be hijacked")
+	}
+	w.written = true
+	return h.Hijack()
+}
+
+// requestError is the line logged for a request that failed.
+type requestError struct {
+	Time       time.Time         `json:"time"`
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	RemoteAddr string            `json:"remoteAddr"`
+	Status     int               `json:"status"`
+	Error      *dune.ErrorReport `json:"error"`
+}
+
+// handleError logs the error and writes the response if nothing was
+// written yet. The errorHandler of the server can write it instead.
+// Otherwise it is a 500, or the code of the error if it is an http
+// error status, with the error or a generic message in production.
+func (s *server) handleError(tw *trackingWriter, rr *responseWriter, req *request, err error) {
+	status := errorStatus(err)
+	if tw.written {
+		status = tw.status
+	}
+
+	if s.logErrors {
+		s.logError(req.request, status, err)
+	}
+
+	// the headers are sent so the status can't change and
+	// a message would be appended to the content.
+	if tw.written {
+		return
+	}
+
+	// use a new vm because the one of the request
+	// could be in any state if it panicked.
+	vm := s.newVM()
+
+	if isFunc(s.errorHandler) {
+		e := toVMError(vm, err)
+		if herr := runFuncOrClosure(vm, s.errorHandler, dune.NewObject(rr), dune.NewObject(req), dune.NewObject(e)); herr != nil {
+			if s.logErrors {
+				s.logError(req.request, http.StatusInternalServerError, herr)
+			}
+		}
+
+		if tw.written {
+			return
+		}
+	}
+
+	msg := err.Error()
+	if s.production {
+		msg = Translate(genericError, vm)
+	}
+
+	http.Error(tw, msg, status)
+}
+
+func (s *server) logError(r *http.Request, status int, err error) {
+	e := requestError{
+		Time:       time.Now(),
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		RemoteAddr: r.RemoteAddr,
+		Status:     status,
+	}
+
+	var vmErr *dune.VMError
+	if errors.As(err, &vmErr) {
+		e.Error = vmErr.Report()
+	} else {
+		e.Error = &dune.ErrorReport{Message: err.Error(), Stack: []dune.ReportFrame{}}
+	}
+
+	b, jerr := json.Marshal(e)
+	if jerr != nil {
+		b = []byte(err.Error())
+	}
+
+	fmt.Fprintln(s.vm.GetStderr(), string(b))
+}
+
+// errorStatus returns the code of the error if it is an http error status.
+func errorStatus(err error) int {
+	var e *dune.VMError
+	if errors.As(err, &e) && e.Code >= 400 && e.Code < 600 {
+		return e.Code
+	}
+	return http.StatusInternalServerError
+}
+
+func toVMError(vm *dune.VM, err error) *dune.VMError {
+	var e *dune.VMError
+	if errors.As(err, &e) {
+		return e
+	}
+	return vm.WrapError(err)
+}
+
+// panicError converts a panic in a handler in an error with the stack
+// of the program where it happened.
+func panicError(vm *dune.VM, v interface{}) error {
+	return vm.NewError("panic: %v", v)
+}
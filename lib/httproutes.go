@@ -0,0 +1,356 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dunelang/dune"
+)
+
+// serverRoutes are the middleware and the routes registered in a server.
+// There is a router for each method and "*" matches any method.
+type serverRoutes struct {
+	mu         sync.RWMutex
+	middleware []dune.Value
+	routers    map[string]*httpRouter
+}
+
+func newServerRoutes() *serverRoutes {
+	return &serverRoutes{routers: make(map[string]*httpRouter)}
+}
+
+// routeHandler is the value stored in the routers.
+type routeHandler struct {
+	group      *routeGroup
+	middleware []dune.Value
+	handler    dune.Value
+}
+
+func (h *routeHandler) Type() string {
+	return "http.Route"
+}
+
+func (s *serverRoutes) add(method, url string, h *routeHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.routers[method]
+	if !ok {
+		r = newRouter()
+		s.routers[method] = r
+	}
+
+	r.Add(&httpRoute{URL: fixRouteURL(url), Value: dune.NewObject(h)})
+}
+
+func (s *serverRoutes) use(mw []dune.Value) {
+	s.mu.Lock()
+	s.middleware = append(s.middleware, mw...)
+	s.mu.Unlock()
+}
+
+func (s *serverRoutes) globalMiddleware() []dune.Value {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.middleware
+}
+
+// match returns the route for the method and the url. If the url only
+// matches routes of other methods it returns the methods that are allowed.
+func (s *serverRoutes) match(method, url string) (*routeMatch, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	methods := []string{method, "*"}
+	if method == http.MethodHead {
+		methods = append(methods, http.MethodGet)
+	}
+
+	for _, m := range methods {
+		if r, ok := s.routers[m]; ok {
+			if rm, ok := matchRoute(r, url); ok {
+				return rm, nil
+			}
+		}
+	}
+
+	var allowed []string
+	for m, r := range s.routers {
+		if m == "*" {
+			continue
+		}
+		if _, ok := matchRoute(r, url); ok {
+			allowed = append(allowed, m)
+		}
+	}
+
+	sort.Strings(allowed)
+	return nil, allowed
+}
+
+func matchRoute(r *httpRouter, url string) (*routeMatch, bool) {
+	m, ok := r.Match(url)
+	if !ok {
+		return nil, false
+	}
+
+	// the router falls back to the root for urls that don't match
+	// but the routes of a server must match the whole url.
+	if m.Route.URL == "/" && strings.Trim(url, "/") != "" {
+		return nil, false
+	}
+
+	return m, true
+}
+
+// routeGroup registers routes under a prefix that share middleware.
+// The server has a root group without prefix.
+type routeGroup struct {
+	routes     *serverRoutes
+	parent     *routeGroup
+	prefix     string
+	middleware []dune.Value
+}
+
+func (g *routeGroup) Type() string {
+	return "http.RouteGroup"
+}
+
+func (g *routeGroup) GetMethod(name string) dune.NativeMethod {
+	switch name {
+	case "use":
+		return g.use
+	case "get":
+		return g.methodRoute(http.MethodGet)
+	case "post":
+		return g.methodRoute(http.MethodPost)
+	case "put":
+		return g.methodRoute(http.MethodPut)
+	case "patch":
+		return g.methodRoute(http.MethodPatch)
+	case "delete":
+		return g.methodRoute(http.MethodDelete)
+	case "options":
+		return g.methodRoute(http.MethodOptions)
+	case "head":
+		return g.methodRoute(http.MethodHead)
+	case "handle":
+		return g.handle
+	case "group":
+		return g.group
+	}
+	return nil
+}
+
+// chain returns the middleware of the group and its parents.
+func (g *routeGroup) chain() []dune.Value {
+	g.routes.mu.RLock()
+	defer g.routes.mu.RUnlock()
+
+	var groups []*routeGroup
+	for p := g; p != nil; p = p.parent {
+		groups = append(groups, p)
+	}
+
+	var mw []dune.Value
+	for i := len(groups) - 1; i >= 0; i-- {
+		mw = append(mw, groups[i].middleware...)
+	}
+	return mw
+}
+
+func (g *routeGroup) use(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := validateHandlers(args, 1); err != nil {
+		return dune.NullValue, err
+	}
+
+	// the middleware of the server runs for all requests.
+	if g.parent == nil && g.prefix == "" {
+		g.routes.use(args)
+		return dune.NullValue, nil
+	}
+
+	g.routes.mu.Lock()
+	g.middleware = append(g.middleware, args...)
+	g.routes.mu.Unlock()
+	return dune.NullValue, nil
+}
+
+func (g *routeGroup) methodRoute(method string) dune.NativeMethod {
+	return func(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+		return g.addRoute(method, args)
+	}
+}
+
+func (g *routeGroup) handle(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if len(args) == 0 || args[0].Type != dune.String {
+		return dune.NullValue, fmt.Errorf("expected the method as first argument")
+	}
+	return g.addRoute(strings.ToUpper(args[0].String()), args[1:])
+}
+
+func (g *routeGroup) addRoute(method string, args []dune.Value) (dune.Value, error) {
+	if len(args) < 2 {
+		return dune.NullValue, fmt.Errorf("expected a path and a handler, got %d arguments", len(args))
+	}
+
+	if args[0].Type != dune.String {
+		return dune.NullValue, fmt.Errorf("expected the path to be a string, got %v", args[0].TypeName())
+	}
+
+	handlers := args[1:]
+	if err := validateHandlers(handlers, 1); err != nil {
+		return dune.NullValue, err
+	}
+
+	h := &routeHandler{
+		group:      g,
+		middleware: append([]dune.Value(nil), handlers[:len(handlers)-1]...),
+		handler:    handlers[len(handlers)-1],
+	}
+
+	g.routes.add(method, joinRoute(g.prefix, args[0].String()), h)
+	return dune.NullValue, nil
+}
+
+func (g *routeGroup) group(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if len(args) == 0 || args[0].Type != dune.String {
+		return dune.NullValue, fmt.Errorf("expected the prefix as first argument")
+	}
+
+	mw := args[1:]
+	if err := validateHandlers(mw, 0); err != nil {
+		return dune.NullValue, err
+	}
+
+	sub := &routeGroup{
+		routes:     g.routes,
+		parent:     g,
+		prefix:     joinRoute(g.prefix, args[0].String()),
+		middleware: append([]dune.Value(nil), mw...),
+	}
+
+	return dune.NewObject(sub), nil
+}
+
+func validateHandlers(args []dune.Value, min int) error {
+	if len(args) < min {
+		return fmt.Errorf("expected at least %d function", min)
+	}
+	for i, v := range args {
+		if !isFunc(v) {
+			return fmt.Errorf("argument %d: %v is not a function", i+1, v.TypeName())
+		}
+	}
+	return nil
+}
+
+func joinRoute(prefix, url string) string {
+	return path.Join("/", prefix, url)
+}
+
+// middlewareChain runs the middleware in order. Each one receives a next
+// function that runs the rest of the chain. If it is not called the
+// request ends there.
+type middlewareChain struct {
+	middleware []dune.Value
+	handler    func(w, r dune.Value) error
+}
+
+func (c *middlewareChain) run(vm *dune.VM, i int, w, r dune.Value) error {
+	if i == len(c.middleware) {
+		return c.handler(w, r)
+	}
+
+	var called bool
+
+	next := dune.NativeMethod(func(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+		if called {
+			return dune.NullValue, fmt.Errorf("next was already called")
+		}
+		called = true
+
+		nw, nr := w, r
+		switch len(args) {
+		case 0:
+		case 1, 2:
+			// the middleware can pass a wrapped writer or request.
+			if !args[0].IsNilOrEmpty() {
+				nw = args[0]
+			}
+			if len(args) == 2 && !args[1].IsNilOrEmpty() {
+				nr = args[1]
+			}
+		default:
+			return dune.NullValue, fmt.Errorf("expected 0 to 2 arguments, got %d", len(args))
+		}
+
+		return dune.NullValue, c.run(vm, i+1, nw, nr)
+	})
+
+	return runFuncOrClosure(vm, c.middleware[i], w, r, dune.NewObject(next))
+}
+
+// serveRoutes runs the middleware of the server and then the route that
+// matches the request. If none matches it runs the handler of the server.
+func (s *server) serveRoutes(vm *dune.VM, rr *responseWriter, req *request) error {
+	chain := &middlewareChain{
+		middleware: s.routes.globalMiddleware(),
+		handler: func(w, r dune.Value) error {
+			return s.dispatch(vm, req, w, r)
+		},
+	}
+
+	return chain.run(vm, 0, dune.NewObject(rr), dune.NewObject(req))
+}
+
+func (s *server) dispatch(vm *dune.VM, req *request, w, r dune.Value) error {
+	m, allowed := s.routes.match(req.request.Method, req.request.URL.Path)
+	if m == nil {
+		if s.hasHandler() {
+			return s.runHandler(vm, w, r)
+		}
+		return notFound(w, allowed)
+	}
+
+	if rq, ok := r.ToObjectOrNil().(*request); ok {
+		rq.route = m
+	}
+
+	h := m.Route.Value.ToObject().(*routeHandler)
+
+	mw := h.group.chain()
+	mw = append(mw, h.middleware...)
+
+	chain := &middlewareChain{
+		middleware: mw,
+		handler: func(w, r dune.Value) error {
+			return runFuncOrClosure(vm, h.handler, w, r)
+		},
+	}
+
+	return chain.run(vm, 0, w, r)
+}
+
+// notFound writes 404 or 405 if the url matches routes of other methods.
+func notFound(w dune.Value, allowed []string) error {
+	rw, ok := w.ToObjectOrNil().(*responseWriter)
+	if !ok {
+		return fmt.Errorf("no route matches the request")
+	}
+
+	if len(allowed) > 0 {
+		rw.writer.Header().Set("Allow", strings.Join(allowed, ", "))
+		rw.status = http.StatusMethodNotAllowed
+		http.Error(rw.writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	rw.status = http.StatusNotFound
+	http.NotFound(rw.writer, rw.request)
+	return nil
+}
@@ -0,0 +1,361 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+func init() {
+	dune.RegisterLib(nil, `
+
+declare namespace http {
+    export interface StreamOptions {
+        /**
+         * Closes the vm of the request when the client disconnects
+         * so the handler stops.
+         */
+        cancelOnDisconnect?: boolean
+    }
+
+    export interface EventStreamOptions extends StreamOptions {
+        /**
+         * Sends a comment with this interval to keep the connection open.
+         */
+        heartbeat?: time.Duration | number
+    }
+
+    /**
+     * A chunked response. Each write is sent to the client.
+     * Writing after the client disconnects throws "client disconnected".
+     * The writeTimeout of the server limits how long it can last.
+     */
+    export interface Stream {
+        readonly disconnected: boolean
+        write(v: any): number
+        close(): void
+    }
+
+    /**
+     * A response of server-sent events.
+     */
+    export interface EventStream {
+        readonly disconnected: boolean
+        /**
+         * Sends an event. Values that are not strings are sent as json.
+         */
+        sendEvent(name: string | null, data: any, id?: string | number): void
+        close(): void
+    }
+}
+
+`)
+}
+
+type streamOptions struct {
+	cancelOnDisconnect bool
+	heartbeat          time.Duration
+}
+
+func parseStreamOptions(args []dune.Value, heartbeat bool) (streamOptions, error) {
+	var o streamOptions
+
+	if err := ValidateOptionalArgs(args, dune.Map); err != nil {
+		return o, err
+	}
+
+	if len(args) == 0 || args[0].Type != dune.Map {
+		return o, nil
+	}
+
+	m := args[0].ToMap()
+	m.RLock()
+	defer m.RUnlock()
+
+	for k, v := range m.Map {
+		switch k.String() {
+		case "cancelOnDisconnect":
+			if v.Type != dune.Bool {
+				return o, fmt.Errorf("invalid cancelOnDisconnect: expected a boolean, got %v", v.TypeName())
+			}
+			o.cancelOnDisconnect = v.ToBool()
+		case "heartbeat":
+			if !heartbeat {
+				return o, fmt.Errorf("invalid option %s", k)
+			}
+			d, err := ToDuration(v)
+			if err != nil {
+				return o, fmt.Errorf("invalid heartbeat: %w", err)
+			}
+			o.heartbeat = d
+		default:
+			return o, fmt.Errorf("invalid option %s", k)
+		}
+	}
+
+	return o, nil
+}
+
+// responseStream writes to the client flushing after each write.
+type responseStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newResponseStream(rw *responseWriter, vm *dune.VM, opts streamOptions) (*responseStream, error) {
+	f, ok := rw.writer.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("the response can't be streamed")
+	}
+
+	s := &responseStream{
+		w:       rw.writer,
+		flusher: f,
+		ctx:     rw.request.Context(),
+		done:    make(chan struct{}),
+	}
+
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	// the stream stops when the handler ends.
+	rw.streams = append(rw.streams, s)
+
+	if opts.heartbeat > 0 || opts.cancelOnDisconnect {
+		go s.watch(vm, opts)
+	}
+
+	return s, nil
+}
+
+// watch sends the heartbeats and closes the vm if the client
+// disconnects and the stream is configured to do it.
+func (s *responseStream) watch(vm *dune.VM, opts streamOptions) {
+	var tick <-chan time.Time
+	if opts.heartbeat > 0 {
+		t := time.NewTicker(opts.heartbeat)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ctx.Done():
+			if opts.cancelOnDisconnect {
+				vm.Close()
+			}
+			return
+		case <-tick:
+			s.Write([]byte(": heartbeat\n\n"))
+		}
+	}
+}
+
+func (s *responseStream) disconnected() bool {
+	select {
+	case <-s.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *responseStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return 0, fmt.Errorf("the stream is closed")
+	default:
+	}
+
+	if s.disconnected() {
+		return 0, ErrClientDisconnected
+	}
+
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("%w: %v", ErrClientDisconnected, err)
+	}
+
+	s.flusher.Flush()
+	return n, nil
+}
+
+func (s *responseStream) Close() error {
+	s.once.Do(func() {
+		// wait for a write in progress.
+		s.mu.Lock()
+		close(s.done)
+		s.mu.Unlock()
+	})
+	return nil
+}
+
+func (s *responseStream) Type() string {
+	return "http.Stream"
+}
+
+func (s *responseStream) GetField(name string, vm *dune.VM) (dune.Value, error) {
+	switch name {
+	case "disconnected":
+		return dune.NewBool(s.disconnected()), nil
+	}
+	return dune.UndefinedValue, nil
+}
+
+func (s *responseStream) GetMethod(name string) dune.NativeMethod {
+	switch name {
+	case "write":
+		return s.write
+	case "close":
+		return s.close
+	}
+	return nil
+}
+
+func (s *responseStream) write(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args, nil); err != nil {
+		return dune.NullValue, err
+	}
+
+	var b []byte
+	switch args[0].Type {
+	case dune.Null, dune.Undefined:
+		return dune.NewInt(0), nil
+	case dune.String, dune.Bytes:
+		b = args[0].ToBytes()
+	default:
+		b = []byte(args[0].String())
+	}
+
+	n, err := s.Write(b)
+	if err != nil {
+		return dune.NullValue, err
+	}
+	return dune.NewInt(n), nil
+}
+
+func (s *responseStream) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+	return dune.NullValue, s.Close()
+}
+
+// eventStream writes server-sent events.
+type eventStream struct {
+	*responseStream
+}
+
+func newEventStream(rw *responseWriter, vm *dune.VM, opts streamOptions) (*eventStream, error) {
+	h := rw.writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+
+	// disable the buffering of proxies like nginx
+	h.Set("X-Accel-Buffering", "no")
+
+	s, err := newResponseStream(rw, vm, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// send the headers now so the client knows that it is connected.
+	s.mu.Lock()
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+	s.mu.Unlock()
+
+	return &eventStream{s}, nil
+}
+
+func (s *eventStream) Type() string {
+	return "http.EventStream"
+}
+
+func (s *eventStream) GetMethod(name string) dune.NativeMethod {
+	switch name {
+	case "sendEvent":
+		return s.sendEvent
+	case "close":
+		return s.close
+	}
+	return nil
+}
+
+func (s *eventStream) sendEvent(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgRange(args, 2, 3); err != nil {
+		return dune.NullValue, err
+	}
+
+	var b strings.Builder
+
+	switch args[0].Type {
+	case dune.Null, dune.Undefined:
+	case dune.String:
+		if err := writeEventField(&b, "event", args[0].String()); err != nil {
+			return dune.NullValue, err
+		}
+	default:
+		return dune.NullValue, fmt.Errorf("expected the name to be a string, got %v", args[0].TypeName())
+	}
+
+	if len(args) == 3 && !args[2].IsNilOrEmpty() {
+		if err := writeEventField(&b, "id", args[2].String()); err != nil {
+			return dune.NullValue, err
+		}
+	}
+
+	var data string
+	switch args[1].Type {
+	case dune.String:
+		data = args[1].String()
+	default:
+		j, err := json.Marshal(args[1].ExportMarshal(0))
+		if err != nil {
+			return dune.NullValue, err
+		}
+		data = string(j)
+	}
+
+	// each line of the data is a data field.
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(strings.TrimSuffix(line, "\r"))
+		b.WriteByte('\n')
+	}
+
+	b.WriteByte('\n')
+
+	if _, err := s.Write([]byte(b.String())); err != nil {
+		return dune.NullValue, err
+	}
+	return dune.NullValue, nil
+}
+
+func writeEventField(b *strings.Builder, name, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("the %s of the event can't have line breaks", name)
+	}
+	b.WriteString(name)
+	b.WriteString(": ")
+	b.WriteString(value)
+	b.WriteByte('\n')
+	return nil
+}
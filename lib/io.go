@@ -363,6 +363,10 @@ func (b Buffer) Type() string {
 	return "io.Buffer"
 }
 
+func (b Buffer) Size() int {
+	return b.Buf.Len()
+}
+
 func (b Buffer) Read(p []byte) (n int, err error) {
 	return b.Buf.Read(p)
 }
@@ -584,7 +588,7 @@ func (f *FileSystemObj) GetMethod(name string) dune.NativeMethod {
 }
 
 func (f *FileSystemObj) getWd(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	s, err := f.FS.Getwd()
+	s, err := f.checked(vm).Getwd()
 	if err != nil {
 		return dune.NullValue, err
 	}
@@ -637,7 +641,7 @@ func (f *FileSystemObj) rename(args []dune.Value, vm *dune.VM) (dune.Value, erro
 	source := args[0].String()
 	dest := args[1].String()
 
-	if err := f.FS.Rename(source, dest); err != nil {
+	if err := f.checked(vm).Rename(source, dest); err != nil {
 		if os.IsNotExist(err) {
 			return dune.NullValue, fmt.Errorf("rename %v to %v: %w", source, dest, err)
 		}
@@ -654,7 +658,7 @@ func (f *FileSystemObj) removeAll(args []dune.Value, vm *dune.VM) (dune.Value, e
 
 	name := args[0].String()
 
-	if err := f.FS.RemoveAll(name); err != nil {
+	if err := f.checked(vm).RemoveAll(name); err != nil {
 		return dune.NullValue, err
 	}
 
@@ -668,7 +672,7 @@ func (f *FileSystemObj) openIfExists(args []dune.Value, vm *dune.VM) (dune.Value
 
 	name := args[0].String()
 
-	fi, err := f.FS.Open(name)
+	fi, err := f.checked(vm).Open(name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return dune.NullValue, nil
@@ -676,7 +680,12 @@ func (f *FileSystemObj) openIfExists(args []dune.Value, vm *dune.VM) (dune.Value
 		return dune.NullValue, err
 	}
 
-	return dune.NewObject(newFile(fi, vm)), nil
+	file, err := newFile(fi, vm)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	return dune.NewObject(file), nil
 }
 
 func (f *FileSystemObj) open(args []dune.Value, vm *dune.VM) (dune.Value, error) {
@@ -686,7 +695,7 @@ func (f *FileSystemObj) open(args []dune.Value, vm *dune.VM) (dune.Value, error)
 
 	name := args[0].String()
 
-	fi, err := f.FS.Open(name)
+	fi, err := f.checked(vm).Open(name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return dune.NullValue, fmt.Errorf("error opening '%v': %w", name, err)
@@ -694,7 +703,12 @@ func (f *FileSystemObj) open(args []dune.Value, vm *dune.VM) (dune.Value, error)
 		return dune.NullValue, err
 	}
 
-	return dune.NewObject(newFile(fi, vm)), nil
+	file, err := newFile(fi, vm)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	return dune.NewObject(file), nil
 }
 
 func (f *FileSystemObj) openForWrite(args []dune.Value, vm *dune.VM) (dune.Value, error) {
@@ -704,12 +718,17 @@ func (f *FileSystemObj) openForWrite(args []dune.Value, vm *dune.VM) (dune.Value
 
 	name := args[0].String()
 
-	fi, err := f.FS.OpenForWrite(name)
+	fi, err := f.checked(vm).OpenForWrite(name)
 	if err != nil {
 		return dune.NullValue, err
 	}
 
-	return dune.NewObject(newFile(fi, vm)), nil
+	file, err := newFile(fi, vm)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	return dune.NewObject(file), nil
 }
 
 func (f *FileSystemObj) openForAppend(args []dune.Value, vm *dune.VM) (dune.Value, error) {
@@ -719,24 +738,34 @@ func (f *FileSystemObj) openForAppend(args []dune.Value, vm *dune.VM) (dune.Valu
 
 	name := args[0].String()
 
-	fi, err := f.FS.OpenForAppend(name)
+	fi, err := f.checked(vm).OpenForAppend(name)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	file, err := newFile(fi, vm)
 	if err != nil {
 		return dune.NullValue, err
 	}
 
-	return dune.NewObject(newFile(fi, vm)), nil
+	return dune.NewObject(file), nil
 }
 
-func newFile(fi filesystem.File, vm *dune.VM) *file {
-	f := &file{f: fi}
+func newFile(fi filesystem.File, vm *dune.VM) (*file, error) {
+	f := &file{f: fi, resources: vm.Resources}
+	if err := vm.Resources.Track(dune.ResourceFile, f); err != nil {
+		fi.Close()
+		return nil, err
+	}
 	vm.SetGlobalFinalizer(f)
-	return f
+	return f, nil
 }
 
 type file struct {
 	io.ReaderAt
-	f      filesystem.File
-	closed bool
+	f         filesystem.File
+	resources *dune.Resources
+	closed    bool
 }
 
 func (f *file) Type() string {
@@ -748,6 +777,9 @@ func (f *file) Close() error {
 		return nil
 	}
 	f.closed = true
+	if f.resources != nil {
+		f.resources.Untrack(f)
+	}
 	return f.f.Close()
 }
 
@@ -840,10 +872,7 @@ func (f *file) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	if len(args) != 0 {
 		return dune.NullValue, fmt.Errorf("no parameters expected")
 	}
-	if !f.closed {
-		f.closed = true
-		f.f.Close()
-	}
+	f.Close()
 	return dune.NullValue, nil
 }
 
@@ -853,7 +882,7 @@ func (f *FileSystemObj) abs(args []dune.Value, vm *dune.VM) (dune.Value, error)
 	}
 
 	path := args[0].String()
-	abs, err := f.FS.Abs(path)
+	abs, err := f.checked(vm).Abs(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return dune.NullValue, fmt.Errorf("error opening '%v': %w", path, err)
@@ -870,7 +899,8 @@ func (f *FileSystemObj) chdir(args []dune.Value, vm *dune.VM) (dune.Value, error
 	}
 
 	dir := args[0].String()
-	if err := f.FS.Chdir(dir); err != nil {
+
+	if err := f.checked(vm).Chdir(dir); err != nil {
 		if os.IsNotExist(err) {
 			return dune.NullValue, fmt.Errorf("error opening '%v': %w", dir, err)
 		}
@@ -887,7 +917,10 @@ func (f *FileSystemObj) exists(args []dune.Value, vm *dune.VM) (dune.Value, erro
 
 	name := args[0].String()
 
-	if _, err := f.FS.Stat(name); err != nil {
+	if _, err := f.checked(vm).Stat(name); err != nil {
+		if isCapabilityError(err) {
+			return dune.FalseValue, err
+		}
 		return dune.FalseValue, nil
 	}
 	return dune.TrueValue, nil
@@ -921,7 +954,7 @@ func (f *FileSystemObj) readNames(args []dune.Value, vm *dune.VM) (dune.Value, e
 		}
 	}
 
-	fis, err := ReadNames(f.FS, name, recursive)
+	fis, err := ReadNames(f.checked(vm), name, recursive)
 	if err != nil {
 		return dune.NullValue, err
 	}
@@ -986,7 +1019,7 @@ func (f *FileSystemObj) stat(args []dune.Value, vm *dune.VM) (dune.Value, error)
 
 	name := args[0].String()
 
-	fi, err := f.FS.Stat(name)
+	fi, err := f.checked(vm).Stat(name)
 	if err != nil {
 		// ignore errors. Just return null if is invalid
 		return dune.NullValue, nil
@@ -1007,7 +1040,7 @@ func (f *FileSystemObj) readDir(args []dune.Value, vm *dune.VM) (dune.Value, err
 		name = args[0].String()
 	}
 
-	file, err := f.FS.Open(name)
+	file, err := f.checked(vm).Open(name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return dune.NullValue, fmt.Errorf("error opening '%v': %w", name, err)
@@ -1036,7 +1069,7 @@ func (f *FileSystemObj) mkdir(args []dune.Value, vm *dune.VM) (dune.Value, error
 	}
 	name := args[0].String()
 
-	if err := f.FS.MkdirAll(name); err != nil {
+	if err := f.checked(vm).MkdirAll(name); err != nil {
 		return dune.NullValue, err
 	}
 
@@ -1052,7 +1085,7 @@ func (f *FileSystemObj) write(args []dune.Value, vm *dune.VM) (dune.Value, error
 	}
 	name := args[0].String()
 
-	file, err := f.FS.OpenForWrite(name)
+	file, err := f.checked(vm).OpenForWrite(name)
 	if err != nil {
 		return dune.NullValue, err
 	}
@@ -1080,7 +1113,7 @@ func (f *FileSystemObj) append(args []dune.Value, vm *dune.VM) (dune.Value, erro
 		return dune.NullValue, fmt.Errorf("expected argument 2 to be a string or byte array, got %s", args[1].TypeName())
 	}
 
-	f.FS.AppendPath(name, b.ToBytes())
+	f.checked(vm).AppendPath(name, b.ToBytes())
 	return dune.NullValue, nil
 }
 
@@ -1137,7 +1170,7 @@ func (f *FileSystemObj) read(ifExists bool, args []dune.Value, vm *dune.VM) ([]b
 	}
 	name := args[0].String()
 
-	file, err := f.FS.Open(name)
+	file, err := f.checked(vm).Open(name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			if ifExists {
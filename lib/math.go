@@ -3,15 +3,11 @@ package lib
 import (
 	"fmt"
 	"math"
-	"math/rand"
-	"time"
 
 	"github.com/dunelang/dune"
 )
 
 func init() {
-	rand.Seed(time.Now().UnixNano())
-
 	dune.RegisterLib(Math, `
 
 declare namespace math {
@@ -124,7 +120,7 @@ var Math = []dune.NativeFunction{
 			if err := ValidateArgs(args, dune.Int); err != nil {
 				return dune.NullValue, err
 			}
-			v := rand.Intn(int(args[0].ToInt()))
+			v := vm.Rand().Intn(int(args[0].ToInt()))
 			return dune.NewInt(v), nil
 		},
 	},
@@ -12,6 +12,10 @@ import (
 )
 
 func init() {
+	dune.RegisterReplayable("net.getIPAddress", "net.getMacAddress")
+	dune.RegisterNonReplayable("net.dial", "net.dialTCP", "net.dialTimeout",
+		"net.listen", "net.listenTCP", "net.resolveTCPAddr")
+
 	dune.RegisterLib(Net, `
 
 declare namespace net {
@@ -188,12 +192,19 @@ var Net = []dune.NativeFunction{
 				return dune.NullValue, fmt.Errorf("expected param 3 to be TCPAddr, got %s", args[1].TypeName())
 			}
 
+			if err := checkDial(vm, remoteAddr.addr.String()); err != nil {
+				return dune.NullValue, err
+			}
+
 			conn, err := net.DialTCP(network, localAddr, remoteAddr.addr)
 			if err != nil {
 				return dune.NullValue, err
 			}
 
-			tc := newTCPConn(conn, vm)
+			tc, err := newTCPConn(conn, vm)
+			if err != nil {
+				return dune.NullValue, err
+			}
 
 			return dune.NewObject(tc), nil
 		},
@@ -205,12 +216,20 @@ var Net = []dune.NativeFunction{
 			if err := ValidateArgs(args, dune.String, dune.String); err != nil {
 				return dune.NullValue, err
 			}
+			if err := checkDial(vm, args[1].String()); err != nil {
+				return dune.NullValue, err
+			}
 			conn, err := net.Dial(args[0].String(), args[1].String())
 			if err != nil {
 				return dune.NullValue, err
 			}
 
-			return dune.NewObject(newNetConn(conn, vm)), nil
+			c, err := newNetConn(conn, vm)
+			if err != nil {
+				return dune.NullValue, err
+			}
+
+			return dune.NewObject(c), nil
 		},
 	},
 	{
@@ -229,12 +248,21 @@ var Net = []dune.NativeFunction{
 				return dune.NullValue, err
 			}
 
+			if err := checkDial(vm, args[1].String()); err != nil {
+				return dune.NullValue, err
+			}
+
 			conn, err := net.DialTimeout(args[0].String(), args[1].String(), d)
 			if err != nil {
 				return dune.NullValue, err
 			}
 
-			return dune.NewObject(newNetConn(conn, vm)), nil
+			c, err := newNetConn(conn, vm)
+			if err != nil {
+				return dune.NullValue, err
+			}
+
+			return dune.NewObject(c), nil
 		},
 	},
 	{
@@ -324,14 +352,19 @@ var Net = []dune.NativeFunction{
 	},
 }
 
-func newNetConn(conn net.Conn, vm *dune.VM) netConn {
-	f := netConn{conn: conn}
+func newNetConn(conn net.Conn, vm *dune.VM) (netConn, error) {
+	f := netConn{conn: conn, resources: vm.Resources}
+	if err := vm.Resources.Track(dune.ResourceConnection, f); err != nil {
+		conn.Close()
+		return netConn{}, err
+	}
 	vm.SetGlobalFinalizer(f)
-	return f
+	return f, nil
 }
 
 type netConn struct {
-	conn net.Conn
+	conn      net.Conn
+	resources *dune.Resources
 }
 
 func (netConn) Type() string {
@@ -339,6 +372,7 @@ func (netConn) Type() string {
 }
 
 func (c netConn) Close() error {
+	c.resources.Untrack(c)
 	return c.conn.Close()
 }
 
@@ -448,7 +482,7 @@ func (c netConn) setReadDeadline(args []dune.Value, vm *dune.VM) (dune.Value, er
 }
 
 func (c netConn) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	c.conn.Close()
+	c.Close()
 	return dune.NullValue, nil
 }
 
@@ -559,14 +593,19 @@ func (a *tcpAddr) String() string {
 	return a.addr.String()
 }
 
-func newTCPConn(conn *net.TCPConn, vm *dune.VM) *tcpConn {
-	f := &tcpConn{conn: conn}
+func newTCPConn(conn *net.TCPConn, vm *dune.VM) (*tcpConn, error) {
+	f := &tcpConn{conn: conn, resources: vm.Resources}
+	if err := vm.Resources.Track(dune.ResourceConnection, f); err != nil {
+		conn.Close()
+		return nil, err
+	}
 	vm.SetGlobalFinalizer(f)
-	return f
+	return f, nil
 }
 
 type tcpConn struct {
-	conn *net.TCPConn
+	conn      *net.TCPConn
+	resources *dune.Resources
 }
 
 func (*tcpConn) Type() string {
@@ -574,6 +613,7 @@ func (*tcpConn) Type() string {
 }
 
 func (c *tcpConn) Close() error {
+	c.resources.Untrack(c)
 	return c.conn.Close()
 }
 
@@ -705,7 +745,7 @@ func (c *tcpConn) setReadDeadline(args []dune.Value, vm *dune.VM) (dune.Value, e
 }
 
 func (c *tcpConn) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	c.conn.Close()
+	c.Close()
 	return dune.NullValue, nil
 }
 
@@ -714,13 +754,18 @@ func newNetListener(network, port string, vm *dune.VM) (*netListener, error) {
 	if err != nil {
 		return nil, err
 	}
-	listener := &netListener{ls: ls}
+	listener := &netListener{ls: ls, resources: vm.Resources}
+	if err := vm.Resources.Track(dune.ResourceConnection, listener); err != nil {
+		ls.Close()
+		return nil, err
+	}
 	vm.SetGlobalFinalizer(listener)
 	return listener, nil
 }
 
 type netListener struct {
-	ls net.Listener
+	ls        net.Listener
+	resources *dune.Resources
 }
 
 func (netListener) Type() string {
@@ -728,6 +773,7 @@ func (netListener) Type() string {
 }
 
 func (c *netListener) Close() error {
+	c.resources.Untrack(c)
 	return c.ls.Close()
 }
 
@@ -751,7 +797,12 @@ func (c *netListener) accept(args []dune.Value, vm *dune.VM) (dune.Value, error)
 		return dune.NullValue, err
 	}
 
-	return dune.NewObject(newNetConn(conn, vm)), nil
+	nc, err := newNetConn(conn, vm)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	return dune.NewObject(nc), nil
 }
 
 func (c *netListener) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
@@ -759,7 +810,7 @@ func (c *netListener) close(args []dune.Value, vm *dune.VM) (dune.Value, error)
 		return dune.NullValue, err
 	}
 
-	err := c.ls.Close()
+	err := c.Close()
 	if err != nil {
 		return dune.NullValue, err
 	}
@@ -772,13 +823,18 @@ func newTCPListener(network string, localAddr *net.TCPAddr, vm *dune.VM) (*tcpLi
 	if err != nil {
 		return nil, err
 	}
-	listener := &tcpListener{ls: ls}
+	listener := &tcpListener{ls: ls, resources: vm.Resources}
+	if err := vm.Resources.Track(dune.ResourceConnection, listener); err != nil {
+		ls.Close()
+		return nil, err
+	}
 	vm.SetGlobalFinalizer(listener)
 	return listener, nil
 }
 
 type tcpListener struct {
-	ls *net.TCPListener
+	ls        *net.TCPListener
+	resources *dune.Resources
 }
 
 func (tcpListener) Type() string {
@@ -786,6 +842,7 @@ func (tcpListener) Type() string {
 }
 
 func (c *tcpListener) Close() error {
+	c.resources.Untrack(c)
 	return c.ls.Close()
 }
 
@@ -809,7 +866,12 @@ func (c *tcpListener) accept(args []dune.Value, vm *dune.VM) (dune.Value, error)
 		return dune.NullValue, err
 	}
 
-	return dune.NewObject(newTCPConn(conn, vm)), nil
+	tc, err := newTCPConn(conn, vm)
+	if err != nil {
+		return dune.NullValue, err
+	}
+
+	return dune.NewObject(tc), nil
 }
 
 func (c *tcpListener) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
@@ -817,7 +879,7 @@ func (c *tcpListener) close(args []dune.Value, vm *dune.VM) (dune.Value, error)
 		return dune.NullValue, err
 	}
 
-	err := c.ls.Close()
+	err := c.Close()
 	if err != nil {
 		return dune.NullValue, err
 	}
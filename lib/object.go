@@ -90,15 +90,7 @@ var libMap = []dune.NativeFunction{
 				}
 
 			case dune.Map:
-				m := a.ToMap()
-				m.RLock()
-				keys = make([]dune.Value, len(m.Map))
-				var i int
-				for k := range m.Map {
-					keys[i] = k
-					i++
-				}
-				m.RUnlock()
+				keys = vm.MapKeys(a.ToMap())
 
 			default:
 				return dune.NullValue, fmt.Errorf("invalid type %s", a.TypeName())
@@ -117,12 +109,13 @@ var libMap = []dune.NativeFunction{
 			}
 
 			m := a.ToMap()
+			keys := vm.MapKeys(m)
+			values := make([]dune.Value, 0, len(keys))
 			m.RLock()
-			values := make([]dune.Value, len(m.Map))
-			var i int
-			for k := range m.Map {
-				values[i] = m.Map[k]
-				i++
+			for _, k := range keys {
+				if v, ok := m.Map[k]; ok {
+					values = append(values, v)
+				}
 			}
 			m.RUnlock()
 			return dune.NewArrayValues(values), nil
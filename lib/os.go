@@ -13,6 +13,13 @@ import (
 )
 
 func init() {
+	dune.RegisterReplayable("os.hostName", "os.getWd", "os.exists", "os.exec",
+		"os.readAll", "os.readAllIfExists", "os.readString", "os.readStringIfExists",
+		"os.readLine", "os.getEnv", "os.readNames")
+
+	dune.RegisterNonReplayable("os.open", "os.openIfExists", "os.stat", "os.readDir",
+		"->os.stdin", "os.newCommand")
+
 	dune.RegisterLib(OS, `
 
 declare namespace os {
@@ -189,7 +196,7 @@ var OS = []dune.NativeFunction{
 	{
 		Name:        "os.exec",
 		Arguments:   -1,
-		Permissions: []string{"trusted"},
+		Permissions: []string{"exec"},
 		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
 			l := len(args)
 			if l == 0 {
@@ -201,6 +208,10 @@ var OS = []dune.NativeFunction{
 				values[i] = v.String()
 			}
 
+			if err := vm.CheckCapability("exec", values[0]); err != nil {
+				return dune.NullValue, err
+			}
+
 			cmd := exec.Command(values[0], values[1:]...)
 			cmd.Stderr = os.Stderr
 			cmd.Stdout = os.Stdout
@@ -215,7 +226,7 @@ var OS = []dune.NativeFunction{
 	{
 		Name:        "os.newCommand",
 		Arguments:   -1,
-		Permissions: []string{"trusted"},
+		Permissions: []string{"exec"},
 		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
 			l := len(args)
 			if l == 0 {
@@ -227,6 +238,10 @@ var OS = []dune.NativeFunction{
 				values[i] = v.String()
 			}
 
+			if err := vm.CheckCapability("exec", values[0]); err != nil {
+				return dune.NullValue, err
+			}
+
 			cmd := newCommand(values[0], values[1:]...)
 
 			return dune.NewObject(cmd), nil
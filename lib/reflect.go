@@ -55,7 +55,7 @@ var Reflect = []dune.NativeFunction{
 		Name:      "reflect.nativeFunctions",
 		Arguments: 0,
 		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
-			v := getNativeFuncions(false)
+			v := getNativeFuncions(false, vm)
 			return v, nil
 		},
 	},
@@ -63,7 +63,7 @@ var Reflect = []dune.NativeFunction{
 		Name:      "reflect.nativeProperties",
 		Arguments: 0,
 		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
-			v := getNativeFuncions(true)
+			v := getNativeFuncions(true, vm)
 			return v, nil
 		},
 	},
@@ -172,8 +172,8 @@ var Reflect = []dune.NativeFunction{
 	},
 }
 
-func getNativeFuncions(properties bool) dune.Value {
-	fns := dune.AllNativeFuncs()
+func getNativeFuncions(properties bool, vm *dune.VM) dune.Value {
+	fns := vm.NativeFuncs()
 
 	var v []dune.Value
 
@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+func init() {
+	dune.RegisterLib(REPLServer, `
+
+declare namespace runtime {
+    /**
+     * Accepts remote REPL sessions to evaluate code against the globals
+     * of the running program. Attach with "dune -attach addr -token token".
+     *
+     * @param addr a tcp address like "127.0.0.1:9000" or "unix:/path/to/socket"
+     * @param token the secret that clients must send to be accepted
+     */
+    export function listenREPL(addr: string, token: string): REPLServer
+
+    export interface REPLServer {
+        readonly addr: string
+        close(): void
+    }
+}
+`)
+}
+
+var REPLServer = []dune.NativeFunction{
+	{
+		Name:        "runtime.listenREPL",
+		Arguments:   2,
+		Permissions: []string{"trusted"},
+		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			if err := ValidateArgs(args, dune.String, dune.String); err != nil {
+				return dune.NullValue, err
+			}
+
+			s, err := listenREPL(args[0].String(), args[1].String(), vm)
+			if err != nil {
+				return dune.NullValue, err
+			}
+
+			return dune.NewObject(s), nil
+		},
+	},
+}
+
+// replRequest is a line sent by the client after the token.
+type replRequest struct {
+	Code string `json:"code"`
+}
+
+// replResponse is the line sent back for each request.
+type replResponse struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type replServer struct {
+	listener net.Listener
+	token    string
+	vm       *dune.VM
+}
+
+func listenREPL(addr, token string, vm *dune.VM) (*replServer, error) {
+	if token == "" {
+		return nil, fmt.Errorf("a token is required")
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network = "unix"
+		addr = strings.TrimPrefix(addr, "unix:")
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &replServer{listener: l, token: token, vm: vm}
+	go s.serve()
+	return s, nil
+}
+
+func (s *replServer) Type() string {
+	return "runtime.REPLServer"
+}
+
+func (s *replServer) GetField(name string, vm *dune.VM) (dune.Value, error) {
+	switch name {
+	case "addr":
+		return dune.NewString(s.listener.Addr().String()), nil
+	}
+	return dune.UndefinedValue, nil
+}
+
+func (s *replServer) GetMethod(name string) dune.NativeMethod {
+	switch name {
+	case "close":
+		return s.close
+	}
+	return nil
+}
+
+func (s *replServer) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+	return dune.NullValue, s.listener.Close()
+}
+
+func (s *replServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle runs a session. The client sends first the token in a line and
+// then JSON requests, one per line, that are answered with a JSON response.
+func (s *replServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	enc := json.NewEncoder(conn)
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	token, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.token)) != 1 {
+		enc.Encode(replResponse{Error: "unauthorized"})
+		return
+	}
+
+	if err := enc.Encode(replResponse{Output: fmt.Sprintf("connected to process %d", os.Getpid())}); err != nil {
+		return
+	}
+
+	var out bytes.Buffer
+	session := s.vm.CloneInitialized(s.vm.Program, s.vm.Globals())
+	session.Stdout = &out
+	session.Stderr = &out
+
+	dec := json.NewDecoder(r)
+	for {
+		var req replRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+
+		resp := s.eval(session, req.Code)
+		if out.Len() > 0 {
+			resp.Output = out.String() + resp.Output
+			out.Reset()
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *replServer) eval(session *dune.VM, code string) replResponse {
+	switch strings.TrimSpace(code) {
+	case ":globals":
+		return replResponse{Output: replGlobals(session)}
+	case ":vms":
+		return replResponse{Output: replVMs()}
+	case ":caches":
+		return replResponse{Output: replCaches(session)}
+	}
+
+	v, err := dune.EvalShared(session, code)
+	if err != nil {
+		return replResponse{Error: err.Error()}
+	}
+
+	if v.Type == dune.Undefined {
+		return replResponse{}
+	}
+
+	return replResponse{Output: replFormat(v)}
+}
+
+func replFormat(v dune.Value) string {
+	switch v.Type {
+	case dune.String, dune.Int, dune.Float, dune.Bool, dune.Null, dune.Rune:
+		return v.String()
+	}
+
+	b, err := json.MarshalIndent(v.ExportMarshal(0), "", "    ")
+	if err != nil {
+		return v.String()
+	}
+	return string(b)
+}
+
+func replGlobals(vm *dune.VM) string {
+	var b strings.Builder
+	for _, v := range vm.GlobalVariables() {
+		fmt.Fprintf(&b, "%s: %s\n", v.Name, v.Value.TypeName())
+	}
+	return b.String()
+}
+
+func replVMs() string {
+	var b strings.Builder
+	for _, v := range runningAsync() {
+		fmt.Fprintf(&b, "#%d %s running %v, %d steps\n", v.id, v.function,
+			time.Since(v.started).Round(time.Millisecond), v.vm.Steps())
+	}
+	return b.String()
+}
+
+// replCaches lists the caches referenced by the globals or their fields.
+func replCaches(vm *dune.VM) string {
+	var lines []string
+
+	add := func(name string, v dune.Value) {
+		if c, ok := v.ToObjectOrNil().(*cacheObj); ok {
+			lines = append(lines, fmt.Sprintf("%s: %d items", name, c.cache.ItemCount()))
+		}
+	}
+
+	for _, g := range vm.GlobalVariables() {
+		add(g.Name, g.Value)
+
+		if g.Value.Type == dune.Map {
+			m := g.Value.ToMap()
+			m.RLock()
+			for k, v := range m.Map {
+				add(g.Name+"."+k.String(), v)
+			}
+			m.RUnlock()
+		}
+	}
+
+	sort.Strings(lines)
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
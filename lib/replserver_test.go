@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/dunelang/dune"
+)
+
+func TestREPLServer(t *testing.T) {
+	vm, err := runExpr(t, `
+		let counter = 5
+		let server = runtime.listenREPL("127.0.0.1:0", "secret")
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, _ := vm.RegisterValue("server")
+	s := server.ToObject().(*replServer)
+	defer s.listener.Close()
+
+	addr := s.listener.Addr().String()
+
+	resp, _ := replSession(t, addr, "wrong")
+	if resp.Error != "unauthorized" {
+		t.Fatalf("%+v", resp)
+	}
+
+	resp, send := replSession(t, addr, "secret")
+	if resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+
+	if resp = send("counter + 1"); resp.Output != "6" {
+		t.Fatalf("%+v", resp)
+	}
+
+	if resp = send("counter = 10"); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+
+	if v, _ := vm.RegisterValue("counter"); v != dune.NewValue(10) {
+		t.Fatalf("expected 10, got %v", v)
+	}
+
+	if resp = send(":globals"); resp.Output != "counter: int\nserver: runtime.REPLServer\n" {
+		t.Fatalf("%q", resp.Output)
+	}
+
+	if resp = send("foo("); resp.Error == "" {
+		t.Fatal("expected an error")
+	}
+}
+
+// replSession connects to the server and returns the greeting and
+// a function to send code.
+func replSession(t *testing.T, addr, token string) (replResponse, func(string) replResponse) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fmt.Fprintln(conn, token)
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+
+	var greeting replResponse
+	if err := dec.Decode(&greeting); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(code string) replResponse {
+		if err := json.NewEncoder(conn).Encode(replRequest{Code: code}); err != nil {
+			t.Fatal(err)
+		}
+		var resp replResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	return greeting, send
+}
+
+func TestREPLVMsSteps(t *testing.T) {
+	p, err := dune.CompileStr(`
+		function main() {
+			let wg = sync.newWaitGroup()
+			wg.go(() => {
+				let n = 0
+				for (let i = 0; i < 20000; i++) {
+					n += i
+				}
+			})
+			wg.wait()
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.AddPermission("trusted")
+
+	vm := dune.NewVM(p)
+	vm.MaxSteps = 1000000
+
+	done := make(chan error)
+	go func() {
+		_, err := vm.Run()
+		done <- err
+	}()
+
+	// list the steps of the goroutine while it runs
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		default:
+			replVMs()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dunelang/dune"
+)
+
+func TestResourceLimits(t *testing.T) {
+	p, err := dune.CompileStr(`
+		function main() {
+			let fs = io.newVirtualFS()
+			fs.write("a.txt", "a")
+			let f1 = fs.open("a.txt")
+			let f2 = fs.open("a.txt")
+			let err
+			try {
+				fs.open("a.txt")
+			} catch (e) {
+				err = e.message
+			}
+			f1.close()
+			let f3 = fs.open("a.txt")
+
+			time.newTicker(time.Hour, () => {})
+			go(() => { while (true) {} })
+
+			return err
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.AddPermission("trusted")
+
+	vm := dune.NewVM(p)
+	vm.Resources.SetLimit(dune.ResourceFile, 2)
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.String() != "quota exceeded: limit of 2 files" {
+		t.Fatal(v)
+	}
+
+	// the files are closed by the finalizers of the run
+	if n := vm.Resources.Count(dune.ResourceFile); n != 0 {
+		t.Fatalf("expected 0 files, got %d", n)
+	}
+
+	if n := vm.Resources.Count(dune.ResourceTimer); n != 1 {
+		t.Fatalf("expected 1 timer, got %d", n)
+	}
+
+	if n := vm.Resources.Count(dune.ResourceGoroutine); n != 1 {
+		t.Fatalf("expected 1 goroutine, got %d", n)
+	}
+
+	if err := vm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && vm.Resources.Count(dune.ResourceGoroutine) > 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if n := vm.Resources.Count(dune.ResourceGoroutine); n != 0 {
+		t.Fatalf("the goroutine is still running")
+	}
+}
+
+func TestSandboxResourceLimits(t *testing.T) {
+	v := runTest(t, `
+		function main() {
+			let vm = runtime.newVM(runtime.vm.program)
+			vm.maxGoroutines = 1
+			try {
+				vm.runFunc("spawn")
+			} catch (e) {
+				return vm.maxGoroutines + " " + e.message
+			} finally {
+				vm.close()
+			}
+		}
+
+		function spawn() {
+			go(() => time.sleep(50 * time.Millisecond))
+			go(() => time.sleep(50 * time.Millisecond))
+		}
+	`)
+
+	if v.String() != "1 quota exceeded: limit of 1 goroutines" {
+		t.Fatal(v)
+	}
+}
+
+func TestTimerReleasedWhenFired(t *testing.T) {
+	p, err := dune.CompileStr(`
+		function main() {
+			let fired = sync.newChannel(3)
+			let a = time.newTimer(time.Millisecond, () => fired.send(1))
+			time.newTimer(time.Millisecond, () => fired.send(2))
+			fired.receive()
+			fired.receive()
+
+			// the fired timers don't count
+			time.newTimer(time.Hour, () => {})
+
+			a.reset(time.Millisecond)
+			return fired.receive()
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.AddPermission("trusted")
+
+	vm := dune.NewVM(p)
+	vm.Resources.SetLimit(dune.ResourceTimer, 2)
+	defer vm.Close()
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.ToInt() != 1 {
+		t.Fatalf("expected the first timer, got %v", v)
+	}
+
+	if n := vm.Resources.Count(dune.ResourceTimer); n != 1 {
+		t.Fatalf("expected 1 timer, got %d", n)
+	}
+}
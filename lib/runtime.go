@@ -117,6 +117,14 @@ declare namespace runtime {
 		maxAllocations: number
 		maxFrames: number
 		maxSteps: number
+		/**
+		 * the maximum number of files, connections, timers and goroutines
+		 * open at the same time. Zero means no limit.
+		 */
+		maxFiles: number
+		maxConnections: number
+		maxTimers: number
+		maxGoroutines: number
 		fileSystem: io.FileSystem
 		stdout: io.Writer
 		localizer: locale.Localizer
@@ -137,6 +145,10 @@ declare namespace runtime {
 		stackTrace(): string
 		clone(): VirtualMachine
 		resetSteps(): void
+		/**
+		 * stops the vm and its goroutines and closes everything that they opened.
+		 */
+		close(): void
 	}
 }
 `)
@@ -400,6 +412,7 @@ var Runtime = []dune.NativeFunction{
 			m.MaxAllocations = vm.MaxAllocations
 			m.MaxFrames = vm.MaxFrames
 			m.MaxSteps = vm.MaxSteps
+			copyResourceLimits(m, vm)
 
 			if err := m.AddSteps(vm.Steps()); err != nil {
 				return dune.NullValue, err
@@ -479,11 +492,14 @@ func (f finalizable) Close() error {
 
 	case dune.NativeFunc:
 		i := v.ToNativeFunction()
-		f := dune.NativeFuncFromIndex(i)
+		f, err := vm.NativeFunc(i)
+		if err != nil {
+			return err
+		}
 		if f.Arguments != 0 {
 			return fmt.Errorf("function '%s' expects %d parameters", f.Name, f.Arguments)
 		}
-		_, err := f.Function(dune.NullValue, nil, vm)
+		_, err = f.Function(dune.NullValue, nil, vm)
 		return err
 
 	case dune.Func:
@@ -974,6 +990,8 @@ func (m *libVM) GetField(name string, vm *dune.VM) (dune.Value, error) {
 		return dune.NewInt(m.vm.MaxFrames), nil
 	case "maxSteps":
 		return dune.NewInt64(m.vm.MaxSteps), nil
+	case "maxFiles", "maxConnections", "maxTimers", "maxGoroutines":
+		return dune.NewInt(m.vm.Resources.Limit(resourceKinds[name])), nil
 	case "steps":
 		return dune.NewInt64(m.vm.Steps()), nil
 	case "now":
@@ -1083,6 +1101,13 @@ func (m *libVM) SetField(name string, v dune.Value, vm *dune.VM) error {
 		}
 		m.vm.MaxSteps = v.ToInt()
 		return nil
+
+	case "maxFiles", "maxConnections", "maxTimers", "maxGoroutines":
+		if v.Type != dune.Int {
+			return ErrInvalidType
+		}
+		m.vm.Resources.SetLimit(resourceKinds[name], int(v.ToInt()))
+		return nil
 	}
 
 	return ErrReadOnlyOrUndefined
@@ -1106,6 +1131,8 @@ func (m *libVM) GetMethod(name string) dune.NativeMethod {
 		return m.stackTrace
 	case "resetSteps":
 		return m.resetSteps
+	case "close":
+		return m.close
 	}
 	return nil
 }
@@ -1120,9 +1147,42 @@ func (m *libVM) clone(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	}
 
 	c := m.vm.CloneInitialized(m.vm.Program, m.vm.Globals())
+	copyResourceLimits(c, m.vm)
 	return dune.NewObject(&libVM{c}), nil
 }
 
+func (m *libVM) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+	if !vm.HasPermission("trusted") {
+		return dune.NullValue, ErrUnauthorized
+	}
+
+	if err := ValidateArgs(args); err != nil {
+		return dune.NullValue, err
+	}
+
+	if err := m.vm.Close(); err != nil {
+		return dune.NullValue, err
+	}
+
+	return dune.NullValue, nil
+}
+
+// resourceKinds maps the limit properties of a vm to the resources.
+var resourceKinds = map[string]string{
+	"maxFiles":       dune.ResourceFile,
+	"maxConnections": dune.ResourceConnection,
+	"maxTimers":      dune.ResourceTimer,
+	"maxGoroutines":  dune.ResourceGoroutine,
+}
+
+// copyResourceLimits applies the limits of the vm to the new one like
+// the step and allocation limits.
+func copyResourceLimits(m, vm *dune.VM) {
+	for kind, n := range vm.Resources.Limits() {
+		m.Resources.SetLimit(kind, n)
+	}
+}
+
 func (m *libVM) resetSteps(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	if !vm.HasPermission("trusted") {
 		return dune.NullValue, ErrUnauthorized
@@ -15,6 +15,8 @@ import (
 )
 
 func init() {
+	dune.RegisterNonReplayable("sql.open")
+
 	dune.RegisterLib(SQL, `
 
 
@@ -337,6 +337,10 @@ var Strings = []dune.NativeFunction{
 			a := args[0].String()
 			b := int(args[1].ToInt())
 
+			if err := vm.AddAllocations(len(a) * b); err != nil {
+				return dune.NullValue, err
+			}
+
 			values := make([]string, b)
 
 			for i := 0; i < b; i++ {
@@ -737,6 +741,9 @@ var Strings = []dune.NativeFunction{
 				return dune.NullValue, fmt.Errorf("invalid pad size. Must be one character")
 			}
 			total := int(args[1].ToInt())
+			if err := vm.AddAllocations(total); err != nil {
+				return dune.NullValue, err
+			}
 			s := this.String()
 			return dune.NewString(rightPad(s, rune(pad[0]), total)), nil
 		},
@@ -757,6 +764,9 @@ var Strings = []dune.NativeFunction{
 				return dune.NullValue, fmt.Errorf("invalid pad size. Must be one character")
 			}
 			total := int(args[1].ToInt())
+			if err := vm.AddAllocations(total); err != nil {
+				return dune.NullValue, err
+			}
 			s := this.String()
 			return dune.NewString(leftPad(s, rune(pad[0]), total)), nil
 		},
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dunelang/dune"
@@ -70,7 +71,7 @@ var Sync = []dune.NativeFunction{
 
 			err = WithDeadline(d, func(dl *Deadline) error {
 				obj := dune.NewObject(dl)
-				return runAsyncFuncOrClosure(vm, v, obj)
+				return runAsyncFuncOrClosure(vm, nil, v, obj)
 			})
 
 			return dune.NullValue, err
@@ -104,16 +105,19 @@ var Sync = []dune.NativeFunction{
 				return dune.NullValue, err
 			}
 
-			var ch chan dune.Value
 			var b int
 			if len(args) > 0 {
 				b = int(args[0].ToInt())
-				ch = make(chan dune.Value, b)
+			}
+
+			c := &channel{buffer: b}
+			if b == 0 && vm.Deterministic() {
+				c.c = make(chan dune.Value, 1)
+				c.handoff = true
 			} else {
-				ch = make(chan dune.Value)
+				c.c = make(chan dune.Value, b)
 			}
 
-			c := &channel{buffer: b, c: ch}
 			return dune.NewObject(c), nil
 		},
 	},
@@ -132,28 +136,51 @@ var Sync = []dune.NativeFunction{
 				return dune.NullValue, fmt.Errorf("expected arg 1 to be an array of channels, got %s", a.TypeName())
 			}
 
-			chans := a.ToArray()
-			l := len(chans)
+			values := a.ToArray()
+			l := len(values)
+			chans := make([]*channel, l)
 			cases := make([]reflect.SelectCase, l)
-			for i, c := range chans {
-				ch := c.ToObjectOrNil().(*channel)
+			for i, c := range values {
+				ch, _ := c.ToObjectOrNil().(*channel)
 				if ch == nil {
 					return dune.NullValue, fmt.Errorf("invalid channel at index %d", i)
 				}
+				chans[i] = ch
 				cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.c)}
 			}
 
+			var defaultCase bool
 			if argLen == 2 {
 				b := args[1]
 				if b.Type != dune.Bool {
 					return dune.NullValue, fmt.Errorf("expected arg 2 to be a bool, got %s", b.TypeName())
 				}
-				if b.ToBool() {
-					cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+				defaultCase = b.ToBool()
+			}
+
+			var i int
+			var value reflect.Value
+			var ok bool
+
+			if defaultCase || vm.Deterministic() {
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+			}
+
+			if vm.Deterministic() && !defaultCase {
+				err := vm.Wait(func() bool {
+					i, value, ok = reflect.Select(cases)
+					return i < l
+				})
+				if err != nil {
+					return dune.NullValue, err
 				}
+			} else {
+				i, value, ok = reflect.Select(cases)
 			}
 
-			i, value, ok := reflect.Select(cases)
+			if i < l && ok {
+				chans[i].received()
+			}
 
 			m := make(map[dune.Value]dune.Value, 3)
 			m[dune.NewString("index")] = dune.NewInt(i)
@@ -173,7 +200,7 @@ var Sync = []dune.NativeFunction{
 		Arguments:   0,
 		Permissions: []string{"sync"},
 		Function: func(this dune.Value, args []dune.Value, vm *dune.VM) (dune.Value, error) {
-			m := &mutex{mutex: &sync.Mutex{}}
+			m := &mutex{c: make(chan struct{}, 1)}
 			return dune.NewObject(m), nil
 		},
 	},
@@ -195,8 +222,10 @@ var Sync = []dune.NativeFunction{
 
 			m := globalKeyMutex.getMutex(keyVal.String())
 
-			m.Lock()
-			defer m.Unlock()
+			if err := m.lock(vm); err != nil {
+				return dune.NullValue, err
+			}
+			defer m.unlock()
 
 			var retVal dune.Value
 			var err error
@@ -230,27 +259,29 @@ var Sync = []dune.NativeFunction{
 var globalKeyMutex = newKeyMutex()
 
 func newKeyMutex() *keyMutex {
-	return &keyMutex{mutexes: make(map[string]*sync.Mutex)}
+	return &keyMutex{mutexes: make(map[string]*mutex)}
 }
 
 type keyMutex struct {
 	sync.RWMutex
-	mutexes map[string]*sync.Mutex
+	mutexes map[string]*mutex
 }
 
-func (m *keyMutex) getMutex(key string) *sync.Mutex {
+func (m *keyMutex) getMutex(key string) *mutex {
 	m.Lock()
 	v, ok := m.mutexes[key]
 	if !ok {
-		v = &sync.Mutex{}
+		v = &mutex{c: make(chan struct{}, 1)}
 		m.mutexes[key] = v
 	}
 	m.Unlock()
 	return v
 }
 
+// mutex is a channel with room for one value so a deterministic
+// vm can try to lock it and let other goroutines run if it can't.
 type mutex struct {
-	mutex *sync.Mutex
+	c chan struct{}
 }
 
 func (mutex) Type() string {
@@ -260,26 +291,54 @@ func (mutex) Type() string {
 func (m *mutex) GetMethod(name string) dune.NativeMethod {
 	switch name {
 	case "lock":
-		return m.lock
+		return func(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			return dune.NullValue, m.lock(vm)
+		}
 	case "unlock":
-		return m.unlock
+		return func(args []dune.Value, vm *dune.VM) (dune.Value, error) {
+			return dune.NullValue, m.unlock()
+		}
 	}
 	return nil
 }
 
-func (m *mutex) lock(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	m.mutex.Lock()
-	return dune.NullValue, nil
+func (m *mutex) lock(vm *dune.VM) error {
+	if !vm.Deterministic() {
+		m.c <- struct{}{}
+		return nil
+	}
+
+	return vm.Wait(func() bool {
+		select {
+		case m.c <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	})
 }
 
-func (m *mutex) unlock(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	m.mutex.Unlock()
-	return dune.NullValue, nil
+func (m *mutex) unlock() error {
+	select {
+	case <-m.c:
+		return nil
+	default:
+		return fmt.Errorf("unlock of unlocked mutex")
+	}
 }
 
 type channel struct {
+	// sends and receives count the values of handoff channels.
+	sends    int64
+	receives int64
+
 	buffer int
 	c      chan dune.Value
+
+	// handoff channels are the unbuffered channels of a deterministic vm.
+	// They have room for one value and the sender waits until it is
+	// received so a vm can try to send or receive without blocking.
+	handoff bool
 }
 
 func (c *channel) Type() string {
@@ -306,16 +365,60 @@ func (c *channel) send(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	if len(args) != 1 {
 		return dune.NullValue, fmt.Errorf("expected 1 arg")
 	}
-	c.c <- args[0]
-	return dune.NullValue, nil
+
+	if !vm.Deterministic() {
+		c.c <- args[0]
+		return dune.NullValue, nil
+	}
+
+	var n int64
+	err := vm.Wait(func() bool {
+		select {
+		case c.c <- args[0]:
+			n = atomic.AddInt64(&c.sends, 1)
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil || !c.handoff {
+		return dune.NullValue, err
+	}
+
+	err = vm.Wait(func() bool {
+		return atomic.LoadInt64(&c.receives) >= n
+	})
+	return dune.NullValue, err
 }
 
 func (c *channel) receive(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	if len(args) != 0 {
 		return dune.NullValue, fmt.Errorf("expected 0 args")
 	}
-	v := <-c.c
-	return v, nil
+
+	if !vm.Deterministic() {
+		v := <-c.c
+		return v, nil
+	}
+
+	var v dune.Value
+	err := vm.Wait(func() bool {
+		var ok bool
+		select {
+		case v, ok = <-c.c:
+			if ok {
+				c.received()
+			}
+			return true
+		default:
+			return false
+		}
+	})
+	return v, err
+}
+
+func (c *channel) received() {
+	atomic.AddInt64(&c.receives, 1)
 }
 
 func (c *channel) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
@@ -327,8 +430,14 @@ func (c *channel) close(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 }
 
 type waitGroup struct {
-	w     *sync.WaitGroup
-	limit chan bool
+	running int64
+	w       *sync.WaitGroup
+	limit   chan bool
+}
+
+func (t *waitGroup) add(delta int) {
+	atomic.AddInt64(&t.running, int64(delta))
+	t.w.Add(delta)
 }
 
 func (t *waitGroup) Type() string {
@@ -355,10 +464,29 @@ func (t *waitGroup) goRun(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	}
 
 	if t.limit != nil {
-		t.limit <- true
+		if vm.Deterministic() {
+			err := vm.Wait(func() bool {
+				select {
+				case t.limit <- true:
+					return true
+				default:
+					return false
+				}
+			})
+			if err != nil {
+				return dune.NullValue, err
+			}
+		} else {
+			t.limit <- true
+		}
 	}
 
-	return launchGoroutine(args, vm, t)
+	v, err := launchGoroutine(args, vm, t)
+	if err != nil && t.limit != nil {
+		// it was not launched
+		<-t.limit
+	}
+	return v, err
 }
 
 func (t *waitGroup) wait(args []dune.Value, vm *dune.VM) (dune.Value, error) {
@@ -366,6 +494,13 @@ func (t *waitGroup) wait(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 		return dune.NullValue, fmt.Errorf("expected 0 arguments, got %d", len(args))
 	}
 
+	if vm.Deterministic() {
+		err := vm.Wait(func() bool {
+			return atomic.LoadInt64(&t.running) == 0
+		})
+		return dune.NullValue, err
+	}
+
 	t.w.Wait()
 	return dune.NullValue, nil
 }
@@ -152,7 +152,7 @@ var Templates = []dune.NativeFunction{
 				return dune.NullValue, fmt.Errorf("expected one or two arguments, got %d", l)
 			}
 
-			buf, err := readFile(path, fs, vm)
+			buf, err := readFile(path, checkedFS(vm, fs), vm)
 			if err != nil {
 				if os.IsNotExist(err) {
 					return dune.NullValue, nil
@@ -288,6 +288,8 @@ func writeHTML(html bool, this dune.Value, args []dune.Value, vm *dune.VM) (dune
 		return dune.NullValue, fmt.Errorf("there is no filesystem")
 	}
 
+	fs = checkedFS(vm, fs)
+
 	src, err := filesystem.ReadAll(fs, vPath.String())
 	if err != nil {
 		return dune.NullValue, err
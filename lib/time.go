@@ -6,12 +6,41 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dunelang/dune"
 )
 
 func init() {
+	dune.RegisterConverter(time.Time{}, &dune.Converter{
+		TypeDef: "time.Time",
+		ToValue: func(v interface{}) dune.Value {
+			return dune.NewObject(TimeObj(v.(time.Time)))
+		},
+		FromValue: func(v dune.Value) (interface{}, error) {
+			switch v.Type {
+			case dune.Object:
+				if t, ok := v.ToObject().(TimeObj); ok {
+					return time.Time(t), nil
+				}
+			case dune.String:
+				return time.Parse(time.RFC3339, v.String())
+			}
+			return nil, fmt.Errorf("expected time.Time, got %s", v.TypeName())
+		},
+	})
+
+	dune.RegisterConverter(time.Duration(0), &dune.Converter{
+		TypeDef: "time.Duration",
+		ToValue: func(v interface{}) dune.Value {
+			return dune.NewObject(Duration(v.(time.Duration)))
+		},
+		FromValue: func(v dune.Value) (interface{}, error) {
+			return ToDuration(v)
+		},
+	})
+
 	dune.RegisterLib(Time, `
 
 declare namespace time {
@@ -704,17 +733,12 @@ var Time = []dune.NativeFunction{
 				return dune.NullValue, fmt.Errorf("%v is not a function", v.TypeName())
 			}
 
-			ticker := time.NewTicker(d)
-
-			go func() {
-				for range ticker.C {
-					if err := runAsyncFuncOrClosure(vm, v); err != nil {
-						fmt.Fprintln(vm.GetStderr(), err)
-					}
-				}
-			}()
+			t, err := newTickerObj(d, v, vm)
+			if err != nil {
+				return dune.NullValue, err
+			}
 
-			return dune.NewObject(&tickerObj{ticker}), nil
+			return dune.NewObject(t), nil
 		},
 	},
 	{
@@ -735,17 +759,15 @@ var Time = []dune.NativeFunction{
 				return dune.NullValue, fmt.Errorf("%v is not a function", v.TypeName())
 			}
 
-			timer := time.NewTimer(d)
-
-			go func() {
-				for range timer.C {
-					if err := runAsyncFuncOrClosure(vm, v); err != nil {
-						fmt.Fprintln(vm.GetStderr(), err)
-					}
-				}
-			}()
+			t := &timerObj{fn: v, vm: vm, origin: vm.SpawnTrace()}
+			t.mu.Lock()
+			err = t.start(d)
+			t.mu.Unlock()
+			if err != nil {
+				return dune.NullValue, err
+			}
 
-			return dune.NewObject(&timerObj{timer}), nil
+			return dune.NewObject(t), nil
 		},
 	},
 	{
@@ -934,6 +956,11 @@ func (t TimeObj) Size() int {
 	return 1
 }
 
+// CloneValue allows passing times to other isolates.
+func (t TimeObj) CloneValue() dune.Value {
+	return dune.NewObject(t)
+}
+
 func (t TimeObj) String() string {
 	return time.Time(t).Format(time.RFC3339)
 }
@@ -1594,6 +1621,10 @@ func (t Duration) Type() string {
 	return "duration"
 }
 
+func (t Duration) CloneValue() dune.Value {
+	return dune.NewObject(t)
+}
+
 func (t Duration) Size() int {
 	return 1
 }
@@ -1827,6 +1858,10 @@ func (l location) Type() string {
 	return "time.Location"
 }
 
+func (l location) CloneValue() dune.Value {
+	return dune.NewObject(l)
+}
+
 func (l location) GetField(name string, vm *dune.VM) (dune.Value, error) {
 	switch name {
 	case "name":
@@ -1857,8 +1892,15 @@ func ToDuration(v dune.Value) (time.Duration, error) {
 	}
 }
 
+// timerObj runs the function when the timer fires. Once it has fired
+// or it is stopped it doesn't count as a resource until it is reset.
 type timerObj struct {
-	timer *time.Timer
+	mu     sync.Mutex
+	timer  *time.Timer
+	done   chan struct{}
+	fn     dune.Value
+	vm     *dune.VM
+	origin []dune.TraceLine
 }
 
 func (t *timerObj) Type() string {
@@ -1879,6 +1921,55 @@ func (t *timerObj) GetMethod(name string) dune.NativeMethod {
 	return nil
 }
 
+// start must be called with the lock held.
+func (t *timerObj) start(d time.Duration) error {
+	if err := t.vm.Resources.Track(dune.ResourceTimer, t); err != nil {
+		return err
+	}
+
+	t.timer = time.NewTimer(d)
+	t.done = make(chan struct{})
+
+	go func(timer *time.Timer, done chan struct{}) {
+		select {
+		case <-timer.C:
+			// it has fired so it is released unless it has been
+			// reset in the meantime and it is another timer now.
+			t.mu.Lock()
+			if t.done == done {
+				t.close()
+			}
+			t.mu.Unlock()
+
+			if err := runAsyncFuncOrClosure(t.vm, t.origin, t.fn); err != nil {
+				fmt.Fprintln(t.vm.GetStderr(), err)
+			}
+		case <-done:
+		}
+	}(t.timer, t.done)
+
+	return nil
+}
+
+func (t *timerObj) Close() error {
+	t.mu.Lock()
+	t.close()
+	t.mu.Unlock()
+	return nil
+}
+
+// close must be called with the lock held.
+func (t *timerObj) close() {
+	if t.done == nil {
+		return
+	}
+
+	t.timer.Stop()
+	close(t.done)
+	t.done = nil
+	t.vm.Resources.Untrack(t)
+}
+
 func (t *timerObj) reset(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 	if len(args) != 1 {
 		return dune.NullValue, fmt.Errorf("expected 1 arg, got 0")
@@ -1889,17 +1980,61 @@ func (t *timerObj) reset(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 		return dune.NullValue, fmt.Errorf("expected time.Duration, got: %s", args[0].TypeName())
 	}
 
-	t.timer.Reset(d)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done != nil && t.timer.Stop() {
+		t.timer.Reset(d)
+		return dune.NullValue, nil
+	}
+
+	// it has been stopped or it has fired
+	t.close()
+
+	if err := t.start(d); err != nil {
+		return dune.NullValue, err
+	}
+
 	return dune.NullValue, nil
 }
 
 func (t *timerObj) stop(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	t.timer.Stop()
+	t.Close()
 	return dune.NullValue, nil
 }
 
+// tickerObj runs the function on each tick until it
+// is stopped or the vm is closed.
 type tickerObj struct {
-	ticker *time.Ticker
+	ticker    *time.Ticker
+	done      chan struct{}
+	once      sync.Once
+	resources *dune.Resources
+}
+
+func newTickerObj(d time.Duration, fn dune.Value, vm *dune.VM) (*tickerObj, error) {
+	t := &tickerObj{done: make(chan struct{}), resources: vm.Resources}
+	if err := vm.Resources.Track(dune.ResourceTimer, t); err != nil {
+		return nil, err
+	}
+
+	t.ticker = time.NewTicker(d)
+	origin := vm.SpawnTrace()
+
+	go func() {
+		for {
+			select {
+			case <-t.ticker.C:
+				if err := runAsyncFuncOrClosure(vm, origin, fn); err != nil {
+					fmt.Fprintln(vm.GetStderr(), err)
+				}
+			case <-t.done:
+				return
+			}
+		}
+	}()
+
+	return t, nil
 }
 
 func (t *tickerObj) Type() string {
@@ -1910,6 +2045,15 @@ func (t *tickerObj) Size() int {
 	return 1
 }
 
+func (t *tickerObj) Close() error {
+	t.once.Do(func() {
+		t.ticker.Stop()
+		close(t.done)
+		t.resources.Untrack(t)
+	})
+	return nil
+}
+
 func (t *tickerObj) GetMethod(name string) dune.NativeMethod {
 	switch name {
 	case "stop":
@@ -1919,7 +2063,7 @@ func (t *tickerObj) GetMethod(name string) dune.NativeMethod {
 }
 
 func (t *tickerObj) stop(args []dune.Value, vm *dune.VM) (dune.Value, error) {
-	t.ticker.Stop()
+	t.Close()
 	return dune.NullValue, nil
 }
 
@@ -124,7 +124,7 @@ var TLS = []dune.NativeFunction{
 			}
 
 			if cache == nil {
-				cache = &autocertCache{fs: vm.FileSystem}
+				cache = &autocertCache{fs: checkedFS(vm, vm.FileSystem)}
 			}
 
 			cache.dir = cacheDir
@@ -152,7 +152,7 @@ var TLS = []dune.NativeFunction{
 				return dune.NullValue, fmt.Errorf("invalid filesystem argument, got %v", args[0])
 			}
 
-			c := &autocertCache{fs: fs.FS}
+			c := &autocertCache{fs: fs.checked(vm)}
 			return dune.NewObject(c), nil
 		},
 	},
@@ -380,7 +380,7 @@ func (t *tlsConfig) loadCertificate(args []dune.Value, vm *dune.VM) (dune.Value,
 		return dune.NullValue, err
 	}
 
-	fs := vm.FileSystem
+	fs := checkedFS(vm, vm.FileSystem)
 	if fs == nil {
 		return dune.NullValue, fmt.Errorf("there is no filesystem set")
 	}
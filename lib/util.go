@@ -152,6 +152,19 @@ func ValidateArgRange(args []dune.Value, counts ...int) error {
 	return fmt.Errorf("expected %s arguments, got %d", s, l)
 }
 
+func isFunc(fn dune.Value) bool {
+	switch fn.Type {
+	case dune.Func:
+		return true
+	case dune.Object:
+		switch fn.ToObject().(type) {
+		case *dune.Closure, *dune.Method, dune.NativeMethod:
+			return true
+		}
+	}
+	return false
+}
+
 func runFuncOrClosure(vm *dune.VM, fn dune.Value, args ...dune.Value) error {
 	switch fn.Type {
 	case dune.Func:
@@ -159,12 +172,19 @@ func runFuncOrClosure(vm *dune.VM, fn dune.Value, args ...dune.Value) error {
 		return err
 
 	case dune.Object:
-		c, ok := fn.ToObject().(*dune.Closure)
-		if !ok {
+		switch t := fn.ToObject().(type) {
+		case *dune.Closure:
+			_, err := vm.RunClosure(t, args...)
+			return err
+		case *dune.Method:
+			_, err := vm.RunMethod(t, args...)
+			return err
+		case dune.NativeMethod:
+			_, err := t(args, vm)
+			return err
+		default:
 			return fmt.Errorf("%v is not a function", fn.TypeName())
 		}
-		_, err := vm.RunClosure(c, args...)
-		return err
 
 	default:
 		return fmt.Errorf("%v is not a function", fn.TypeName())
@@ -139,7 +139,7 @@ var XLSX = []dune.NativeFunction{
 				size = st.Size()
 
 			case dune.String:
-				f, err := vm.FileSystem.Open(a.String())
+				f, err := checkedFS(vm, vm.FileSystem).Open(a.String())
 				if err != nil {
 					return dune.NullValue, err
 				}
@@ -251,7 +251,7 @@ func (x *xlsxFile) save(args []dune.Value, vm *dune.VM) (dune.Value, error) {
 		return dune.NullValue, fmt.Errorf("need a name to save the file")
 	}
 
-	f, err := vm.FileSystem.OpenForWrite(path)
+	f, err := checkedFS(vm, vm.FileSystem).OpenForWrite(path)
 	if err != nil {
 		return dune.NullValue, err
 	}
@@ -10,6 +10,8 @@ import (
 )
 
 func init() {
+	dune.RegisterNonReplayable("zip.open")
+
 	dune.RegisterLib(ZIP, `
 
 
@@ -103,7 +105,7 @@ var ZIP = []dune.NativeFunction{
 				fs = vm.FileSystem
 			}
 
-			f, err := fs.Open(args[0].String())
+			f, err := checkedFS(vm, fs).Open(args[0].String())
 			if err != nil {
 				return dune.NullValue, err
 			}
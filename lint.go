@@ -0,0 +1,537 @@
+package dune
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dunelang/dune/ast"
+	"github.com/dunelang/dune/filesystem"
+	"github.com/dunelang/dune/parser"
+)
+
+// LintRules are the checks that Lint knows about.
+var LintRules = []string{
+	"unused",        // locals and globals declared but never read
+	"unused-import", // imported modules that are never referenced
+	"unreachable",   // statements after return, throw, break or continue
+	"shadow",        // declarations that hide another one of an outer scope
+	"const-assign",  // assignments to constants
+	"permission",    // native functions that need a permission not declared
+	"null-compare",  // == and != comparisons with null
+}
+
+// LintIssue is a possible mistake found by a rule.
+type LintIssue struct {
+	Rule    string       `json:"rule"`
+	Pos     ast.Position `json:"pos"`
+	Message string       `json:"message"`
+}
+
+func (i *LintIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", i.Pos.FileName, i.Pos.Line, i.Message, i.Rule)
+}
+
+// Lint checks the file in path with the rules. If no rules are passed
+// all of them are checked.
+//
+// The rules that only need the source are checked even if the program
+// doesn't compile. The compilation error is then reported as an issue
+// of the rule "compile" and the rules that need the program are skipped.
+func Lint(fs filesystem.FS, path string, rules ...string) ([]*LintIssue, error) {
+	if len(rules) == 0 {
+		rules = LintRules
+	}
+
+	l := &linter{rules: make(map[string]bool)}
+	for _, r := range rules {
+		if !isLintRule(r) {
+			return nil, fmt.Errorf("unknown lint rule: %s", r)
+		}
+		l.rules[r] = true
+	}
+
+	a, err := parser.Parse(fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := a.File
+
+	if l.rules["unused-import"] {
+		if err := l.checkImports(fs, file); err != nil {
+			return nil, err
+		}
+	}
+
+	l.checkFile(file)
+
+	if l.rules["unused"] || l.rules["permission"] {
+		c := NewCompiler()
+		c.symbols = newSymbols()
+		p, err := c.Compile(a)
+		if err != nil {
+			l.compileError(err, file.Path)
+			l.sort()
+			return l.issues, nil
+		}
+		if l.rules["unused"] {
+			l.checkUnused(c.symbols, file.Path)
+		}
+		if l.rules["permission"] {
+			l.checkPermissions(p)
+		}
+	}
+
+	l.sort()
+	return l.issues, nil
+}
+
+func isLintRule(name string) bool {
+	for _, r := range LintRules {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+type linter struct {
+	rules  map[string]bool
+	issues []*LintIssue
+	scope  *lintScope
+
+	// positions of the identifiers that are written, not read
+	assigned map[ast.Position]int
+	// declarations that are not reported as unused
+	ignored map[ast.Position]bool
+}
+
+type lintScope struct {
+	parent *lintScope
+	names  map[string]*lintDecl
+}
+
+type lintDecl struct {
+	pos      ast.Position
+	constant bool
+}
+
+func (l *linter) report(rule string, pos ast.Position, format string, args ...interface{}) {
+	if !l.rules[rule] {
+		return
+	}
+	l.issues = append(l.issues, &LintIssue{
+		Rule:    rule,
+		Pos:     pos,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// compileError reports an error of the compiler with its position.
+func (l *linter) compileError(err error, path string) {
+	issue := &LintIssue{Rule: "compile", Pos: ast.Position{FileName: path}, Message: err.Error()}
+
+	if e, ok := err.(CompilerError); ok {
+		issue.Pos = e.Pos
+		issue.Message = e.ErrorMessage()
+	}
+
+	if issue.Pos.FileName == "" {
+		issue.Pos.FileName = path
+	}
+
+	l.issues = append(l.issues, issue)
+}
+
+func (l *linter) sort() {
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		a, b := l.issues[i].Pos, l.issues[j].Pos
+		if a.FileName != b.FileName {
+			return a.FileName < b.FileName
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// checkImports reports modules that are imported but whose alias
+// is not used anywhere else in the source, including types.
+func (l *linter) checkImports(fs filesystem.FS, file *ast.File) error {
+	if len(file.Imports) == 0 {
+		return nil
+	}
+
+	code, err := filesystem.ReadAll(fs, file.Path)
+	if err != nil {
+		return err
+	}
+
+	lex := ast.New(strings.NewReader(string(code)), file.Path)
+	if err := lex.Run(); err != nil {
+		return err
+	}
+
+	idents := make(map[string]int)
+	for _, t := range lex.Tokens {
+		if t.Type == ast.IDENT {
+			idents[t.Str]++
+		}
+	}
+
+	for _, imp := range file.Imports {
+		// imports without alias are executed by their side effects
+		if imp.Alias == "" {
+			continue
+		}
+		// one is the import itself
+		if idents[imp.Alias] <= 1 {
+			l.report("unused-import", imp.Pos, "'%s' imported and not used", imp.Path)
+		}
+	}
+
+	return nil
+}
+
+// checkFile walks the source tracking the scopes to check the rules
+// that don't need to compile the program.
+func (l *linter) checkFile(file *ast.File) {
+	l.assigned = make(map[ast.Position]int)
+	l.ignored = make(map[ast.Position]bool)
+
+	l.openScope()
+
+	// top level declarations are visible from the functions
+	// declared before them
+	for _, s := range file.Stms {
+		if t, ok := s.(*ast.VarDeclStmt); ok {
+			l.scope.names[t.Name] = &lintDecl{pos: t.Pos, constant: t.Const}
+		}
+	}
+
+	l.stmts(file.Stms)
+	l.closeScope()
+}
+
+func (l *linter) openScope() {
+	l.scope = &lintScope{parent: l.scope, names: make(map[string]*lintDecl)}
+}
+
+func (l *linter) closeScope() {
+	l.scope = l.scope.parent
+}
+
+func (l *linter) declare(name string, pos ast.Position, constant bool) {
+	for s := l.scope.parent; s != nil; s = s.parent {
+		if d, ok := s.names[name]; ok {
+			l.report("shadow", pos, "declaration of '%s' shadows the one in line %d", name, d.pos.Line)
+			break
+		}
+	}
+	l.scope.names[name] = &lintDecl{pos: pos, constant: constant}
+}
+
+func (l *linter) lookup(name string) *lintDecl {
+	for s := l.scope; s != nil; s = s.parent {
+		if d, ok := s.names[name]; ok {
+			return d
+		}
+	}
+	return nil
+}
+
+func (l *linter) stmts(list []ast.Stmt) {
+	var dead, reported bool
+	for _, s := range list {
+		// functions are hoisted so they are reachable
+		if _, ok := s.(*ast.FuncDeclStmt); dead && !ok && !reported {
+			l.report("unreachable", s.Position(), "unreachable code")
+			reported = true
+		}
+		if terminates(s) {
+			dead = true
+		}
+		l.stmt(s)
+	}
+}
+
+// terminates reports if the statement never continues with the next one.
+func terminates(s ast.Stmt) bool {
+	switch t := s.(type) {
+	case *ast.ReturnStmt, *ast.TailCallStmt, *ast.ThrowStmt, *ast.BreakStmt, *ast.ContinueStmt:
+		return true
+	case *ast.BlockStmt:
+		for _, v := range t.List {
+			if terminates(v) {
+				return true
+			}
+		}
+		return false
+	case *ast.IfStmt:
+		if t.Else == nil || !terminates(t.Else) {
+			return false
+		}
+		for _, b := range t.IfBlocks {
+			if !terminates(b.Body) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *linter) stmt(s ast.Stmt) {
+	switch t := s.(type) {
+	case *ast.BlockStmt:
+		l.openScope()
+		l.stmts(t.List)
+		l.closeScope()
+
+	case *ast.VarDeclStmt:
+		l.expr(t.Value)
+		l.declare(t.Name, t.Pos, t.Const)
+
+	case *ast.FuncDeclStmt:
+		l.function(t.Args, t.Body)
+
+	case *ast.ClassDeclStmt:
+		for _, v := range t.Fields {
+			l.expr(v.Value)
+		}
+		for _, list := range [][]*ast.FuncDeclStmt{t.Functions, t.Getters, t.Setters} {
+			for _, f := range list {
+				l.function(f.Args, f.Body)
+			}
+		}
+
+	case *ast.IfStmt:
+		for _, b := range t.IfBlocks {
+			l.expr(b.Condition)
+			l.stmt(b.Body)
+		}
+		if t.Else != nil {
+			l.stmt(t.Else)
+		}
+
+	case *ast.WhileStmt:
+		l.expr(t.Expression)
+		l.stmt(t.Body)
+
+	case *ast.ForStmt:
+		l.openScope()
+		l.expr(t.InExpression)
+		l.expr(t.OfExpression)
+		l.stmts(t.Declaration)
+		l.expr(t.Expression)
+		if t.Step != nil {
+			l.stmt(t.Step)
+		}
+		l.stmt(t.Body)
+		l.closeScope()
+
+	case *ast.SwitchStmt:
+		l.expr(t.Expression)
+		blocks := t.Blocks
+		if t.Default != nil {
+			blocks = append(blocks, t.Default)
+		}
+		for _, b := range blocks {
+			l.expr(b.Expression)
+			l.openScope()
+			l.stmts(b.Stmts)
+			l.closeScope()
+		}
+
+	case *ast.TryStmt:
+		l.stmt(t.Body)
+		if t.Catch != nil {
+			l.openScope()
+			if t.CatchIdent != nil {
+				l.declare(t.CatchIdent.Name, t.CatchIdent.Pos, false)
+				l.ignored[t.CatchIdent.Pos] = true
+			}
+			l.stmt(t.Catch)
+			l.closeScope()
+		}
+		if t.Finally != nil {
+			l.stmt(t.Finally)
+		}
+
+	case *ast.AsignStmt:
+		l.assign(t.Left)
+		if b, ok := t.Value.(*ast.BinaryExpr); ok && b.Left == t.Left {
+			// a compound assignment reads the value
+			l.expr(b.Right)
+		} else {
+			if id, ok := t.Left.(*ast.IdentExpr); ok {
+				l.assigned[id.Pos]++
+			}
+			l.expr(t.Left)
+			l.expr(t.Value)
+		}
+
+	case *ast.IncStmt:
+		l.assign(t.Left)
+		if id, ok := t.Left.(*ast.IdentExpr); ok {
+			l.assigned[id.Pos]++
+		}
+		l.expr(t.Left)
+
+	case *ast.IndexAsignStmt:
+		l.expr(t.IndexExpr)
+		l.expr(t.Value)
+
+	case *ast.CallStmt:
+		l.expr(t.CallExpr)
+
+	case *ast.TailCallStmt:
+		l.expr(t.CallExpr)
+
+	case *ast.ReturnStmt:
+		l.expr(t.Value)
+
+	case *ast.ThrowStmt:
+		l.expr(t.Value)
+	}
+}
+
+func (l *linter) function(args *ast.Arguments, body *ast.BlockStmt) {
+	l.openScope()
+	if args != nil {
+		for _, a := range args.List {
+			l.declare(a.Name, a.Pos, false)
+		}
+	}
+	// the arguments are declared in the same scope as the body
+	if body != nil {
+		l.stmts(body.List)
+	}
+	l.closeScope()
+}
+
+func (l *linter) assign(left ast.Expr) {
+	id, ok := left.(*ast.IdentExpr)
+	if !ok {
+		return
+	}
+	if d := l.lookup(id.Name); d != nil && d.constant {
+		l.report("const-assign", id.Pos, "cannot assign to constant '%s'", id.Name)
+	}
+}
+
+func (l *linter) expr(e ast.Expr) {
+	if e == nil {
+		return
+	}
+
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch t := n.(type) {
+		case *ast.FuncDeclExpr:
+			l.function(t.Args, t.Body)
+			return false
+
+		case *ast.BinaryExpr:
+			var op string
+			switch t.Operator {
+			case ast.EQL:
+				op = "=="
+			case ast.NEQ:
+				op = "!="
+			}
+			if op != "" && (isNullExpr(t.Left) || isNullExpr(t.Right)) {
+				l.report("null-compare", t.Position(),
+					"'%s null' is also true for undefined, use '%s=' to compare only with null", op, op)
+			}
+		}
+		return true
+	})
+}
+
+func isNullExpr(e ast.Expr) bool {
+	k, ok := e.(*ast.ConstantExpr)
+	return ok && k.Kind == ast.NULL
+}
+
+// checkUnused reports the variables declared in the file that
+// are never read. Names that start with _ are not reported.
+func (l *linter) checkUnused(symbols *Symbols, file string) {
+	for key, sym := range symbols.keys {
+		switch sym.Kind {
+		case SymbolVariable, SymbolConstant:
+		default:
+			continue
+		}
+
+		if sym.Pos.FileName != file || strings.HasPrefix(sym.Name, "_") || l.ignored[sym.Pos] {
+			continue
+		}
+
+		if r, ok := key.(*Register); ok && r.Exported {
+			continue
+		}
+
+		reads := len(sym.References)
+		for _, ref := range sym.References {
+			if l.assigned[ref] > 0 {
+				reads--
+			}
+		}
+
+		if reads <= 0 {
+			l.report("unused", sym.Pos, "'%s' declared and not used", sym.Name)
+		}
+	}
+}
+
+// checkPermissions reports the calls to native functions that require
+// a permission that is not declared by the program or the function.
+func (l *linter) checkPermissions(p *Program) {
+	seen := make(map[ast.Position]bool)
+
+	for _, f := range p.Functions {
+		for pc, in := range f.Instructions {
+			for _, addr := range []*Address{in.A, in.B, in.C} {
+				if addr == nil || addr.Kind != AddrNativeFunc {
+					continue
+				}
+
+				nf, err := p.NativeFunc(int(addr.Value))
+				if err != nil {
+					continue
+				}
+				for _, perm := range nf.Permissions {
+					if p.HasPermission(perm) || f.HasPermission(perm) {
+						continue
+					}
+
+					pos := instructionPosition(p, f, pc)
+					if seen[pos] {
+						continue
+					}
+					seen[pos] = true
+
+					l.report("permission", pos, "%s requires the permission '%s'", nf.Name, perm)
+				}
+			}
+		}
+	}
+}
+
+func instructionPosition(p *Program, f *Function, pc int) ast.Position {
+	if pc >= len(f.Positions) {
+		return ast.Position{}
+	}
+
+	pos := f.Positions[pc]
+
+	var file string
+	if pos.File >= 0 && pos.File < len(p.Files) {
+		file = p.Files[pos.File]
+	}
+
+	return ast.Position{FileName: file, Line: pos.Line, Column: pos.Column}
+}
@@ -0,0 +1,183 @@
+package dune
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dunelang/dune/filesystem"
+)
+
+func TestLint(t *testing.T) {
+	fs := filesystem.NewVirtualFS()
+	filesystem.WritePath(fs, "lib.ts", []byte(`
+export const x = 1
+`))
+	filesystem.WritePath(fs, "main.ts", []byte(`
+import * as lib from "lib"
+
+const limit = foo()
+let counter = 0
+
+function foo() {
+	return 1
+}
+
+function main(a: number) {
+	let unused = 1
+	let written = 2
+	written = 3
+	counter++
+	limit = 4
+
+	if (a == null) {
+		let a = 2
+		return a
+	}
+
+	for (let _i = 0; _i < 2; _i++) {
+		try {
+			throw "x"
+		} catch (e) {
+			break
+		}
+	}
+
+	return a
+	foo()
+}
+`))
+
+	issues, err := Lint(fs, "main.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		rule string
+		line int
+	}{
+		{"unused-import", 2},
+		{"unused", 4},
+		{"unused", 5},
+		{"unused", 12},
+		{"unused", 13},
+		{"const-assign", 16},
+		{"null-compare", 18},
+		{"shadow", 19},
+		{"unreachable", 32},
+	}
+
+	if len(issues) != len(expected) {
+		t.Fatalf("%v", issues)
+	}
+
+	for i, e := range expected {
+		issue := issues[i]
+		if issue.Rule != e.rule || issue.Pos.Line != e.line {
+			t.Fatalf("%d: expected %s in line %d, got %v", i, e.rule, e.line, issue)
+		}
+	}
+
+	b, err := json.Marshal(issues[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"pos":{"fileName":"/main.ts","line":2,"column":5}`) {
+		t.Fatal(string(b))
+	}
+}
+
+func TestLintRules(t *testing.T) {
+	fs := filesystem.NewVirtualFS()
+	filesystem.WritePath(fs, "main.ts", []byte(`
+function main() {
+	let x = 1
+	return
+	x = 2
+}
+`))
+
+	issues, err := Lint(fs, "main.ts", "unreachable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issues) != 1 || issues[0].Rule != "unreachable" || issues[0].Pos.Line != 5 {
+		t.Fatalf("%v", issues)
+	}
+
+	if _, err := Lint(fs, "main.ts", "foo"); err == nil {
+		t.Fatal("expected an unknown rule error")
+	}
+}
+
+func TestLintCompileError(t *testing.T) {
+	fs := filesystem.NewVirtualFS()
+	filesystem.WritePath(fs, "main.ts", []byte(`
+const max = 10
+
+function main(a: number) {
+	max = 20
+	if (a == null) {
+		return
+	}
+}
+`))
+
+	issues, err := Lint(fs, "main.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		rule string
+		line int
+	}{
+		{"const-assign", 5},
+		{"compile", 5},
+		{"null-compare", 6},
+	}
+
+	if len(issues) != len(expected) {
+		t.Fatalf("%v", issues)
+	}
+
+	for i, e := range expected {
+		issue := issues[i]
+		if issue.Rule != e.rule || issue.Pos.Line != e.line || !strings.HasSuffix(issue.Pos.FileName, "main.ts") {
+			t.Fatalf("%d: expected %s in line %d, got %v", i, e.rule, e.line, issue)
+		}
+	}
+}
+
+func TestLintPermissions(t *testing.T) {
+	AddNativeFunc(NativeFunction{
+		Name:        "lintTest.secret",
+		Permissions: []string{"secrets"},
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NullValue, nil
+		},
+	})
+
+	fs := filesystem.NewVirtualFS()
+	filesystem.WritePath(fs, "main.ts", []byte(`
+function main() {
+	lintTest.secret()
+}
+
+// [permissions secrets]
+function allowed() {
+	lintTest.secret()
+}
+`))
+
+	issues, err := Lint(fs, "main.ts", "permission")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issues) != 1 || issues[0].Pos.Line != 3 {
+		t.Fatalf("%v", issues)
+	}
+}
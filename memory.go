@@ -0,0 +1,157 @@
+package dune
+
+// memoryWalkFraction is the part of MaxAllocations that the count has to
+// grow after measuring the live memory before measuring it again.
+const memoryWalkFraction = 8
+
+// AddAllocations counts an allocation of size and returns an error if the
+// memory retained by the VM would go over MaxAllocations.
+//
+// When the count goes over the limit the VM measures what is still
+// reachable from its stack frames and globals and the count is reset to
+// that value. Values that are no longer referenced are not counted anymore.
+// To not measure on every allocation when the live memory is close to the
+// limit, it is not measured again until the count grows by a fraction of it.
+func (vm *VM) AddAllocations(size int) error {
+	if vm.MaxAllocations == 0 {
+		return nil
+	}
+
+	if size < 0 {
+		return vm.NewError("invalid allocation size: %d", size)
+	}
+
+	vm.allocations += int64(size)
+	if vm.allocations <= vm.MaxAllocations || vm.allocations <= vm.nextMemoryWalk {
+		return nil
+	}
+
+	vm.memoryWalks++
+	vm.allocations = vm.LiveMemory() + int64(size)
+	if vm.allocations > vm.MaxAllocations {
+		return vm.NewError("Max allocations reached: %d", vm.MaxAllocations)
+	}
+
+	vm.nextMemoryWalk = vm.allocations + vm.MaxAllocations/memoryWalkFraction
+	return nil
+}
+
+// allocate counts the size of the value if there is a limit.
+func (vm *VM) allocate(v Value) error {
+	if vm.MaxAllocations == 0 {
+		return nil
+	}
+	return vm.AddAllocations(v.Size())
+}
+
+// LiveMemory returns an estimate of the size of the values reachable from
+// the stack frames and globals of the VM. Values referenced from several
+// places are counted once.
+func (vm *VM) LiveMemory() int64 {
+	w := &memoryWalker{seen: make(map[interface{}]bool)}
+
+	size := int64(vm.Program.kSize)
+
+	for i := 0; i <= vm.fp && i < len(vm.callStack); i++ {
+		frame := vm.callStack[i]
+		for _, v := range frame.values {
+			size += w.size(v)
+		}
+		for _, c := range frame.closures {
+			size += w.size(c.get())
+		}
+	}
+
+	size += w.size(vm.RetValue)
+	size += w.size(vm.Context)
+	return size
+}
+
+type memoryWalker struct {
+	seen  map[interface{}]bool
+	stack []Value
+}
+
+func (w *memoryWalker) size(v Value) int64 {
+	var size int64
+
+	w.stack = append(w.stack[:0], v)
+
+	for len(w.stack) > 0 {
+		v := w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+
+		switch v.Type {
+		case Array:
+			a := v.object.(*NewArrayObject)
+			if w.seen[a] {
+				continue
+			}
+			w.seen[a] = true
+			size += int64(len(a.Array)) + 1
+			w.stack = append(w.stack, a.Array...)
+
+		case Map:
+			m := v.ToMap()
+			if w.seen[m] {
+				continue
+			}
+			w.seen[m] = true
+			m.RLock()
+			size += int64(len(m.Map))*2 + 1
+			for k, item := range m.Map {
+				w.stack = append(w.stack, k, item)
+			}
+			m.RUnlock()
+
+		case Object:
+			size += w.object(v.object)
+
+		default:
+			size += int64(v.Size())
+		}
+	}
+
+	return size
+}
+
+func (w *memoryWalker) object(o interface{}) int64 {
+	switch t := o.(type) {
+	case *instance:
+		if w.seen[t] {
+			return 0
+		}
+		w.seen[t] = true
+		t.RLock()
+		for _, v := range t.iMap {
+			w.stack = append(w.stack, v)
+		}
+		size := int64(len(t.iMap)) + 1
+		t.RUnlock()
+		return size
+
+	case *Closure:
+		if w.seen[t] {
+			return 0
+		}
+		w.seen[t] = true
+		for _, c := range t.closures {
+			w.stack = append(w.stack, c.get())
+		}
+		return int64(len(t.closures)) + 1
+
+	case *Method:
+		w.stack = append(w.stack, t.ThisObject)
+		return 1
+
+	case *closureRegister:
+		w.stack = append(w.stack, t.get())
+		return 1
+
+	case Allocator:
+		return int64(t.Size())
+
+	default:
+		return 1
+	}
+}
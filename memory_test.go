@@ -0,0 +1,133 @@
+package dune
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaxAllocationsReleased(t *testing.T) {
+	p, err := CompileStr(`
+		function build() {
+			let s = ""
+			for (let i = 0; i < 100; i++) {
+				s += "x"
+			}
+			return s.length
+		}
+
+		function main() {
+			let n = 0
+			for (let i = 0; i < 1000; i++) {
+				n += build()
+			}
+			return n
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+	vm.MaxAllocations = 20000
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ToInt() != 100000 {
+		t.Fatal(v)
+	}
+}
+
+func TestMaxAllocationsRetained(t *testing.T) {
+	p, err := CompileStr(`
+		let m = {}
+
+		function main() {
+			for (let i = 0; i < 1000; i++) {
+				m["k" + i] = "0123456789012345678901234567890123456789"
+			}
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+	vm.MaxAllocations = 20000
+
+	if _, err := vm.Run(); err == nil || !strings.Contains(err.Error(), "Max allocations reached") {
+		t.Fatal(err)
+	}
+
+	// it can go over the limit until the live memory is measured again
+	if live := vm.LiveMemory(); live > vm.MaxAllocations+vm.MaxAllocations/memoryWalkFraction {
+		t.Fatalf("live memory %d over the limit", live)
+	}
+}
+
+func TestLiveMemory(t *testing.T) {
+	p, err := CompileStr(`
+		let a
+		let b
+		let c
+
+		function main() {
+			a = ["0123456789", "0123456789"]
+			b = a
+			c = { x: a, y: "0123456789" }
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+	if _, err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	base := int64(p.kSize)
+
+	// a is counted once: 3 for the array, 20 for its strings
+	// and 5 for the map with 12 for its keys and string. The
+	// rest are the other registers.
+	if live := vm.LiveMemory() - base; live < 38 || live > 50 {
+		t.Fatalf("unexpected live memory %d", live)
+	}
+}
+
+func TestMaxAllocationsWalks(t *testing.T) {
+	p, err := CompileStr(`
+		let retained = {}
+
+		function main() {
+			for (let i = 0; i < 1000; i++) {
+				retained["k" + i] = "0123456789012345678901234567890"
+			}
+
+			let n = 0
+			for (let i = 0; i < 20000; i++) {
+				let s = "a" + i
+				n += s.length
+			}
+			return n
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+	vm.MaxAllocations = vm.Allocations() + 38500
+
+	if _, err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the live memory is close to the limit but it is not
+	// measured again until the count grows enough.
+	if vm.memoryWalks > 100 {
+		t.Fatalf("the live memory was measured %d times", vm.memoryWalks)
+	}
+}
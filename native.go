@@ -1,6 +1,7 @@
 package dune
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -74,6 +75,141 @@ func AllNativeFuncs() []NativeFunction {
 	return allNativeFuncs
 }
 
+// NativeRegistry is a set of native functions for the programs and virtual
+// machines that must not see all the global ones. It starts as a copy of the
+// functions registered in the process and natives can be removed, replaced
+// or added without affecting other programs:
+//
+//	r := dune.NewNativeRegistry()
+//	r.Allow("Array.*", "String.*", "strings.*", "json.*", "time.*")
+//	r.Add(dune.NativeFunction{Name: "time.now", Arguments: 0, Function: fakeNow})
+//	r.Add(dune.NativeFunction{Name: "tenant.name", Arguments: 0, Function: tenantName})
+//
+// The indexes of the functions registered before the copy are the same as the
+// global ones so a program compiled without registry can run with it.
+type NativeRegistry struct {
+	funcs  []NativeFunction
+	byName map[string]int
+}
+
+// NewNativeRegistry returns a copy of the natives registered in the process.
+func NewNativeRegistry() *NativeRegistry {
+	r := &NativeRegistry{
+		funcs:  make([]NativeFunction, len(allNativeFuncs)),
+		byName: make(map[string]int, len(allNativeFuncs)),
+	}
+
+	copy(r.funcs, allNativeFuncs)
+	for i, f := range r.funcs {
+		r.byName[f.Name] = i
+	}
+	return r
+}
+
+// Add adds a function or replaces the one with the same name.
+func (r *NativeRegistry) Add(f NativeFunction) {
+	if i, ok := r.byName[f.Name]; ok {
+		f.Index = i
+		r.funcs[i] = f
+		return
+	}
+
+	// reuse the index if it was removed
+	for i, existing := range r.funcs {
+		if existing.Name == f.Name {
+			f.Index = i
+			r.funcs[i] = f
+			r.byName[f.Name] = i
+			return
+		}
+	}
+
+	f.Index = len(r.funcs)
+	r.funcs = append(r.funcs, f)
+	r.byName[f.Name] = f.Index
+}
+
+// Allow removes the functions that don't match any of the patterns. A pattern
+// is a name or a prefix like "strings.*" that includes also the properties of
+// the namespace.
+func (r *NativeRegistry) Allow(patterns ...string) {
+	for name := range r.byName {
+		if !matchNative(patterns, name) {
+			r.remove(name)
+		}
+	}
+}
+
+// Deny removes the functions that match any of the patterns.
+func (r *NativeRegistry) Deny(patterns ...string) {
+	for name := range r.byName {
+		if matchNative(patterns, name) {
+			r.remove(name)
+		}
+	}
+}
+
+// remove keeps the name in its index so programs that reference it
+// get an error instead of calling another function.
+func (r *NativeRegistry) remove(name string) {
+	i := r.byName[name]
+	delete(r.byName, name)
+	r.funcs[i] = NativeFunction{Name: name, Index: i}
+}
+
+func matchNative(patterns []string, name string) bool {
+	name = strings.TrimPrefix(name, "->")
+	for _, p := range patterns {
+		if p == "*" || p == name {
+			return true
+		}
+		if strings.HasSuffix(p, ".*") && strings.HasPrefix(name, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the function with the name if it is available.
+func (r *NativeRegistry) Lookup(name string) (NativeFunction, bool) {
+	i, ok := r.byName[name]
+	if !ok {
+		return NativeFunction{}, false
+	}
+	return r.funcs[i], true
+}
+
+// FromIndex returns the function referenced by the programs with the index.
+// It returns an error if the index is unknown or the function was removed.
+func (r *NativeRegistry) FromIndex(i int) (NativeFunction, error) {
+	return nativeFromIndex(r.funcs, i)
+}
+
+// nativeFromIndex returns the function or an error if it is not available.
+// Removed functions are returned with the error to know their name.
+func nativeFromIndex(funcs []NativeFunction, i int) (NativeFunction, error) {
+	if i < 0 || i >= len(funcs) {
+		return NativeFunction{Index: i}, fmt.Errorf("native function %d is not available", i)
+	}
+
+	f := funcs[i]
+	if f.Function == nil {
+		return f, fmt.Errorf("native function '%s' is not available", f.Name)
+	}
+	return f, nil
+}
+
+// Funcs returns the available functions.
+func (r *NativeRegistry) Funcs() []NativeFunction {
+	var list []NativeFunction
+	for _, f := range r.funcs {
+		if f.Function != nil {
+			list = append(list, f)
+		}
+	}
+	return list
+}
+
 func TypeDefs() string {
 	return strings.Join(typeDefs, "\n\n")
 }
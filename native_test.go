@@ -0,0 +1,160 @@
+package dune
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNativeRegistry(t *testing.T) {
+	AddNativeFunc(NativeFunction{
+		Name:      "registryTest.now",
+		Arguments: 0,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NewInt(1), nil
+		},
+	})
+	AddNativeFunc(NativeFunction{
+		Name:      "registryTest.secret",
+		Arguments: 0,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NewString("secret"), nil
+		},
+	})
+
+	r := NewNativeRegistry()
+	r.Deny("registryTest.secret")
+	r.Add(NativeFunction{
+		Name:      "registryTest.now",
+		Arguments: 0,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NewInt(2), nil
+		},
+	})
+	r.Add(NativeFunction{
+		Name:      "tenant.name",
+		Arguments: 0,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NewString("acme"), nil
+		},
+	})
+
+	// compiled with the registry
+	p, err := CompileStrWith(`return tenant.name() + registryTest.now()`, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVM(p).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "acme2" {
+		t.Fatalf("got %v", v)
+	}
+
+	if _, err := CompileStrWith(`return registryTest.secret()`, r); err == nil {
+		t.Fatal("expected to fail compiling a denied native")
+	}
+
+	if _, err := CompileStr(`return tenant.name()`); err == nil {
+		t.Fatal("expected the custom native to be only in the registry")
+	}
+
+	// compiled with the global natives and run with the registry
+	p, err = CompileStr(`return registryTest.now()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err = NewVM(p).Run()
+	if err != nil || v.ToInt() != 1 {
+		t.Fatal(v, err)
+	}
+
+	vm := NewVM(p)
+	vm.Natives = r
+	v, err = vm.Run()
+	if err != nil || v.ToInt() != 2 {
+		t.Fatal(v, err)
+	}
+
+	p, err = CompileStr(`return registryTest.secret()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm = NewVM(p)
+	vm.Natives = r
+	if _, err := vm.Run(); err == nil || !strings.Contains(err.Error(), "native function 'registryTest.secret' is not available") {
+		t.Fatal(err)
+	}
+}
+
+func TestNativeRegistryAllow(t *testing.T) {
+	AddNativeFunc(NativeFunction{
+		Name:      "allowTest.a",
+		Arguments: 0,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NullValue, nil
+		},
+	})
+	AddNativeFunc(NativeFunction{
+		Name:      "->allowTest.b",
+		Arguments: 0,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NullValue, nil
+		},
+	})
+
+	r := NewNativeRegistry()
+	r.Allow("allowTest.*")
+
+	var names []string
+	for _, f := range r.Funcs() {
+		names = append(names, f.Name)
+	}
+
+	if strings.Join(names, ",") != "allowTest.a,->allowTest.b" {
+		t.Fatal(names)
+	}
+
+	// the index of a removed native is reused if it is added again
+	f, _ := NativeFuncFromName("allowTest.a")
+	r.Deny("allowTest.a")
+	if _, ok := r.Lookup("allowTest.a"); ok {
+		t.Fatal("expected allowTest.a to be removed")
+	}
+
+	r.Add(NativeFunction{Name: "allowTest.a", Function: f.Function})
+	if g, _ := r.Lookup("allowTest.a"); g.Index != f.Index {
+		t.Fatalf("expected index %d, got %d", f.Index, g.Index)
+	}
+}
+
+func TestNativeRegistryUnknownIndex(t *testing.T) {
+	r := NewNativeRegistry()
+
+	// registered after the copy so its index is not in the registry
+	AddNativeFunc(NativeFunction{
+		Name:      "indexTest.f",
+		Arguments: 0,
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			return NullValue, nil
+		},
+	})
+
+	f, _ := NativeFuncFromName("indexTest.f")
+	if _, err := r.FromIndex(f.Index); err == nil || err.Error() != fmt.Sprintf("native function %d is not available", f.Index) {
+		t.Fatal(err)
+	}
+
+	p, err := CompileStr(`return indexTest.f()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+	vm.Natives = r
+	if _, err := vm.Run(); err == nil || !strings.Contains(err.Error(), "is not available") {
+		t.Fatal(err)
+	}
+}
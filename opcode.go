@@ -1358,17 +1358,7 @@ func exec_keys(instr *Instruction, vm *VM) int {
 		vm.set(instr.A, NewArrayValues(values))
 
 	case Map:
-		m := bv.ToMap()
-		m.RLock()
-		s := m.Map
-		values := make([]Value, len(s))
-		i := 0
-		for k := range s {
-			values[i] = k
-			i++
-		}
-		m.RUnlock()
-		vm.set(instr.A, NewArrayValues(values))
+		vm.set(instr.A, NewArrayValues(vm.MapKeys(bv.ToMap())))
 
 	case Enum:
 		i := bv.ToEnum()
@@ -1431,13 +1421,13 @@ func exec_values(instr *Instruction, vm *VM) int {
 		vm.set(instr.A, NewArrayValues(values))
 	case Map:
 		m := bv.ToMap()
+		keys := vm.MapKeys(m)
+		values := make([]Value, 0, len(keys))
 		m.RLock()
-		s := m.Map
-		values := make([]Value, len(s))
-		i := 0
-		for _, v := range s {
-			values[i] = v
-			i++
+		for _, k := range keys {
+			if v, ok := m.Map[k]; ok {
+				values = append(values, v)
+			}
 		}
 		m.RUnlock()
 		vm.set(instr.A, NewArrayValues(values))
@@ -1528,18 +1518,24 @@ func exec_throw(instr *Instruction, vm *VM) int {
 			} else {
 				newError := vm.NewCodeError(e.Code, e.Message)
 				newError.Wrapped = e.Wrapped
+				newError.Thrown = e.Thrown
+				newError.goError = e.goError
 				err = newError
 			}
 		}
 	}
 
 	if err == nil {
-		err = vm.NewError(v.String())
+		err = vm.thrownError(v)
 	}
 
 	// check if is inside a catch to discard it.
 	l := len(vm.tryCatchs) - 1
 	if l < 0 {
+		if vm.debugger != nil {
+			vm.debugger.onException(vm, err)
+		}
+
 		// run finalizers before exiting
 		vm.cleanupNotGlobalFrame(vm.fp)
 
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/dunelang/dune/ast"
+)
+
+func TestDocs(t *testing.T) {
+	f, err := ParseSource(`
+/**
+ * Adds two numbers.
+ * The result is an int.
+ */
+export function add(a: number, b: number): number {
+    return a + b
+}
+
+// not documented because it is not exported
+function sub(a: number, b: number) {
+    return a - b
+}
+
+// the default timeout
+
+export const timeout = 10
+
+// A point in the plane.
+// [serializable]
+export class Point {
+    // the horizontal axis
+    x: number
+    private secret: string
+
+    /** Moves the point. */
+    move(dx: number) {
+        this.x += dx
+    }
+}
+
+export interface Shape {
+    area(): number
+}
+
+export enum Color {
+    // like the sky
+    Blue,
+    Red,
+}
+`, "main.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := ast.Docs(f)
+
+	var names []string
+	for _, d := range docs {
+		names = append(names, d.Kind+" "+d.Name)
+	}
+
+	expected := []string{"function add", "const timeout", "class Point", "interface Shape", "enum Color"}
+	if len(names) != len(expected) {
+		t.Fatalf("%v", names)
+	}
+	for i, n := range expected {
+		if names[i] != n {
+			t.Fatalf("%v", names)
+		}
+	}
+
+	add := docs[0]
+	if add.Signature != "function add(a: number, b: number): number" {
+		t.Fatal(add.Signature)
+	}
+	if add.Doc != "Adds two numbers.\nThe result is an int." {
+		t.Fatalf("%q", add.Doc)
+	}
+
+	// separated by an empty line
+	if docs[1].Doc != "" {
+		t.Fatalf("%q", docs[1].Doc)
+	}
+
+	// the attributes are not part of the documentation
+	point := docs[2]
+	if point.Doc != "A point in the plane." {
+		t.Fatalf("%q", point.Doc)
+	}
+	if len(point.Members) != 2 || point.Members[0].Doc != "the horizontal axis" || point.Members[1].Doc != "Moves the point." {
+		t.Fatalf("%+v", point.Members)
+	}
+
+	if docs[3].Signature != "interface Shape {\n    area(): number\n}" {
+		t.Fatalf("%q", docs[3].Signature)
+	}
+
+	if c := docs[4]; len(c.Members) != 2 || c.Members[0].Doc != "like the sky" {
+		t.Fatalf("%+v", c.Members)
+	}
+}
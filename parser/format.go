@@ -0,0 +1,38 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/dunelang/dune/ast"
+)
+
+// Format returns the code in the canonical style. Everything below a
+// //ts:ignore attribute is kept as it is.
+func Format(code, fileName string) ([]byte, error) {
+	code = strings.Replace(code, "\r", "", -1)
+
+	var tail string
+	if i := strings.Index(code, "//ts:ignore"); i != -1 {
+		code, tail = code[:i], code[i:]
+	}
+
+	f, err := ParseSource(code, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := ast.Format(&b, f, []byte(code)); err != nil {
+		return nil, err
+	}
+
+	if tail != "" {
+		if b.Len() > 0 && strings.HasSuffix(strings.TrimRight(code, " \t"), "\n\n") {
+			b.WriteByte('\n')
+		}
+		b.WriteString(tail)
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	data := []struct {
+		src      string
+		expected string
+	}{
+		{
+			"let a=1;let b   =  'xy'",
+			"let a = 1\nlet b = \"xy\"\n",
+		},
+		{
+			"function  foo(a:number,b?:string):void{\nif(a){return}\nelse {b+=a}\n}",
+			"function foo(a: number, b?: string): void {\n    if (a) {\n        return\n    } else {\n        b += a\n    }\n}\n",
+		},
+		{
+			"// [permissions trusted]\n\n// the main function\nexport function main() {\n    let x = (a) => a * (2 + 1) // double\n}",
+			"// [permissions trusted]\n\n// the main function\nexport function main() {\n    let x = (a) => a * (2 + 1) // double\n}\n",
+		},
+		{
+			"interface Foo {\n  a: string\n  b(): number\n}\n\nlet x = y as Foo;",
+			"interface Foo {\n    a: string\n    b(): number\n}\n\nlet x = y as Foo\n",
+		},
+		{
+			"let x = (a && b) || c\nlet m = {a: 1, 'b-c': [1,2]}",
+			"let x = (a && b) || c\nlet m = { a: 1, \"b-c\": [1, 2] }\n",
+		},
+	}
+
+	for i, d := range data {
+		b, err := Format(d.src, "test.ts")
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		if string(b) != d.expected {
+			t.Fatalf("%d: expected:\n%s\ngot:\n%s", i, d.expected, b)
+		}
+
+		again, err := Format(string(b), "test.ts")
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		if string(again) != string(b) {
+			t.Fatalf("%d: not idempotent:\n%s", i, again)
+		}
+	}
+}
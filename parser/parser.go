@@ -41,6 +41,7 @@ func ParseStr(code string) (*ast.Program, error) {
 
 	a := newAST()
 	a.File = file
+	a.File.Source = code
 	a.File.Global = p.global
 	p.importedPaths = make(map[string]bool)
 
@@ -68,6 +69,16 @@ func ParseExpr(code string) (ast.Expr, error) {
 	return expr, nil
 }
 
+// ParseSource parses a single file keeping the syntax as it was written
+// instead of preparing it for the compiler: types, interfaces and hex
+// literals are preserved and there are no implicit values or tail calls.
+// Imports are not resolved.
+func ParseSource(code, fileName string) (*ast.File, error) {
+	p := newParser(nil)
+	p.source = true
+	return p.parseCode(code, fileName)
+}
+
 func newParser(fs filesystem.FS) *parser {
 	return &parser{FS: fs}
 }
@@ -79,6 +90,7 @@ type parser struct {
 	FS            filesystem.FS
 	global        []ast.Stmt
 	importedPaths map[string]bool
+	source        bool
 }
 
 func (p *parser) SetFS(fs filesystem.FS) {
@@ -399,6 +411,7 @@ func (p *parser) parseCode(code, absPath string) (*ast.File, error) {
 	}
 
 	file.Path = absPath
+	file.Source = code
 	return file, nil
 }
 
@@ -432,7 +445,7 @@ loop:
 				return nil, NewError(attributes[0].Pos, "invalid attribute")
 			}
 
-			if len(file.Stms) > 0 {
+			if len(file.Stms) > 0 && !p.source {
 				return nil, NewError(t.Pos, "non-declaration statement outside function body")
 			}
 			imp, err := p.parseImport()
@@ -494,9 +507,13 @@ loop:
 				return nil, NewError(attributes[0].Pos, "invalid attribute")
 			}
 
+			start := p.index
 			if err := p.ignoreInterface(); err != nil {
 				return nil, err
 			}
+			if s := p.typeDecl(start); s != nil {
+				file.Stms = append(file.Stms, s)
+			}
 
 		case ast.EXPORT:
 			// parseExportStmtOrNIL can return nil because there is no
@@ -551,16 +568,25 @@ loop:
 			switch t.Str {
 			case "type":
 				// type definitions like: type a = "foo" | "bar";
+				start := p.index
 				if err := p.ignoreTypeDefinition(); err != nil {
 					return nil, err
 				}
+				if s := p.typeDecl(start); s != nil {
+					file.Stms = append(file.Stms, s)
+				}
 
 			case "declare":
+				start := p.index
 				stmts, err := p.parseDeclareGlobal()
 				if err != nil {
 					return nil, err
 				}
-				p.global = append(p.global, stmts...)
+				if s := p.typeDecl(start); s != nil {
+					file.Stms = append(file.Stms, s)
+				} else {
+					p.global = append(p.global, stmts...)
+				}
 
 			case "namespace":
 				return nil, NewError(t.Pos, "Namespaces are not supported. Use modules instead.")
@@ -602,14 +628,21 @@ loop:
 func (p *parser) parseComments() []*ast.Comment {
 	var cs []*ast.Comment
 
+	// the line where the last token that is not a comment ends
+	var line int
+
 	for _, t := range p.tokens {
 		switch t.Type {
 		case ast.COMMENT, ast.MULTILINE_COMMENT:
+			start := t.Pos.Line - strings.Count(t.Str, "\n")
 			cs = append(cs, &ast.Comment{
 				MultiLine: t.Type == ast.MULTILINE_COMMENT,
 				Str:       t.Str,
 				Pos:       t.Pos,
+				Trailing:  start == line,
 			})
+		default:
+			line = t.Pos.Line
 		}
 	}
 
@@ -758,7 +791,7 @@ func (p *parser) parseClassDeclStmt() (*ast.ClassDeclStmt, error) {
 			}
 
 		case ast.RBRACE:
-			p.next()
+			c.Rbrace = p.next().Pos
 			return c, nil
 
 		case ast.EOF:
@@ -856,7 +889,9 @@ func (p *parser) parseEnumDeclStmt(exported bool) (*ast.EnumDeclStmt, error) {
 			}
 		} else {
 			v.Kind = ast.INT
-			v.Value = &ast.ConstantExpr{Pos: tt.Pos, Kind: ast.INT, Value: strconv.Itoa(i)}
+			if !p.source {
+				v.Value = &ast.ConstantExpr{Pos: tt.Pos, Kind: ast.INT, Value: strconv.Itoa(i)}
+			}
 		}
 
 		i++
@@ -872,7 +907,7 @@ func (p *parser) parseEnumDeclStmt(exported bool) (*ast.EnumDeclStmt, error) {
 	}
 
 	// consume the rbrace
-	p.next()
+	enum.Rbrace = p.next().Pos
 
 	// optional semicolon
 	p.ignore(ast.SEMICOLON, 1)
@@ -925,9 +960,11 @@ func (p *parser) parseFuncDeclStmt(exported bool, t *ast.Token) (*ast.FuncDeclSt
 	}
 	f.Name = t.Str
 
+	start := p.index
 	if err := p.ignoreGenericDecl(); err != nil {
 		return nil, err
 	}
+	f.Generic = p.typeSince(start)
 
 	args, variadic, err := p.parseArguments()
 	if err != nil {
@@ -937,11 +974,7 @@ func (p *parser) parseFuncDeclStmt(exported bool, t *ast.Token) (*ast.FuncDeclSt
 	f.Variadic = variadic
 	f.Exported = exported
 
-	if err := p.ignoreUnionTypeDecl(); err != nil {
-		return nil, err
-	}
-
-	if err := p.ignoreGenericDecl(); err != nil {
+	if f.ReturnType, err = p.parseTypeAnnotation(); err != nil {
 		return nil, err
 	}
 
@@ -953,7 +986,7 @@ func (p *parser) parseFuncDeclStmt(exported bool, t *ast.Token) (*ast.FuncDeclSt
 
 	p.ignore(ast.SEMICOLON, 1)
 
-	if Optimizations {
+	if Optimizations && !p.source {
 		p.setTailCall(f)
 	}
 
@@ -1001,7 +1034,7 @@ func (p *parser) setTailCall(f *ast.FuncDeclStmt) {
 
 func (p *parser) parseLambda() (*ast.FuncDeclExpr, error) {
 	t := p.peek()
-	f := &ast.FuncDeclExpr{Pos: t.Pos}
+	f := &ast.FuncDeclExpr{Pos: t.Pos, Lambda: true}
 
 	switch t.Type {
 	case ast.LPAREN:
@@ -1060,11 +1093,7 @@ func (p *parser) parseFuncDeclExpr() (*ast.FuncDeclExpr, error) {
 	f.Args = args
 	f.Variadic = variadic
 
-	if err := p.ignoreUnionTypeDecl(); err != nil {
-		return nil, err
-	}
-
-	if err := p.ignoreGenericDecl(); err != nil {
+	if f.ReturnType, err = p.parseTypeAnnotation(); err != nil {
 		return nil, err
 	}
 
@@ -1115,11 +1144,7 @@ func (p *parser) parseArguments() (*ast.Arguments, bool, error) {
 
 		p.ignore(ast.QUESTION, 1)
 
-		if err := p.ignoreUnionTypeDecl(); err != nil {
-			return nil, false, err
-		}
-
-		if err := p.ignoreGenericDecl(); err != nil {
+		if f.Type, err = p.parseTypeAnnotation(); err != nil {
 			return nil, false, err
 		}
 
@@ -1362,9 +1387,11 @@ LOOP:
 		}
 	}
 
-	if _, err := p.accept(ast.RBRACE); err != nil {
+	r, err := p.accept(ast.RBRACE)
+	if err != nil {
 		return nil, err
 	}
+	sw.Rbrace = r.Pos
 
 	// validate fallthroughs.
 	// Only empty cases or the last one are allowed without a break or exit stmt
@@ -1772,18 +1799,19 @@ func (p *parser) parseForInOfDeclarationPart(f *ast.ForStmt) error {
 }
 
 func (p *parser) parseForInOfVarDeclStmt() (*ast.VarDeclStmt, error) {
-	p.next()
+	isVar := p.next().Type == ast.VAR && p.source
 
 	t, err := p.accept(ast.IDENT)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := p.ignoreUnionTypeDecl(); err != nil {
+	typ, err := p.parseTypeAnnotation()
+	if err != nil {
 		return nil, err
 	}
 
-	return &ast.VarDeclStmt{Pos: t.Pos, Name: t.Str}, nil
+	return &ast.VarDeclStmt{Pos: t.Pos, Name: t.Str, Type: typ, Var: isVar}, nil
 }
 
 func (p *parser) isPrototype() bool {
@@ -1836,9 +1864,11 @@ func (p *parser) parseMethod() (*ast.FuncDeclStmt, error) {
 		return nil, err
 	}
 
+	start := p.index
 	if err := p.ignoreGenericDecl(); err != nil {
 		return nil, err
 	}
+	f.Generic = p.typeSince(start)
 
 	args, variadic, err := p.parseArguments()
 	if err != nil {
@@ -1847,11 +1877,7 @@ func (p *parser) parseMethod() (*ast.FuncDeclStmt, error) {
 	f.Args = args
 	f.Variadic = variadic
 
-	if err := p.ignoreUnionTypeDecl(); err != nil {
-		return nil, err
-	}
-
-	if err := p.ignoreGenericDecl(); err != nil {
+	if f.ReturnType, err = p.parseTypeAnnotation(); err != nil {
 		return nil, err
 	}
 
@@ -2139,6 +2165,7 @@ loop:
 // parseExportStmtOrNIL can return nil because there is no
 // equivalent statement like "export interface"
 func (p *parser) parseExportStmtOrNIL() (ast.Stmt, error) {
+	start := p.index
 	if _, err := p.accept(ast.EXPORT); err != nil {
 		return nil, err
 	}
@@ -2164,12 +2191,14 @@ func (p *parser) parseExportStmtOrNIL() (ast.Stmt, error) {
 		if err := p.ignoreInterface(); err != nil {
 			return nil, err
 		}
-		return nil, nil
+		return p.typeDecl(start), nil
 
 	case ast.IDENT:
 		if t.Str == "type" {
-			err := p.ignoreTypeDefinition()
-			return nil, err
+			if err := p.ignoreTypeDefinition(); err != nil {
+				return nil, err
+			}
+			return p.typeDecl(start), nil
 		}
 		return nil, NewError(t.Pos, "Unexpected %v after export", t.Type)
 
@@ -2196,17 +2225,24 @@ func (p *parser) parseExportStmtOrNIL() (ast.Stmt, error) {
 }
 
 func (p *parser) parseVarDeclStmt(isConst bool) (*ast.VarDeclStmt, error) {
+	// in source mode remember if it was declared with var
+	isVar := p.source && p.index > 0 && p.tokens[p.index-1].Type == ast.VAR
+
 	t, err := p.accept(ast.IDENT)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := p.ignoreUnionTypeDecl(); err != nil {
+	typ, err := p.parseTypeAnnotation()
+	if err != nil {
 		return nil, err
 	}
 
 	if p.peek().Type != ast.ASSIGN {
 		p.ignore(ast.SEMICOLON, 1)
+		if p.source {
+			return &ast.VarDeclStmt{Pos: t.Pos, Name: t.Str, Const: isConst, Var: isVar, Type: typ}, nil
+		}
 		v := &ast.ConstantExpr{t.Pos, ast.UNDEFINED, "undefined"}
 		return &ast.VarDeclStmt{Pos: t.Pos, Name: t.Str, Value: v}, nil
 	}
@@ -2225,12 +2261,52 @@ func (p *parser) parseVarDeclStmt(isConst bool) (*ast.VarDeclStmt, error) {
 		Name:  t.Str,
 		Value: expr,
 		Const: isConst,
+		Var:   isVar,
+		Type:  typ,
 	}
 
 	p.ignore(ast.SEMICOLON, 1)
 	return v, nil
 }
 
+// parseTypeAnnotation skips a type annotation like ": string[]" and
+// returns it without the colon in source mode.
+func (p *parser) parseTypeAnnotation() (string, error) {
+	start := p.index
+	if err := p.ignoreUnionTypeDecl(); err != nil {
+		return "", err
+	}
+	if err := p.ignoreGenericDecl(); err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(p.typeSince(start), ": "), nil
+}
+
+// typeSince returns the type consumed since the token start in source mode.
+func (p *parser) typeSince(start int) string {
+	if !p.source || p.index <= start {
+		return ""
+	}
+	return ast.FormatType(p.tokens[start:p.index])
+}
+
+// typeDecl returns the declaration consumed since the token start as
+// a statement in source mode. Otherwise it is discarded.
+func (p *parser) typeDecl(start int) ast.Stmt {
+	if !p.source {
+		return nil
+	}
+
+	tokens := p.tokens[start:p.index]
+
+	// the comments before the declaration are not part of it
+	for len(tokens) > 0 && isComment(tokens[0]) {
+		tokens = tokens[1:]
+	}
+
+	return &ast.TypeDeclStmt{Pos: tokens[0].Pos, Tokens: tokens}
+}
+
 func (p *parser) ignoreUnionTypeDecl() error {
 	if p.peek().Type != ast.COLON {
 		return nil
@@ -2277,19 +2353,26 @@ func (p *parser) ignoreLambda() error {
 	return nil
 }
 
-func (p *parser) ignoreAsExpression() error {
+// parseAsExpression skips a type assertion like "x as T". In source mode
+// the assertion is kept in the tree.
+func (p *parser) parseAsExpression(expr ast.Expr) (ast.Expr, error) {
 	t := p.peek()
 	if t.Type != ast.IDENT || t.Str != "as" {
-		return nil
+		return expr, nil
 	}
 
 	p.next()
 
+	start := p.index
 	if err := p.ignoreTypeDecl(); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	if p.source {
+		return &ast.TypeAssertExpr{X: expr, Type: p.typeSince(start)}, nil
+	}
+
+	return expr, nil
 }
 
 func (p *parser) ignoreTypeDecl() error {
@@ -2997,10 +3080,7 @@ func (p *parser) parseSignedFactor() (ast.Expr, error) {
 		}
 
 		expr = &ast.UnaryExpr{Pos: t.Pos, Operator: t.Type, Operand: expr}
-		if err := p.ignoreAsExpression(); err != nil {
-			return nil, err
-		}
-		return expr, nil
+		return p.parseAsExpression(expr)
 	}
 
 	expr, err := p.parseFactor()
@@ -3008,11 +3088,7 @@ func (p *parser) parseSignedFactor() (ast.Expr, error) {
 		return nil, err
 	}
 
-	if err := p.ignoreAsExpression(); err != nil {
-		return nil, err
-	}
-
-	return expr, nil
+	return p.parseAsExpression(expr)
 }
 
 func (p *parser) parseMapExpr() (*ast.MapDeclExpr, error) {
@@ -3026,14 +3102,12 @@ func (p *parser) parseMapExpr() (*ast.MapDeclExpr, error) {
 		return nil, err
 	}
 
-	exp := &ast.MapDeclExpr{t.Pos, items}
-
-	_, err = p.accept(ast.RBRACE)
+	r, err := p.accept(ast.RBRACE)
 	if err != nil {
 		return nil, err
 	}
 
-	return exp, nil
+	return &ast.MapDeclExpr{Pos: t.Pos, List: items, Rbrace: r.Pos}, nil
 }
 
 func (p *parser) parseIndexDeclExpr() (*ast.ArrayDeclExpr, error) {
@@ -3047,14 +3121,12 @@ func (p *parser) parseIndexDeclExpr() (*ast.ArrayDeclExpr, error) {
 		return nil, err
 	}
 
-	exp := &ast.ArrayDeclExpr{t.Pos, items}
-
-	_, err = p.accept(ast.RBRACK)
+	r, err := p.accept(ast.RBRACK)
 	if err != nil {
 		return nil, err
 	}
 
-	return exp, nil
+	return &ast.ArrayDeclExpr{Pos: t.Pos, List: items, Rbrack: r.Pos}, nil
 }
 func (p *parser) parseMapElementList() ([]ast.KeyValue, error) {
 	var args []ast.KeyValue
@@ -3132,11 +3204,7 @@ func (p *parser) parseIdentExpr() (ast.Expr, error) {
 		t.First = true
 	}
 
-	if err := p.ignoreAsExpression(); err != nil {
-		return nil, err
-	}
-
-	return v, nil
+	return p.parseAsExpression(v)
 }
 
 // parse the right part after a value, for example:
@@ -3242,8 +3310,9 @@ func (p *parser) parseSimpleIdentExpr() (*ast.IdentExpr, error) {
 		return nil, NewError(t.Pos, "Expecting IDENT, got %v", t.Type)
 	}
 
+	start := p.index
 	p.tryIgnoreGenericDecl()
-	return &ast.IdentExpr{t.Pos, t.Str}, nil
+	return &ast.IdentExpr{Pos: t.Pos, Name: t.Str, TypeArgs: p.typeSince(start)}, nil
 }
 
 func (p *parser) parseSelectorExpr(exp ast.Expr, optional bool) (*ast.SelectorExpr, error) {
@@ -3330,14 +3399,27 @@ func (p *parser) parseNewInstanceExpr() (ast.Expr, error) {
 }
 
 func (p *parser) parseFactor() (ast.Expr, error) {
+	start := p.index
 	if err := p.ignoreTypeAssert(); err != nil {
 		return nil, err
 	}
 
+	if typ := p.typeSince(start); typ != "" {
+		exp, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		typ = strings.TrimSuffix(strings.TrimPrefix(typ, "<"), ">")
+		return &ast.TypeAssertExpr{X: exp, Type: typ}, nil
+	}
+
 	t := p.peek()
 	switch t.Type {
 	case ast.HEX:
 		p.next()
+		if p.source {
+			return &ast.ConstantExpr{Pos: t.Pos, Kind: ast.HEX, Value: t.Str}, nil
+		}
 		i, err := strconv.ParseInt(t.Str, 0, 64)
 		if err != nil {
 			return nil, NewError(t.Pos, "Error parsing Hex: %v", err)
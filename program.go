@@ -4,9 +4,12 @@ package dune
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -299,9 +302,11 @@ type Program struct {
 	Classes     []*Class
 	Constants   []Value
 	Files       []string
+	Sources     []string // the code of each file in Files. Not written to binaries.
 	Attributes  []string
 	permissions []string
 	Resources   map[string][]byte
+	Natives     *NativeRegistry // the natives it was compiled with if not the global ones
 
 	kSize   int // the memory for all constants
 	funcMap map[string]*Function
@@ -360,10 +365,20 @@ func (p *Program) Copy() *Program {
 		copy.Files[i] = v
 	}
 
+	if p.Sources != nil {
+		copy.Sources = make([]string, len(p.Sources))
+		for i, v := range p.Sources {
+			copy.Sources[i] = v
+		}
+	}
+
+	copy.Attributes = make([]string, len(p.Attributes))
 	for k, v := range p.Attributes {
 		copy.Attributes[k] = v
 	}
 
+	copy.Natives = p.Natives
+
 	copy.permissions = make([]string, len(p.permissions))
 	for i, v := range p.permissions {
 		copy.permissions[i] = v
@@ -386,8 +401,28 @@ func (p *Program) Copy() *Program {
 	return copy
 }
 
+// NativeFunc returns the native function with the index in
+// the registry of the program or the global one.
+func (p *Program) NativeFunc(i int) (NativeFunction, error) {
+	if p.Natives != nil {
+		return p.Natives.FromIndex(i)
+	}
+	return nativeFromIndex(allNativeFuncs, i)
+}
+
+// NativeFuncFromName returns the native function with the name in
+// the registry of the program or the global one.
+func (p *Program) NativeFuncFromName(name string) (NativeFunction, bool) {
+	if p.Natives != nil {
+		return p.Natives.Lookup(name)
+	}
+	return NativeFuncFromName(name)
+}
+
+// AddPermission grants a permission or a parameterized
+// capability like "net:api.partner.com:443".
 func (p *Program) AddPermission(name string) {
-	p.permissions = append(p.permissions, name)
+	p.permissions = append(p.Permissions(), name)
 }
 
 func (p *Program) HasPermission(name string) bool {
@@ -432,6 +467,7 @@ func (p *Program) Strip() {
 		}
 	}
 	p.Files = nil
+	p.Sources = nil
 }
 
 func (p *Program) FileIndex(file string) int {
@@ -466,7 +502,22 @@ func (p *Program) ToTraceLine(f *Function, pc int) TraceLine {
 		break
 	}
 
-	return TraceLine{Function: f.Name, File: file, Line: pos.Line}
+	return TraceLine{Function: f.Name, File: file, Line: pos.Line, Column: pos.Column}
+}
+
+// SourceLine returns the code of the line of the file if
+// the program has its sources.
+func (p *Program) SourceLine(file string, line int) (string, bool) {
+	i := p.FileIndex(file)
+	if i == -1 || i >= len(p.Sources) || line < 1 {
+		return "", false
+	}
+
+	lines := strings.Split(p.Sources[i], "\n")
+	if line > len(lines) {
+		return "", false
+	}
+	return lines[line-1], true
 }
 
 func (p *Program) initFuncMap() {
@@ -495,6 +546,7 @@ type TraceLine struct {
 	Function string
 	File     string
 	Line     int
+	Column   int
 }
 
 func (p TraceLine) String() string {
@@ -549,6 +601,8 @@ func Sprint(p *Program) (string, error) {
 const separator1 = "\n==============================================================="
 const separator2 = "\n---------------------------------------------------------------"
 
+// Fprint writes the disassembly of the program. The listing contains
+// everything that is needed to read it back with Assemble.
 func Fprint(w io.Writer, p *Program) {
 	if len(p.Attributes) > 0 {
 		fmt.Fprint(w, separator1)
@@ -578,6 +632,18 @@ func Fprint(w io.Writer, p *Program) {
 		fmt.Fprint(w, separator1)
 		for i, c := range p.Classes {
 			fmt.Fprintf(w, "\n%dC Class %s", i, c.Name)
+			if c.Exported {
+				fmt.Fprint(w, " [exported]")
+			}
+			for _, a := range c.Attributes {
+				fmt.Fprintf(w, "\n  attribute %s", a)
+			}
+			for _, f := range c.Fields {
+				fmt.Fprintf(w, "\n  field %s", f.Name)
+				if f.Exported {
+					fmt.Fprint(w, " [exported]")
+				}
+			}
 			for _, f := range c.Getters {
 				FprintFunction(w, "get", p.Functions[f], p)
 			}
@@ -596,9 +662,12 @@ func Fprint(w io.Writer, p *Program) {
 		fmt.Fprint(w, separator1)
 		for i, enum := range p.Enums {
 			fmt.Fprintf(w, "\n%dE %s", i, enum.Name)
+			if enum.Exported {
+				fmt.Fprint(w, " [exported]")
+			}
 			for ii, v := range enum.Values {
 				k := p.Constants[v.KIndex]
-				fmt.Fprintf(w, "\n  %-5d %s=%v", ii, v.Name, k.String())
+				fmt.Fprintf(w, "\n  %-5d %dK %s=%v", ii, v.KIndex, v.Name, k.String())
 			}
 		}
 		fmt.Fprint(w, "\n")
@@ -612,6 +681,33 @@ func Fprint(w io.Writer, p *Program) {
 		FprintConstants(w, p)
 	}
 
+	if len(p.Files) > 0 {
+		fmt.Fprint(w, separator1)
+		fmt.Fprint(w, "\nFiles")
+		fmt.Fprint(w, separator1)
+		fmt.Fprintln(w)
+		for i, f := range p.Files {
+			fmt.Fprintf(w, "%d %s\n", i, strconv.Quote(f))
+		}
+	}
+
+	if len(p.Resources) > 0 {
+		fmt.Fprint(w, separator1)
+		fmt.Fprint(w, "\nResources")
+		fmt.Fprint(w, separator1)
+		fmt.Fprintln(w)
+
+		names := make([]string, 0, len(p.Resources))
+		for k := range p.Resources {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		for _, k := range names {
+			fmt.Fprintf(w, "%s %s\n", strconv.Quote(k), base64.StdEncoding.EncodeToString(p.Resources[k]))
+		}
+	}
+
 	fmt.Fprint(w, "\n")
 }
 
@@ -627,7 +723,7 @@ func FprintFunction(w io.Writer, prefix string, f *Function, p *Program) {
 		}
 	}
 
-	fmt.Fprintf(w, "\n%dF %s", f.Index, name)
+	fmt.Fprintf(w, "\n%dF %s [%s]", f.Index, name, functionProperties(f))
 	fmt.Fprint(w, separator2)
 
 	if len(f.Attributes) > 0 {
@@ -652,34 +748,93 @@ func FprintFunction(w io.Writer, prefix string, f *Function, p *Program) {
 
 	//fmt.Fprintf(w, "\n  MaxRegIndex %d", f.MaxRegIndex)
 	fmt.Fprintln(w)
-	for i, r := range f.Registers {
-		fmt.Fprintf(w, "\n  %d%s %s %d-%d", i, regType, r.Name, r.StartPC, r.EndPC)
+	for _, r := range f.Registers {
+		printRegister(w, r, regType)
+	}
+	for _, r := range f.Closures {
+		printRegister(w, r, "C")
 	}
 
 	fmt.Fprint(w, "\n")
 }
 
+// functionProperties returns the fields of the function that are not
+// visible in the instructions.
+func functionProperties(f *Function) string {
+	props := []string{fmt.Sprintf("regs=%d", f.MaxRegIndex)}
+
+	if f.Arguments > 0 {
+		props = append(props, fmt.Sprintf("args=%d", f.Arguments))
+	}
+	if f.OptionalArguments > 0 {
+		props = append(props, fmt.Sprintf("optional=%d", f.OptionalArguments))
+	}
+	if f.IsClass {
+		props = append(props, fmt.Sprintf("class=%d", f.Class))
+	}
+	if f.WrapClass != -1 {
+		props = append(props, fmt.Sprintf("wrap=%d", f.WrapClass))
+	}
+	if f.Variadic {
+		props = append(props, "variadic")
+	}
+	if f.Exported {
+		props = append(props, "exported")
+	}
+	if f.Anonimous {
+		props = append(props, "anonymous")
+	}
+	if f.IsGlobal {
+		props = append(props, "global")
+	}
+
+	return strings.Join(props, " ")
+}
+
+func printRegister(w io.Writer, r *Register, regType string) {
+	fmt.Fprintf(w, "\n  %d%s %s %d-%d", r.Index, regType, r.Name, r.StartPC, r.EndPC)
+	if r.Exported {
+		fmt.Fprint(w, " [exported]")
+	}
+}
+
 func printInstruction(w io.Writer, p *Program, f *Function, i int, instr *Instruction) {
-	fmt.Fprintf(w, "\n  %-5d %s", i, instr.Format(true))
+	fmt.Fprintf(w, "\n  %-5d %-15s %6s %6s %6s", i, strings.Title(instr.Opcode.String()[3:]),
+		operand(p, instr.A), operand(p, instr.B), operand(p, instr.C))
 
+	// the positions are printed as they are, without filling the gaps
+	// with the previous line, so they can be read back
 	if len(f.Positions) > i {
-		t := p.ToTraceLine(f, i)
-		fmt.Fprintf(w, "   ;   %s", t.String())
+		if pos := f.Positions[i]; pos.Line > 0 {
+			if pos.File < len(p.Files) {
+				fmt.Fprintf(w, "   ;   %s:%d", p.Files[pos.File], pos.Line)
+			} else {
+				fmt.Fprintf(w, "   ;   line %d", pos.Line)
+			}
+		}
 	}
+}
 
-	fmt.Fprint(w)
+// operand returns the address with the name of native functions because
+// their index depends on the libraries registered by the process.
+func operand(p *Program, a *Address) string {
+	if a.Kind == AddrNativeFunc {
+		if f, _ := p.NativeFunc(int(a.Value)); f.Name != "" {
+			return "N(" + f.Name + ")"
+		}
+	}
+	return a.String()
 }
 
 func FprintConstants(w io.Writer, p *Program) {
 	for i, k := range p.Constants {
 		switch k.Type {
 		case String:
-			s := k.String()
-			if len(s) > 50 {
-				s = s[:50]
-			}
-			s = strings.Replace(s, "\n", "\\n", -1)
-			fmt.Fprintf(w, "%dK string %v\n", i, s)
+			fmt.Fprintf(w, "%dK string %s\n", i, strconv.Quote(k.String()))
+		case Rune:
+			fmt.Fprintf(w, "%dK rune %s\n", i, strconv.QuoteRune(k.ToRune()))
+		case Float:
+			fmt.Fprintf(w, "%dK float %s\n", i, strconv.FormatFloat(k.ToFloat(), 'g', -1, 64))
 		default:
 			fmt.Fprintf(w, "%dK %v %v\n", i, k.Type, k.String())
 		}
@@ -0,0 +1,169 @@
+package dune
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// The kinds of resources accounted per VM.
+const (
+	ResourceFile       = "file"
+	ResourceConnection = "connection"
+	ResourceTimer      = "timer"
+	ResourceGoroutine  = "goroutine"
+)
+
+// ErrClosed is the error of a VM that has been closed.
+var ErrClosed = errors.New("the vm has been closed")
+
+// QuotaError is returned when a VM reaches the limit of a kind of resource.
+// Scripts can catch it.
+type QuotaError struct {
+	Kind  string
+	Limit int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded: limit of %d %ss", e.Limit, e.Kind)
+}
+
+// Resources tracks the files, connections, timers and goroutines that a VM
+// opens. The goroutines launched by the VM share its resources.
+type Resources struct {
+	mu     sync.Mutex
+	limits map[string]int
+	counts map[string]int
+	open   map[io.Closer]string
+	closed int32
+}
+
+func NewResources() *Resources {
+	return &Resources{
+		limits: make(map[string]int),
+		counts: make(map[string]int),
+		open:   make(map[io.Closer]string),
+	}
+}
+
+// SetLimit sets the maximum number of resources of the kind open at the
+// same time. Zero means no limit.
+func (r *Resources) SetLimit(kind string, n int) {
+	r.mu.Lock()
+	r.limits[kind] = n
+	r.mu.Unlock()
+}
+
+func (r *Resources) Limit(kind string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limits[kind]
+}
+
+// Limits returns a copy of the limits.
+func (r *Resources) Limits() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := make(map[string]int, len(r.limits))
+	for k, v := range r.limits {
+		m[k] = v
+	}
+	return m
+}
+
+// Count returns the number of resources of the kind open.
+func (r *Resources) Count(kind string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[kind]
+}
+
+// Acquire counts a resource of the kind. It returns a QuotaError
+// if the limit has been reached.
+func (r *Resources) Acquire(kind string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.acquire(kind)
+}
+
+func (r *Resources) acquire(kind string) error {
+	if r.isClosed() {
+		return ErrClosed
+	}
+
+	if l := r.limits[kind]; l > 0 && r.counts[kind] >= l {
+		return &QuotaError{Kind: kind, Limit: l}
+	}
+
+	r.counts[kind]++
+	return nil
+}
+
+// Release discounts a resource acquired with Acquire.
+func (r *Resources) Release(kind string) {
+	r.mu.Lock()
+	if r.counts[kind] > 0 {
+		r.counts[kind]--
+	}
+	r.mu.Unlock()
+}
+
+// Track counts the resource and keeps it to close it when the VM is closed.
+func (r *Resources) Track(kind string, c io.Closer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.acquire(kind); err != nil {
+		return err
+	}
+
+	r.open[c] = kind
+	return nil
+}
+
+// Untrack discounts the resource. Call it when it is closed.
+func (r *Resources) Untrack(c io.Closer) {
+	r.mu.Lock()
+	if kind, ok := r.open[c]; ok {
+		delete(r.open, c)
+		r.counts[kind]--
+	}
+	r.mu.Unlock()
+}
+
+func (r *Resources) isClosed() bool {
+	return atomic.LoadInt32(&r.closed) == 1
+}
+
+// Close closes all the resources. The VMs that share them
+// stop with ErrClosed.
+func (r *Resources) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return nil
+	}
+
+	r.mu.Lock()
+	open := make([]io.Closer, 0, len(r.open))
+	for c := range r.open {
+		open = append(open, c)
+	}
+	r.open = make(map[io.Closer]string)
+	r.counts = make(map[string]int)
+	r.mu.Unlock()
+
+	var err error
+	for _, c := range open {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close stops the VM and the goroutines that it launched and closes
+// the files, connections and timers that they opened.
+func (vm *VM) Close() error {
+	return vm.Resources.Close()
+}
@@ -0,0 +1,119 @@
+package dune
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCloser struct {
+	closed bool
+}
+
+func (c *testCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestResources(t *testing.T) {
+	r := NewResources()
+	r.SetLimit(ResourceFile, 2)
+
+	a, b, c := &testCloser{}, &testCloser{}, &testCloser{}
+
+	if err := r.Track(ResourceFile, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Track(ResourceFile, b); err != nil {
+		t.Fatal(err)
+	}
+
+	var qe *QuotaError
+	if err := r.Track(ResourceFile, c); !errors.As(err, &qe) || qe.Limit != 2 {
+		t.Fatal(err)
+	}
+
+	r.Untrack(a)
+	if err := r.Track(ResourceFile, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := r.Count(ResourceFile); n != 2 {
+		t.Fatalf("expected 2, got %d", n)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.closed || !b.closed || !c.closed {
+		t.Fatalf("%v %v %v", a.closed, b.closed, c.closed)
+	}
+
+	if err := r.Acquire(ResourceGoroutine); err != ErrClosed {
+		t.Fatal(err)
+	}
+}
+
+func TestQuotaErrorCatchable(t *testing.T) {
+	AddNativeFunc(NativeFunction{
+		Name: "resourcesTest.open",
+		Function: func(this Value, args []Value, vm *VM) (Value, error) {
+			if err := vm.Resources.Track(ResourceFile, &testCloser{}); err != nil {
+				return NullValue, err
+			}
+			return TrueValue, nil
+		},
+	})
+
+	p, err := CompileStr(`
+		function main() {
+			let n = 0
+			try {
+				for (let i = 0; i < 10; i++) {
+					resourcesTest.open()
+					n++
+				}
+			} catch (e) {
+				return n + " " + e.message
+			}
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+	vm.Resources.SetLimit(ResourceFile, 3)
+
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(v.String(), "3 quota exceeded: limit of 3 files") {
+		t.Fatal(v)
+	}
+}
+
+func TestCloseVM(t *testing.T) {
+	p, err := CompileStr(`
+		function main() {
+			while (true) {}
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVM(p)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		vm.Close()
+	}()
+
+	if _, err := vm.Run(); err != ErrClosed {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+package dune
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// scheduler runs the goroutines of a deterministic VM one at a time.
+// A goroutine keeps the turn until it blocks or ends and then the next
+// one in the order they were launched continues, so they are interleaved
+// the same way in every run.
+type scheduler struct {
+	mu    sync.Mutex
+	root  *task
+	tasks []*task
+
+	// idle counts the waits that found nothing to do since the last
+	// progress. When it goes over the number of tasks all of them are
+	// blocked and only the outside world can wake them.
+	idle int
+
+	// done is closed when the root stops waiting for the tasks.
+	done chan struct{}
+}
+
+type task struct {
+	turn chan struct{}
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{done: make(chan struct{})}
+	s.root = &task{turn: make(chan struct{}, 1)}
+	s.tasks = []*task{s.root}
+	return s
+}
+
+func (s *scheduler) add() *task {
+	t := &task{turn: make(chan struct{}, 1)}
+	s.mu.Lock()
+	s.tasks = append(s.tasks, t)
+	s.idle = 0
+	s.mu.Unlock()
+	return t
+}
+
+func (s *scheduler) indexOf(t *task) int {
+	for i, v := range s.tasks {
+		if v == t {
+			return i
+		}
+	}
+	return -1
+}
+
+// next gives the turn to the task after t and returns the channel
+// that is closed if t is never going to get it back.
+func (s *scheduler) next(t *task) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(t)
+	if i == -1 {
+		return s.done
+	}
+	s.tasks[(i+1)%len(s.tasks)].turn <- struct{}{}
+	return s.done
+}
+
+// yield gives the turn to the next task and waits until it comes back.
+// The tasks that are abandoned when the program ends exit here.
+func (s *scheduler) yield(t *task) {
+	done := s.next(t)
+	select {
+	case <-t.turn:
+	case <-done:
+		runtime.Goexit()
+	}
+}
+
+// start waits for the first turn of the task.
+func (s *scheduler) start(t *task) bool {
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+
+	select {
+	case <-t.turn:
+		s.progress()
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// exit removes the task and gives the turn to the one after it.
+func (s *scheduler) exit(t *task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(t)
+	if i == -1 {
+		return
+	}
+	s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+	s.idle = 0
+	s.tasks[i%len(s.tasks)].turn <- struct{}{}
+}
+
+func (s *scheduler) progress() {
+	s.mu.Lock()
+	s.idle = 0
+	s.mu.Unlock()
+}
+
+// blocked records a wait that found nothing to do and returns
+// true if all the tasks have since the last progress.
+func (s *scheduler) blocked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idle++
+	return s.idle > len(s.tasks)
+}
+
+func (s *scheduler) running() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tasks)
+}
+
+// stop abandons the tasks that are still blocked.
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	close(s.done)
+	s.done = make(chan struct{})
+	s.tasks = []*task{s.root}
+	s.idle = 0
+	s.mu.Unlock()
+}
+
+// Go runs f in a new goroutine. If the VM is deterministic it is a task of
+// its scheduler: it starts when the goroutines launched before it block or
+// end and it runs alone until it blocks or ends too.
+func (vm *VM) Go(f func()) {
+	s := vm.scheduler
+	if s == nil {
+		go f()
+		return
+	}
+
+	t := s.add()
+	vm.task = t
+
+	go func() {
+		if !s.start(t) {
+			return
+		}
+		defer s.exit(t)
+		f()
+	}()
+}
+
+// Wait blocks until ready returns true. The natives that block call it when
+// the VM is deterministic so the other goroutines run while they wait.
+func (vm *VM) Wait(ready func() bool) error {
+	s := vm.scheduler
+	for {
+		if vm.Resources != nil && vm.Resources.isClosed() {
+			return ErrClosed
+		}
+
+		if ready() {
+			if s != nil {
+				s.progress()
+			}
+			return nil
+		}
+
+		if s == nil || vm.task == nil {
+			// not a task, like the function of a timer.
+			time.Sleep(time.Millisecond)
+			continue
+		}
+
+		if s.blocked() {
+			// all are waiting for the outside world, like a timer.
+			time.Sleep(time.Millisecond)
+		}
+
+		s.yield(vm.task)
+	}
+}
+
+// waitTasks runs the goroutines launched by a deterministic VM until they
+// end. Those that are blocked when all the others are too are not resumed,
+// like in a Go program that returns from main.
+func (vm *VM) waitTasks() {
+	s := vm.scheduler
+	if s == nil || vm.task != s.root {
+		return
+	}
+
+	for s.running() > 1 {
+		if s.blocked() {
+			break
+		}
+		s.yield(vm.task)
+	}
+
+	s.stop()
+}
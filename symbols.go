@@ -0,0 +1,140 @@
+package dune
+
+import (
+	"github.com/dunelang/dune/ast"
+	"github.com/dunelang/dune/filesystem"
+	"github.com/dunelang/dune/parser"
+)
+
+type SymbolKind int
+
+const (
+	SymbolVariable SymbolKind = iota
+	SymbolConstant
+	SymbolParameter
+	SymbolFunction
+	SymbolClass
+	SymbolEnum
+)
+
+func (k SymbolKind) String() string {
+	switch k {
+	case SymbolVariable:
+		return "variable"
+	case SymbolConstant:
+		return "constant"
+	case SymbolParameter:
+		return "parameter"
+	case SymbolFunction:
+		return "function"
+	case SymbolClass:
+		return "class"
+	case SymbolEnum:
+		return "enum"
+	default:
+		return "unknown"
+	}
+}
+
+// Symbol is a declaration and all the places where the compiler
+// resolved a name to it.
+type Symbol struct {
+	Name       string
+	Kind       SymbolKind
+	Pos        ast.Position
+	References []ast.Position
+}
+
+// Symbols is the index of declarations and references of a program.
+type Symbols struct {
+	List []*Symbol
+	keys map[interface{}]*Symbol
+}
+
+func newSymbols() *Symbols {
+	return &Symbols{keys: make(map[interface{}]*Symbol)}
+}
+
+func (s *Symbols) get(key interface{}) *Symbol {
+	sym, ok := s.keys[key]
+	if !ok {
+		sym = &Symbol{}
+		s.keys[key] = sym
+		s.List = append(s.List, sym)
+	}
+	return sym
+}
+
+// Find returns the symbol named name that is declared or referenced
+// in the line of the file.
+func (s *Symbols) Find(file string, line int, name string) *Symbol {
+	for _, sym := range s.List {
+		if sym.Name != name {
+			continue
+		}
+		if sym.Pos.FileName == file && sym.Pos.Line == line {
+			return sym
+		}
+		for _, r := range sym.References {
+			if r.FileName == file && r.Line == line {
+				return sym
+			}
+		}
+	}
+	return nil
+}
+
+// InFile returns the symbols declared in a file.
+func (s *Symbols) InFile(file string) []*Symbol {
+	var list []*Symbol
+	for _, sym := range s.List {
+		if sym.Pos.FileName == file {
+			list = append(list, sym)
+		}
+	}
+	return list
+}
+
+// CompileSymbols compiles the program recording the declarations and
+// references of the names. The symbols found are returned even
+// if the compilation fails.
+func CompileSymbols(fs filesystem.FS, path string) (*Program, *Symbols, error) {
+	a, err := parser.Parse(fs, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := NewCompiler()
+	c.symbols = newSymbols()
+	p, err := c.Compile(a)
+	return p, c.symbols, err
+}
+
+// declare records the last register of the current function as a
+// declaration in the source.
+func (c *compiler) declare(name string, kind SymbolKind, pos ast.Position) {
+	if c.symbols == nil {
+		return
+	}
+	regs := c.currentFunc.function.Registers
+	c.declareSymbol(regs[len(regs)-1], name, kind, pos)
+}
+
+func (c *compiler) declareSymbol(key interface{}, name string, kind SymbolKind, pos ast.Position) {
+	if c.symbols == nil {
+		return
+	}
+	sym := c.symbols.get(key)
+	sym.Name = name
+	sym.Kind = kind
+	sym.Pos = pos
+}
+
+// reference records that the last name found by findRegister is used in pos.
+func (c *compiler) reference(pos ast.Position) {
+	if c.symbols == nil || c.lastSymbol == nil {
+		return
+	}
+	sym := c.symbols.get(c.lastSymbol)
+	sym.References = append(sym.References, pos)
+}
@@ -0,0 +1,83 @@
+package dune
+
+import (
+	"testing"
+
+	"github.com/dunelang/dune/filesystem"
+)
+
+func TestSymbols(t *testing.T) {
+	fs := filesystem.NewVirtualFS()
+	filesystem.WritePath(fs, "main.ts", []byte(`
+let total = 1
+
+function add(a: number) {
+	return total + a
+}
+
+function main() {
+	let f = () => add(total)
+	return f() + later
+}
+
+const later = 2
+`))
+
+	_, symbols, err := CompileSymbols(fs, "main.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := symbols.Find("/main.ts", 5, "total")
+	if total == nil {
+		t.Fatal("total not found")
+	}
+
+	if total.Kind != SymbolVariable || total.Pos.Line != 2 {
+		t.Fatalf("%+v", total)
+	}
+
+	if len(total.References) != 2 || total.References[1].Line != 9 {
+		t.Fatalf("%+v", total.References)
+	}
+
+	add := symbols.Find("/main.ts", 9, "add")
+	if add == nil || add.Kind != SymbolFunction || add.Pos.Line != 4 {
+		t.Fatalf("%+v", add)
+	}
+
+	a := symbols.Find("/main.ts", 5, "a")
+	if a == nil || a.Kind != SymbolParameter || len(a.References) != 1 {
+		t.Fatalf("%+v", a)
+	}
+
+	// referenced before declared, resolved at the end of the compilation
+	later := symbols.Find("/main.ts", 10, "later")
+	if later == nil || later.Kind != SymbolConstant || later.Pos.Line != 13 {
+		t.Fatalf("%+v", later)
+	}
+}
+
+func TestSymbolsCompileError(t *testing.T) {
+	fs := filesystem.NewVirtualFS()
+	filesystem.WritePath(fs, "main.ts", []byte(`
+function main() {
+	let x = 1
+	return x + y
+}
+`))
+
+	_, symbols, err := CompileSymbols(fs, "main.ts")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	e, ok := err.(CompilerError)
+	if !ok || e.Position().Line != 4 {
+		t.Fatal(err)
+	}
+
+	if x := symbols.Find("/main.ts", 4, "x"); x == nil || x.Pos.Line != 3 {
+		t.Fatalf("%+v", x)
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/dunelang/dune"
+	"github.com/dunelang/dune/filesystem"
+	"github.com/dunelang/dune/parser"
+)
+
+// TestFormat formats the tests and runs them again.
+func TestFormat(t *testing.T) {
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, "_test.ts") {
+			continue
+		}
+
+		code, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		formatted, err := parser.Format(string(code), name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		again, err := parser.Format(string(formatted), name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if string(again) != string(formatted) {
+			t.Fatalf("%s: the format is not stable", name)
+		}
+
+		fs := filesystem.NewVirtualFS()
+		filesystem.WritePath(fs, name, formatted)
+
+		p, err := dune.Compile(fs, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		p.AddPermission("trusted")
+
+		for _, fn := range p.Functions {
+			if !strings.HasPrefix(fn.Name, "test") {
+				continue
+			}
+			vm := dune.NewVM(p)
+			if _, err = vm.RunFunc(fn.Name); err != nil {
+				t.Errorf("%s: %s: %v", name, fn.Name, err)
+			}
+		}
+	}
+}
@@ -422,46 +422,30 @@ func (v Value) ToObjectOrNil() interface{} {
 	return v.object
 }
 
+// Size returns an estimate of the memory used by the value. Arrays and maps
+// count their entries but not the size of the values that they contain.
 func (v Value) Size() int {
 	switch v.Type {
 	case String:
 		return len(v.object.(string))
+	case Bytes:
+		return len(v.object.([]byte))
+	case Array:
+		return len(v.object.(*NewArrayObject).Array) + 1
+	case Map:
+		m := v.object.(*MapValue)
+		m.RLock()
+		n := len(m.Map)
+		m.RUnlock()
+		return n*2 + 1
+	case Object:
+		if a, ok := v.object.(Allocator); ok {
+			return a.Size()
+		}
+		return 1
 	default:
-		// because of recursive dangers: an array or map that contains itself
 		return 1
 	}
-
-	// switch v.Type {
-	// case NullType, IntType, FloatType, BoolType, FunctionType, NativeFunctionType,
-	// 	UndefinedType, RuneType:
-	// 	return 1
-	// case StringType:
-	// 	return len(v.ToString())
-	// case BytesType:
-	// 	return len(v.ToBytes())
-	// case ArrayType:
-	// 	var i int
-	// 	for _, v := range v.ToArray() {
-	// 		i += v.Size()
-	// 	}
-	// 	return i
-	// case MapType:
-	// 	var i int
-	// 	m := v.ToMap()
-	// 	m.Mutex.RLock()
-	// 	for _, v := range m.Map {
-	// 		i += v.Size()
-	// 	}
-	// 	m.Mutex.RUnlock()
-	// 	return i
-	// case ObjectType:
-	// 	if a, ok := v.Obj.(Allocator); ok {
-	// 		return a.Size()
-	// 	}
-	// 	return 1
-	// default:
-	// 	panic("unknown type: " + v.Type.String())
-	// }
 }
 
 func (v Value) ExportMarshal(recursionLevel int) interface{} {
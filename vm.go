@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -51,7 +54,7 @@ func ParseExpr(code string) (ast.Expr, error) {
 }
 
 func NewVM(p *Program) *VM {
-	vm := &VM{Program: p}
+	vm := &VM{Program: p, Natives: p.Natives, Resources: NewResources()}
 
 	globalFrame := &stackFrame{
 		funcIndex: 0,
@@ -66,6 +69,8 @@ func NewVM(p *Program) *VM {
 func NewInitializedVM(p *Program, globals []Value) *VM {
 	vm := &VM{
 		Program:     p,
+		Natives:     p.Natives,
+		Resources:   NewResources(),
 		initialized: true,
 	}
 
@@ -100,6 +105,30 @@ type VM struct {
 	Stdout         io.Writer
 	Stderr         io.Writer
 
+	// Natives replaces the global native functions. By default
+	// it is the registry the program was compiled with.
+	Natives *NativeRegistry
+
+	// Resources are the files, connections, timers and goroutines
+	// opened by the VM. Set their limits with SetLimit.
+	Resources *Resources
+
+	// Audit, if set, grants all the permissions and records
+	// the ones that the program uses.
+	Audit *Audit
+
+	// Origin is the stack that launched the VM to run async work,
+	// like a goroutine or a timer. Errors include it in their traces.
+	Origin []TraceLine
+
+	auditing *auditCall
+
+	deterministic bool
+	rand          *rand.Rand
+	nativeLog     *nativeLog
+	scheduler     *scheduler
+	task          *task
+
 	fp           int
 	steps        int64
 	allocations  int64
@@ -110,6 +139,12 @@ type VM struct {
 	optchainDest *Address
 	optchainSrc  *Address
 	frameCache   []*stackFrame
+	debugger     *Debugger
+
+	// the count of allocations that triggers the next measure
+	// of the live memory and how many have been done.
+	nextMemoryWalk int64
+	memoryWalks    int
 }
 
 func (vm *VM) GetStdin() io.Reader {
@@ -137,24 +172,26 @@ func (vm *VM) Allocations() int64 {
 	return vm.allocations
 }
 
+// Steps returns the steps executed. It can be called from
+// other goroutines while the VM is running.
 func (vm *VM) Steps() int64 {
-	return vm.steps
+	return atomic.LoadInt64(&vm.steps)
 }
 
 func (vm *VM) ResetSteps() {
-	vm.steps = 0
+	atomic.StoreInt64(&vm.steps, 0)
 }
 
 func (vm *VM) AddSteps(n int64) error {
 	if vm.MaxSteps > 0 {
-		vm.steps += n
+		steps := atomic.AddInt64(&vm.steps, n)
 
 		// Go doesn't check overflows
-		if vm.steps < 0 {
-			return vm.NewError("Step limit overflow: %d", vm.steps)
+		if steps < 0 {
+			return vm.NewError("Step limit overflow: %d", steps)
 		}
 
-		if vm.steps > vm.MaxSteps {
+		if steps > vm.MaxSteps {
 			return vm.NewError("Step limit reached: %d", vm.MaxSteps)
 		}
 	}
@@ -167,6 +204,14 @@ func (vm *VM) CurrentFunc() *Function {
 }
 
 func (vm *VM) HasPermission(name string) bool {
+	if vm.Audit != nil {
+		vm.Audit.record(vm, name)
+		return true
+	}
+	return vm.hasPermission(name)
+}
+
+func (vm *VM) hasPermission(name string) bool {
 	if vm.Program.HasPermission(name) {
 		return true
 	}
@@ -184,6 +229,9 @@ func (vm *VM) Clone(p *Program) *VM {
 	m.MaxFrames = vm.MaxFrames
 	m.MaxSteps = vm.MaxSteps
 	m.FileSystem = vm.FileSystem
+	if p.Natives == nil {
+		m.Natives = vm.Natives
+	}
 	m.Context = vm.Context
 	m.Language = vm.Language
 	m.Localizer = vm.Localizer
@@ -192,6 +240,8 @@ func (vm *VM) Clone(p *Program) *VM {
 	m.Stdout = vm.Stdout
 	m.Stderr = vm.Stderr
 	m.Now = vm.Now
+	m.Audit = vm.Audit
+	vm.inheritDeterminism(m)
 	return m
 }
 
@@ -201,6 +251,9 @@ func (vm *VM) CloneInitialized(p *Program, globals []Value) *VM {
 	m.MaxFrames = vm.MaxFrames
 	m.MaxSteps = vm.MaxSteps
 	m.FileSystem = vm.FileSystem
+	if p.Natives == nil {
+		m.Natives = vm.Natives
+	}
 	m.Context = vm.Context
 	m.Language = vm.Language
 	m.Localizer = vm.Localizer
@@ -209,6 +262,8 @@ func (vm *VM) CloneInitialized(p *Program, globals []Value) *VM {
 	m.Stdout = vm.Stdout
 	m.Stderr = vm.Stderr
 	m.Now = vm.Now
+	m.Audit = vm.Audit
+	vm.inheritDeterminism(m)
 	return m
 }
 
@@ -253,10 +308,13 @@ func (vm *VM) Run(args ...Value) (Value, error) {
 	if !ok {
 		// cleanup if there is nothing more to do
 		vm.cleanupFrame(0)
+		vm.waitTasks()
 		return vm.RetValue, nil
 	}
 
-	return vm.runFunc(f, false, UndefinedValue, true, nil, args...)
+	v, err := vm.runFunc(f, false, UndefinedValue, true, nil, args...)
+	vm.waitTasks()
+	return v, err
 }
 
 // RunFunc executes a function by name
@@ -346,7 +404,7 @@ func (vm *VM) runFunc(f *Function, isMethod bool, this Value, finalizeGlobals bo
 				}
 				v := args[i]
 				locals[i] = v
-				if err := vm.AddAllocations(v.Size()); err != nil {
+				if err := vm.allocate(v); err != nil {
 					return NullValue, err
 				}
 			}
@@ -354,7 +412,7 @@ func (vm *VM) runFunc(f *Function, isMethod bool, this Value, finalizeGlobals bo
 		// set the variadic as an array with the rest of the parameters
 		if lenArgs > regularArgs {
 			v := NewArrayValues(args[regularArgs:])
-			if err := vm.AddAllocations(v.Size()); err != nil {
+			if err := vm.allocate(v); err != nil {
 				return NullValue, err
 			}
 			locals[regularArgs] = v
@@ -369,7 +427,7 @@ func (vm *VM) runFunc(f *Function, isMethod bool, this Value, finalizeGlobals bo
 				break
 			}
 			v := args[i]
-			if err := vm.AddAllocations(v.Size()); err != nil {
+			if err := vm.allocate(v); err != nil {
 				return NullValue, err
 			}
 			locals[i] = v
@@ -450,6 +508,48 @@ func (vm *VM) RegisterValue(name string) (Value, bool) {
 	return NullValue, false
 }
 
+// GlobalVariables returns the values of the global registers.
+func (vm *VM) GlobalVariables() []Variable {
+	f := vm.Program.Functions[0]
+	values := vm.callStack[0].values
+
+	var vars []Variable
+	for _, r := range f.Registers {
+		if r.Name == "" || r.Name[0] == '@' || strings.HasPrefix(r.Name, GlobalNamespace) {
+			continue
+		}
+		if r.Index < len(values) {
+			vars = append(vars, Variable{Name: r.Name, Value: values[r.Index]})
+		}
+	}
+	return vars
+}
+
+// Members returns the keys of a map or the fields and methods of an instance.
+func (vm *VM) Members(v Value) []string {
+	var names []string
+
+	switch v.Type {
+	case Map:
+		m := v.ToMap()
+		m.RLock()
+		for k := range m.Map {
+			if k.Type == String {
+				names = append(names, k.String())
+			}
+		}
+		m.RUnlock()
+
+	case Object:
+		if i, ok := v.ToObject().(*instance); ok {
+			names = i.members(vm.Program)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
 func (vm *VM) SetFinalizer(v Finalizable) {
 	frame := vm.callStack[vm.fp]
 	frame.finalizables = append(frame.finalizables, v)
@@ -488,7 +588,7 @@ func (vm *VM) get(a *Address) Value {
 }
 
 func (vm *VM) set(a *Address, v Value) {
-	if err := vm.AddAllocations(v.Size()); err != nil {
+	if err := vm.allocate(v); err != nil {
 		vm.Error = err
 		return
 	}
@@ -507,18 +607,6 @@ func (vm *VM) set(a *Address, v Value) {
 	}
 }
 
-func (vm *VM) AddAllocations(size int) error {
-	if vm.MaxAllocations == 0 {
-		return nil
-	}
-
-	vm.allocations += int64(size)
-	if vm.allocations > vm.MaxAllocations {
-		return vm.NewError("Max allocations reached: %d", vm.MaxAllocations)
-	}
-	return nil
-}
-
 func (vm *VM) setPrototype(name string, this Value, dst *Address) bool {
 	if m, ok := vm.getNativePrototype(name, this); ok {
 		vm.set(dst, NewObject(m))
@@ -533,7 +621,7 @@ func (vm *VM) setPrototype(name string, this Value, dst *Address) bool {
 }
 
 func (vm *VM) getNativePrototype(name string, this Value) (nativePrototype, bool) {
-	f, ok := allNativeMap[name]
+	f, ok := vm.LookupNative(name)
 	if ok {
 		return nativePrototype{this: this, fn: f.Index}, true
 	}
@@ -558,6 +646,15 @@ func (vm *VM) Stacktrace() []string {
 	return s
 }
 
+// SpawnTrace returns the current stack followed by the origin of the VM.
+// Set it as the Origin of the VMs that run work launched from here.
+func (vm *VM) SpawnTrace() []TraceLine {
+	st := vm.stackTrace()
+	trace := make([]TraceLine, 0, len(st)+len(vm.Origin))
+	trace = append(trace, st...)
+	return append(trace, vm.Origin...)
+}
+
 func (vm *VM) stackTrace() []TraceLine {
 	var trace []TraceLine
 
@@ -607,6 +704,12 @@ func (vm *VM) WrapError(err error) *VMError {
 			return t
 		}
 		t.TraceLines = append(t.TraceLines, vm.stackTrace()...)
+		if t.Origin == nil {
+			t.Origin = vm.Origin
+		}
+		if t.program == nil {
+			t.program = vm.Program
+		}
 		return t
 	case ErrorMessenger:
 		msg = t.ErrorMessage()
@@ -618,8 +721,10 @@ func (vm *VM) WrapError(err error) *VMError {
 	return &VMError{
 		Message:     msg,
 		TraceLines:  vm.stackTrace(),
+		Origin:      vm.Origin,
 		instruction: vm.instruction(),
 		goError:     goError,
+		program:     vm.Program,
 	}
 }
 
@@ -640,6 +745,8 @@ func (vm *VM) NewError(format string, a ...interface{}) *VMError {
 		Message:     format,
 		instruction: vm.instruction(),
 		TraceLines:  st,
+		Origin:      vm.Origin,
+		program:     vm.Program,
 	}
 }
 
@@ -692,6 +799,9 @@ func (vm *VM) returnFromFinally() bool {
 func (vm *VM) handle(err error) bool {
 	ln := len(vm.tryCatchs)
 	if ln == 0 {
+		if vm.debugger != nil {
+			vm.debugger.onException(vm, err)
+		}
 		vm.cleanupNotGlobalFrame(vm.fp)
 		vm.Error = err
 		return false
@@ -848,14 +958,33 @@ func (vm *VM) run(finalizeGlobals bool) {
 	// Print(p)
 
 	for {
+		if vm.Resources != nil && vm.Resources.isClosed() {
+			vm.Error = ErrClosed
+			for i := vm.fp; i > 0; i-- {
+				vm.cleanupFrame(i)
+			}
+			return
+		}
+
 		if vm.MaxSteps > 0 {
-			vm.steps++
-			if vm.steps > vm.MaxSteps {
+			if atomic.AddInt64(&vm.steps, 1) > vm.MaxSteps {
 				vm.Error = vm.NewError("Step limit reached: %d", vm.MaxSteps)
 				return
 			}
 		}
 
+		if vm.debugger != nil {
+			if err := vm.debugger.onInstruction(vm); err != nil {
+				vm.Error = err
+				for i := vm.fp; i > 0; i-- {
+					vm.cleanupFrame(i)
+				}
+				return
+			}
+			// the program can be replaced while paused
+			p = vm.Program
+		}
+
 		frame := vm.callStack[vm.fp]
 		f := p.Functions[frame.funcIndex]
 		i := f.Instructions[frame.pc]
@@ -1072,20 +1201,28 @@ func (vm *VM) callProgramFunc(f *Function, retAddr *Address, args []Value, isMet
 }
 
 func (vm *VM) callNativeFunc(i int, args []Value, retAddress *Address, this Value) error {
-	f := allNativeFuncs[i]
+	f, err := vm.NativeFunc(i)
+	if err != nil {
+		return err
+	}
 
 	l := f.Arguments
 	if l != -1 && l != len(args) {
 		return fmt.Errorf("function '%s' expects %d parameters, got %d", f.Name, l, len(args))
 	}
 
-	for _, perm := range f.Permissions {
-		if !vm.HasPermission(perm) {
-			return errors.New("unauthorized")
+	var ret Value
+
+	if vm.Audit != nil {
+		ret, err = vm.callAudited(f, this, args)
+	} else {
+		for _, perm := range f.Permissions {
+			if !vm.hasPermission(perm) && !vm.hasGrantFor(perm) {
+				return &CapabilityError{Capability: perm}
+			}
 		}
+		ret, err = vm.callNative(f, this, args)
 	}
-
-	ret, err := f.Function(this, args, vm)
 	if err != nil {
 		return err
 	}
@@ -1097,6 +1234,33 @@ func (vm *VM) callNativeFunc(i int, args []Value, retAddress *Address, this Valu
 	return nil
 }
 
+// NativeFunc returns the native function with the index
+// in the registry of the VM or the global one.
+func (vm *VM) NativeFunc(i int) (NativeFunction, error) {
+	if vm.Natives != nil {
+		return vm.Natives.FromIndex(i)
+	}
+	return nativeFromIndex(allNativeFuncs, i)
+}
+
+// LookupNative returns the native function with the name if
+// it is available in the registry of the VM or the global one.
+func (vm *VM) LookupNative(name string) (NativeFunction, bool) {
+	if vm.Natives != nil {
+		return vm.Natives.Lookup(name)
+	}
+	f, ok := allNativeMap[name]
+	return f, ok
+}
+
+// NativeFuncs returns the native functions available to the VM.
+func (vm *VM) NativeFuncs() []NativeFunction {
+	if vm.Natives != nil {
+		return vm.Natives.Funcs()
+	}
+	return allNativeFuncs
+}
+
 func (vm *VM) callNativeMethod(m NativeMethod, args []Value, retAddress *Address) error {
 	ret, err := m(args, vm)
 	if err != nil {
@@ -1122,7 +1286,7 @@ func (vm *VM) setToObject(instr *Instruction) error {
 		}
 	}
 
-	if err := vm.AddAllocations(cv.Size()); err != nil {
+	if err := vm.allocate(cv); err != nil {
 		return err
 	}
 
@@ -1636,6 +1800,44 @@ func eval(vm *VM, code string) error {
 	return nil
 }
 
+// EvalValue returns the value of an expression evaluated in the global
+// scope of the vm without printing it.
+func EvalValue(vm *VM, expr string) (Value, error) {
+	return evalFunc(vm, "return "+expr, nil, nil)
+}
+
+// EvalShared evaluates the code in a copy of the vm that shares its globals
+// so it can be used while the program is running. If the code is an
+// expression its value is returned. Declarations are local to the evaluation.
+func EvalShared(vm *VM, code string) (Value, error) {
+	if _, err := parser.ParseExpr(fmt.Sprintf("print(%s)", code)); err == nil {
+		return EvalValue(vm, code)
+	}
+	return evalFunc(vm, code, nil, nil)
+}
+
+// evalFunc compiles a function with the params and body and runs
+// it in a copy of the vm that shares the globals.
+func evalFunc(vm *VM, body string, params []string, args []Value) (Value, error) {
+	code := fmt.Sprintf("function __eval(%s) {\n%s\n}", strings.Join(params, ", "), body)
+
+	p, err := appendCompile(vm.Program, code)
+	if err != nil {
+		return NullValue, err
+	}
+
+	globals := vm.Globals()
+	if n := p.Functions[0].MaxRegIndex; n > len(globals) {
+		g := make([]Value, n)
+		copy(g, globals)
+		globals = g
+	}
+
+	m := vm.CloneInitialized(p, globals)
+	m.MaxSteps = 0
+	return m.RunFunc("__eval", args...)
+}
+
 func appendCompile(p *Program, code string) (*Program, error) {
 	a, err := parser.ParseStr(code)
 	if err != nil {
@@ -1670,6 +1872,18 @@ func appendCompile(p *Program, code string) (*Program, error) {
 		c.functions[fn.Name] = &functionInfo{function: fn}
 	}
 
+	// functions declared again replace the previous declaration
+	// so they can be fixed without starting again.
+	replaced := make(map[string]int)
+	for _, s := range a.File.Stms {
+		if t, ok := s.(*ast.FuncDeclStmt); ok {
+			if fi, ok := c.functions[t.Name]; ok && !fi.function.IsClass && !fi.function.IsGlobal {
+				replaced[t.Name] = fi.function.Index
+				delete(c.functions, t.Name)
+			}
+		}
+	}
+
 	if err := c.compileStmts(a.File.Stms); err != nil {
 		return nil, err
 	}
@@ -1678,7 +1892,15 @@ func appendCompile(p *Program, code string) (*Program, error) {
 		return nil, err
 	}
 
+	// the code compiled before calls the function by its index
+	for name, i := range replaced {
+		p.Functions[i] = c.functions[name].function
+	}
+
 	f.Instructions = append(f.Instructions, ret)
 
+	// new functions may have been declared
+	p.funcMap = nil
+
 	return p, nil
 }
@@ -3414,6 +3414,82 @@ func TestEval3(t *testing.T) {
 	assertError(t, "Redeclared identifier", err)
 }
 
+func TestEvalRedeclareFunction(t *testing.T) {
+	p, err := CompileStr(`
+		function foo() { return 1 }
+		function bar() { return foo() + 1 }
+	`)
+	assertError(t, "", err)
+
+	vm := NewVM(p)
+	if _, err = vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Eval(vm, `function foo() { return 10 }`)
+	assertError(t, "", err)
+
+	v, err := EvalValue(vm, `bar()`)
+	assertError(t, "", err)
+
+	if v.ToInt() != 11 {
+		t.Fatal(v)
+	}
+}
+
+func TestEvalValue(t *testing.T) {
+	p, err := CompileStr(`
+		let a = { b: 2 }
+	`)
+	assertError(t, "", err)
+
+	vm := NewVM(p)
+	if _, err = vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := EvalValue(vm, `a.b * 3`)
+	assertError(t, "", err)
+
+	if v.ToInt() != 6 {
+		t.Fatal(v)
+	}
+
+	a, err := EvalValue(vm, `a`)
+	assertError(t, "", err)
+
+	if m := vm.Members(a); len(m) != 1 || m[0] != "b" {
+		t.Fatal(m)
+	}
+}
+
+func TestEvalShared(t *testing.T) {
+	p, err := CompileStr(`
+		let a = 1
+	`)
+	assertError(t, "", err)
+
+	vm := NewVM(p)
+	if _, err = vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = EvalShared(vm, `let b = a + 1
+	a = b * 2`)
+	assertError(t, "", err)
+
+	if v, _ := vm.RegisterValue("a"); v.ToInt() != 4 {
+		t.Fatal(v)
+	}
+
+	v, err := EvalShared(vm, `a + 1`)
+	assertError(t, "", err)
+
+	if v.ToInt() != 5 {
+		t.Fatal(v)
+	}
+}
+
 func assertError(t *testing.T, msg string, err error) {
 	if msg == "" {
 		if err != nil {